@@ -23,6 +23,9 @@ type Backend struct {
 	capabilities       compute.Capabilities
 	numDevices         int
 
+	// compileOptions are applied to every compilation, see the compilation options in the package documentation.
+	compileOptions []compileOption
+
 	// DotGeneralUseTF32 controls whether to use TF32 for DotGeneral operations that are using float32.
 	// (it can be faster in modern GPUs, and it's enabled by default)
 	DotGeneralUseTF32 bool
@@ -98,6 +101,46 @@ func (backend *Backend) DeviceDescription(deviceNum compute.DeviceNum) string {
 	return fmt.Sprintf("%s [processId=%d]", pjrtDesc.DebugString(), pjrtDesc.ProcessIndex())
 }
 
+// NewDeviceMesh creates a compute.Mesh with the given axes whose devices are ordered by the physical topology of
+// the backend's devices, see pjrt.Client.NewDeviceMesh for details.
+//
+// It returns the mesh (with its LogicalDeviceAssignment set) and the devices to use with Builder.DeviceAssignment.
+// It returns an error if the mesh has more devices than the backend.
+func (backend *Backend) NewDeviceMesh(name string, axesSizes []int, axesNames []string) (
+	compute.Mesh, []compute.DeviceNum, error) {
+	if err := backend.CheckValid(); err != nil {
+		return compute.Mesh{}, nil, err
+	}
+	shardyMesh, deviceAssignment, err := backend.client.NewDeviceMesh(name, axesSizes, axesNames)
+	if err != nil {
+		return compute.Mesh{}, nil, err
+	}
+	// Convert device IDs to device numbers (indices in the addressable devices).
+	idToDeviceNum := make(map[int]compute.DeviceNum, backend.numDevices)
+	for deviceNum, device := range backend.client.AddressableDevices() {
+		desc, err := device.GetDescription()
+		if err != nil {
+			return compute.Mesh{}, nil, err
+		}
+		id, err := desc.ID()
+		if err != nil {
+			return compute.Mesh{}, nil, err
+		}
+		idToDeviceNum[id] = compute.DeviceNum(deviceNum)
+	}
+	devices := make([]compute.DeviceNum, len(deviceAssignment))
+	for i, id := range deviceAssignment {
+		devices[i] = idToDeviceNum[id]
+	}
+	mesh := compute.Mesh{
+		Name:                    name,
+		AxesSizes:               shardyMesh.AxesSizes(),
+		AxesNames:               shardyMesh.AxesNames(),
+		LogicalDeviceAssignment: shardyMesh.LogicalDeviceAssignment(),
+	}
+	return mesh, devices, nil
+}
+
 // Finalize releases all the associated resources immediately and makes the backend invalid.
 func (backend *Backend) Finalize() {
 	if backend.plugin == nil {
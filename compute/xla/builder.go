@@ -197,6 +197,11 @@ func (b *Builder) DistributedAutoSharding(meshes ...compute.Mesh) error {
 		if err != nil {
 			return errors.WithMessagef(err, "while creating mesh %q", mesh.Name)
 		}
+		if len(mesh.LogicalDeviceAssignment) > 0 {
+			if err = b.meshes[i].SetLogicalDeviceAssignment(mesh.LogicalDeviceAssignment...); err != nil {
+				return errors.WithMessagef(err, "while setting the logical device assignment of mesh %q", mesh.Name)
+			}
+		}
 		meshNumDevices := b.meshes[i].NumDevices()
 		if meshNumDevices > b.backend.NumDevices() {
 			return errors.Errorf("mesh %q has %d devices, but the backend only has %d devices",
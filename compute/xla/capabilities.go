@@ -142,8 +142,15 @@ var Capabilities = compute.Capabilities{
 		dtypes.Uint64:     true,
 		dtypes.Float32:    true,
 		dtypes.Float64:    true,
+		dtypes.Float16:    true,
 		dtypes.BFloat16:   true,
 		dtypes.Complex64:  true,
 		dtypes.Complex128: true,
+
+		// Sub-byte integers, stored packed (see dtypes.DType.IsPacked), and 8-bit floats, stored as their raw bits.
+		dtypes.Int4:     true,
+		dtypes.Uint4:    true,
+		dtypes.F8E4M3FN: true,
+		dtypes.F8E5M2:   true,
 	},
 }
@@ -60,6 +60,9 @@ func (b *Builder) Compile() (compute.Executable, error) {
 	}
 
 	compileConfig := b.backend.client.Compile().WithStableHLO(program)
+	for _, option := range b.backend.compileOptions {
+		compileConfig = option(compileConfig)
+	}
 	var portable bool
 	switch b.distStrategy {
 	case distributed.SPMD:
@@ -11,6 +11,7 @@ import (
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/compute/shapes"
 	"github.com/gomlx/compute/support/xslices"
+	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/gomlx/go-xla/stablehlo"
 	stablehlotypes "github.com/gomlx/go-xla/types"
 	stablehloshapes "github.com/gomlx/go-xla/types/shapes"
@@ -446,7 +447,7 @@ func (f *Function) Bitcast(x compute.Value, targetDType dtypes.DType) (compute.V
 		return nil, err
 	}
 	xValue := nodes[0].value
-	if targetDType.IsPacked() && xValue.OpName() == stableHLOConstantOp {
+	if !utils.IsFloat8(targetDType) && targetDType.IsPacked() && xValue.OpName() == stableHLOConstantOp {
 		return nil, errors.Errorf("Cannot bitcast constant value to packed sub-byte type %s (x.Shape is %s): see details in "+
 			"https://github.com/openxla/xla/issues/38964", targetDType, xValue.Shape())
 	}
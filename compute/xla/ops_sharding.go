@@ -0,0 +1,86 @@
+// Copyright 2023-2026 The GoMLX Authors. SPDX-License-Identifier: Apache-2.0
+
+package xla
+
+import (
+	"github.com/gomlx/compute"
+	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+)
+
+// This file contains the sharding ops, only available for computations using DistributedAutoSharding.
+
+// opShardingSpec converts the sharding spec given to the op to a shardy.ShardingSpec.
+func (f *Function) opShardingSpec(opName string, sharding *compute.ShardingSpec) (*shardy.ShardingSpec, error) {
+	if sharding == nil {
+		return nil, errors.Errorf("%s requires a non-nil sharding spec", opName)
+	}
+	if len(f.builder.meshes) == 0 {
+		return nil, errors.Errorf("%s requires a computation with DistributedAutoSharding", opName)
+	}
+	shardySpec, err := f.builder.shardingSpecToShardy(sharding)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "while converting sharding spec for %s", opName)
+	}
+	return shardySpec, nil
+}
+
+// ShardingConstraint returns x unchanged, but constrains its sharding to the given spec.
+// It is used to pin the sharding of intermediary values (e.g. activations) that auto-sharding would otherwise
+// choose poorly.
+//
+// See stablehlo.ShardingConstraint.
+func (f *Function) ShardingConstraint(x compute.Value, sharding *compute.ShardingSpec) (compute.Value, error) {
+	nodes, err := f.verifyAndCastValues("ShardingConstraint", x)
+	if err != nil {
+		return nil, err
+	}
+	shardySpec, err := f.opShardingSpec("ShardingConstraint", sharding)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stablehlo.ShardingConstraint(nodes[0].value, shardySpec)
+	if err != nil {
+		return nil, err
+	}
+	return f.newNode(value), nil
+}
+
+// Reshard returns x unchanged, but explicitly resharded to the given spec.
+//
+// See stablehlo.Reshard.
+func (f *Function) Reshard(x compute.Value, sharding *compute.ShardingSpec) (compute.Value, error) {
+	nodes, err := f.verifyAndCastValues("Reshard", x)
+	if err != nil {
+		return nil, err
+	}
+	shardySpec, err := f.opShardingSpec("Reshard", sharding)
+	if err != nil {
+		return nil, err
+	}
+	value, err := stablehlo.Reshard(nodes[0].value, shardySpec)
+	if err != nil {
+		return nil, err
+	}
+	return f.newNode(value), nil
+}
+
+// WithSharding annotates the op that created x with the sharding of x, and returns x itself.
+// x cannot be a parameter: use the sharding argument of Parameter instead.
+//
+// See stablehlo.Value.WithSharding.
+func (f *Function) WithSharding(x compute.Value, sharding *compute.ShardingSpec) (compute.Value, error) {
+	nodes, err := f.verifyAndCastValues("WithSharding", x)
+	if err != nil {
+		return nil, err
+	}
+	shardySpec, err := f.opShardingSpec("WithSharding", sharding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = nodes[0].value.WithSharding(shardySpec); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
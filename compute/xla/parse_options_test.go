@@ -5,6 +5,7 @@ package xla
 import (
 	"testing"
 
+	"github.com/gomlx/go-xla/pjrt"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -87,3 +88,88 @@ func TestParseOptions(t *testing.T) {
 	_, _, err = parseOptions[[]int64]("bad_int", opts)
 	assert.Error(t, err)
 }
+
+func TestParseCompileOptions(t *testing.T) {
+	opts := map[string]string{
+		"xla_dump":                 "/tmp/xla_dump",
+		"xla_dump_format":          "text;html",
+		"autotune_level":           "2",
+		"nodeterministic_ops":      "",
+		"fast_math":                "",
+		"memory_limit":             "1073741824",
+		"alias_passthrough_params": "true",
+		"xla_env_option":           "xla_gpu_enable_latency_hiding_scheduler=true;some_int_option=7",
+		"tf32":                     "false",
+	}
+	options, err := parseCompileOptions(opts)
+	assert.NoError(t, err)
+	assert.Len(t, options, 8)
+	assert.Equal(t, map[string]string{"tf32": "false"}, opts, "only compile options should be consumed")
+
+	// Test int64 option
+	val, found, err := parseOptions[int64]("n", map[string]string{"n": "-3"})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(-3), val)
+
+	// Test error cases
+	for _, badOpts := range []map[string]string{
+		{"xla_dump": "/tmp/xla_dump", "xla_dump_format": "svg"},
+		{"xla_dump_format": "text"},
+		{"autotune_level": "5"},
+		{"autotune_level": "high"},
+		{"memory_limit": "-1"},
+		{"fast_math": "maybe"},
+		{"fast_math": "honor_nans;honor_everything"},
+		{"xla_env_option": "no_value"},
+		{"xla_env_option": "=3"},
+		{"xla_env_option": ""},
+	} {
+		_, err = parseCompileOptions(badOpts)
+		assert.Error(t, err, "options %v should fail", badOpts)
+	}
+}
+
+func TestParseFastMath(t *testing.T) {
+	for _, tc := range []struct {
+		opts     map[string]string
+		expected pjrt.FastMathFlags
+	}{
+		{map[string]string{"fast_math": ""}, pjrt.FastMathEnabled},
+		{map[string]string{"fast_math": "true"}, pjrt.FastMathEnabled},
+		{map[string]string{"fast_math": "false"}, pjrt.FastMathDisabled},
+		{map[string]string{"nofast_math": ""}, pjrt.FastMathDisabled},
+		{map[string]string{"fast_math": "honor_nans"}, pjrt.FastMathEnabled | pjrt.FastMathHonorNaNs},
+		{map[string]string{"fast_math": "honor_nans;honor_infs;honor_division;honor_functions"},
+			pjrt.FastMathEnabled | pjrt.FastMathHonorNaNs | pjrt.FastMathHonorInfs | pjrt.FastMathHonorDivision |
+				pjrt.FastMathHonorFunctions},
+	} {
+		flags, found, err := parseFastMath(tc.opts)
+		assert.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, tc.expected, flags, "options %v", tc.opts)
+		assert.Empty(t, tc.opts, "the option should be consumed")
+	}
+
+	_, found, err := parseFastMath(map[string]string{"tf32": "false"})
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestParseEnvOptionOverrides(t *testing.T) {
+	overrides, err := parseEnvOptionOverrides("flag=true;other_flag=false;count=7;ratio=0.5;name=foo;empty=")
+	assert.NoError(t, err)
+	assert.Equal(t, []envOptionOverride{
+		{"flag", true},
+		{"other_flag", false},
+		{"count", int64(7)},
+		{"ratio", 0.5},
+		{"name", "foo"},
+		{"empty", ""},
+	}, overrides)
+
+	// The value itself may contain "=".
+	overrides, err = parseEnvOptionOverrides("xla_gpu_flags=a=b;")
+	assert.NoError(t, err)
+	assert.Equal(t, []envOptionOverride{{"xla_gpu_flags", "a=b"}}, overrides)
+}
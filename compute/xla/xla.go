@@ -58,6 +58,24 @@
 //   - "visible_devices" (list of integers, e.g., "0;1;2"): list IDs of the devices made visible to the backend.
 //   - "use_tfrt_gpu_client" (boolean, default=false): uses the "TFRT" dispatcher for GPU.
 //
+// The following options configure the compilation of every computation (see the corresponding pjrt.CompileConfig
+// methods):
+//
+//   - "xla_dump" (string): directory where XLA dumps the HLO modules it compiles.
+//   - "xla_dump_format" (list of formats, e.g., "text;html", default="text"): formats of the dump, one of "text",
+//     "proto", "dot" or "html".
+//   - "autotune_level" (integer, 0 to 4): level of autotuning of GPU kernels, 0 disables it.
+//   - "deterministic_ops" (boolean, default=false): guarantees run-to-run determinism on GPUs.
+//   - "fast_math" (boolean or list of flags, default=false): enables fast-math optimizations (CPU only). Instead
+//     of a boolean, it can be given a list of the behaviors to keep exact, which also enables fast-math, e.g.
+//     "honor_nans;honor_infs". The flags are "honor_infs", "honor_nans", "honor_division" and "honor_functions".
+//   - "memory_limit" (integer, bytes): device memory size the compiler targets, rematerializing values to fit in it.
+//   - "alias_passthrough_params" (boolean, default=false): aliases input and output buffers of parameters passed
+//     through unchanged.
+//   - "xla_env_option" (list of "name=value" pairs, e.g., "xla_gpu_enable_latency_hiding_scheduler=true;foo=3"):
+//     overrides plugin-specific compilation options, usually XLA flags. Values "true" and "false" are booleans,
+//     numbers are integers or floats, and anything else is a string.
+//
 // # (NO) Dynamic Shapes
 //
 // XLA doesn't support dynamic shapes. Sort of ... it suppots, but any new shape triggers a re-compilation, something
@@ -127,7 +145,21 @@ const optionsDocumentation = `"xla" backend extra options:
     (== "bfc"), "bfc" ("best-fit for coalescing", avoids framementation), "cuda_async" (dynamic, no preallocation),
     "platform" (slow, good for debugging), "vmm"
   - "visible_devices" (list of integers, e.g., "0;1;2"): list IDs of the devices made visible to the backend.
-  - "use_tfrt_gpu_client" (boolean, default=false): uses the "TFRT" dispatcher for GPU.`
+  - "use_tfrt_gpu_client" (boolean, default=false): uses the "TFRT" dispatcher for GPU.
+  - "xla_dump" (string): directory where XLA dumps the HLO modules it compiles.
+  - "xla_dump_format" (list of formats, e.g., "text;html", default="text"): formats of the dump, one of "text",
+    "proto", "dot" or "html".
+  - "autotune_level" (integer, 0 to 4): level of autotuning of GPU kernels, 0 disables it.
+  - "deterministic_ops" (boolean, default=false): guarantees run-to-run determinism on GPUs.
+  - "fast_math" (boolean or list of flags, default=false): enables fast-math optimizations (CPU only). Instead
+    of a boolean, it can be given a list of the behaviors to keep exact, which also enables fast-math, e.g.
+    "honor_nans;honor_infs". The flags are "honor_infs", "honor_nans", "honor_division" and "honor_functions".
+  - "memory_limit" (integer, bytes): device memory size the compiler targets, rematerializing values to fit in it.
+  - "alias_passthrough_params" (boolean, default=false): aliases input and output buffers of parameters passed
+    through unchanged.
+  - "xla_env_option" (list of "name=value" pairs, e.g., "xla_gpu_enable_latency_hiding_scheduler=true;foo=3"):
+    overrides plugin-specific compilation options, usually XLA flags. Values "true" and "false" are booleans,
+    numbers are integers or floats, and anything else is a string.`
 
 // NewWithOptions creates a StableHLO backend with the given client options.
 // It allows more control, not available with the default New constructor.
@@ -256,6 +288,13 @@ func NewWithOptions(config string, options pjrt.NamedValuesMap) (*Backend, error
 		pluginOptions["use_tfrt_gpu_client"] = useTFRT
 	}
 
+	// Compilation options:
+	var err error
+	backend.compileOptions, err = parseCompileOptions(backendOptions)
+	if err != nil {
+		return nil, err
+	}
+
 	// Any leftover plugin options are unknown.
 	if len(backendOptions) != 0 {
 		// Get keys
@@ -310,7 +349,7 @@ func NewWithOptions(config string, options pjrt.NamedValuesMap) (*Backend, error
 // For bool options, it also searches for "no"+optionName, and if found, removes it and returns false.
 // It returns the parsed value, whether it was found, and any parsing error.
 func parseOptions[T interface {
-	string | bool | float32 | []int64
+	string | bool | int64 | float32 | []int64
 }](
 	optionName string, backendOptions map[string]string) (T, bool, error) {
 	var val T
@@ -341,6 +380,12 @@ func parseOptions[T interface {
 			return val, true, errors.Wrapf(err, "Failed to parse option %q=%q", optionName, valStr)
 		}
 		return any(b).(T), true, nil
+	case int64:
+		i, err := strconv.ParseInt(valStr, 10, 64)
+		if err != nil {
+			return val, true, errors.Wrapf(err, "Failed to parse option %q=%q", optionName, valStr)
+		}
+		return any(i).(T), true, nil
 	case float32:
 		f, err := strconv.ParseFloat(valStr, 32)
 		if err != nil {
@@ -368,6 +413,177 @@ func parseOptions[T interface {
 	}
 }
 
+// compileOption configures a pjrt.CompileConfig, it is applied to every compilation of the backend.
+type compileOption func(cc *pjrt.CompileConfig) *pjrt.CompileConfig
+
+// parseCompileOptions parses the compilation options from backendOptions, removing the ones found.
+func parseCompileOptions(backendOptions map[string]string) ([]compileOption, error) {
+	var options []compileOption
+	if dir, found, err := parseOptions[string]("xla_dump", backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		var formats []pjrt.DumpFormat
+		if formatsStr, found, _ := parseOptions[string]("xla_dump_format", backendOptions); found {
+			for _, name := range strings.FieldsFunc(formatsStr, func(r rune) bool {
+				return r == ';' || r == ':' || r == ' '
+			}) {
+				format, err := pjrt.ParseDumpFormat(name)
+				if err != nil {
+					return nil, errors.WithMessagef(err, "backend %q option \"xla_dump_format\"", BackendName)
+				}
+				formats = append(formats, format)
+			}
+		}
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithDump(dir, formats...)
+		})
+	} else if _, found := backendOptions["xla_dump_format"]; found {
+		return nil, errors.Errorf("backend %q option \"xla_dump_format\" requires \"xla_dump\" to be set", BackendName)
+	}
+
+	if level, found, err := parseOptions[int64]("autotune_level", backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		if level < 0 || level > pjrt.MaxAutotuneLevel {
+			return nil, errors.Errorf("backend %q option \"autotune_level\"=%d must be between 0 and %d",
+				BackendName, level, pjrt.MaxAutotuneLevel)
+		}
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithAutotuneLevel(int(level))
+		})
+	}
+
+	if deterministic, found, err := parseOptions[bool]("deterministic_ops", backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithDeterministicOps(deterministic)
+		})
+	}
+
+	if flags, found, err := parseFastMath(backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithFastMath(flags)
+		})
+	}
+
+	if limit, found, err := parseOptions[int64]("memory_limit", backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		if limit < 0 {
+			return nil, errors.Errorf("backend %q option \"memory_limit\"=%d must be >= 0", BackendName, limit)
+		}
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithMemoryLimit(limit)
+		})
+	}
+
+	if alias, found, err := parseOptions[bool]("alias_passthrough_params", backendOptions); err != nil {
+		return nil, err
+	} else if found {
+		options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+			return cc.WithAliasPassthroughParams(alias)
+		})
+	}
+
+	if overridesStr, found, _ := parseOptions[string]("xla_env_option", backendOptions); found {
+		overrides, err := parseEnvOptionOverrides(overridesStr)
+		if err != nil {
+			return nil, err
+		}
+		for _, override := range overrides {
+			options = append(options, func(cc *pjrt.CompileConfig) *pjrt.CompileConfig {
+				return cc.WithEnvOptionOverride(override.name, override.value)
+			})
+		}
+	}
+	return options, nil
+}
+
+// fastMathHonorFlags maps the names accepted by the "fast_math" option to the corresponding pjrt.FastMathFlags.
+var fastMathHonorFlags = map[string]pjrt.FastMathFlags{
+	"honor_infs":      pjrt.FastMathHonorInfs,
+	"honor_nans":      pjrt.FastMathHonorNaNs,
+	"honor_division":  pjrt.FastMathHonorDivision,
+	"honor_functions": pjrt.FastMathHonorFunctions,
+}
+
+// parseFastMath parses the "fast_math" (or "nofast_math") option from backendOptions, removing it if found.
+//
+// Its value is either a boolean or a list of "honor" flags (e.g. "honor_nans;honor_infs"), which enable fast-math
+// while keeping the corresponding behavior exact.
+func parseFastMath(backendOptions map[string]string) (flags pjrt.FastMathFlags, found bool, err error) {
+	if _, found = backendOptions["nofast_math"]; found {
+		delete(backendOptions, "nofast_math")
+		return pjrt.FastMathDisabled, true, nil
+	}
+	valStr, found, _ := parseOptions[string]("fast_math", backendOptions)
+	if !found {
+		return pjrt.FastMathDisabled, false, nil
+	}
+	if valStr == "" {
+		return pjrt.FastMathEnabled, true, nil
+	}
+	if enabled, err := strconv.ParseBool(valStr); err == nil {
+		if enabled {
+			return pjrt.FastMathEnabled, true, nil
+		}
+		return pjrt.FastMathDisabled, true, nil
+	}
+	flags = pjrt.FastMathEnabled
+	for _, name := range strings.FieldsFunc(valStr, func(r rune) bool {
+		return r == ';' || r == ':' || r == ' '
+	}) {
+		flag, ok := fastMathHonorFlags[name]
+		if !ok {
+			return pjrt.FastMathDisabled, true, errors.Errorf("backend %q option \"fast_math\"=%q: %q is not a "+
+				"boolean or one of \"honor_infs\", \"honor_nans\", \"honor_division\" or \"honor_functions\"",
+				BackendName, valStr, name)
+		}
+		flags |= flag
+	}
+	return flags, true, nil
+}
+
+// envOptionOverride is a compilation option override parsed from the "xla_env_option" backend option.
+type envOptionOverride struct {
+	name  string
+	value any
+}
+
+// parseEnvOptionOverrides parses the value of the "xla_env_option" option: a list of "name=value" pairs separated
+// by ";". Values "true" and "false" are booleans, integers are int64, other numbers are float64, and anything else
+// is a string.
+func parseEnvOptionOverrides(valStr string) ([]envOptionOverride, error) {
+	var overrides []envOptionOverride
+	for _, part := range strings.Split(valStr, ";") {
+		if part == "" {
+			continue
+		}
+		name, value, found := strings.Cut(part, "=")
+		if !found || name == "" {
+			return nil, errors.Errorf("backend %q option \"xla_env_option\"=%q: %q is not in the form "+
+				"\"name=value\"", BackendName, valStr, part)
+		}
+		override := envOptionOverride{name: name, value: value}
+		if value == "true" || value == "false" {
+			override.value = value == "true"
+		} else if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			override.value = i
+		} else if f, err := strconv.ParseFloat(value, 64); err == nil {
+			override.value = f
+		}
+		overrides = append(overrides, override)
+	}
+	if len(overrides) == 0 {
+		return nil, errors.Errorf("backend %q option \"xla_env_option\" requires at least one \"name=value\" "+
+			"pair", BackendName)
+	}
+	return overrides, nil
+}
+
 // Registers New() as the default constructor for "xla" backend.
 func init() {
 	compute.Register(BackendName, New)
@@ -7,11 +7,11 @@ import (
 	"strings"
 )
 
-const _OpTypeName = "InvalidFuncReturnConstantIdentityAbsAddAllGatherAllReduceAllToAllAndAtan2BatchNormInferenceBatchNormTrainingBatchNormGradBitcastConvertBroadcastInDimCallCbrtCeilClampCollectiveBroadcastCollectivePermuteCompareComplexConcatenateConvertConvolutionCosineCountLeadingZerosDivideDotGeneralDynamicBroadcastInDimDynamicConvDynamicGatherDynamicIotaDynamicPadDynamicSliceDynamicUpdateSliceErfExponentialExponentialMinusOneFftFloorGatherIfImagIsFiniteIotaLogLogPlusOneLogisticMaximumMinimumMultiplyNegateNotOptimizationBarrierOrPadPopcntPowerRealRemainderReduceReduceWindowReshapeReverseRNGBitGeneratorRoundNearestAfzRoundNearestEvenRsqrtScatterSelectSelectAndScatterShiftLeftShiftRightArithmeticShiftRightLogicalSignSineSliceSortSqrtSubtractTanTanhTransposeUniformDequantizeUniformQuantizeWhileXorGetDimensionSizeCaseCholeskyCompositeCustomCallDynamicReshapeGetTupleElementInfeedOutfeedPartitionIdRecvReducePrecisionReduceScatterSendTriangularSolveTupleLast"
+const _OpTypeName = "InvalidFuncReturnConstantIdentityAbsAddAllGatherAllReduceAllToAllAndAtan2BatchNormInferenceBatchNormTrainingBatchNormGradBitcastConvertBroadcastInDimCallCbrtCeilClampCollectiveBroadcastCollectivePermuteCompareComplexConcatenateConvertConvolutionCosineCountLeadingZerosDivideDotGeneralDynamicBroadcastInDimDynamicConvDynamicGatherDynamicIotaDynamicPadDynamicSliceDynamicUpdateSliceErfExponentialExponentialMinusOneFftFloorGatherIfImagIsFiniteIotaLogLogPlusOneLogisticMaximumMinimumMultiplyNegateNotOptimizationBarrierOrPadPopcntPowerRealRemainderReduceReduceWindowReshapeReverseRNGBitGeneratorRoundNearestAfzRoundNearestEvenRsqrtScatterSelectSelectAndScatterShiftLeftShiftRightArithmeticShiftRightLogicalSignSineSliceSortSqrtSubtractTanTanhTransposeUniformDequantizeUniformQuantizeWhileXorGetDimensionSizeShardingConstraintReshardManualComputationShardingGroupCaseCholeskyCompositeCustomCallDynamicReshapeGetTupleElementInfeedOutfeedPartitionIdRecvReducePrecisionReduceScatterSendTriangularSolveTupleLast"
 
-var _OpTypeIndex = [...]uint16{0, 7, 17, 25, 33, 36, 39, 48, 57, 65, 68, 73, 91, 108, 121, 135, 149, 153, 157, 161, 166, 185, 202, 209, 216, 227, 234, 245, 251, 268, 274, 284, 305, 316, 329, 340, 350, 362, 380, 383, 394, 413, 416, 421, 427, 429, 433, 441, 445, 448, 458, 466, 473, 480, 488, 494, 497, 516, 518, 521, 527, 532, 536, 545, 551, 563, 570, 577, 592, 607, 623, 628, 635, 641, 657, 666, 686, 703, 707, 711, 716, 720, 724, 732, 735, 739, 748, 765, 780, 785, 788, 804, 808, 816, 825, 835, 849, 864, 870, 877, 888, 892, 907, 920, 924, 939, 944, 948}
+var _OpTypeIndex = [...]uint16{0, 7, 17, 25, 33, 36, 39, 48, 57, 65, 68, 73, 91, 108, 121, 135, 149, 153, 157, 161, 166, 185, 202, 209, 216, 227, 234, 245, 251, 268, 274, 284, 305, 316, 329, 340, 350, 362, 380, 383, 394, 413, 416, 421, 427, 429, 433, 441, 445, 448, 458, 466, 473, 480, 488, 494, 497, 516, 518, 521, 527, 532, 536, 545, 551, 563, 570, 577, 592, 607, 623, 628, 635, 641, 657, 666, 686, 703, 707, 711, 716, 720, 724, 732, 735, 739, 748, 765, 780, 785, 788, 804, 822, 829, 846, 859, 863, 871, 880, 890, 904, 919, 925, 932, 943, 947, 962, 975, 979, 994, 999, 1003}
 
-const _OpTypeLowerName = "invalidfuncreturnconstantidentityabsaddallgatherallreducealltoallandatan2batchnorminferencebatchnormtrainingbatchnormgradbitcastconvertbroadcastindimcallcbrtceilclampcollectivebroadcastcollectivepermutecomparecomplexconcatenateconvertconvolutioncosinecountleadingzerosdividedotgeneraldynamicbroadcastindimdynamicconvdynamicgatherdynamiciotadynamicpaddynamicslicedynamicupdatesliceerfexponentialexponentialminusonefftfloorgatherifimagisfiniteiotaloglogplusonelogisticmaximumminimummultiplynegatenotoptimizationbarrierorpadpopcntpowerrealremainderreducereducewindowreshapereverserngbitgeneratorroundnearestafzroundnearestevenrsqrtscatterselectselectandscattershiftleftshiftrightarithmeticshiftrightlogicalsignsineslicesortsqrtsubtracttantanhtransposeuniformdequantizeuniformquantizewhilexorgetdimensionsizecasecholeskycompositecustomcalldynamicreshapegettupleelementinfeedoutfeedpartitionidrecvreduceprecisionreducescattersendtriangularsolvetuplelast"
+const _OpTypeLowerName = "invalidfuncreturnconstantidentityabsaddallgatherallreducealltoallandatan2batchnorminferencebatchnormtrainingbatchnormgradbitcastconvertbroadcastindimcallcbrtceilclampcollectivebroadcastcollectivepermutecomparecomplexconcatenateconvertconvolutioncosinecountleadingzerosdividedotgeneraldynamicbroadcastindimdynamicconvdynamicgatherdynamiciotadynamicpaddynamicslicedynamicupdatesliceerfexponentialexponentialminusonefftfloorgatherifimagisfiniteiotaloglogplusonelogisticmaximumminimummultiplynegatenotoptimizationbarrierorpadpopcntpowerrealremainderreducereducewindowreshapereverserngbitgeneratorroundnearestafzroundnearestevenrsqrtscatterselectselectandscattershiftleftshiftrightarithmeticshiftrightlogicalsignsineslicesortsqrtsubtracttantanhtransposeuniformdequantizeuniformquantizewhilexorgetdimensionsizeshardingconstraintreshardmanualcomputationshardinggroupcasecholeskycompositecustomcalldynamicreshapegettupleelementinfeedoutfeedpartitionidrecvreduceprecisionreducescattersendtriangularsolvetuplelast"
 
 func (i OpType) String() string {
 	if i < 0 || i >= OpType(len(_OpTypeIndex)-1) {
@@ -115,241 +115,253 @@ func _OpTypeNoOp() {
 	_ = x[While-(88)]
 	_ = x[Xor-(89)]
 	_ = x[GetDimensionSize-(90)]
-	_ = x[Case-(91)]
-	_ = x[Cholesky-(92)]
-	_ = x[Composite-(93)]
-	_ = x[CustomCall-(94)]
-	_ = x[DynamicReshape-(95)]
-	_ = x[GetTupleElement-(96)]
-	_ = x[Infeed-(97)]
-	_ = x[Outfeed-(98)]
-	_ = x[PartitionId-(99)]
-	_ = x[Recv-(100)]
-	_ = x[ReducePrecision-(101)]
-	_ = x[ReduceScatter-(102)]
-	_ = x[Send-(103)]
-	_ = x[TriangularSolve-(104)]
-	_ = x[Tuple-(105)]
-	_ = x[Last-(106)]
+	_ = x[ShardingConstraint-(91)]
+	_ = x[Reshard-(92)]
+	_ = x[ManualComputation-(93)]
+	_ = x[ShardingGroup-(94)]
+	_ = x[Case-(95)]
+	_ = x[Cholesky-(96)]
+	_ = x[Composite-(97)]
+	_ = x[CustomCall-(98)]
+	_ = x[DynamicReshape-(99)]
+	_ = x[GetTupleElement-(100)]
+	_ = x[Infeed-(101)]
+	_ = x[Outfeed-(102)]
+	_ = x[PartitionId-(103)]
+	_ = x[Recv-(104)]
+	_ = x[ReducePrecision-(105)]
+	_ = x[ReduceScatter-(106)]
+	_ = x[Send-(107)]
+	_ = x[TriangularSolve-(108)]
+	_ = x[Tuple-(109)]
+	_ = x[Last-(110)]
 }
 
-var _OpTypeValues = []OpType{Invalid, FuncReturn, Constant, Identity, Abs, Add, AllGather, AllReduce, AllToAll, And, Atan2, BatchNormInference, BatchNormTraining, BatchNormGrad, BitcastConvert, BroadcastInDim, Call, Cbrt, Ceil, Clamp, CollectiveBroadcast, CollectivePermute, Compare, Complex, Concatenate, Convert, Convolution, Cosine, CountLeadingZeros, Divide, DotGeneral, DynamicBroadcastInDim, DynamicConv, DynamicGather, DynamicIota, DynamicPad, DynamicSlice, DynamicUpdateSlice, Erf, Exponential, ExponentialMinusOne, Fft, Floor, Gather, If, Imag, IsFinite, Iota, Log, LogPlusOne, Logistic, Maximum, Minimum, Multiply, Negate, Not, OptimizationBarrier, Or, Pad, Popcnt, Power, Real, Remainder, Reduce, ReduceWindow, Reshape, Reverse, RNGBitGenerator, RoundNearestAfz, RoundNearestEven, Rsqrt, Scatter, Select, SelectAndScatter, ShiftLeft, ShiftRightArithmetic, ShiftRightLogical, Sign, Sine, Slice, Sort, Sqrt, Subtract, Tan, Tanh, Transpose, UniformDequantize, UniformQuantize, While, Xor, GetDimensionSize, Case, Cholesky, Composite, CustomCall, DynamicReshape, GetTupleElement, Infeed, Outfeed, PartitionId, Recv, ReducePrecision, ReduceScatter, Send, TriangularSolve, Tuple, Last}
+var _OpTypeValues = []OpType{Invalid, FuncReturn, Constant, Identity, Abs, Add, AllGather, AllReduce, AllToAll, And, Atan2, BatchNormInference, BatchNormTraining, BatchNormGrad, BitcastConvert, BroadcastInDim, Call, Cbrt, Ceil, Clamp, CollectiveBroadcast, CollectivePermute, Compare, Complex, Concatenate, Convert, Convolution, Cosine, CountLeadingZeros, Divide, DotGeneral, DynamicBroadcastInDim, DynamicConv, DynamicGather, DynamicIota, DynamicPad, DynamicSlice, DynamicUpdateSlice, Erf, Exponential, ExponentialMinusOne, Fft, Floor, Gather, If, Imag, IsFinite, Iota, Log, LogPlusOne, Logistic, Maximum, Minimum, Multiply, Negate, Not, OptimizationBarrier, Or, Pad, Popcnt, Power, Real, Remainder, Reduce, ReduceWindow, Reshape, Reverse, RNGBitGenerator, RoundNearestAfz, RoundNearestEven, Rsqrt, Scatter, Select, SelectAndScatter, ShiftLeft, ShiftRightArithmetic, ShiftRightLogical, Sign, Sine, Slice, Sort, Sqrt, Subtract, Tan, Tanh, Transpose, UniformDequantize, UniformQuantize, While, Xor, GetDimensionSize, ShardingConstraint, Reshard, ManualComputation, ShardingGroup, Case, Cholesky, Composite, CustomCall, DynamicReshape, GetTupleElement, Infeed, Outfeed, PartitionId, Recv, ReducePrecision, ReduceScatter, Send, TriangularSolve, Tuple, Last}
 
 var _OpTypeNameToValueMap = map[string]OpType{
-	_OpTypeName[0:7]:          Invalid,
-	_OpTypeLowerName[0:7]:     Invalid,
-	_OpTypeName[7:17]:         FuncReturn,
-	_OpTypeLowerName[7:17]:    FuncReturn,
-	_OpTypeName[17:25]:        Constant,
-	_OpTypeLowerName[17:25]:   Constant,
-	_OpTypeName[25:33]:        Identity,
-	_OpTypeLowerName[25:33]:   Identity,
-	_OpTypeName[33:36]:        Abs,
-	_OpTypeLowerName[33:36]:   Abs,
-	_OpTypeName[36:39]:        Add,
-	_OpTypeLowerName[36:39]:   Add,
-	_OpTypeName[39:48]:        AllGather,
-	_OpTypeLowerName[39:48]:   AllGather,
-	_OpTypeName[48:57]:        AllReduce,
-	_OpTypeLowerName[48:57]:   AllReduce,
-	_OpTypeName[57:65]:        AllToAll,
-	_OpTypeLowerName[57:65]:   AllToAll,
-	_OpTypeName[65:68]:        And,
-	_OpTypeLowerName[65:68]:   And,
-	_OpTypeName[68:73]:        Atan2,
-	_OpTypeLowerName[68:73]:   Atan2,
-	_OpTypeName[73:91]:        BatchNormInference,
-	_OpTypeLowerName[73:91]:   BatchNormInference,
-	_OpTypeName[91:108]:       BatchNormTraining,
-	_OpTypeLowerName[91:108]:  BatchNormTraining,
-	_OpTypeName[108:121]:      BatchNormGrad,
-	_OpTypeLowerName[108:121]: BatchNormGrad,
-	_OpTypeName[121:135]:      BitcastConvert,
-	_OpTypeLowerName[121:135]: BitcastConvert,
-	_OpTypeName[135:149]:      BroadcastInDim,
-	_OpTypeLowerName[135:149]: BroadcastInDim,
-	_OpTypeName[149:153]:      Call,
-	_OpTypeLowerName[149:153]: Call,
-	_OpTypeName[153:157]:      Cbrt,
-	_OpTypeLowerName[153:157]: Cbrt,
-	_OpTypeName[157:161]:      Ceil,
-	_OpTypeLowerName[157:161]: Ceil,
-	_OpTypeName[161:166]:      Clamp,
-	_OpTypeLowerName[161:166]: Clamp,
-	_OpTypeName[166:185]:      CollectiveBroadcast,
-	_OpTypeLowerName[166:185]: CollectiveBroadcast,
-	_OpTypeName[185:202]:      CollectivePermute,
-	_OpTypeLowerName[185:202]: CollectivePermute,
-	_OpTypeName[202:209]:      Compare,
-	_OpTypeLowerName[202:209]: Compare,
-	_OpTypeName[209:216]:      Complex,
-	_OpTypeLowerName[209:216]: Complex,
-	_OpTypeName[216:227]:      Concatenate,
-	_OpTypeLowerName[216:227]: Concatenate,
-	_OpTypeName[227:234]:      Convert,
-	_OpTypeLowerName[227:234]: Convert,
-	_OpTypeName[234:245]:      Convolution,
-	_OpTypeLowerName[234:245]: Convolution,
-	_OpTypeName[245:251]:      Cosine,
-	_OpTypeLowerName[245:251]: Cosine,
-	_OpTypeName[251:268]:      CountLeadingZeros,
-	_OpTypeLowerName[251:268]: CountLeadingZeros,
-	_OpTypeName[268:274]:      Divide,
-	_OpTypeLowerName[268:274]: Divide,
-	_OpTypeName[274:284]:      DotGeneral,
-	_OpTypeLowerName[274:284]: DotGeneral,
-	_OpTypeName[284:305]:      DynamicBroadcastInDim,
-	_OpTypeLowerName[284:305]: DynamicBroadcastInDim,
-	_OpTypeName[305:316]:      DynamicConv,
-	_OpTypeLowerName[305:316]: DynamicConv,
-	_OpTypeName[316:329]:      DynamicGather,
-	_OpTypeLowerName[316:329]: DynamicGather,
-	_OpTypeName[329:340]:      DynamicIota,
-	_OpTypeLowerName[329:340]: DynamicIota,
-	_OpTypeName[340:350]:      DynamicPad,
-	_OpTypeLowerName[340:350]: DynamicPad,
-	_OpTypeName[350:362]:      DynamicSlice,
-	_OpTypeLowerName[350:362]: DynamicSlice,
-	_OpTypeName[362:380]:      DynamicUpdateSlice,
-	_OpTypeLowerName[362:380]: DynamicUpdateSlice,
-	_OpTypeName[380:383]:      Erf,
-	_OpTypeLowerName[380:383]: Erf,
-	_OpTypeName[383:394]:      Exponential,
-	_OpTypeLowerName[383:394]: Exponential,
-	_OpTypeName[394:413]:      ExponentialMinusOne,
-	_OpTypeLowerName[394:413]: ExponentialMinusOne,
-	_OpTypeName[413:416]:      Fft,
-	_OpTypeLowerName[413:416]: Fft,
-	_OpTypeName[416:421]:      Floor,
-	_OpTypeLowerName[416:421]: Floor,
-	_OpTypeName[421:427]:      Gather,
-	_OpTypeLowerName[421:427]: Gather,
-	_OpTypeName[427:429]:      If,
-	_OpTypeLowerName[427:429]: If,
-	_OpTypeName[429:433]:      Imag,
-	_OpTypeLowerName[429:433]: Imag,
-	_OpTypeName[433:441]:      IsFinite,
-	_OpTypeLowerName[433:441]: IsFinite,
-	_OpTypeName[441:445]:      Iota,
-	_OpTypeLowerName[441:445]: Iota,
-	_OpTypeName[445:448]:      Log,
-	_OpTypeLowerName[445:448]: Log,
-	_OpTypeName[448:458]:      LogPlusOne,
-	_OpTypeLowerName[448:458]: LogPlusOne,
-	_OpTypeName[458:466]:      Logistic,
-	_OpTypeLowerName[458:466]: Logistic,
-	_OpTypeName[466:473]:      Maximum,
-	_OpTypeLowerName[466:473]: Maximum,
-	_OpTypeName[473:480]:      Minimum,
-	_OpTypeLowerName[473:480]: Minimum,
-	_OpTypeName[480:488]:      Multiply,
-	_OpTypeLowerName[480:488]: Multiply,
-	_OpTypeName[488:494]:      Negate,
-	_OpTypeLowerName[488:494]: Negate,
-	_OpTypeName[494:497]:      Not,
-	_OpTypeLowerName[494:497]: Not,
-	_OpTypeName[497:516]:      OptimizationBarrier,
-	_OpTypeLowerName[497:516]: OptimizationBarrier,
-	_OpTypeName[516:518]:      Or,
-	_OpTypeLowerName[516:518]: Or,
-	_OpTypeName[518:521]:      Pad,
-	_OpTypeLowerName[518:521]: Pad,
-	_OpTypeName[521:527]:      Popcnt,
-	_OpTypeLowerName[521:527]: Popcnt,
-	_OpTypeName[527:532]:      Power,
-	_OpTypeLowerName[527:532]: Power,
-	_OpTypeName[532:536]:      Real,
-	_OpTypeLowerName[532:536]: Real,
-	_OpTypeName[536:545]:      Remainder,
-	_OpTypeLowerName[536:545]: Remainder,
-	_OpTypeName[545:551]:      Reduce,
-	_OpTypeLowerName[545:551]: Reduce,
-	_OpTypeName[551:563]:      ReduceWindow,
-	_OpTypeLowerName[551:563]: ReduceWindow,
-	_OpTypeName[563:570]:      Reshape,
-	_OpTypeLowerName[563:570]: Reshape,
-	_OpTypeName[570:577]:      Reverse,
-	_OpTypeLowerName[570:577]: Reverse,
-	_OpTypeName[577:592]:      RNGBitGenerator,
-	_OpTypeLowerName[577:592]: RNGBitGenerator,
-	_OpTypeName[592:607]:      RoundNearestAfz,
-	_OpTypeLowerName[592:607]: RoundNearestAfz,
-	_OpTypeName[607:623]:      RoundNearestEven,
-	_OpTypeLowerName[607:623]: RoundNearestEven,
-	_OpTypeName[623:628]:      Rsqrt,
-	_OpTypeLowerName[623:628]: Rsqrt,
-	_OpTypeName[628:635]:      Scatter,
-	_OpTypeLowerName[628:635]: Scatter,
-	_OpTypeName[635:641]:      Select,
-	_OpTypeLowerName[635:641]: Select,
-	_OpTypeName[641:657]:      SelectAndScatter,
-	_OpTypeLowerName[641:657]: SelectAndScatter,
-	_OpTypeName[657:666]:      ShiftLeft,
-	_OpTypeLowerName[657:666]: ShiftLeft,
-	_OpTypeName[666:686]:      ShiftRightArithmetic,
-	_OpTypeLowerName[666:686]: ShiftRightArithmetic,
-	_OpTypeName[686:703]:      ShiftRightLogical,
-	_OpTypeLowerName[686:703]: ShiftRightLogical,
-	_OpTypeName[703:707]:      Sign,
-	_OpTypeLowerName[703:707]: Sign,
-	_OpTypeName[707:711]:      Sine,
-	_OpTypeLowerName[707:711]: Sine,
-	_OpTypeName[711:716]:      Slice,
-	_OpTypeLowerName[711:716]: Slice,
-	_OpTypeName[716:720]:      Sort,
-	_OpTypeLowerName[716:720]: Sort,
-	_OpTypeName[720:724]:      Sqrt,
-	_OpTypeLowerName[720:724]: Sqrt,
-	_OpTypeName[724:732]:      Subtract,
-	_OpTypeLowerName[724:732]: Subtract,
-	_OpTypeName[732:735]:      Tan,
-	_OpTypeLowerName[732:735]: Tan,
-	_OpTypeName[735:739]:      Tanh,
-	_OpTypeLowerName[735:739]: Tanh,
-	_OpTypeName[739:748]:      Transpose,
-	_OpTypeLowerName[739:748]: Transpose,
-	_OpTypeName[748:765]:      UniformDequantize,
-	_OpTypeLowerName[748:765]: UniformDequantize,
-	_OpTypeName[765:780]:      UniformQuantize,
-	_OpTypeLowerName[765:780]: UniformQuantize,
-	_OpTypeName[780:785]:      While,
-	_OpTypeLowerName[780:785]: While,
-	_OpTypeName[785:788]:      Xor,
-	_OpTypeLowerName[785:788]: Xor,
-	_OpTypeName[788:804]:      GetDimensionSize,
-	_OpTypeLowerName[788:804]: GetDimensionSize,
-	_OpTypeName[804:808]:      Case,
-	_OpTypeLowerName[804:808]: Case,
-	_OpTypeName[808:816]:      Cholesky,
-	_OpTypeLowerName[808:816]: Cholesky,
-	_OpTypeName[816:825]:      Composite,
-	_OpTypeLowerName[816:825]: Composite,
-	_OpTypeName[825:835]:      CustomCall,
-	_OpTypeLowerName[825:835]: CustomCall,
-	_OpTypeName[835:849]:      DynamicReshape,
-	_OpTypeLowerName[835:849]: DynamicReshape,
-	_OpTypeName[849:864]:      GetTupleElement,
-	_OpTypeLowerName[849:864]: GetTupleElement,
-	_OpTypeName[864:870]:      Infeed,
-	_OpTypeLowerName[864:870]: Infeed,
-	_OpTypeName[870:877]:      Outfeed,
-	_OpTypeLowerName[870:877]: Outfeed,
-	_OpTypeName[877:888]:      PartitionId,
-	_OpTypeLowerName[877:888]: PartitionId,
-	_OpTypeName[888:892]:      Recv,
-	_OpTypeLowerName[888:892]: Recv,
-	_OpTypeName[892:907]:      ReducePrecision,
-	_OpTypeLowerName[892:907]: ReducePrecision,
-	_OpTypeName[907:920]:      ReduceScatter,
-	_OpTypeLowerName[907:920]: ReduceScatter,
-	_OpTypeName[920:924]:      Send,
-	_OpTypeLowerName[920:924]: Send,
-	_OpTypeName[924:939]:      TriangularSolve,
-	_OpTypeLowerName[924:939]: TriangularSolve,
-	_OpTypeName[939:944]:      Tuple,
-	_OpTypeLowerName[939:944]: Tuple,
-	_OpTypeName[944:948]:      Last,
-	_OpTypeLowerName[944:948]: Last,
+	_OpTypeName[0:7]:           Invalid,
+	_OpTypeLowerName[0:7]:      Invalid,
+	_OpTypeName[7:17]:          FuncReturn,
+	_OpTypeLowerName[7:17]:     FuncReturn,
+	_OpTypeName[17:25]:         Constant,
+	_OpTypeLowerName[17:25]:    Constant,
+	_OpTypeName[25:33]:         Identity,
+	_OpTypeLowerName[25:33]:    Identity,
+	_OpTypeName[33:36]:         Abs,
+	_OpTypeLowerName[33:36]:    Abs,
+	_OpTypeName[36:39]:         Add,
+	_OpTypeLowerName[36:39]:    Add,
+	_OpTypeName[39:48]:         AllGather,
+	_OpTypeLowerName[39:48]:    AllGather,
+	_OpTypeName[48:57]:         AllReduce,
+	_OpTypeLowerName[48:57]:    AllReduce,
+	_OpTypeName[57:65]:         AllToAll,
+	_OpTypeLowerName[57:65]:    AllToAll,
+	_OpTypeName[65:68]:         And,
+	_OpTypeLowerName[65:68]:    And,
+	_OpTypeName[68:73]:         Atan2,
+	_OpTypeLowerName[68:73]:    Atan2,
+	_OpTypeName[73:91]:         BatchNormInference,
+	_OpTypeLowerName[73:91]:    BatchNormInference,
+	_OpTypeName[91:108]:        BatchNormTraining,
+	_OpTypeLowerName[91:108]:   BatchNormTraining,
+	_OpTypeName[108:121]:       BatchNormGrad,
+	_OpTypeLowerName[108:121]:  BatchNormGrad,
+	_OpTypeName[121:135]:       BitcastConvert,
+	_OpTypeLowerName[121:135]:  BitcastConvert,
+	_OpTypeName[135:149]:       BroadcastInDim,
+	_OpTypeLowerName[135:149]:  BroadcastInDim,
+	_OpTypeName[149:153]:       Call,
+	_OpTypeLowerName[149:153]:  Call,
+	_OpTypeName[153:157]:       Cbrt,
+	_OpTypeLowerName[153:157]:  Cbrt,
+	_OpTypeName[157:161]:       Ceil,
+	_OpTypeLowerName[157:161]:  Ceil,
+	_OpTypeName[161:166]:       Clamp,
+	_OpTypeLowerName[161:166]:  Clamp,
+	_OpTypeName[166:185]:       CollectiveBroadcast,
+	_OpTypeLowerName[166:185]:  CollectiveBroadcast,
+	_OpTypeName[185:202]:       CollectivePermute,
+	_OpTypeLowerName[185:202]:  CollectivePermute,
+	_OpTypeName[202:209]:       Compare,
+	_OpTypeLowerName[202:209]:  Compare,
+	_OpTypeName[209:216]:       Complex,
+	_OpTypeLowerName[209:216]:  Complex,
+	_OpTypeName[216:227]:       Concatenate,
+	_OpTypeLowerName[216:227]:  Concatenate,
+	_OpTypeName[227:234]:       Convert,
+	_OpTypeLowerName[227:234]:  Convert,
+	_OpTypeName[234:245]:       Convolution,
+	_OpTypeLowerName[234:245]:  Convolution,
+	_OpTypeName[245:251]:       Cosine,
+	_OpTypeLowerName[245:251]:  Cosine,
+	_OpTypeName[251:268]:       CountLeadingZeros,
+	_OpTypeLowerName[251:268]:  CountLeadingZeros,
+	_OpTypeName[268:274]:       Divide,
+	_OpTypeLowerName[268:274]:  Divide,
+	_OpTypeName[274:284]:       DotGeneral,
+	_OpTypeLowerName[274:284]:  DotGeneral,
+	_OpTypeName[284:305]:       DynamicBroadcastInDim,
+	_OpTypeLowerName[284:305]:  DynamicBroadcastInDim,
+	_OpTypeName[305:316]:       DynamicConv,
+	_OpTypeLowerName[305:316]:  DynamicConv,
+	_OpTypeName[316:329]:       DynamicGather,
+	_OpTypeLowerName[316:329]:  DynamicGather,
+	_OpTypeName[329:340]:       DynamicIota,
+	_OpTypeLowerName[329:340]:  DynamicIota,
+	_OpTypeName[340:350]:       DynamicPad,
+	_OpTypeLowerName[340:350]:  DynamicPad,
+	_OpTypeName[350:362]:       DynamicSlice,
+	_OpTypeLowerName[350:362]:  DynamicSlice,
+	_OpTypeName[362:380]:       DynamicUpdateSlice,
+	_OpTypeLowerName[362:380]:  DynamicUpdateSlice,
+	_OpTypeName[380:383]:       Erf,
+	_OpTypeLowerName[380:383]:  Erf,
+	_OpTypeName[383:394]:       Exponential,
+	_OpTypeLowerName[383:394]:  Exponential,
+	_OpTypeName[394:413]:       ExponentialMinusOne,
+	_OpTypeLowerName[394:413]:  ExponentialMinusOne,
+	_OpTypeName[413:416]:       Fft,
+	_OpTypeLowerName[413:416]:  Fft,
+	_OpTypeName[416:421]:       Floor,
+	_OpTypeLowerName[416:421]:  Floor,
+	_OpTypeName[421:427]:       Gather,
+	_OpTypeLowerName[421:427]:  Gather,
+	_OpTypeName[427:429]:       If,
+	_OpTypeLowerName[427:429]:  If,
+	_OpTypeName[429:433]:       Imag,
+	_OpTypeLowerName[429:433]:  Imag,
+	_OpTypeName[433:441]:       IsFinite,
+	_OpTypeLowerName[433:441]:  IsFinite,
+	_OpTypeName[441:445]:       Iota,
+	_OpTypeLowerName[441:445]:  Iota,
+	_OpTypeName[445:448]:       Log,
+	_OpTypeLowerName[445:448]:  Log,
+	_OpTypeName[448:458]:       LogPlusOne,
+	_OpTypeLowerName[448:458]:  LogPlusOne,
+	_OpTypeName[458:466]:       Logistic,
+	_OpTypeLowerName[458:466]:  Logistic,
+	_OpTypeName[466:473]:       Maximum,
+	_OpTypeLowerName[466:473]:  Maximum,
+	_OpTypeName[473:480]:       Minimum,
+	_OpTypeLowerName[473:480]:  Minimum,
+	_OpTypeName[480:488]:       Multiply,
+	_OpTypeLowerName[480:488]:  Multiply,
+	_OpTypeName[488:494]:       Negate,
+	_OpTypeLowerName[488:494]:  Negate,
+	_OpTypeName[494:497]:       Not,
+	_OpTypeLowerName[494:497]:  Not,
+	_OpTypeName[497:516]:       OptimizationBarrier,
+	_OpTypeLowerName[497:516]:  OptimizationBarrier,
+	_OpTypeName[516:518]:       Or,
+	_OpTypeLowerName[516:518]:  Or,
+	_OpTypeName[518:521]:       Pad,
+	_OpTypeLowerName[518:521]:  Pad,
+	_OpTypeName[521:527]:       Popcnt,
+	_OpTypeLowerName[521:527]:  Popcnt,
+	_OpTypeName[527:532]:       Power,
+	_OpTypeLowerName[527:532]:  Power,
+	_OpTypeName[532:536]:       Real,
+	_OpTypeLowerName[532:536]:  Real,
+	_OpTypeName[536:545]:       Remainder,
+	_OpTypeLowerName[536:545]:  Remainder,
+	_OpTypeName[545:551]:       Reduce,
+	_OpTypeLowerName[545:551]:  Reduce,
+	_OpTypeName[551:563]:       ReduceWindow,
+	_OpTypeLowerName[551:563]:  ReduceWindow,
+	_OpTypeName[563:570]:       Reshape,
+	_OpTypeLowerName[563:570]:  Reshape,
+	_OpTypeName[570:577]:       Reverse,
+	_OpTypeLowerName[570:577]:  Reverse,
+	_OpTypeName[577:592]:       RNGBitGenerator,
+	_OpTypeLowerName[577:592]:  RNGBitGenerator,
+	_OpTypeName[592:607]:       RoundNearestAfz,
+	_OpTypeLowerName[592:607]:  RoundNearestAfz,
+	_OpTypeName[607:623]:       RoundNearestEven,
+	_OpTypeLowerName[607:623]:  RoundNearestEven,
+	_OpTypeName[623:628]:       Rsqrt,
+	_OpTypeLowerName[623:628]:  Rsqrt,
+	_OpTypeName[628:635]:       Scatter,
+	_OpTypeLowerName[628:635]:  Scatter,
+	_OpTypeName[635:641]:       Select,
+	_OpTypeLowerName[635:641]:  Select,
+	_OpTypeName[641:657]:       SelectAndScatter,
+	_OpTypeLowerName[641:657]:  SelectAndScatter,
+	_OpTypeName[657:666]:       ShiftLeft,
+	_OpTypeLowerName[657:666]:  ShiftLeft,
+	_OpTypeName[666:686]:       ShiftRightArithmetic,
+	_OpTypeLowerName[666:686]:  ShiftRightArithmetic,
+	_OpTypeName[686:703]:       ShiftRightLogical,
+	_OpTypeLowerName[686:703]:  ShiftRightLogical,
+	_OpTypeName[703:707]:       Sign,
+	_OpTypeLowerName[703:707]:  Sign,
+	_OpTypeName[707:711]:       Sine,
+	_OpTypeLowerName[707:711]:  Sine,
+	_OpTypeName[711:716]:       Slice,
+	_OpTypeLowerName[711:716]:  Slice,
+	_OpTypeName[716:720]:       Sort,
+	_OpTypeLowerName[716:720]:  Sort,
+	_OpTypeName[720:724]:       Sqrt,
+	_OpTypeLowerName[720:724]:  Sqrt,
+	_OpTypeName[724:732]:       Subtract,
+	_OpTypeLowerName[724:732]:  Subtract,
+	_OpTypeName[732:735]:       Tan,
+	_OpTypeLowerName[732:735]:  Tan,
+	_OpTypeName[735:739]:       Tanh,
+	_OpTypeLowerName[735:739]:  Tanh,
+	_OpTypeName[739:748]:       Transpose,
+	_OpTypeLowerName[739:748]:  Transpose,
+	_OpTypeName[748:765]:       UniformDequantize,
+	_OpTypeLowerName[748:765]:  UniformDequantize,
+	_OpTypeName[765:780]:       UniformQuantize,
+	_OpTypeLowerName[765:780]:  UniformQuantize,
+	_OpTypeName[780:785]:       While,
+	_OpTypeLowerName[780:785]:  While,
+	_OpTypeName[785:788]:       Xor,
+	_OpTypeLowerName[785:788]:  Xor,
+	_OpTypeName[788:804]:       GetDimensionSize,
+	_OpTypeLowerName[788:804]:  GetDimensionSize,
+	_OpTypeName[804:822]:       ShardingConstraint,
+	_OpTypeLowerName[804:822]:  ShardingConstraint,
+	_OpTypeName[822:829]:       Reshard,
+	_OpTypeLowerName[822:829]:  Reshard,
+	_OpTypeName[829:846]:       ManualComputation,
+	_OpTypeLowerName[829:846]:  ManualComputation,
+	_OpTypeName[846:859]:       ShardingGroup,
+	_OpTypeLowerName[846:859]:  ShardingGroup,
+	_OpTypeName[859:863]:       Case,
+	_OpTypeLowerName[859:863]:  Case,
+	_OpTypeName[863:871]:       Cholesky,
+	_OpTypeLowerName[863:871]:  Cholesky,
+	_OpTypeName[871:880]:       Composite,
+	_OpTypeLowerName[871:880]:  Composite,
+	_OpTypeName[880:890]:       CustomCall,
+	_OpTypeLowerName[880:890]:  CustomCall,
+	_OpTypeName[890:904]:       DynamicReshape,
+	_OpTypeLowerName[890:904]:  DynamicReshape,
+	_OpTypeName[904:919]:       GetTupleElement,
+	_OpTypeLowerName[904:919]:  GetTupleElement,
+	_OpTypeName[919:925]:       Infeed,
+	_OpTypeLowerName[919:925]:  Infeed,
+	_OpTypeName[925:932]:       Outfeed,
+	_OpTypeLowerName[925:932]:  Outfeed,
+	_OpTypeName[932:943]:       PartitionId,
+	_OpTypeLowerName[932:943]:  PartitionId,
+	_OpTypeName[943:947]:       Recv,
+	_OpTypeLowerName[943:947]:  Recv,
+	_OpTypeName[947:962]:       ReducePrecision,
+	_OpTypeLowerName[947:962]:  ReducePrecision,
+	_OpTypeName[962:975]:       ReduceScatter,
+	_OpTypeLowerName[962:975]:  ReduceScatter,
+	_OpTypeName[975:979]:       Send,
+	_OpTypeLowerName[975:979]:  Send,
+	_OpTypeName[979:994]:       TriangularSolve,
+	_OpTypeLowerName[979:994]:  TriangularSolve,
+	_OpTypeName[994:999]:       Tuple,
+	_OpTypeLowerName[994:999]:  Tuple,
+	_OpTypeName[999:1003]:      Last,
+	_OpTypeLowerName[999:1003]: Last,
 }
 
 var _OpTypeNames = []string{
@@ -444,22 +456,26 @@ var _OpTypeNames = []string{
 	_OpTypeName[780:785],
 	_OpTypeName[785:788],
 	_OpTypeName[788:804],
-	_OpTypeName[804:808],
-	_OpTypeName[808:816],
-	_OpTypeName[816:825],
-	_OpTypeName[825:835],
-	_OpTypeName[835:849],
-	_OpTypeName[849:864],
-	_OpTypeName[864:870],
-	_OpTypeName[870:877],
-	_OpTypeName[877:888],
-	_OpTypeName[888:892],
-	_OpTypeName[892:907],
-	_OpTypeName[907:920],
-	_OpTypeName[920:924],
-	_OpTypeName[924:939],
-	_OpTypeName[939:944],
-	_OpTypeName[944:948],
+	_OpTypeName[804:822],
+	_OpTypeName[822:829],
+	_OpTypeName[829:846],
+	_OpTypeName[846:859],
+	_OpTypeName[859:863],
+	_OpTypeName[863:871],
+	_OpTypeName[871:880],
+	_OpTypeName[880:890],
+	_OpTypeName[890:904],
+	_OpTypeName[904:919],
+	_OpTypeName[919:925],
+	_OpTypeName[925:932],
+	_OpTypeName[932:943],
+	_OpTypeName[943:947],
+	_OpTypeName[947:962],
+	_OpTypeName[962:975],
+	_OpTypeName[975:979],
+	_OpTypeName[979:994],
+	_OpTypeName[994:999],
+	_OpTypeName[999:1003],
 }
 
 // OpTypeString retrieves an enum value from the enum constants string name.
@@ -107,6 +107,13 @@ const (
 
 	GetDimensionSize
 
+	// Shardy ops.
+
+	ShardingConstraint
+	Reshard
+	ManualComputation
+	ShardingGroup
+
 	// Here the ones not implemented yet, please add an issue in the repo if you need them.
 
 	Case
@@ -136,7 +143,12 @@ var (
 		FuncReturn: "stablehlo.return",
 		Call:       "func.call",
 		Erf:        "chlo.erf",
-		AllReduce:  "stablehlo.all_reduce"}
+		AllReduce:  "stablehlo.all_reduce",
+
+		ShardingConstraint: "sdy.sharding_constraint",
+		Reshard:            "sdy.reshard",
+		ManualComputation:  "sdy.manual_computation",
+		ShardingGroup:      "sdy.sharding_group"}
 )
 
 // ToStableHLO returns the ToStableHLO name of the operation.
@@ -177,16 +177,16 @@ func BinaryOp(opType optypes.OpType, lhsShape, rhsShape shapes.Shape) (output sh
 		return
 	}
 
-	if NumberOperations.Has(opType) && !ComparisonOperations.Has(opType) && !(lhsShape.DType.IsInt() || lhsShape.DType.IsFloat() || lhsShape.DType.IsComplex()) {
+	if NumberOperations.Has(opType) && !ComparisonOperations.Has(opType) && !(lhsShape.DType.IsInt() || isFloat(lhsShape.DType) || lhsShape.DType.IsComplex()) {
 		err = errors.Errorf("numeric BinaryOp %s must have a number (Int32, Float32, Complex64, ...) data type as input, got %s", opType, lhsShape)
 		return
 	}
 
-	if FloatOperations.Has(opType) && !lhsShape.DType.IsFloat() {
+	if FloatOperations.Has(opType) && !isFloat(lhsShape.DType) {
 		err = errors.Errorf("float BinaryOp %s must have a float (Float32, Float64, ...) data type as input, got %s", opType, lhsShape)
 		return
 	}
-	if FloatOrComplexOperations.Has(opType) && !(lhsShape.DType.IsFloat() || lhsShape.DType.IsComplex()) {
+	if FloatOrComplexOperations.Has(opType) && !(isFloat(lhsShape.DType) || lhsShape.DType.IsComplex()) {
 		err = errors.Errorf("float/complex BinaryOp %s must have a float or complex (Float32, Complex64, ...) data type as input, got %s", opType, lhsShape)
 		return
 	}
@@ -257,12 +257,12 @@ func Compare(lhsShape, rhsShape shapes.Shape, direction types.ComparisonDirectio
 	dtype := lhsShape.DType
 	switch compareType {
 	case types.CompareFloat:
-		if !dtype.IsFloat() && !dtype.IsComplex() {
+		if !isFloat(dtype) && !dtype.IsComplex() {
 			err = errors.Errorf("data type %s is not a float or complex, cannot process it with Compare(direction=%s, type=FLOAT)", dtype, direction)
 			return
 		}
 	case types.CompareTotalOrder:
-		if !dtype.IsFloat() {
+		if !isFloat(dtype) {
 			err = errors.Errorf("data type %s is not a float, cannot process it with Compare(direction=%s, type=TOTAL_ORDER)", dtype, direction)
 			return
 		}
@@ -312,19 +312,19 @@ func UnaryOp(opType optypes.OpType, operand shapes.Shape) (output shapes.Shape,
 		return
 	}
 	if SignedNumberOperations.Has(opType) && (operand.DType.IsUnsigned() ||
-		!(operand.DType.IsInt() || operand.DType.IsFloat() || operand.DType.IsComplex())) {
+		!(operand.DType.IsInt() || isFloat(operand.DType) || operand.DType.IsComplex())) {
 		err = errors.Errorf("signed UnaryOp %s must have a signed data type as input, got %s", opType, operand)
 		return
 	}
-	if NumberOperations.Has(opType) && !(operand.DType.IsInt() || operand.DType.IsFloat() || operand.DType.IsComplex()) {
+	if NumberOperations.Has(opType) && !(operand.DType.IsInt() || isFloat(operand.DType) || operand.DType.IsComplex()) {
 		err = errors.Errorf("numeric UnaryOp %s must have a number (Int32, Float32, Complex64, ...) data type as input, got %s", opType, operand)
 		return
 	}
-	if FloatOperations.Has(opType) && !operand.DType.IsFloat() {
+	if FloatOperations.Has(opType) && !isFloat(operand.DType) {
 		err = errors.Errorf("float UnaryOp %s must have a float (Float32, Float64, ...) data type as input, got %s", opType, operand)
 		return
 	}
-	if FloatOrComplexOperations.Has(opType) && !(operand.DType.IsFloat() || operand.DType.IsComplex()) {
+	if FloatOrComplexOperations.Has(opType) && !(isFloat(operand.DType) || operand.DType.IsComplex()) {
 		err = errors.Errorf("float/complex UnaryOp %s must have a float or complex (Float32, Complex64, ...) data type as input, got %s", opType, operand)
 		return
 	}
@@ -1070,7 +1070,7 @@ func ArgMinMax(operand shapes.Shape, axis int, outputDType dtypes.DType) (output
 		err = errors.Errorf("ArgMinMax outputDType must be an integer type, got %s", outputDType)
 		return
 	}
-	if !operand.DType.IsFloat() && !operand.DType.IsInt() {
+	if !isFloat(operand.DType) && !operand.DType.IsInt() {
 		err = errors.Errorf("ArgMinMax operand DType must be a floating point or integer type, got %s", operand)
 		return
 	}
@@ -1596,7 +1596,7 @@ func dotGeneralFindSizes(shape shapes.Shape, contractingAxes, batchAxes []int) (
 
 func IsFinite(operand shapes.Shape) (output shapes.Shape, err error) {
 	dtype := operand.DType
-	if !dtype.IsFloat() {
+	if !isFloat(dtype) {
 		err = errors.Errorf("IsFinite: operand data type %s is a floating point type", dtype)
 		return
 	}
@@ -1684,6 +1684,25 @@ func Reduce(inputs, initialValues, reductionInputs, reductionOutputs []shapes.Sh
 	return
 }
 
+// isFloat returns whether dtype is a float, including the 8-bit floats (e.g. F8E4M3FN) not covered by
+// dtypes.DType.IsFloat.
+func isFloat(dtype dtypes.DType) bool {
+	return dtype.IsFloat() || utils.IsFloat8(dtype)
+}
+
+// Convert returns the shape of the conversion of operand to the given dtype: the same dimensions with the new dtype.
+func Convert(operand shapes.Shape, dtype dtypes.DType) (outputShape shapes.Shape, err error) {
+	if operand.DType == dtypes.INVALID || operand.IsTuple() {
+		return shapes.Invalid(), errors.Errorf("Convert: invalid operand shape %s", operand)
+	}
+	if dtype == dtypes.INVALID || (!dtypes.SupportedDTypes[dtype] && !utils.IsFloat8(dtype)) {
+		return shapes.Invalid(), errors.Errorf("Convert: target data type %s is not supported", dtype)
+	}
+	outputShape = operand.Clone()
+	outputShape.DType = dtype
+	return outputShape, nil
+}
+
 func BitcastConvert(operand shapes.Shape, targetDType dtypes.DType) (outputShape shapes.Shape, err error) {
 	if operand.DType == dtypes.INVALID {
 		return shapes.Invalid(), errors.New("BitcastConvert: operand data type is invalid")
@@ -1691,24 +1710,25 @@ func BitcastConvert(operand shapes.Shape, targetDType dtypes.DType) (outputShape
 	sourceDType := operand.DType
 	outputShape = operand.Clone()
 	outputShape.DType = targetDType
-	if sourceDType.Bits() == targetDType.Bits() {
+	sourceBits, targetBits := utils.DTypeBits(sourceDType), utils.DTypeBits(targetDType)
+	if sourceBits == targetBits {
 		// No changes in shape.
 		return
 	}
-	if sourceDType.Bits() > targetDType.Bits() {
+	if sourceBits > targetBits {
 		// Convert to a smaller data type, append to a new dimension.
-		newDim := sourceDType.Bits() / targetDType.Bits()
+		newDim := sourceBits / targetBits
 		outputShape.Dimensions = append(outputShape.Dimensions, newDim)
 		return
 	}
 
 	// Convert to a larger data type, shrink the last dimension.
 	lastDim := outputShape.Dim(-1)
-	expectedDim := (targetDType.Bits() + sourceDType.Bits() - 1) / sourceDType.Bits()
+	expectedDim := (targetBits + sourceBits - 1) / sourceBits
 	// Skip dimension check if last dimension is dynamic
 	if lastDim != shapes.DimUnknown && lastDim != expectedDim {
 		return shapes.Invalid(), errors.Errorf("BitcastConvert: cannot convert from %d x %s (%d bits) to %s (%d bits)",
-			lastDim, sourceDType, sourceDType.Bits(), targetDType, targetDType.Bits())
+			lastDim, sourceDType, sourceBits, targetDType, targetBits)
 	}
 	outputShape.Dimensions = outputShape.Dimensions[:len(outputShape.Dimensions)-1]
 	return
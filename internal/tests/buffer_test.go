@@ -193,5 +193,46 @@ func TestSubByteDTypes(t *testing.T) {
 				t.Errorf("expected %v output, got %v (dimensions=%v)", want, gotFlat, gotDims)
 			}
 		})
+
+		t.Run("Int4-FromFlatDataWithDType", func(t *testing.T) {
+			builder := New(t.Name())
+			fn := builder.Main()
+			input := must1(fn.NamedInput("x", shapes.Make(dtypes.Int4, 3)))
+			constant := must1(fn.ConstantFromFlatAndShape([]int8{-8, 0, 7}, shapes.Make(dtypes.Int4, 3)))
+			sum := must1(Add(must1(Convert(input, dtypes.Int8)), must1(Convert(constant, dtypes.Int8))))
+			must(fn.Return(input, sum))
+			program := must1(builder.Build())
+			fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+			x := must1(client.BufferFromHost().FromFlatDataWithDType([]int8{1, -2, 3}, dtypes.Int4, []int{3}).Done())
+			outputs := compileAndExecute(t, client, program, x)
+			gotInput, gotDims := must2(outputs[0].ToFlatDataAndDimensions())
+			if !slices.Equal(gotInput.([]int8), []int8{1, -2, 3}) || !slices.Equal(gotDims, []int{3}) {
+				t.Errorf("expected Int4 values [1 -2 3] (dimensions=[3]), got %v (dimensions=%v)", gotInput, gotDims)
+			}
+			must(outputs[0].Destroy())
+			requireBuffersEqual(t, []FlatAndDims{{[]int8{-7, -2, 10}, []int{3}}}, outputs[1:])
+		})
+
+		t.Run("Float8", func(t *testing.T) {
+			builder := New(t.Name())
+			fn := builder.Main()
+			input := must1(fn.NamedInput("x", shapes.Make(dtypes.F8E4M3FN, 2)))
+			// 0x38 is 1.0 and 0x40 is 2.0 in F8E4M3FN.
+			constant := must1(fn.ConstantFromFlatAndShape([]uint8{0x38, 0x40}, shapes.Make(dtypes.F8E4M3FN, 2)))
+			product := must1(Multiply(must1(Convert(input, dtypes.Float32)), must1(Convert(constant, dtypes.Float32))))
+			must(fn.Return(product, must1(Convert(product, dtypes.F8E5M2))))
+			program := must1(builder.Build())
+			fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+			// 0x48 is 4.0 and 0xB8 is -1.0 in F8E4M3FN.
+			x := must1(client.BufferFromHost().FromFlatDataWithDType([]uint8{0x48, 0xB8}, dtypes.F8E4M3FN, []int{2}).Done())
+			outputs := compileAndExecute(t, client, program, x)
+			// 0x44 is 4.0 and 0xC0 is -2.0 in F8E5M2.
+			gotF8, _ := must2(outputs[1].ToFlatDataAndDimensions())
+			if !slices.Equal(gotF8.([]uint8), []uint8{0x44, 0xC0}) {
+				t.Errorf("expected F8E5M2 raw bits [0x44 0xc0], got %#v", gotF8)
+			}
+			must(outputs[1].Destroy())
+			requireBuffersEqual(t, []FlatAndDims{{[]float32{4, -2}, []int{2}}}, outputs[:1])
+		})
 	})
 }
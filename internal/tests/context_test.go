@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+func TestDoneContext(t *testing.T) {
+	iterateClientsAndTest(t, testDoneContext)
+}
+
+// buildCountingLoop builds a program with a While loop that counts from x up to limit (both int64 inputs), and
+// returns the final count. With a large limit it takes long enough to be cancelled, but it still ends.
+func buildCountingLoop(t *testing.T) []byte {
+	builder := New(t.Name())
+	fn := builder.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.Int64)))
+	limit := must1(fn.NamedInput("limit", shapes.Make(dtypes.Int64)))
+	cond := fn.Closure()
+	condCounter := must1(cond.Input(x.Shape()))
+	condLimit := must1(cond.Input(limit.Shape()))
+	must(cond.Return(must1(Compare(condCounter, condLimit, types.CompareLT, types.CompareSigned))))
+	body := fn.Closure()
+	bodyCounter := must1(body.Input(x.Shape()))
+	bodyLimit := must1(body.Input(limit.Shape()))
+	one := must1(body.ConstantFromScalar(int64(1)))
+	must(body.Return(must1(Add(bodyCounter, one)), bodyLimit))
+	must(fn.Return(must1(While(cond, body, x, limit))[0]))
+	return must1(builder.Build())
+}
+
+func testDoneContext(t *testing.T, client *pjrt.Client) {
+	program := buildCountingLoop(t)
+
+	t.Run("CompileCancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := client.Compile().WithStableHLO(program).DoneContext(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled error, got %v", err)
+		}
+	})
+
+	t.Run("Finished", func(t *testing.T) {
+		loadedExec := must1(client.Compile().WithStableHLO(program).DoneContext(context.Background()))
+		defer func() { must(loadedExec.Destroy()) }()
+		x := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int64{3}, nil).Done())
+		limit := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int64{10}, nil).Done())
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		outputs := must1(loadedExec.Execute(x, limit).DonateAll().DoneContext(ctx))
+		requireBuffersEqual(t, []FlatAndDims{{[]int64{10}, nil}}, outputs)
+	})
+
+	t.Run("ExecutionTimeout", func(t *testing.T) {
+		loadedExec := must1(client.Compile().WithStableHLO(program).DoneContext(context.Background()))
+		const numIterations = int64(1 << 26)
+		x := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int64{0}, nil).Done())
+		limit := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int64{numIterations}, nil).Done())
+
+		// DoneContext must return promptly after the deadline.
+		const timeout = 50 * time.Millisecond
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		start := time.Now()
+		outputs, err := loadedExec.Execute(x, limit).DoneContext(ctx)
+		elapsed := time.Since(start)
+		if err == nil {
+			t.Logf("Execution of %d iterations finished in %s, before the deadline of %s", numIterations, elapsed, timeout)
+		} else {
+			fmt.Printf("\texpected error after %s: %v\n", elapsed, err)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected context.DeadlineExceeded error, got %v", err)
+			}
+			if elapsed > 10*timeout {
+				t.Errorf("DoneContext took %s to return after a timeout of %s", elapsed, timeout)
+			}
+		}
+		if len(outputs) != 1 {
+			t.Fatalf("expected the 1 output of the execution, got %d outputs", len(outputs))
+		}
+
+		// The executable and the inputs can be destroyed while the execution is still running.
+		must(loadedExec.Destroy())
+		must(x.Destroy())
+		must(limit.Destroy())
+
+		// Reading the output waits for the execution to finish: either it was poisoned, and the output carries the
+		// error, or it ran to completion.
+		count, err := pjrt.BufferToScalar[int64](outputs[0])
+		must(outputs[0].Destroy())
+		if err != nil {
+			fmt.Printf("\toutput of the poisoned execution: %v\n", err)
+			if !errors.Is(err, pjrt.ErrCancelled) {
+				t.Fatalf("expected the output to carry a cancelled error, got %v", err)
+			}
+		} else if count != numIterations {
+			t.Fatalf("expected the execution to count to %d, got %d", numIterations, count)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestExecutePerDevice(t *testing.T) {
+	iterateClientsAndTest(t, testExecutePerDevice)
+}
+
+func testExecutePerDevice(t *testing.T, client *pjrt.Client) {
+	const numReplicas = 2
+	if client.NumDevices() < numReplicas {
+		t.Skipf("Skipping test: not enough devices: %d < %d", client.NumDevices(), numReplicas)
+		return
+	}
+
+	// f(x, w) = (x*w, x+w), with one x per replica, and w broadcast to all replicas.
+	b := New(t.Name()).WithNumReplicas(numReplicas)
+	fn := b.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2)))
+	w := must1(fn.NamedInput("w", shapes.Make(dtypes.F32, 2)))
+	must(fn.Return(must1(Multiply(x, w)), must1(Add(x, w))))
+	program := must1(b.Build())
+	exec := must1(client.Compile().WithStableHLO(program).WithSPMD(numReplicas).Done())
+	defer func() { must(exec.Destroy()) }()
+
+	devices := must1(exec.AddressableDevices())
+	if len(devices) != numReplicas {
+		t.Fatalf("expected %d addressable devices for the executable, got %d", numReplicas, len(devices))
+	}
+	x0 := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2}, []int{2}).
+		ToDevice(devices[0]).Done())
+	x1 := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{3, 4}, []int{2}).
+		ToDevice(devices[1]).Done())
+	wBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{10, 100}, []int{2}).
+		ToDevice(devices[0]).Done())
+
+	outputs := must1(exec.ExecutePerDevice([][]*pjrt.Buffer{{x0, nil}, {x1, nil}}).
+		BroadcastInput(1, wBuf).
+		DonePerDevice())
+	if len(outputs) != numReplicas {
+		t.Fatalf("expected outputs for %d devices, got %d", numReplicas, len(outputs))
+	}
+	requireBuffersEqual(t, []FlatAndDims{
+		{[]float32{10, 200}, []int{2}},
+		{[]float32{11, 102}, []int{2}},
+	}, outputs[0])
+	requireBuffersEqual(t, []FlatAndDims{
+		{[]float32{30, 400}, []int{2}},
+		{[]float32{13, 104}, []int{2}},
+	}, outputs[1])
+
+	// The broadcast buffer is not donated by default, so it is still valid.
+	wFlat, _ := must2(pjrt.BufferToArray[float32](wBuf))
+	if len(wFlat) != 2 || wFlat[0] != 10 || wFlat[1] != 100 {
+		t.Errorf("broadcast buffer changed after execution: %v", wFlat)
+	}
+
+	// Invalid configurations.
+	_, err := exec.ExecutePerDevice([][]*pjrt.Buffer{{x0, nil}, {x1}}).DonePerDevice()
+	if err == nil {
+		t.Errorf("expected error for devices with different number of inputs")
+	}
+	_, err = exec.ExecutePerDevice([][]*pjrt.Buffer{{x0, wBuf}, {x1, nil}}).BroadcastInput(1, wBuf).DonePerDevice()
+	if err == nil {
+		t.Errorf("expected error for broadcasting an input that is already set")
+	}
+	_, err = exec.Execute(x0, wBuf, x1, wBuf).BroadcastInput(1, wBuf).Done()
+	if err == nil {
+		t.Errorf("expected error for BroadcastInput without ExecutePerDevice")
+	}
+	_, err = exec.ExecutePerDevice([][]*pjrt.Buffer{{x0, wBuf}}).DonePerDevice()
+	if err == nil {
+		t.Errorf("expected error for the wrong number of devices")
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/ffi"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+func TestFFI(t *testing.T) {
+	iterateClientsAndTest(t, testFFI)
+}
+
+func testFFI(t *testing.T, client *pjrt.Client) {
+	if !client.Plugin().HasFFI() {
+		t.Skipf("Plugin %s doesn't support FFI", client.Plugin())
+		return
+	}
+	xShape := shapes.Make(dtypes.F32, 3)
+
+	t.Run("HostCallback", func(t *testing.T) {
+		var logged []float32
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", xShape))
+		outputs, unregister := must2(HostCallback(fn, func(call *ffi.CallFrame) error {
+			in := must1(ffi.Flat[float32](call.Inputs[0]))
+			out := must1(ffi.Flat[float32](call.Outputs[0]))
+			logged = append(logged, in...)
+			for i, v := range in {
+				out[i] = 2 * v
+			}
+			return nil
+		}, []*Value{x}, []shapes.Shape{xShape}))
+		defer unregister()
+		must(fn.Return(must1(Negate(outputs[0]))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		x0 := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2, 3}, []int{3}).Done())
+		outputBuffers := compileAndExecute(t, client, program, x0)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{-2, -4, -6}, []int{3}}}, outputBuffers)
+		if len(logged) != 3 {
+			t.Errorf("host callback got %v, expected the 3 input values", logged)
+		}
+	})
+
+	t.Run("HostCallbackError", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", xShape))
+		outputs, unregister := must2(HostCallback(fn, func(call *ffi.CallFrame) error {
+			return errors.New("host callback failure")
+		}, []*Value{x}, []shapes.Shape{xShape}))
+		defer unregister()
+		must(fn.Return(outputs[0]))
+		program := must1(builder.Build())
+		loadedExec := must1(client.Compile().WithStableHLO(program).Done())
+		defer func() { must(loadedExec.Destroy()) }()
+		x0 := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2, 3}, []int{3}).Done())
+		_, err := loadedExec.Execute(x0).DonateAll().Done()
+		if err == nil {
+			t.Fatalf("execution should fail with the host callback error")
+		}
+		fmt.Printf("\texpected error: %v\n", err)
+	})
+
+	t.Run("RegisterFFIHandler", func(t *testing.T) {
+		const target = "goxla_test_scale"
+		err := client.Plugin().RegisterFFIHandler(target, func(call *ffi.CallFrame) error {
+			scale, err := ffi.Attribute[float32](call, "scale")
+			if err != nil {
+				return err
+			}
+			offsets, err := ffi.Attribute[[]int64](call, "offsets")
+			if err != nil {
+				return err
+			}
+			in := must1(ffi.Flat[float32](call.Inputs[0]))
+			out := must1(ffi.Flat[float32](call.Outputs[0]))
+			for i, v := range in {
+				out[i] = scale*v + float32(offsets[i])
+			}
+			return nil
+		})
+		if err != nil {
+			// Test re-run (e.g. -count=2) on the same process: handlers can only be registered once.
+			t.Logf("RegisterFFIHandler(%q): %v", target, err)
+		}
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", xShape))
+		outputs := must1(CustomCallFFI(target, []*Value{x}, []shapes.Shape{xShape},
+			map[string]any{"scale": float32(10), "offsets": []int64{1, 2, 3}}))
+		must(fn.Return(outputs[0]))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		x0 := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2, 3}, []int{3}).Done())
+		outputBuffers := compileAndExecute(t, client, program, x0)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{11, 22, 33}, []int{3}}}, outputBuffers)
+	})
+}
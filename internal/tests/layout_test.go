@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestLayouts(t *testing.T) {
+	iterateClientsAndTest(t, testLayouts)
+}
+
+// float32Bytes returns the bytes of the given values.
+func float32Bytes(values []float32) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(values))), len(values)*4)
+}
+
+func testLayouts(t *testing.T, client *pjrt.Client) {
+	// x is [2, 3] given in column-major order: the logical value is [[1, 2, 3], [4, 5, 6]].
+	xColumnMajor := []float32{1, 4, 2, 5, 3, 6}
+	xShape := shapes.Make(dtypes.F32, 2, 3)
+
+	t.Run("Transfers", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", xShape))
+		must(fn.Return(must1(Negate(x))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		x0 := must1(client.BufferFromHost().
+			FromFlatDataWithDimensions(xColumnMajor, xShape.Dimensions).
+			WithHostLayout(shapes.ColumnMajorLayout(2)).
+			Done())
+		outputs := compileAndExecute(t, client, program, x0)
+
+		// Transfer back with a column-major layout.
+		got := make([]float32, 6)
+		must(outputs[0].ToHostWithLayout(float32Bytes(got), shapes.ColumnMajorLayout(2)))
+		if want := []float32{-1, -4, -2, -5, -3, -6}; !slices.Equal(got, want) {
+			t.Errorf("expected column-major %v, got %v", want, got)
+		}
+
+		// Default transfer is row-major.
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{-1, -2, -3, -4, -5, -6}, []int{2, 3}}}, outputs)
+	})
+
+	t.Run("Function", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInputWithLayout("x", xShape, shapes.ColumnMajorLayout(2)))
+		must(fn.ReturnWithLayouts([]*Value{must1(Negate(x))}, []*shapes.Layout{shapes.ColumnMajorLayout(2)}))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		x0 := must1(client.BufferFromHost().
+			FromFlatDataWithDimensions(xColumnMajor, xShape.Dimensions).
+			WithHostLayout(shapes.ColumnMajorLayout(2)).
+			WithDeviceLayout(shapes.ColumnMajorLayout(2)).
+			Done())
+		outputs := compileAndExecute(t, client, program, x0)
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{-1, -2, -3, -4, -5, -6}, []int{2, 3}}}, outputs)
+	})
+
+	t.Run("CompileConfig", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", xShape))
+		must(fn.Return(must1(Negate(x))))
+		program := must1(builder.Build())
+		loadedExec := must1(client.Compile().WithStableHLO(program).
+			WithArgumentLayouts([]shapes.Shape{xShape}, []*shapes.Layout{shapes.ColumnMajorLayout(2)}).
+			WithResultLayouts([]shapes.Shape{xShape}, []*shapes.Layout{shapes.ColumnMajorLayout(2)}).
+			Done())
+		defer func() { must(loadedExec.Destroy()) }()
+		// The device buffer must match the argument layout.
+		x0 := must1(client.BufferFromHost().
+			FromFlatDataWithDimensions([]float32{1, 2, 3, 4, 5, 6}, xShape.Dimensions).
+			WithDeviceLayout(shapes.ColumnMajorLayout(2)).
+			Done())
+		outputs := must1(loadedExec.Execute(x0).DonateAll().Done())
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{-1, -2, -3, -4, -5, -6}, []int{2, 3}}}, outputs)
+	})
+}
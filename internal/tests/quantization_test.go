@@ -11,22 +11,35 @@ import (
 	"github.com/gomlx/go-xla/types/shapes"
 )
 
-var flagQuantization = flag.Bool("quant", false, "Include quantization tests: disabled by default since default CPU PJRT currently doesn't support it.")
+var flagQuantization = flag.Bool("quant", false, "Include native quantization tests (without lowering): disabled by default since default CPU PJRT currently doesn't support it.")
 
 // TestQuantization tests quantization support in stablehlo.
-// It creates a multiplication operation, sets quantization metadata on the output,
-// and then converts it to int8 storage type before returning.
+// It quantizes a constant per-axis, and dequantizes it back.
 //
-// Test currently broken, since PJRT currently doesn't seem to include the quantization lowering passes.
+// By default the quantized ops are lowered to plain integer arithmetic (see Builder.WithQuantizationLowering),
+// since PJRT currently doesn't seem to include the quantization lowering passes.
+// With -quant it also runs the native (not lowered) version.
 func TestQuantization(t *testing.T) {
-	if !*flagQuantization {
-		t.Skip("Quantization tests skipped -- to enable them use -quant")
+	lowerings := []bool{true}
+	if *flagQuantization {
+		lowerings = append(lowerings, false)
 	}
-	iterateClientsAndTest(t, func(t *testing.T, client *pjrt.Client) {
+	for _, lower := range lowerings {
+		t.Run(fmt.Sprintf("lower=%v", lower), func(t *testing.T) {
+			iterateClientsAndTest(t, func(t *testing.T, client *pjrt.Client) {
+				testQuantization(t, client, lower)
+			})
+		})
+	}
+}
+
+func testQuantization(t *testing.T, client *pjrt.Client, lower bool) {
+	t.Run("per-axis", func(t *testing.T) {
 		builder := New("quantization_test")
+		if lower {
+			builder.WithQuantizationLowering()
+		}
 		fn := builder.Main()
-
-		// Create two constants
 		quantization0 := &shapes.Quantization{
 			StorageType:   dtypes.Int8,
 			ExpressedType: dtypes.Float32,
@@ -35,51 +48,46 @@ func TestQuantization(t *testing.T) {
 			QuantizedAxes: []int{0},
 		}
 		c1 := must1(fn.ConstantFromFlatAndDimensions([]float32{4.0, 15.0}, 2))
-		// c2 := must1(fn.ConstantFromFlatAndDimensions([]float32{5.0, 2.0}, 2))
 		c1 = must1(UniformQuantize(c1, c1.Shape().WithQuantization(quantization0)))
-		// c2 = must1(UniformQuantize(c2, c2.Shape().WithQuantization(quantization0)))
-
-		// Multiply them and update quantization metadata on the output.
-		// product := must1(Multiply(c1, c2))
-		// quantization1 := shapes.UniformQuantization(dtypes.Int8, dtypes.Float32, 0.2, 0)
-		// product = must1(product.WithQuantization(quantization1))
-		// fmt.Printf("product.shape=%s\n", product.Shape())
-
-		// Return the quantized value (now in int8 storage format)
-		must(fn.Return(must1(UniformDequantize(c1))))
-		// must(fn.Return(must1(UniformDequantize(product))))
-
-		// Build and compile
+		// The quantized value is returned in its storage type.
+		must(fn.Return(c1, must1(UniformDequantize(c1))))
 		program := must1(builder.Build())
-		fmt.Printf("Quantization test StableHLO:\n%s\n", string(program))
-
-		// Compile
-		loadedExec, err := client.Compile().WithStableHLO(program).Done()
-		must(err)
-		defer func() {
-			must(loadedExec.Destroy())
-		}()
-
-		// Execute with no inputs (since we're using constants)
-		outputBuffers, err := loadedExec.Execute().Done()
-		must(err)
-		defer func() {
-			for _, b := range outputBuffers {
-				must(b.Destroy())
-			}
-		}()
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+		outputs := compileAndExecute(t, client, program)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]int8{10, 10}, []int{2}}, // 4.0/0.1-30 and 15.0/0.5-20
+			{[]float32{4, 15}, []int{2}},
+		}, outputs)
+	})
 
-		// Check that the output is int8
-		if len(outputBuffers) != 1 {
-			t.Fatalf("expected 1 output buffer, got %d", len(outputBuffers))
+	t.Run("dot", func(t *testing.T) {
+		builder := New("quantization_dot_test")
+		if lower {
+			builder.WithQuantizationLowering()
 		}
-		if outputDType := must1(outputBuffers[0].DType()); outputDType != dtypes.Int8 {
-			t.Errorf("expected output dtype to be Int8, got %v", outputDType)
+		fn := builder.Main()
+		xQuantization := shapes.UniformQuantization(dtypes.Int8, dtypes.Float32, 0.5, 0)
+		wQuantization := &shapes.Quantization{
+			StorageType:   dtypes.Int8,
+			ExpressedType: dtypes.Float32,
+			Scales:        []float64{0.1, 0.2, 1, 2},
+			ZeroPoints:    []int64{0, 0, 0, 0},
+			QuantizedAxes: []int{0},
+			BlockSizes:    []int64{1},
 		}
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 4).WithQuantization(xQuantization)))
+		w := must1(fn.NamedInput("w", shapes.Make(dtypes.Float32, 4, 2).WithQuantization(wQuantization)))
+		y := must1(DotGeneral(x, []int{0}, nil, w, []int{0}, nil).Done())
+		y = must1(y.WithQuantization(shapes.UniformQuantization(dtypes.Int8, dtypes.Float32, 0.25, -10)))
+		must(fn.Return(must1(UniformDequantize(y))))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
 
-		got := must1(pjrt.BufferToScalar[int8](outputBuffers[0]))
-		if got != 60 { // 2.0 * 3.0 = 6.0, quantized with scale 0.1 and zero point 0: 6.0 / 0.1 = 60
-			t.Errorf("expected 60 output, got %d", got)
-		}
+		// Values of x: {1, 2, 3, 4} * 0.5; values of w: row i is {1, 2} * scale_i.
+		xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int8{1, 2, 3, 4}, []int{4}).Done())
+		wBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]int8{1, 2, 1, 2, 1, 2, 1, 2}, []int{4, 2}).Done())
+		outputs := compileAndExecute(t, client, program, xBuf, wBuf)
+		// sum_i x_i*scale_i = 0.5*(0.1 + 0.4 + 3 + 8) = 5.75; and twice that for the second column, 11.5.
+		requireBuffersEqual(t, []FlatAndDims{{[]float32{5.75, 11.5}, []int{2}}}, outputs)
 	})
 }
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+)
+
+func TestShardedArray(t *testing.T) {
+	iterateClientsAndTest(t, testShardedArray)
+}
+
+func testShardedArray(t *testing.T, client *pjrt.Client) {
+	const numDevices = 2
+	if client.NumDevices() < numDevices {
+		t.Skipf("Skipping test: not enough devices: %d < %d", client.NumDevices(), numDevices)
+		return
+	}
+	mesh, deviceAssignment := must2(client.NewDeviceMesh("mesh", []int{numDevices}, []string{"data"}))
+	dataSpec := shardy.NewShardingSpec(mesh).AddShardedAxis("data")
+	flat := []float32{0, 1, 2, 3, 4, 5, 6, 7}
+
+	// Sharded creation and gathering back.
+	x := must1(pjrt.ArrayToShardedArray(client, dataSpec, deviceAssignment, flat, 4, 2))
+	defer func() { must(x.Destroy()) }()
+	if x.NumDevices() != numDevices {
+		t.Fatalf("expected %d devices, got %d", numDevices, x.NumDevices())
+	}
+	for i, buffer := range x.Buffers() {
+		dims := must1(buffer.Dimensions())
+		if !slices.Equal(dims, []int{2, 2}) {
+			t.Errorf("shard of logical device #%d: expected dimensions [2 2], got %v", i, dims)
+		}
+	}
+	gotFlat, gotDims := must2(pjrt.ShardedArrayToArray[float32](x))
+	if !slices.Equal(gotFlat, flat) || !slices.Equal(gotDims, []int{4, 2}) {
+		t.Errorf("ShardedArrayToArray: expected %v %v, got %v %v", flat, []int{4, 2}, gotFlat, gotDims)
+	}
+
+	// Execution with ShardedArray as input and output.
+	builder := stablehlo.New(t.Name()).WithShardy(mesh)
+	fn := builder.Main()
+	input := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 4, 2), dataSpec))
+	must(fn.ReturnWithShardingAndAttributes([]*stablehlo.Value{must1(stablehlo.Negate(input))},
+		[]*shardy.ShardingSpec{dataSpec}, nil))
+	program := must1(builder.Build())
+	exec := must1(client.Compile().WithStableHLO(program).WithShardy(numDevices).
+		WithDeviceAssignment(deviceAssignment).Done())
+	defer func() { must(exec.Destroy()) }()
+	outputs := must1(exec.ExecuteSharded(x).DoneSharded(dataSpec))
+	negated := outputs[0]
+	if !negated.Shape().Equal(shapes.Make(dtypes.F32, 4, 2)) {
+		t.Errorf("expected output global shape (Float32)[4 2], got %s", negated.Shape())
+	}
+	gotFlat, _ = must2(pjrt.ShardedArrayToArray[float32](negated))
+	if !slices.Equal(gotFlat, []float32{0, -1, -2, -3, -4, -5, -6, -7}) {
+		t.Errorf("unexpected negated values %v", gotFlat)
+	}
+	must(negated.Destroy())
+
+	// Resharding to replicated: each device holds the full array.
+	replicated := must1(x.Reshard(shardy.NewShardingSpec(mesh)))
+	defer func() { must(replicated.Destroy()) }()
+	for i, buffer := range replicated.Buffers() {
+		shardFlat, shardDims := must2(pjrt.BufferToArray[float32](buffer))
+		if !slices.Equal(shardFlat, flat) || !slices.Equal(shardDims, []int{4, 2}) {
+			t.Errorf("replicated shard of logical device #%d: expected %v, got %v %v", i, flat, shardFlat, shardDims)
+		}
+	}
+	gotFlat, _ = must2(pjrt.ShardedArrayToArray[float32](replicated))
+	if !slices.Equal(gotFlat, flat) {
+		t.Errorf("ShardedArrayToArray of the resharded array: expected %v, got %v", flat, gotFlat)
+	}
+
+	// Invalid inputs.
+	if _, err := pjrt.ArrayToShardedArray(client, dataSpec, deviceAssignment, flat[:6], 3, 2); err == nil {
+		t.Errorf("expected error for an axis not divisible by the number of shards")
+	}
+	if _, err := pjrt.NewShardedArray(dataSpec, shapes.Make(dtypes.F32, 4, 2), deviceAssignment,
+		replicated.Buffers()); err == nil {
+		t.Errorf("expected error for buffers with the wrong shard shape")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/go-xla/pjrt"
 	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types"
 	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/gomlx/go-xla/types/shardy"
 )
@@ -84,6 +85,32 @@ func testShardy(t *testing.T, client *pjrt.Client) {
 		}, outputs)
 	})
 
+	t.Run("topology-device-mesh", func(t *testing.T) {
+		mesh, meshDeviceAssignment, err := client.NewDeviceMesh("mesh", []int{numReplicas}, []string{"data"})
+		must(err)
+		builder := stablehlo.New(t.Name()).WithShardy(mesh)
+		fn := builder.Main()
+		x := must1(fn.NamedInputWithSharding("arg0", shapes.Make(dtypes.F32, 2, 3),
+			builder.NewShardingSpec().AddShardedAxis("data")))
+		output := must1(stablehlo.Negate(x))
+		must(fn.Return(output))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		x0 := must1(client.BufferFromHost().
+			ToDeviceNum(meshDeviceAssignment[0]).
+			FromFlatDataWithDimensions([]float32{0, 1, 2}, []int{1, 3}).
+			Done())
+		x1 := must1(client.BufferFromHost().
+			ToDeviceNum(meshDeviceAssignment[1]).
+			FromFlatDataWithDimensions([]float32{3, 4, 5}, []int{1, 3}).
+			Done())
+		outputs := shardyCompileAndExecute(t, client, program, meshDeviceAssignment, x0, x1)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{0, -1, -2}, []int{1, 3}},
+			{[]float32{-3, -4, -5}, []int{1, 3}},
+		}, outputs)
+	})
+
 	t.Run("output-data-sharding", func(t *testing.T) {
 		mesh := must1(shardy.NewDeviceMesh("data_mesh", []int{2}, []string{"data"}))
 		builder := stablehlo.New(t.Name()).WithShardy(mesh)
@@ -114,4 +141,64 @@ func testShardy(t *testing.T, client *pjrt.Client) {
 		}, outputs)
 	})
 
+	t.Run("sharding-constraint-and-reshard", func(t *testing.T) {
+		mesh := must1(shardy.NewDeviceMesh("mesh", []int{2}, []string{"data"}))
+		builder := stablehlo.New(t.Name()).WithShardy(mesh)
+		fn := builder.Main()
+		// Replicated input: each device holds the full value.
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2, 3)))
+		y := must1(stablehlo.ShardingConstraint(x, builder.NewShardingSpec().AddShardedAxis("data")))
+		y = must1(must1(stablehlo.Negate(y)).WithSharding(builder.NewShardingSpec().AddShardedAxis("data")))
+		y = must1(stablehlo.Reshard(y, builder.NewShardingSpec()))
+		must(fn.Return(y))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		inputs := make([]*pjrt.Buffer, numReplicas)
+		for i := range inputs {
+			inputs[i] = must1(client.BufferFromHost().
+				ToDeviceNum(deviceAssignment[i]).
+				FromFlatDataWithDimensions([]float32{0, 1, 2, 3, 4, 5}, []int{2, 3}).
+				Done())
+		}
+		outputs := shardyCompileAndExecute(t, client, program, deviceAssignment, inputs...)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{0, -1, -2, -3, -4, -5}, []int{2, 3}},
+			{[]float32{0, -1, -2, -3, -4, -5}, []int{2, 3}},
+		}, outputs)
+	})
+
+	t.Run("manual-computation", func(t *testing.T) {
+		mesh := must1(shardy.NewDeviceMesh("mesh", []int{2}, []string{"data"}))
+		builder := stablehlo.New(t.Name()).WithShardy(mesh)
+		fn := builder.Main()
+		spec := builder.NewShardingSpec().AddShardedAxis("data")
+		x := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 2, 3), spec))
+		mc := must1(stablehlo.ManualComputation([]string{"data"}, []*shardy.ShardingSpec{spec}, x))
+		body := mc.Body()
+		addFn := body.Closure()
+		lhs := must1(addFn.NamedInput("lhs", shapes.Make(dtypes.F32)))
+		rhs := must1(addFn.NamedInput("rhs", shapes.Make(dtypes.F32)))
+		must(addFn.Return(must1(stablehlo.Add(lhs, rhs))))
+		groups := must1(mc.ReplicaGroups("data"))
+		config := &types.CollectiveConfig{ChannelType: types.CrossPartition, UseGlobalDeviceIDs: true}
+		sum := must1(stablehlo.AllReduce(body.Inputs, groups, addFn, config))
+		// The sum is the same in all devices: replicated output.
+		outputs := must1(mc.Done([]*shardy.ShardingSpec{builder.NewShardingSpec()}, sum...))
+		must(fn.Return(outputs...))
+		program := must1(builder.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		x0 := must1(client.BufferFromHost().
+			ToDeviceNum(deviceAssignment[0]).
+			FromFlatDataWithDimensions([]float32{0, 1, 2}, []int{1, 3}).
+			Done())
+		x1 := must1(client.BufferFromHost().
+			ToDeviceNum(deviceAssignment[1]).
+			FromFlatDataWithDimensions([]float32{0, 0.1, 0.2}, []int{1, 3}).
+			Done())
+		outputBuffers := shardyCompileAndExecute(t, client, program, deviceAssignment, x0, x1)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{0, 1.1, 2.2}, []int{1, 3}},
+			{[]float32{0, 1.1, 2.2}, []int{1, 3}},
+		}, outputBuffers)
+	})
 }
@@ -0,0 +1,426 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestVJP(t *testing.T) {
+	iterateClientsAndTest(t, testVJP)
+}
+
+// callFromMain makes the main function of the builder call fn with its inputs, and return its outputs.
+func callFromMain(b *Builder, fn *Function) {
+	mainFn := b.Main()
+	inputs := make([]*Value, len(fn.Inputs))
+	for i, input := range fn.Inputs {
+		inputs[i] = must1(mainFn.Input(input.Shape()))
+	}
+	must(mainFn.Return(must1(Call(fn, inputs...))...))
+}
+
+func testVJP(t *testing.T, client *pjrt.Client) {
+	t.Run("square and sum", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.NewFunction("f")
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		sumFn := fn.Closure()
+		lhs := must1(sumFn.Input(shapes.Make(dtypes.F32)))
+		rhs := must1(sumFn.Input(shapes.Make(dtypes.F32)))
+		must(sumFn.Return(must1(Add(lhs, rhs))))
+		must(fn.Return(must1(Reduce(must1(Multiply(x, x)), zero, sumFn, 0))))
+		callFromMain(b, must1(VJP(fn, "grad", 0)))
+		program := must1(b.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2, 3}, []int{3}).Done())
+		ctBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{2}, []int{}).Done())
+		outputs := compileAndExecute(t, client, program, xBuf, ctBuf)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{14}, nil},
+			{[]float32{4, 8, 12}, []int{3}},
+		}, outputs)
+	})
+
+	t.Run("matmul", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.NewFunction("f")
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 2, 3)))
+		w := must1(fn.NamedInput("w", shapes.Make(dtypes.F32, 3, 2)))
+		must(fn.Return(must1(DotGeneral(x, []int{1}, nil, w, []int{0}, nil).Done())))
+		callFromMain(b, must1(VJP(fn, "grad", 0, 1)))
+		program := must1(b.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 2, 3, 4, 5, 6}, []int{2, 3}).Done())
+		wBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 0, 0, 1, 1, 1}, []int{3, 2}).Done())
+		ctBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{1, 1, 1, 1}, []int{2, 2}).Done())
+		outputs := compileAndExecute(t, client, program, xBuf, wBuf, ctBuf)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{4, 5, 10, 11}, []int{2, 2}},
+			// dx = ct @ w^T: each row is the row sums of w.
+			{[]float32{1, 1, 2, 1, 1, 2}, []int{2, 3}},
+			// dw = x^T @ ct: each row is the column sums of x.
+			{[]float32{5, 5, 7, 7, 9, 9}, []int{3, 2}},
+		}, outputs)
+	})
+
+	t.Run("slice and concatenate", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.NewFunction("f")
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4)))
+		sliced := must1(Slice(x, []int{0}, []int{4}, []int{2}))
+		must(fn.Return(must1(Concatenate(0, sliced, must1(Sine(x))))))
+		callFromMain(b, must1(VJP(fn, "grad", 0)))
+		program := must1(b.Build())
+		fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+
+		xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions([]float32{0, 0, 0, 0}, []int{4}).Done())
+		ctBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions(
+			[]float32{10, 20, 1, 2, 3, 4}, []int{6}).Done())
+		outputs := compileAndExecute(t, client, program, xBuf, ctBuf)
+		requireBuffersEqual(t, []FlatAndDims{
+			{[]float32{0, 0, 0, 0, 0, 0}, []int{6}},
+			// cos(0) = 1, plus the strided slice cotangents on positions 0 and 2.
+			{[]float32{11, 2, 23, 4}, []int{4}},
+		}, outputs)
+	})
+
+	t.Run("finite differences", func(t *testing.T) {
+		for _, check := range vjpChecks() {
+			t.Run(check.name, func(t *testing.T) {
+				checkVJP(t, client, check)
+			})
+		}
+	})
+}
+
+// vjpCheck is a function whose VJP is compared against finite differences, see checkVJP.
+type vjpCheck struct {
+	name string
+
+	// inputs are the shapes of the inputs of the function, all Float64: the VJP is taken with respect to all of them.
+	inputs []shapes.Shape
+
+	// values of the inputs. If nil, pseudo-random values in [0.5, 1.5) are used.
+	values [][]float64
+
+	// build the function: it must return Float64 outputs.
+	build func(fn *Function, inputs []*Value) []*Value
+}
+
+// buildVJPCheck builds the program of the check: the main function takes the inputs followed by the cotangents of
+// the outputs, and returns the outputs followed by the cotangents of the inputs, see VJP.
+func buildVJPCheck(t *testing.T, check vjpCheck) (program []byte, outputShapes []shapes.Shape) {
+	b := New(t.Name())
+	fn := b.NewFunction("f")
+	inputs := make([]*Value, len(check.inputs))
+	wrt := make([]int, len(check.inputs))
+	for i, shape := range check.inputs {
+		inputs[i] = must1(fn.NamedInput(fmt.Sprintf("x%d", i), shape))
+		wrt[i] = i
+	}
+	outputs := check.build(fn, inputs)
+	must(fn.Return(outputs...))
+	for _, output := range outputs {
+		outputShapes = append(outputShapes, output.Shape())
+	}
+	callFromMain(b, must1(VJP(fn, "grad", wrt...)))
+	return must1(b.Build()), outputShapes
+}
+
+// checkVJP compares the VJP of the function of the check with the central finite differences of the function:
+// for each input element x_i, sum_j ct_j * (f_j(x + eps*e_i) - f_j(x - eps*e_i)) / (2*eps), where ct are
+// pseudo-random cotangents of the outputs.
+func checkVJP(t *testing.T, client *pjrt.Client, check vjpCheck) {
+	const (
+		eps       = 1e-6
+		tolerance = 1e-5
+	)
+	program, outputShapes := buildVJPCheck(t, check)
+	loadedExec, err := client.Compile().WithStableHLO(program).Done()
+	if err != nil {
+		t.Fatalf("failed to compile program: \n%s\nError: %v", withLines(program), err)
+	}
+	defer func() { must(loadedExec.Destroy()) }()
+
+	rng := rand.New(rand.NewPCG(42, uint64(len(check.name))))
+	values := check.values
+	if values == nil {
+		values = make([][]float64, len(check.inputs))
+		for i, shape := range check.inputs {
+			values[i] = make([]float64, shape.Size())
+			for j := range values[i] {
+				values[i][j] = 0.5 + rng.Float64()
+			}
+		}
+	}
+	shapesAndValues := slices.Clone(check.inputs)
+	cotangents := make([][]float64, len(outputShapes))
+	for i, shape := range outputShapes {
+		shapesAndValues = append(shapesAndValues, shape)
+		cotangents[i] = make([]float64, shape.Size())
+		for j := range cotangents[i] {
+			cotangents[i][j] = 2*rng.Float64() - 1
+		}
+	}
+
+	// run executes the program with the given input values, and returns the flat values of all its outputs.
+	run := func(inputValues [][]float64) [][]float64 {
+		allValues := append(slices.Clone(inputValues), cotangents...)
+		buffers := make([]*pjrt.Buffer, len(allValues))
+		for i, flat := range allValues {
+			buffers[i] = must1(client.BufferFromHost().
+				FromFlatDataWithDimensions(flat, shapesAndValues[i].Dimensions).Done())
+		}
+		outputs := must1(loadedExec.Execute(buffers...).DonateAll().Done())
+		results := make([][]float64, len(outputs))
+		for i, output := range outputs {
+			results[i], _ = must2(pjrt.BufferToArray[float64](output))
+			must(output.Destroy())
+		}
+		return results
+	}
+
+	grads := run(values)[len(outputShapes):]
+	for inputIdx := range values {
+		for elementIdx := range values[inputIdx] {
+			perturbed := make([][]float64, len(values))
+			for i := range values {
+				perturbed[i] = slices.Clone(values[i])
+			}
+			perturbed[inputIdx][elementIdx] = values[inputIdx][elementIdx] + eps
+			plus := run(perturbed)
+			perturbed[inputIdx][elementIdx] = values[inputIdx][elementIdx] - eps
+			minus := run(perturbed)
+			var numeric float64
+			for outputIdx, ct := range cotangents {
+				for j, ctValue := range ct {
+					numeric += ctValue * (plus[outputIdx][j] - minus[outputIdx][j]) / (2 * eps)
+				}
+			}
+			got := grads[inputIdx][elementIdx]
+			if math.Abs(got-numeric) > tolerance*math.Max(1, math.Abs(numeric)) {
+				t.Errorf("VJP of input #%d element #%d: got %g, finite differences give %g",
+					inputIdx, elementIdx, got, numeric)
+			}
+		}
+	}
+}
+
+// vjpChecks returns the checks of the op families supported by VJP.
+func vjpChecks() []vjpCheck {
+	f64 := func(dimensions ...int) shapes.Shape { return shapes.Make(dtypes.Float64, dimensions...) }
+	scalarClosure := func(fn *Function, op func(lhs, rhs *Value) (*Value, error)) *Function {
+		closure := fn.Closure()
+		lhs := must1(closure.Input(f64()))
+		rhs := must1(closure.Input(f64()))
+		if op == nil {
+			// Returns the second operand, used by scatter to replace values.
+			must(closure.Return(rhs))
+		} else {
+			must(closure.Return(must1(op(lhs, rhs))))
+		}
+		return closure
+	}
+	var checks []vjpCheck
+
+	unaryOps := []struct {
+		name string
+		op   func(*Value) (*Value, error)
+	}{
+		{"Negate", Negate}, {"Abs", Abs}, {"Exponential", Exponential},
+		{"ExponentialMinusOne", ExponentialMinusOne}, {"Log", Log}, {"LogPlusOne", LogPlusOne}, {"Sqrt", Sqrt},
+		{"Rsqrt", Rsqrt}, {"Cbrt", Cbrt}, {"Tanh", Tanh}, {"Logistic", Logistic}, {"Sine", Sine},
+		{"Cosine", Cosine}, {"Tan", Tan}, {"Erf", Erf},
+	}
+	for _, unary := range unaryOps {
+		checks = append(checks, vjpCheck{
+			name:   unary.name,
+			inputs: []shapes.Shape{f64(4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{must1(unary.op(x[0]))}
+			},
+		})
+	}
+	binaryOps := []struct {
+		name string
+		op   func(lhs, rhs *Value) (*Value, error)
+	}{
+		{"Add", Add}, {"Subtract", Subtract}, {"Multiply", Multiply}, {"Divide", Divide}, {"Power", Power},
+		{"Maximum", Maximum}, {"Minimum", Minimum}, {"Atan2", Atan2}, {"Remainder", Remainder},
+	}
+	for _, binary := range binaryOps {
+		checks = append(checks, vjpCheck{
+			name:   binary.name,
+			inputs: []shapes.Shape{f64(4), f64(4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{must1(binary.op(x[0], x[1]))}
+			},
+		})
+	}
+
+	checks = append(checks,
+		vjpCheck{
+			name:   "Clamp",
+			inputs: []shapes.Shape{f64(), f64(4), f64()},
+			values: [][]float64{{0.8}, {0.6, 0.9, 1.1, 1.4}, {1.2}},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{must1(Clamp(x[0], x[1], x[2]))}
+			},
+		},
+		vjpCheck{
+			name:   "Select",
+			inputs: []shapes.Shape{f64(4), f64(4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				pred := must1(Compare(x[0], x[1], types.CompareGT, types.CompareFloat))
+				return []*Value{must1(Select(pred, must1(Sine(x[0])), must1(Multiply(x[0], x[1]))))}
+			},
+		},
+		vjpCheck{
+			name:   "Reshape and Reverse",
+			inputs: []shapes.Shape{f64(2, 3)},
+			build: func(fn *Function, x []*Value) []*Value {
+				reversed := must1(Reverse(must1(Multiply(x[0], x[0])), 1))
+				return []*Value{must1(Reshape(reversed, f64(3, 2)))}
+			},
+		},
+		vjpCheck{
+			name:   "Transpose",
+			inputs: []shapes.Shape{f64(2, 3, 4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{must1(Transpose(must1(Sine(x[0])), 2, 0, 1))}
+			},
+		},
+		vjpCheck{
+			name:   "BroadcastInDim",
+			inputs: []shapes.Shape{f64(), f64(3, 1), f64(2, 3)},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{
+					// Scalar broadcast.
+					must1(BroadcastInDim(x[0], f64(2, 2), nil)),
+					// Expansion of an axis of size 1, and a new axis.
+					must1(BroadcastInDim(must1(Sine(x[1])), f64(2, 3, 4), []int{1, 2})),
+					// Transposed axes.
+					must1(BroadcastInDim(x[2], f64(3, 4, 2), []int{2, 0})),
+				}
+			},
+		},
+		vjpCheck{
+			name:   "Slice and Concatenate",
+			inputs: []shapes.Shape{f64(3, 5), f64(2, 3)},
+			build: func(fn *Function, x []*Value) []*Value {
+				sliced := must1(Slice(must1(Sine(x[0])), []int{0, 1}, []int{3, 5}, []int{2, 2}))
+				return []*Value{must1(Concatenate(1, sliced, must1(Multiply(x[1], x[1]))))}
+			},
+		},
+		vjpCheck{
+			name:   "Pad",
+			inputs: []shapes.Shape{f64(2, 3), f64()},
+			build: func(fn *Function, x []*Value) []*Value {
+				padded := must1(Pad(x[0], x[1], []int{1, -1}, []int{0, 2}, []int{1, 0}))
+				return []*Value{must1(Multiply(padded, padded))}
+			},
+		},
+		vjpCheck{
+			name:   "DotGeneral",
+			inputs: []shapes.Shape{f64(2, 3, 4), f64(2, 4, 5)},
+			build: func(fn *Function, x []*Value) []*Value {
+				return []*Value{must1(DotGeneral(x[0], []int{2}, []int{0}, x[1], []int{1}, []int{0}).Done())}
+			},
+		},
+		vjpCheck{
+			name:   "Convolution",
+			inputs: []shapes.Shape{f64(2, 2, 5, 6), f64(3, 2, 3, 2)},
+			build: func(fn *Function, x []*Value) []*Value {
+				// Input in NCHW layout, kernel in OIHW layout, with strides, padding and kernel dilation.
+				spatialAxes := []int{2, 3}
+				return []*Value{must1(Convolution(x[0], x[1],
+					[]int{2, 1}, [][2]int{{1, 1}, {0, 1}}, nil, []int{1, 2},
+					0, 1, spatialAxes,
+					1, 0, spatialAxes,
+					0, 1, spatialAxes,
+					1, 1,
+					types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))}
+			},
+		},
+		vjpCheck{
+			name:   "Reduce",
+			inputs: []shapes.Shape{f64(3, 4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				zero := must1(fn.ConstantFromScalar(0.0))
+				lowest := must1(fn.ConstantFromScalar(math.Inf(-1)))
+				highest := must1(fn.ConstantFromScalar(math.Inf(1)))
+				squared := must1(Multiply(x[0], x[0]))
+				return []*Value{
+					must1(Reduce(squared, zero, scalarClosure(fn, Add), 1)),
+					must1(Reduce(squared, zero, scalarClosure(fn, Add), 0, 1)),
+					must1(Reduce(x[0], lowest, scalarClosure(fn, Maximum), 1)),
+					must1(Reduce(x[0], highest, scalarClosure(fn, Minimum), 0)),
+				}
+			},
+		},
+		vjpCheck{
+			name:   "Gather",
+			inputs: []shapes.Shape{f64(3, 4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				// Row 2 is gathered twice, so its cotangents are accumulated.
+				indices := must1(fn.ConstantFromFlatAndDimensions([]int32{2, 0, 2}, 3, 1))
+				gathered := must1(Gather(must1(Sine(x[0])), indices, 1,
+					[]int{1}, []int{0}, nil, nil, []int{0},
+					[]int{1, 4}, false))
+				return []*Value{gathered}
+			},
+		},
+		vjpCheck{
+			name:   "Scatter",
+			inputs: []shapes.Shape{f64(3, 4), f64(2, 4)},
+			build: func(fn *Function, x []*Value) []*Value {
+				indices := must1(fn.ConstantFromFlatAndDimensions([]int32{2, 0}, 2, 1))
+				scatter := func(updateFn *Function) *Value {
+					return must1(Scatter(must1(Sine(x[0])), indices, must1(Multiply(x[1], x[1])),
+						[]int{1}, []int{0}, nil, nil, []int{0}, 1,
+						true, true, updateFn))
+				}
+				return []*Value{scatter(scalarClosure(fn, Add)), scatter(scalarClosure(fn, nil))}
+			},
+		},
+		vjpCheck{
+			name:   "While",
+			inputs: []shapes.Shape{f64(3)},
+			build: func(fn *Function, x []*Value) []*Value {
+				counter := must1(fn.ConstantFromScalar(int32(0)))
+				cond := fn.Closure()
+				condCounter := must1(cond.Input(counter.Shape()))
+				must1(cond.Input(x[0].Shape()))
+				limit := must1(cond.ConstantFromScalar(int32(3)))
+				must(cond.Return(must1(Compare(condCounter, limit, types.CompareLT, types.CompareSigned))))
+				body := fn.Closure()
+				bodyCounter := must1(body.Input(counter.Shape()))
+				bodyX := must1(body.Input(x[0].Shape()))
+				one := must1(body.ConstantFromScalar(int32(1)))
+				must(body.Return(must1(Add(bodyCounter, one)), must1(Multiply(must1(Sine(bodyX)), bodyX))))
+				return []*Value{must1(While(cond, body, counter, x[0]))[1]}
+			},
+		},
+		vjpCheck{
+			name:   "OptimizationBarrier",
+			inputs: []shapes.Shape{f64(3), f64(3)},
+			build: func(fn *Function, x []*Value) []*Value {
+				barrier := must1(OptimizationBarrier(must1(Sine(x[0])), x[1]))
+				return []*Value{must1(Multiply(barrier[0], barrier[1]))}
+			},
+		},
+	)
+	return checks
+}
@@ -0,0 +1,340 @@
+package tests
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/pjrt"
+	. "github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestVmap(t *testing.T) {
+	iterateClientsAndTest(t, testVmap)
+}
+
+// buildVmapExample builds in fn a function that, for x (shape [3]) and w (shape [3, 2]), returns
+// tanh(x·w) and the number of doublings of sum(x) (in a While loop with a data-dependent trip count) to reach 100.
+func buildVmapExample(fn *Function) {
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+	w := must1(fn.NamedInput("w", shapes.Make(dtypes.F32, 3, 2)))
+	y := must1(Tanh(must1(DotGeneral(x, []int{0}, nil, w, []int{0}, nil).Done())))
+
+	zero := must1(fn.ConstantFromScalar(float32(0)))
+	sumFn := fn.Closure()
+	lhs := must1(sumFn.Input(shapes.Make(dtypes.F32)))
+	rhs := must1(sumFn.Input(shapes.Make(dtypes.F32)))
+	must(sumFn.Return(must1(Add(lhs, rhs))))
+	sum := must1(Reduce(x, zero, sumFn, 0))
+
+	cond := fn.Closure()
+	condSum := must1(cond.Input(sum.Shape()))
+	must1(cond.Input(shapes.Make(dtypes.Int32)))
+	limit := must1(cond.ConstantFromScalar(float32(100)))
+	must(cond.Return(must1(Compare(condSum, limit, types.CompareLT, types.CompareFloat))))
+	body := fn.Closure()
+	bodySum := must1(body.Input(sum.Shape()))
+	bodyCount := must1(body.Input(shapes.Make(dtypes.Int32)))
+	one := must1(body.ConstantFromScalar(int32(1)))
+	must(body.Return(must1(Add(bodySum, bodySum)), must1(Add(bodyCount, one))))
+	count := must1(fn.ConstantFromScalar(int32(0)))
+	results := must1(While(cond, body, sum, count))
+	must(fn.Return(y, results[1]))
+}
+
+func testVmap(t *testing.T, client *pjrt.Client) {
+	const batchSize = 4
+	xs := []float32{1, 2, 3, 0.1, 0.2, 0.3, 10, 20, 30, -1, 0.5, 2}
+	ws := []float32{0.1, -0.2, 0.3, 0.4, -0.5, 0.6}
+
+	// Reference: run the unbatched function in a loop.
+	b := New(t.Name() + "_unbatched")
+	buildVmapExample(b.Main())
+	program := must1(b.Build())
+	var expectedY []float32
+	var expectedCount []int32
+	for example := range batchSize {
+		xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions(xs[example*3:(example+1)*3], []int{3}).Done())
+		wBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions(ws, []int{3, 2}).Done())
+		outputs := compileAndExecute(t, client, program, xBuf, wBuf)
+		y, _ := must2(pjrt.BufferToArray[float32](outputs[0]))
+		count, _ := must2(pjrt.BufferToArray[int32](outputs[1]))
+		expectedY = append(expectedY, y...)
+		expectedCount = append(expectedCount, count...)
+		for _, output := range outputs {
+			must(output.Destroy())
+		}
+	}
+
+	// Batched version, with x batched on its first axis and w shared.
+	b = New(t.Name())
+	fn := b.NewFunction("f")
+	buildVmapExample(fn)
+	callFromMain(b, must1(Vmap(fn, "batched", batchSize, []int{0, -1})))
+	program = must1(b.Build())
+	fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+	xBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions(xs, []int{batchSize, 3}).Done())
+	wBuf := must1(client.BufferFromHost().FromFlatDataWithDimensions(ws, []int{3, 2}).Done())
+	outputs := compileAndExecute(t, client, program, xBuf, wBuf)
+	requireBuffersEqual(t, []FlatAndDims{
+		{expectedY, []int{batchSize, 2}},
+		{expectedCount, []int{batchSize}},
+	}, outputs)
+
+	for _, check := range vmapChecks() {
+		t.Run(check.name, func(t *testing.T) {
+			checkVmap(t, client, check)
+		})
+	}
+}
+
+// vmapCheck is a function whose Vmap is compared against running the function in a loop, see checkVmap.
+type vmapCheck struct {
+	name string
+
+	// inputs are the (unbatched) shapes of the inputs of the function, all Float32.
+	inputs []shapes.Shape
+
+	// inputAxes are the batch axes of the inputs (-1 for inputs that are not batched), see Vmap.
+	inputAxes []int
+
+	// values of the (batched) inputs. If nil, pseudo-random values in [-1, 1) are used.
+	values [][]float32
+
+	// build the function: it must return Float32 outputs.
+	build func(fn *Function, inputs []*Value) []*Value
+}
+
+// vmapCheckBatchSize is the batch size used by checkVmap.
+const vmapCheckBatchSize = 3
+
+// buildVmapCheck builds the program of the check, either the function itself (unbatched), or its Vmap called from
+// the main function (batched).
+func buildVmapCheck(t *testing.T, check vmapCheck, batched bool) (program []byte, outputShapes []shapes.Shape) {
+	b := New(t.Name())
+	var fn *Function
+	if batched {
+		fn = b.NewFunction("f")
+	} else {
+		fn = b.Main()
+	}
+	inputs := make([]*Value, len(check.inputs))
+	for i, shape := range check.inputs {
+		inputs[i] = must1(fn.NamedInput(fmt.Sprintf("x%d", i), shape))
+	}
+	outputs := check.build(fn, inputs)
+	must(fn.Return(outputs...))
+	for _, output := range outputs {
+		outputShapes = append(outputShapes, output.Shape())
+	}
+	if batched {
+		callFromMain(b, must1(Vmap(fn, "batched", vmapCheckBatchSize, check.inputAxes)))
+	}
+	return must1(b.Build()), outputShapes
+}
+
+// sliceAxis returns the flat values of the slice index along the axis of the row-major flat values.
+func sliceAxis(flat []float32, dimensions []int, axis, index int) []float32 {
+	outerSize, innerSize := 1, 1
+	for _, dim := range dimensions[:axis] {
+		outerSize *= dim
+	}
+	for _, dim := range dimensions[axis+1:] {
+		innerSize *= dim
+	}
+	result := make([]float32, 0, outerSize*innerSize)
+	for outer := range outerSize {
+		start := (outer*dimensions[axis] + index) * innerSize
+		result = append(result, flat[start:start+innerSize]...)
+	}
+	return result
+}
+
+// checkVmap compares the outputs of the Vmap of the function of the check with the outputs of the function run
+// for each example of the batch.
+func checkVmap(t *testing.T, client *pjrt.Client, check vmapCheck) {
+	batchedDims := make([][]int, len(check.inputs))
+	for i, shape := range check.inputs {
+		batchedDims[i] = slices.Clone(shape.Dimensions)
+		if axis := check.inputAxes[i]; axis >= 0 {
+			batchedDims[i] = slices.Insert(batchedDims[i], axis, vmapCheckBatchSize)
+		}
+	}
+	values := check.values
+	if values == nil {
+		rng := rand.New(rand.NewPCG(42, uint64(len(check.name))))
+		values = make([][]float32, len(check.inputs))
+		for i, dims := range batchedDims {
+			values[i] = make([]float32, shapes.Make(dtypes.F32, dims...).Size())
+			for j := range values[i] {
+				values[i][j] = float32(2*rng.Float64() - 1)
+			}
+		}
+	}
+
+	// Reference: run the unbatched function for each example.
+	program, outputShapes := buildVmapCheck(t, check, false)
+	expected := make([]FlatAndDims, len(outputShapes))
+	for i, shape := range outputShapes {
+		expected[i] = FlatAndDims{[]float32{}, append([]int{vmapCheckBatchSize}, shape.Dimensions...)}
+	}
+	for example := range vmapCheckBatchSize {
+		buffers := make([]*pjrt.Buffer, len(values))
+		for i, flat := range values {
+			if axis := check.inputAxes[i]; axis >= 0 {
+				flat = sliceAxis(flat, batchedDims[i], axis, example)
+			}
+			buffers[i] = must1(client.BufferFromHost().
+				FromFlatDataWithDimensions(flat, check.inputs[i].Dimensions).Done())
+		}
+		for i, output := range compileAndExecute(t, client, program, buffers...) {
+			flat, _ := must2(pjrt.BufferToArray[float32](output))
+			expected[i].Flat = append(expected[i].Flat.([]float32), flat...)
+			must(output.Destroy())
+		}
+	}
+
+	program, _ = buildVmapCheck(t, check, true)
+	fmt.Printf("%s program:\n%s", t.Name(), withLines(program))
+	buffers := make([]*pjrt.Buffer, len(values))
+	for i, flat := range values {
+		buffers[i] = must1(client.BufferFromHost().FromFlatDataWithDimensions(flat, batchedDims[i]).Done())
+	}
+	requireBuffersEqual(t, expected, compileAndExecute(t, client, program, buffers...))
+}
+
+// vmapChecks returns the checks of Gather/Scatter, If, Convolution and inputs batched on non-leading axes.
+func vmapChecks() []vmapCheck {
+	f32 := func(dimensions ...int) shapes.Shape { return shapes.Make(dtypes.F32, dimensions...) }
+	addClosure := func(fn *Function) *Function {
+		closure := fn.Closure()
+		lhs := must1(closure.Input(f32()))
+		rhs := must1(closure.Input(f32()))
+		must(closure.Return(must1(Add(lhs, rhs))))
+		return closure
+	}
+	// toIndices converts float values holding integers to indices.
+	toIndices := func(x *Value) *Value { return must1(Convert(x, dtypes.Int32)) }
+	return []vmapCheck{
+		{
+			name:      "Gather",
+			inputs:    []shapes.Shape{f32(4, 3), f32(4, 3), f32(2, 1)},
+			inputAxes: []int{0, -1, 0},
+			values: [][]float32{
+				{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25,
+					26, 27, 28, 29, 30, 31, 32, 33, 34, 35},
+				{-1, -2, -3, -4, -5, -6, -7, -8, -9, -10, -11, -12},
+				{0, 3, 1, 1, 2, 0},
+			},
+			build: func(fn *Function, x []*Value) []*Value {
+				gather := func(operand, indices *Value) *Value {
+					return must1(Gather(operand, indices, 1,
+						[]int{1}, []int{0}, nil, nil, []int{0},
+						[]int{1, 3}, false))
+				}
+				constIndices := must1(fn.ConstantFromFlatAndDimensions([]int32{2, 0, 3}, 3, 1))
+				indices := toIndices(x[2])
+				return []*Value{
+					gather(x[0], constIndices), // Batched operand.
+					gather(x[1], indices),      // Batched indices.
+					gather(x[0], indices),      // Both batched.
+				}
+			},
+		},
+		{
+			name:      "Scatter",
+			inputs:    []shapes.Shape{f32(4, 3), f32(2, 3), f32(2, 1), f32(4, 3)},
+			inputAxes: []int{0, 0, 0, -1},
+			values: [][]float32{
+				{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25,
+					26, 27, 28, 29, 30, 31, 32, 33, 34, 35},
+				{100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100, 1200, 1300, 1400, 1500, 1600, 1700, 1800},
+				{0, 3, 2, 1, 3, 0},
+				{-1, -2, -3, -4, -5, -6, -7, -8, -9, -10, -11, -12},
+			},
+			build: func(fn *Function, x []*Value) []*Value {
+				scatter := func(input, indices, updates *Value) *Value {
+					return must1(Scatter(input, indices, updates,
+						[]int{1}, []int{0}, nil, nil, []int{0}, 1,
+						false, true, addClosure(fn)))
+				}
+				constIndices := must1(fn.ConstantFromFlatAndDimensions([]int32{1, 2}, 2, 1))
+				indices := toIndices(x[2])
+				return []*Value{
+					scatter(x[0], constIndices, x[1]), // Batched input and updates.
+					scatter(x[3], indices, x[1]),      // Non-batched input.
+					scatter(x[0], indices, x[1]),      // All batched.
+				}
+			},
+		},
+		{
+			name:      "If",
+			inputs:    []shapes.Shape{f32(3), f32(3)},
+			inputAxes: []int{0, -1},
+			values: [][]float32{
+				{1, 2, 3, -1, -2, -3, 0.5, -0.1, 0.2},
+				{0.5, -0.25, 1},
+			},
+			build: func(fn *Function, x []*Value) []*Value {
+				zero := must1(fn.ConstantFromScalar(float32(0)))
+				isPositiveSum := func(v *Value) *Value {
+					sum := must1(Reduce(v, zero, addClosure(fn), 0))
+					return must1(Compare(sum, zero, types.CompareGT, types.CompareFloat))
+				}
+				ifOp := func(pred *Value, onTrue, onFalse func(*Function) *Value) *Value {
+					trueBranch := fn.Closure()
+					must(trueBranch.Return(onTrue(trueBranch)))
+					falseBranch := fn.Closure()
+					must(falseBranch.Return(onFalse(falseBranch)))
+					return must1(If(pred, trueBranch, falseBranch))[0]
+				}
+				return []*Value{
+					// Non-batched predicate, batched branches.
+					ifOp(isPositiveSum(x[1]),
+						func(branch *Function) *Value {
+							return must1(Multiply(must1(branch.UseParentValue(x[0])), must1(branch.UseParentValue(x[1]))))
+						},
+						func(branch *Function) *Value {
+							return must1(Subtract(must1(branch.UseParentValue(x[0])), must1(branch.UseParentValue(x[1]))))
+						}),
+					// Batched predicate: the examples take different branches.
+					ifOp(isPositiveSum(x[0]),
+						func(branch *Function) *Value { return must1(Sine(must1(branch.UseParentValue(x[0])))) },
+						func(branch *Function) *Value {
+							return must1(branch.ConstantFromFlatAndDimensions([]float32{7, 8, 9}, 3))
+						}),
+				}
+			},
+		},
+		{
+			name:      "Convolution",
+			inputs:    []shapes.Shape{f32(2, 2, 5, 5), f32(3, 2, 2, 2)},
+			inputAxes: []int{0, -1},
+			build: func(fn *Function, x []*Value) []*Value {
+				// Input in NCHW layout and kernel in OIHW layout.
+				spatialAxes := []int{2, 3}
+				return []*Value{must1(Convolution(x[0], x[1],
+					[]int{1, 2}, [][2]int{{1, 0}, {0, 1}}, nil, nil,
+					0, 1, spatialAxes,
+					1, 0, spatialAxes,
+					0, 1, spatialAxes,
+					1, 1,
+					types.DotGeneralPrecisionDefault, types.DotGeneralPrecisionDefault))}
+			},
+		},
+		{
+			name:      "Non-leading batch axes",
+			inputs:    []shapes.Shape{f32(3, 2), f32(2, 4), f32(3)},
+			inputAxes: []int{1, 2, -1},
+			build: func(fn *Function, x []*Value) []*Value {
+				dot := must1(DotGeneral(x[0], []int{1}, nil, x[1], []int{0}, nil).Done())
+				scaled := must1(Multiply(x[0], must1(BroadcastInDim(x[2], f32(3, 2), []int{0}))))
+				return []*Value{must1(Tanh(dot)), must1(Transpose(scaled, 1, 0))}
+			},
+		},
+	}
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"github.com/gomlx/compute/dtypes"
+	"github.com/pkg/errors"
+)
+
+// IsFloat8 returns whether dtype is one of the 8-bit float types (e.g. F8E4M3FN, F8E5M2).
+//
+// These are not (yet) handled by dtypes.DType.GoType (and hence by dtypes.DType.Size or dtypes.DType.Bits):
+// on the host they are stored as their raw bits, one uint8 per value.
+func IsFloat8(dtype dtypes.DType) bool {
+	switch dtype {
+	case dtypes.F8E4M3FN, dtypes.F8E5M2, dtypes.F8E4M3, dtypes.F8E4M3FNUZ, dtypes.F8E5M2FNUZ, dtypes.F8E4M3B11FNUZ:
+		return true
+	default:
+		return false
+	}
+}
+
+// DTypeBits returns the number of bits used by dtype, including the 8-bit floats (see IsFloat8).
+func DTypeBits(dtype dtypes.DType) int {
+	if IsFloat8(dtype) {
+		return 8
+	}
+	return dtype.Bits()
+}
+
+// DTypeSizeForDimensions returns the number of bytes used on the host by an array of the given dtype and dimensions,
+// including the 8-bit floats (see IsFloat8).
+// Sub-byte dtypes (e.g. Int4) are packed, see PackSubByte.
+func DTypeSizeForDimensions(dtype dtypes.DType, dimensions ...int) int {
+	if IsFloat8(dtype) {
+		return dtypes.Uint8.SizeForDimensions(dimensions...)
+	}
+	return dtype.SizeForDimensions(dimensions...)
+}
+
+// DTypeGoType returns the dtype of the Go values used to store one value of dtype on the host, one per element:
+//
+//   - Sub-byte signed integers (Int4, Int2) are stored as int8 and unsigned ones (Uint4, Uint2) as uint8.
+//     See PackSubByte and UnpackSubByte to convert to/from the packed storage.
+//   - 8-bit floats (see IsFloat8) are stored as their raw bits, in an uint8.
+//   - Everything else is stored as the Go type of the dtype (dtypes.DType.GoType).
+func DTypeGoType(dtype dtypes.DType) dtypes.DType {
+	switch {
+	case IsFloat8(dtype):
+		return dtypes.Uint8
+	case dtype.IsPacked() && dtype.IsUnsigned():
+		return dtypes.Uint8
+	case dtype.IsPacked():
+		return dtypes.Int8
+	default:
+		return dtype
+	}
+}
+
+// PackSubByte packs the values (one per element) of a sub-byte dtype (e.g. Int4, Uint4), into bytes.
+// The first values go into the lower bits of each byte, and the last byte is padded with zeros if needed.
+//
+// values must be a []int8 for the signed dtypes, or a []uint8 for the unsigned dtypes, see DTypeGoType.
+// It returns an error if a value is out of range for dtype.
+func PackSubByte(dtype dtypes.DType, values any) ([]byte, error) {
+	if !dtype.IsPacked() {
+		return nil, errors.Errorf("dtype %s is not a sub-byte (packed) dtype", dtype)
+	}
+	bits := dtype.Bits()
+	lowest, highest := -(1 << (bits - 1)), (1<<(bits-1))-1
+	if dtype.IsUnsigned() {
+		lowest, highest = 0, (1<<bits)-1
+	}
+	var ints []int
+	switch flat := values.(type) {
+	case []int8:
+		if dtype.IsUnsigned() {
+			return nil, errors.Errorf("values for unsigned dtype %s must be given as []uint8, got %T", dtype, values)
+		}
+		ints = make([]int, len(flat))
+		for i, v := range flat {
+			ints[i] = int(v)
+		}
+	case []uint8:
+		if !dtype.IsUnsigned() {
+			return nil, errors.Errorf("values for signed dtype %s must be given as []int8, got %T", dtype, values)
+		}
+		ints = make([]int, len(flat))
+		for i, v := range flat {
+			ints[i] = int(v)
+		}
+	default:
+		return nil, errors.Errorf("values for dtype %s must be given as []int8 or []uint8, got %T", dtype, values)
+	}
+	mask := byte(1<<bits) - 1
+	valuesPerByte := dtype.ValuesPerStorageUnit()
+	packed := make([]byte, DTypeSizeForDimensions(dtype, len(ints)))
+	for i, v := range ints {
+		if v < lowest || v > highest {
+			return nil, errors.Errorf("value %d at index %d is out of range [%d, %d] for dtype %s", v, i, lowest, highest, dtype)
+		}
+		packed[i/valuesPerByte] |= (byte(v) & mask) << ((i % valuesPerByte) * bits)
+	}
+	return packed, nil
+}
+
+// UnpackSubByte unpacks numElements values of a sub-byte dtype (e.g. Int4, Uint4) from their packed storage,
+// see PackSubByte.
+//
+// It returns a []int8 for the signed dtypes, or a []uint8 for the unsigned dtypes, see DTypeGoType.
+func UnpackSubByte(dtype dtypes.DType, packed []byte, numElements int) (any, error) {
+	if !dtype.IsPacked() {
+		return nil, errors.Errorf("dtype %s is not a sub-byte (packed) dtype", dtype)
+	}
+	if len(packed) < DTypeSizeForDimensions(dtype, numElements) {
+		return nil, errors.Errorf("%d bytes are not enough to hold %d values of dtype %s", len(packed), numElements, dtype)
+	}
+	bits := dtype.Bits()
+	mask := byte(1<<bits) - 1
+	valuesPerByte := dtype.ValuesPerStorageUnit()
+	value := func(i int) byte {
+		return (packed[i/valuesPerByte] >> ((i % valuesPerByte) * bits)) & mask
+	}
+	if dtype.IsUnsigned() {
+		values := make([]uint8, numElements)
+		for i := range values {
+			values[i] = value(i)
+		}
+		return values, nil
+	}
+	values := make([]int8, numElements)
+	shift := 8 - bits
+	for i := range values {
+		// Shift the sign bit to the top, and back, to sign-extend.
+		values[i] = int8(value(i)<<shift) >> shift
+	}
+	return values, nil
+}
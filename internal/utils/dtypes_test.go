@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+)
+
+func TestPackSubByte(t *testing.T) {
+	// Int4: first value goes to the lower bits.
+	packed, err := PackSubByte(dtypes.Int4, []int8{1, -2, 7})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []byte{0xE1, 0x07}; !slices.Equal(packed, want) {
+		t.Errorf("expected %#v, got %#v", want, packed)
+	}
+	unpacked, err := UnpackSubByte(dtypes.Int4, packed, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []int8{1, -2, 7}; !slices.Equal(unpacked.([]int8), want) {
+		t.Errorf("expected %v, got %v", want, unpacked)
+	}
+
+	// Uint2: 4 values per byte.
+	packed, err = PackSubByte(dtypes.Uint2, []uint8{0, 1, 2, 3, 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []byte{0xE4, 0x03}; !slices.Equal(packed, want) {
+		t.Errorf("expected %#v, got %#v", want, packed)
+	}
+	unpacked, err = UnpackSubByte(dtypes.Uint2, packed, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []uint8{0, 1, 2, 3, 3}; !slices.Equal(unpacked.([]uint8), want) {
+		t.Errorf("expected %v, got %v", want, unpacked)
+	}
+
+	// Errors.
+	if _, err = PackSubByte(dtypes.Int4, []int8{8}); err == nil {
+		t.Errorf("expected out of range error for Int4 value 8")
+	}
+	if _, err = PackSubByte(dtypes.Uint4, []int8{1}); err == nil {
+		t.Errorf("expected error for signed values of Uint4")
+	}
+	if _, err = PackSubByte(dtypes.Int8, []int8{1}); err == nil {
+		t.Errorf("expected error for non-packed dtype")
+	}
+	if _, err = UnpackSubByte(dtypes.Int4, []byte{0}, 3); err == nil {
+		t.Errorf("expected error for not enough packed bytes")
+	}
+}
+
+func TestFloat8(t *testing.T) {
+	if !IsFloat8(dtypes.F8E4M3FN) || !IsFloat8(dtypes.F8E5M2) || IsFloat8(dtypes.Float16) {
+		t.Errorf("IsFloat8 returned unexpected results")
+	}
+	if got := DTypeBits(dtypes.F8E5M2); got != 8 {
+		t.Errorf("expected 8 bits for F8E5M2, got %d", got)
+	}
+	if got := DTypeSizeForDimensions(dtypes.F8E4M3FN, 2, 3); got != 6 {
+		t.Errorf("expected 6 bytes for F8E4M3FN[2, 3], got %d", got)
+	}
+	if got := DTypeToStableHLO(dtypes.F8E4M3FN); got != "f8E4M3FN" {
+		t.Errorf("expected f8E4M3FN, got %q", got)
+	}
+}
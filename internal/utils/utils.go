@@ -45,6 +45,18 @@ func DTypeToStableHLO(dtype dtypes.DType) string {
 		return "f16"
 	case dtypes.BFloat16:
 		return "bf16"
+	case dtypes.F8E4M3FN:
+		return "f8E4M3FN"
+	case dtypes.F8E5M2:
+		return "f8E5M2"
+	case dtypes.F8E4M3:
+		return "f8E4M3"
+	case dtypes.F8E4M3FNUZ:
+		return "f8E4M3FNUZ"
+	case dtypes.F8E5M2FNUZ:
+		return "f8E5M2FNUZ"
+	case dtypes.F8E4M3B11FNUZ:
+		return "f8E4M3B11FNUZ"
 	case dtypes.Int64:
 		return "i64"
 	case dtypes.Int32:
@@ -16,6 +16,7 @@ import (
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/compute/dtypes/gotype"
 	"github.com/gomlx/go-xla/internal/shapeinference"
+	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/pkg/errors"
 	"k8s.io/klog/v2"
@@ -44,6 +45,9 @@ type bufferWrapper struct {
 	sharedRawStorage unsafe.Pointer
 	plugin           *Plugin
 	client           *Client
+
+	// inFlight defers the destruction of the C buffer while asynchronous executions are using it.
+	inFlight inFlightGuard
 }
 
 func (wrapper *bufferWrapper) IsValid() bool {
@@ -54,35 +58,47 @@ func (wrapper *bufferWrapper) Destroy() error {
 	if wrapper == nil {
 		return nil
 	}
+	plugin, client, cBuffer, sharedRawStorage := wrapper.plugin, wrapper.client, wrapper.c, wrapper.sharedRawStorage
+	wrapper.c = nil
+	wrapper.sharedRawStorage = nil
+	wrapper.plugin = nil
+	wrapper.client = nil
+	deferred := cBuffer != nil && wrapper.inFlight.deferDestroy(func() {
+		if err := destroyBufferC(plugin, client, cBuffer, sharedRawStorage); err != nil {
+			klog.Errorf("Failed to destroy pjrt.Buffer after its executions finished: %v", err)
+		}
+	})
+	if deferred {
+		return nil
+	}
+	return destroyBufferC(plugin, client, cBuffer, sharedRawStorage)
+}
+
+// destroyBufferC destroys the C buffer, and then frees its shared storage, if any.
+func destroyBufferC(plugin *Plugin, client *Client, cBuffer *C.PJRT_Buffer, sharedRawStorage unsafe.Pointer) error {
 	defer func() {
 		// Make sure sharedBuffer is freed, even if plugin or client has been reset.
-		if wrapper.sharedRawStorage != nil {
+		if sharedRawStorage != nil {
 			// Shared storage can only be freed after the buffer is destroyed.
-			AlignedFree(wrapper.sharedRawStorage)
-			wrapper.sharedRawStorage = nil
+			AlignedFree(sharedRawStorage)
 		}
 	}()
 
-	if wrapper.plugin == nil || wrapper.c == nil || wrapper.plugin.api == nil {
+	if plugin == nil || cBuffer == nil || plugin.api == nil {
 		// Already destroyed, no-op.
 		return nil
 	}
-	if !wrapper.client.IsValid() {
+	if !client.IsValid() {
 		// Client is already destroyed, assume buffer is also destroyed.
 		buffersAlive.Add(-1)
 		return nil
 	}
-	defer runtime.KeepAlive(wrapper)
 
-	arena := wrapper.plugin.getDefaultArena()
-	defer wrapper.plugin.returnArena(arena)
+	arena := plugin.getDefaultArena()
+	defer plugin.returnArena(arena)
 	args := arenaAlloc[C.PJRT_Buffer_Destroy_Args](arena)
 	args.struct_size = C.PJRT_Buffer_Destroy_Args_STRUCT_SIZE
-	args.buffer = wrapper.c
-	wrapper.c = nil
-	plugin := wrapper.plugin
-	wrapper.plugin = nil
-	wrapper.client = nil
+	args.buffer = cBuffer
 	err := toError(plugin, C.call_PJRT_Buffer_Destroy(plugin.api, args))
 	buffersAlive.Add(-1)
 	return err
@@ -329,9 +345,28 @@ func BufferToArray[T gotype.Supported](buffer *Buffer) (flatValues []T, dimensio
 	return
 }
 
+// rawStorageTypeAndLen returns the Go type used to store the raw (as in device memory) values of dtype,
+// and the number of values of that type needed for the given dimensions.
+//
+// Sub-byte dtypes (e.g. Int4) are packed in bytes, and 8-bit floats (e.g. F8E4M3FN) are stored as their raw bits.
+func rawStorageTypeAndLen(dtype dtypes.DType, dimensions []int) (goType reflect.Type, numValues int) {
+	if utils.IsFloat8(dtype) || dtype.IsPacked() {
+		return reflect.TypeFor[uint8](), utils.DTypeSizeForDimensions(dtype, dimensions...)
+	}
+	numValues = 1
+	for _, dim := range dimensions {
+		numValues *= dim
+	}
+	return dtype.GoType(), numValues
+}
+
 // ToFlatDataAndDimensions transfers the buffer to a flat slice and returns also its underlying dimensions.
 //
 // Similar to the generic BufferToArray[T], but this returns an anonymous typed (`any`) flat slice instead of using generics.
+//
+// Values of sub-byte dtypes are unpacked to one value per element: a []int8 for the signed dtypes (Int4, Int2) and
+// a []uint8 for the unsigned ones (Uint4, Uint2). Values of 8-bit float dtypes (e.g. F8E4M3FN) are returned as their
+// raw bits, in a []uint8. Use Buffer.ToHost to get the packed bytes.
 func (b *Buffer) ToFlatDataAndDimensions() (flat any, dimensions []int, err error) {
 	if err = b.Check(); err != nil {
 		return
@@ -355,8 +390,8 @@ func (b *Buffer) ToFlatDataAndDimensions() (flat any, dimensions []int, err erro
 		// and no error.
 		return
 	}
-	goType := dtype.GoType()
-	flatV := reflect.MakeSlice(reflect.SliceOf(goType), totalSize, totalSize)
+	goType, numValues := rawStorageTypeAndLen(dtype, dimensions)
+	flatV := reflect.MakeSlice(reflect.SliceOf(goType), numValues, numValues)
 	element0 := flatV.Index(0)
 	flatValuesPtr := element0.Addr().UnsafePointer()
 	sizeBytes := uintptr(flatV.Len()) * element0.Type().Size()
@@ -364,6 +399,9 @@ func (b *Buffer) ToFlatDataAndDimensions() (flat any, dimensions []int, err erro
 	dst := unsafe.Slice((*byte)(flatValuesPtr), sizeBytes)
 	err = b.ToHost(dst)
 	flat = flatV.Interface()
+	if err == nil && !utils.IsFloat8(dtype) && dtype.IsPacked() {
+		flat, err = utils.UnpackSubByte(dtype, dst, totalSize)
+	}
 	return
 }
 
@@ -40,6 +40,8 @@ import (
 	"unsafe"
 
 	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/utils"
+	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/pkg/errors"
 )
 
@@ -50,18 +52,26 @@ import (
 //
 // - FromRawData: it takes as inputs the bytes and shape (dtype and dimensions).
 // - FromFlatDataWithDimensions: it takes as inputs a flat slice and shape (dtype and dimensions).
+// - FromFlatDataWithDType: it takes as inputs a flat slice, the dtype and dimensions: for sub-byte and 8-bit float dtypes.
 //
 // The device defaults to 0, but it can be configured with BufferFromHostConfig.ToDevice or BufferFromHostConfig.ToDeviceNum.
+// Alternatively, a specific memory (e.g. pinned host memory) can be targeted with BufferFromHostConfig.ToMemory.
+//
+// The data is assumed to be in row-major order, but it can be configured with BufferFromHostConfig.WithHostLayout.
 //
 // At the end call BufferFromHostConfig.Done to actually initiate the transfer.
 //
-// TODO: Implement async transfers, arbitrary memory layout, etc.
+// TODO: Implement async transfers, etc.
 type BufferFromHostConfig struct {
 	client     *Client
 	data       []byte
 	dtype      dtypes.DType
 	dimensions []int
 	device     *Device
+	memory     *Memory
+
+	// hostLayout and deviceLayout are optional, nil means row-major.
+	hostLayout, deviceLayout *shapes.Layout
 
 	hostBufferSemantics PJRT_HostBufferSemantics
 
@@ -72,10 +82,24 @@ type BufferFromHostConfig struct {
 
 // FromRawData configures the data from host to copy: a pointer to bytes that must be kept alive (and constant)
 // during the call. The parameters dtype and dimensions provide the shape of the array.
+//
+// Values of sub-byte dtypes (e.g. Int4) must be packed (see FromFlatDataWithDType), and values of 8-bit float dtypes
+// (e.g. F8E4M3FN) are given as their raw bits, one byte per value.
 func (b *BufferFromHostConfig) FromRawData(data []byte, dtype dtypes.DType, dimensions []int) *BufferFromHostConfig {
 	if b.err != nil {
 		return b
 	}
+	if slices.ContainsFunc(dimensions, func(dim int) bool { return dim < 0 }) {
+		b.err = errors.Errorf("FromRawData cannot be given negative dimensions, got %v", dimensions)
+		return b
+	}
+	if dtypes.SupportedDTypes[dtype] || utils.IsFloat8(dtype) {
+		if expectedSize := utils.DTypeSizeForDimensions(dtype, dimensions...); len(data) < expectedSize {
+			b.err = errors.Errorf("FromRawData(data, dtype=%s, dimensions=%v) requires %d bytes, but got len(data)=%d",
+				dtype, dimensions, expectedSize, len(data))
+			return b
+		}
+	}
 	b.data = data
 	b.dtype = dtype
 	b.dimensions = dimensions
@@ -125,6 +149,55 @@ func (b *BufferFromHostConfig) ToDeviceNum(deviceNum int) *BufferFromHostConfig
 	return b.ToDevice(b.client.addressableDevices[deviceNum])
 }
 
+// ToMemory configures the memory (e.g. the MemoryKindPinnedHost memory of a device, see Device.MemoryByKind) to
+// copy the host data to. It takes precedence over the device configured with ToDevice or ToDeviceNum.
+//
+// If left un-configured, the data is copied to the default memory of the device.
+func (b *BufferFromHostConfig) ToMemory(memory *Memory) *BufferFromHostConfig {
+	if b.err != nil {
+		return b
+	}
+	if memory == nil || memory.cMemory == nil {
+		b.err = errors.New("BufferFromHost().ToMemory() given a nil memory")
+		return b
+	}
+	if memory.client != b.client {
+		b.err = errors.Errorf("BufferFromHost().ToMemory() given memory %s of another client", memory)
+		return b
+	}
+	b.memory = memory
+	return b
+}
+
+// WithHostLayout configures the layout (see shapes.Layout) of the data in host memory.
+// For instance, use shapes.ColumnMajorLayout for column-major (Fortran) data, to transfer it without the need
+// of a transpose.
+//
+// The layout can't have tiles, and it is not supported for sub-byte dtypes (e.g. Int4).
+// If not set, or set to nil, the data is assumed to be in row-major order.
+func (b *BufferFromHostConfig) WithHostLayout(layout *shapes.Layout) *BufferFromHostConfig {
+	if b.err != nil {
+		return b
+	}
+	if layout != nil && len(layout.Tiles) > 0 {
+		b.err = errors.Errorf("BufferFromHost().WithHostLayout(%s) doesn't support tiled layouts", layout)
+		return b
+	}
+	b.hostLayout = layout
+	return b
+}
+
+// WithDeviceLayout configures the layout (see shapes.Layout) of the buffer in device memory.
+//
+// If not set, or set to nil, the device uses a dense row-major layout.
+func (b *BufferFromHostConfig) WithDeviceLayout(layout *shapes.Layout) *BufferFromHostConfig {
+	if b.err != nil {
+		return b
+	}
+	b.deviceLayout = layout
+	return b
+}
+
 // FromFlatDataWithDimensions configures the data to come from a flat slice of the desired data type, and the underlying
 // dimensions.
 // The flat slice size must match the product of the dimension.
@@ -169,6 +242,61 @@ func (b *BufferFromHostConfig) FromFlatDataWithDimensions(flat any, dimensions [
 	return b.FromRawData(data, dtype, dimensions)
 }
 
+// FromFlatDataWithDType configures the data to come from a flat slice, with one value per element, for the given
+// dtype and dimensions. It is meant for the dtypes without a corresponding Go type:
+//
+//   - Sub-byte integers: the values are given in a []int8 for the signed dtypes (Int4, Int2), or in a []uint8 for the
+//     unsigned dtypes (Uint4, Uint2). They are range checked and packed (the first values in the lower bits)
+//     before the transfer.
+//   - 8-bit floats (e.g. F8E4M3FN, F8E5M2): the raw bits of the values are given in a []uint8.
+//
+// For any other dtype it is the same as FromFlatDataWithDimensions, and dtype must match the flat slice type.
+func (b *BufferFromHostConfig) FromFlatDataWithDType(flat any, dtype dtypes.DType, dimensions []int) *BufferFromHostConfig {
+	if b.err != nil {
+		return b
+	}
+	if !utils.IsFloat8(dtype) && !dtype.IsPacked() {
+		flatT := reflect.TypeOf(flat)
+		if flatT == nil || flatT.Kind() != reflect.Slice {
+			b.err = errors.Errorf("FromFlatDataWithDType was given a %T for flat, but it requires a slice", flat)
+			return b
+		}
+		if flatDType := dtypes.FromGoType(flatT.Elem()); flatDType != dtype {
+			b.err = errors.Errorf("FromFlatDataWithDType(flat, dtype=%s) got flat=%T, with values of dtype %s", dtype, flat, flatDType)
+			return b
+		}
+		return b.FromFlatDataWithDimensions(flat, dimensions)
+	}
+	expectedSize := 1
+	for _, dim := range dimensions {
+		if dim < 0 {
+			b.err = errors.Errorf("FromFlatDataWithDType cannot be given negative dimensions, got %v", dimensions)
+			return b
+		}
+		expectedSize *= dim
+	}
+	flatV := reflect.ValueOf(flat)
+	if flatV.Kind() != reflect.Slice || flatV.Len() != expectedSize {
+		b.err = errors.Errorf("FromFlatDataWithDType(flat, dtype=%s, dimensions=%v) needs a slice with %d values, but got %T",
+			dtype, dimensions, expectedSize, flat)
+		return b
+	}
+	if utils.IsFloat8(dtype) {
+		rawBits, ok := flat.([]uint8)
+		if !ok {
+			b.err = errors.Errorf("FromFlatDataWithDType(flat, dtype=%s) requires the raw bits of the values in a []uint8, got %T", dtype, flat)
+			return b
+		}
+		return b.FromRawData(rawBits, dtype, dimensions)
+	}
+	packed, err := utils.PackSubByte(dtype, flat)
+	if err != nil {
+		b.err = errors.WithMessage(err, "FromFlatDataWithDType")
+		return b
+	}
+	return b.FromRawData(packed, dtype, dimensions)
+}
+
 // Done will use the configuration to start the transfer from host to device.
 // It's synchronous: it awaits the transfer to finish and then returns.
 func (b *BufferFromHostConfig) Done() (*Buffer, error) {
@@ -213,8 +341,33 @@ func (b *BufferFromHostConfig) Done() (*Buffer, error) {
 		}
 		args.dims = unsafe.SliceData(dims)
 	}
+	if b.hostLayout != nil && !b.hostLayout.IsRowMajor() {
+		if !utils.IsFloat8(b.dtype) && b.dtype.IsPacked() {
+			return nil, errors.Errorf("BufferFromHost().WithHostLayout(%s) is not supported for sub-byte dtype %s",
+				b.hostLayout, b.dtype)
+		}
+		byteStrides, err := b.hostLayout.Strides(b.dimensions, utils.DTypeSizeForDimensions(b.dtype))
+		if err != nil {
+			return nil, errors.WithMessage(err, "BufferFromHost() invalid host layout")
+		}
+		cByteStrides := arenaAllocSlice[C.int64_t](arena, len(byteStrides))
+		for ii, stride := range byteStrides {
+			cByteStrides[ii] = C.int64_t(stride)
+		}
+		args.byte_strides = unsafe.SliceData(cByteStrides)
+		args.num_byte_strides = C.size_t(len(byteStrides))
+	}
+	if b.deviceLayout != nil {
+		if err := b.deviceLayout.Validate(len(b.dimensions)); err != nil {
+			return nil, errors.WithMessage(err, "BufferFromHost() invalid device layout")
+		}
+		args.device_layout = newCMemoryLayout(arena, b.deviceLayout)
+	}
 	args.host_buffer_semantics = C.PJRT_HostBufferSemantics(b.hostBufferSemantics)
 	args.device = b.device.cDevice
+	if b.memory != nil {
+		args.memory = b.memory.cMemory
+	}
 	err := toError(b.client.plugin, C.BufferFromHostAndWait(b.client.plugin.api, args))
 	if err != nil {
 		return nil, err
@@ -23,6 +23,7 @@ import (
 	"unsafe"
 
 	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/pkg/errors"
 )
 
@@ -59,6 +60,14 @@ import (
 // See: dtypes.SizeForDimensions() to calculate the size for an arbitrary shape; AlignedAlloc, AlignedFree and
 // BufferAlignment (a constant with the required alignment size) to allocate and free aligned storage.
 func (c *Client) CreateViewOfDeviceBuffer(rawData unsafe.Pointer, dtype dtypes.DType, dimensions []int, device ...*Device) (*Buffer, error) {
+	return c.createViewOfDeviceBuffer(rawData, dtype, dimensions, unsafe.Pointer(C.OnDeleteSharedBufferPtr), nil, device...)
+}
+
+// createViewOfDeviceBuffer implements CreateViewOfDeviceBuffer, with the given C on_delete_callback (with the
+// signature `void (*)(void* device_buffer_ptr, void* user_arg)`), called with onDeleteArg when the buffer
+// is no longer used by PJRT.
+func (c *Client) createViewOfDeviceBuffer(rawData unsafe.Pointer, dtype dtypes.DType, dimensions []int,
+	onDelete, onDeleteArg unsafe.Pointer, device ...*Device) (*Buffer, error) {
 	var selectedDevice *Device
 	if len(device) > 1 {
 		return nil, errors.Errorf("only one device can be given to CreateViewOfDeviceBuffer, %d were given", len(device))
@@ -92,8 +101,8 @@ func (c *Client) CreateViewOfDeviceBuffer(rawData unsafe.Pointer, dtype dtypes.D
 		args.dims = unsafe.SliceData(dims)
 	}
 	args.device = selectedDevice.cDevice
-	args.on_delete_callback = (*[0]byte)(unsafe.Pointer(C.OnDeleteSharedBufferPtr))
-	args.on_delete_callback_arg = nil
+	args.on_delete_callback = (*[0]byte)(onDelete)
+	args.on_delete_callback_arg = onDeleteArg
 	err := toError(c.plugin, C.call_PJRT_Client_CreateViewOfDeviceBuffer(c.plugin.api, args))
 	if err != nil {
 		return nil, err
@@ -117,9 +126,10 @@ func (c *Client) CreateViewOfDeviceBuffer(rawData unsafe.Pointer, dtype dtypes.D
 // When the buffer is finalized, the shared memory is also de-allocated.
 //
 // It returns a handle to the buffer and a slice of the corresponding data type pointing
-// to the shared data.
+// to the shared data. For sub-byte dtypes (e.g. Int4) the slice holds the packed bytes, and for 8-bit
+// floats (e.g. F8E4M3FN) it holds the raw bits in a []uint8.
 func (c *Client) NewSharedBuffer(dtype dtypes.DType, dimensions []int, device ...*Device) (buffer *Buffer, flat any, err error) {
-	memorySize := uintptr(dtype.SizeForDimensions(dimensions...))
+	memorySize := uintptr(utils.DTypeSizeForDimensions(dtype, dimensions...))
 	rawStorage := AlignedAlloc(memorySize, BufferAlignment)
 	buffer, err = c.CreateViewOfDeviceBuffer(rawStorage, dtype, dimensions, device...)
 	if err != nil {
@@ -131,8 +141,8 @@ func (c *Client) NewSharedBuffer(dtype dtypes.DType, dimensions []int, device ..
 	buffer.wrapper.sharedRawStorage = rawStorage
 	buffer.isShared = true
 
-	goDType := dtype.GoType()
-	flat = reflect.SliceAt(dtype.GoType(), rawStorage, int(memorySize/goDType.Size())).Interface()
+	goType, numValues := rawStorageTypeAndLen(dtype, dimensions)
+	flat = reflect.SliceAt(goType, rawStorage, numValues).Interface()
 	return
 }
 
@@ -176,6 +186,7 @@ func (b *Buffer) UnsafePointer() (unsafe.Pointer, error) {
 //
 // This is an undocumented feature of PJRT and likely only works for CPU platforms.
 // The flat slice returned is only valid while the buffer is alive.
+// Like with NewSharedBuffer, sub-byte dtypes are returned packed, and 8-bit floats as their raw bits.
 func (b *Buffer) Data() (flat any, err error) {
 	var rawStorage unsafe.Pointer
 	rawStorage, err = b.UnsafePointer()
@@ -197,9 +208,6 @@ func (b *Buffer) Data() (flat any, err error) {
 		}
 	}
 
-	numElements := 1
-	for _, dim := range dims {
-		numElements *= dim
-	}
-	return reflect.SliceAt(dtype.GoType(), rawStorage, numElements).Interface(), nil
+	goType, numValues := rawStorageTypeAndLen(dtype, dims)
+	return reflect.SliceAt(goType, rawStorage, numValues).Interface(), nil
 }
@@ -4,6 +4,7 @@ import (
 	"runtime"
 	"unsafe"
 
+	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/pkg/errors"
 )
 
@@ -12,7 +13,10 @@ import (
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
 
-PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, int64_t dst_size, int rank) {
+// BufferToHost transfers the buffer to host, using the given host_layout. If host_layout is NULL, it uses
+// the major-to-minor (row-major) layout.
+PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, int64_t dst_size, int rank,
+		PJRT_Buffer_MemoryLayout *host_layout) {
 	PJRT_Buffer_ToHostBuffer_Args args = {0};
 
 	args.struct_size = PJRT_Buffer_ToHostBuffer_Args_STRUCT_SIZE;
@@ -20,16 +24,20 @@ PJRT_Error* BufferToHost(const PJRT_Api *api, PJRT_Buffer *buffer, void *dst, in
 	args.dst = dst;
 	args.dst_size = dst_size;
 	PJRT_Buffer_MemoryLayout layout_args = {0};
-	layout_args.struct_size = PJRT_Buffer_MemoryLayout_STRUCT_SIZE;
-	args.host_layout = &layout_args;
-	layout_args.type = PJRT_Buffer_MemoryLayout_Type_Tiled;
-	layout_args.tiled.minor_to_major_size = rank;
 	int64_t minor_to_major[rank > 0 ? rank : 1];
-	if (rank > 0) {
-		for (int axisIdx = 0; axisIdx < rank; axisIdx++) {
-			minor_to_major[axisIdx] = rank - axisIdx - 1;
+	if (host_layout != NULL) {
+		args.host_layout = host_layout;
+	} else {
+		layout_args.struct_size = PJRT_Buffer_MemoryLayout_STRUCT_SIZE;
+		args.host_layout = &layout_args;
+		layout_args.type = PJRT_Buffer_MemoryLayout_Type_Tiled;
+		layout_args.tiled.minor_to_major_size = rank;
+		if (rank > 0) {
+			for (int axisIdx = 0; axisIdx < rank; axisIdx++) {
+				minor_to_major[axisIdx] = rank - axisIdx - 1;
+			}
+			layout_args.tiled.minor_to_major = &minor_to_major[0];
 		}
-		layout_args.tiled.minor_to_major = &minor_to_major[0];
 	}
 	PJRT_Error* err = api->PJRT_Buffer_ToHostBuffer(&args);
 	if (err) {
@@ -55,8 +63,20 @@ import "C"
 // The space in dst has to hold enough space (see Buffer.Size) to hold the required data, or an error is returned.
 //
 // This always request a major-to-minor layout, the assumption of the layout in host memory -- TPUs are known to
-// reorganize the layout.
+// reorganize the layout. See ToHostWithLayout to transfer to a different host layout.
 func (b *Buffer) ToHost(dst []byte) error {
+	return b.ToHostWithLayout(dst, nil)
+}
+
+// ToHostWithLayout transfers the contents of buffer stored on device to the host, laid out in dst according to
+// the given layout (see shapes.Layout).
+// The space in dst has to hold enough space (see Buffer.Size) to hold the required data, or an error is returned.
+//
+// For instance, use shapes.ColumnMajorLayout to get the data in column-major (Fortran) order, without the need
+// of a transpose.
+//
+// If layout is nil, the major-to-minor (row-major) layout is used, same as ToHost.
+func (b *Buffer) ToHostWithLayout(dst []byte, layout *shapes.Layout) error {
 	plugin, err := b.getPlugin()
 	if err != nil {
 		return err
@@ -70,12 +90,22 @@ func (b *Buffer) ToHost(dst []byte) error {
 	}
 	rank := len(dims)
 
+	var cLayout *C.PJRT_Buffer_MemoryLayout
+	if layout != nil {
+		if err := layout.Validate(rank); err != nil {
+			return errors.WithMessage(err, "invalid layout for Buffer.ToHostWithLayout")
+		}
+		arena := plugin.getDefaultArena()
+		defer plugin.returnArena(arena)
+		cLayout = newCMemoryLayout(arena, layout)
+	}
+
 	dstBytes := unsafe.Pointer(unsafe.SliceData(dst))
 	var pinner runtime.Pinner
 	pinner.Pin(dstBytes)
 	defer pinner.Unpin()
 
-	pErr := C.BufferToHost(plugin.api, b.wrapper.c, dstBytes, C.int64_t(len(dst)), C.int(rank))
+	pErr := C.BufferToHost(plugin.api, b.wrapper.c, dstBytes, C.int64_t(len(dst)), C.int(rank), cLayout)
 	err = toError(plugin, pErr)
 	if err != nil {
 		return errors.WithMessage(err, "Failed to call PJRT_Buffer_ToHostBuffer to transfer the buffer to host")
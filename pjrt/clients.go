@@ -102,21 +102,31 @@ func pjrtClientDefaultDeviceAssignment(plugin *Plugin, client *Client, numReplic
 	return assignment, nil
 }
 
+// mallocPJRTProgram creates a PJRT_Program pointing to the program data, which must be kept alive
+// (and pinned) while the returned PJRT_Program is in use. The caller must free it with freePJRTProgram.
+func mallocPJRTProgram(program []byte, programFormat string) *C.PJRT_Program {
+	cProgram := C.new_PJRT_Program()
+	cProgram.format = C.CString(programFormat)
+	cProgram.format_size = (C.size_t)(len(programFormat))
+	cProgram.code = (*C.char)(unsafe.Pointer(unsafe.SliceData(program)))
+	cProgram.code_size = (C.size_t)(len(program))
+	return cProgram
+}
+
+// freePJRTProgram frees a PJRT_Program created with mallocPJRTProgram.
+func freePJRTProgram(cProgram *C.PJRT_Program) {
+	cFree(cProgram.format)
+	cFree(cProgram)
+}
+
 // pjrtClientCompile compiles the program. Make sure that both the program and the compileOptionsProto
 // are pinned until the C function returns.
 func pjrtClientCompile(plugin *Plugin, client *Client, program []byte, programFormat string,
 	compileOptionsProto []byte) (*LoadedExecutable, error) {
 
 	// Create the program struct.
-	var cProgram *C.PJRT_Program
-	cProgram = C.new_PJRT_Program()
-	defer cFree(cProgram)
-	cProgramFormat := C.CString(programFormat)
-	defer cFree(cProgramFormat)
-	cProgram.format = cProgramFormat
-	cProgram.format_size = (C.size_t)(len(programFormat))
-	cProgram.code = (*C.char)(unsafe.Pointer(unsafe.SliceData(program)))
-	cProgram.code_size = (C.size_t)(len(program))
+	cProgram := mallocPJRTProgram(program, programFormat)
+	defer freePJRTProgram(cProgram)
 
 	// Create args for call.
 	args := C.new_PJRT_Client_Compile_Args()
@@ -139,6 +149,47 @@ func pjrtClientCompile(plugin *Plugin, client *Client, program []byte, programFo
 	return newLoadedExecutable(plugin, client, args.executable)
 }
 
+// pjrtCompile compiles the program for the topology, without a client. The returned Executable can't be executed,
+// only serialized. Make sure that the program is pinned until the C function returns.
+func pjrtCompile(plugin *Plugin, topology *TopologyDescription, program []byte, programFormat string,
+	compileOptionsProto []byte) (*Executable, error) {
+	cProgram := mallocPJRTProgram(program, programFormat)
+	defer freePJRTProgram(cProgram)
+
+	args := C.new_PJRT_Compile_Args()
+	defer cFree(args)
+	args.topology = topology.wrapper.c
+	args.program = cProgram
+	if len(compileOptionsProto) != 0 {
+		args.compile_options = (*C.char)(C.CBytes(compileOptionsProto))
+		args.compile_options_size = (C.size_t)(len(compileOptionsProto))
+		defer cFree(args.compile_options)
+	}
+	cErr := C.call_PJRT_Compile(plugin.api, args)
+	runtime.KeepAlive(program) // Makes sure it is alive during the C call.
+	runtime.KeepAlive(topology)
+	err := toError(plugin, cErr)
+	if err != nil {
+		return nil, err
+	}
+	return newExecutable(plugin, args.executable), nil
+}
+
+// pjrtExecutableDeserializeAndLoad loads an executable serialized with Executable.Serialize.
+func pjrtExecutableDeserializeAndLoad(plugin *Plugin, client *Client, serialized []byte) (*LoadedExecutable, error) {
+	args := C.new_PJRT_Executable_DeserializeAndLoad_Args()
+	defer cFree(args)
+	args.client = client.client.c
+	args.serialized_executable = (*C.char)(C.CBytes(serialized))
+	defer cFree(args.serialized_executable)
+	args.serialized_executable_size = C.size_t(len(serialized))
+	err := toError(plugin, C.call_PJRT_Executable_DeserializeAndLoad(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newLoadedExecutable(plugin, client, args.loaded_executable)
+}
+
 // Client manages the resources of one device: its buffers, compilation and execution of HLO code.
 type Client struct {
 	plugin                    *Plugin
@@ -152,10 +203,15 @@ type Client struct {
 type clientC struct {
 	// c holds the pointer to the C/C++ structure.
 	c *C.PJRT_Client
+
+	// kvStore is the handle of the KeyValueStore given to the client, if any.
+	// It must be kept alive until the client is destroyed, since PJRT may use it at any time.
+	kvStore *keyValueStoreHandle
 }
 
 // newClient is called by Plugin.NewClient to create a new PJRT_Client wrapper.
-func newClient(plugin *Plugin, options NamedValuesMap) (*Client, error) {
+// The kvStore is optional (it can be nil), and it is used by multi-process clients.
+func newClient(plugin *Plugin, options NamedValuesMap, kvStore KeyValueStore) (*Client, error) {
 	// Create C.PJRT_Client object.
 	args := C.new_PJRT_Client_Create_Args()
 	defer cFree(args)
@@ -164,16 +220,21 @@ func newClient(plugin *Plugin, options NamedValuesMap) (*Client, error) {
 	if err != nil {
 		return nil, errors.WithMessagef(err, "invalid options when creating a new pjrt.Client")
 	}
-	// No callback support yet, so we leave the various PJRT_KeyValue... fields empty.
+	var kvStoreHandle *keyValueStoreHandle
+	if kvStore != nil {
+		kvStoreHandle = newKeyValueStoreHandle(kvStore)
+		kvStoreHandle.setCallbacks(args)
+	}
 	err = toError(plugin, C.call_PJRT_Client_Create(plugin.api, args))
 	if err != nil {
+		kvStoreHandle.Free()
 		return nil, err
 	}
 
 	// Prepare the Client object: not all initializations are fatal to the construction of the client.
 	c := &Client{
 		plugin: plugin,
-		client: &clientC{c: args.client},
+		client: &clientC{c: args.client, kvStore: kvStoreHandle},
 	}
 	c.platform, err = pjrtClientPlatformName(plugin, c)
 	if err != nil {
@@ -224,6 +285,8 @@ func (client *clientC) Destroy(plugin *Plugin) error {
 	args.client = client.c
 	err := toError(plugin, C.call_PJRT_Client_Destroy(plugin.api, args))
 	client.c = nil
+	client.kvStore.Free()
+	client.kvStore = nil
 	return err
 }
 
@@ -336,7 +399,7 @@ func (c *Client) NumForDevice(device *Device) int {
 // But the proto itself is not documented, instead see documentation in the C++ xla::CompileOptions class defined in:
 // https://github.com/openxla/xla/blob/main/xla/pjrt/pjrt_executable.h .
 func (c *Client) Compile() *CompileConfig {
-	return newCompileConfig(c)
+	return newCompileConfig(c.plugin, c, nil)
 }
 
 // BufferFromHost creates an on-device buffer with the contents copied (optionally reused, if device is CPU) from
@@ -1,3 +1,4 @@
+#include <stdlib.h>
 #include "common.h"
 
 const PJRT_Api* call_GetPJRTApiFn(GetPJRTApiFn fn) {
@@ -46,4 +47,56 @@ void Set_PJRT_NamedValue_Union(PJRT_NamedValue *named_value, PJRT_NamedValueUnio
         named_value->bool_value = split_value.bool_value;
         break;
     }
-}
\ No newline at end of file
+}
+PJRT_Error* call_PJRT_CallbackError(PJRT_CallbackError* callback_error, PJRT_Error_Code code,
+                                    const char* message, size_t message_size) {
+    return (*callback_error)(code, message, message_size);
+}
+
+void free_PJRT_KeyValue_Value(char* value) {
+    free(value);
+}
+
+PJRT_Error* call_PJRT_Error_ForEachPayload_GoVisitor(const PJRT_Api *api, PJRT_Error_ForEachPayload_Args* args) {
+    if (api->struct_size <= offsetof(PJRT_Api, PJRT_Error_ForEachPayload) || api->PJRT_Error_ForEachPayload == NULL) {
+        return NULL;
+    }
+    args->visitor = (PJRT_Error_PayloadVisitor)pjrtErrorPayloadVisitor;
+    return api->PJRT_Error_ForEachPayload(args);
+}
+
+void CallDLPackDeleter(DLManagedTensor* managed) {
+    if (managed != NULL && managed->deleter != NULL) {
+        managed->deleter(managed);
+    }
+}
+
+void OnDeleteDLPackView(void* device_buffer_ptr, void* user_arg) {
+    CallDLPackDeleter((DLManagedTensor*)user_arg);
+}
+
+PJRT_Error* AwaitEvents(const PJRT_Api *api, PJRT_Event** events, int num_events) {
+    PJRT_Error *first_err = NULL;
+    for (int ii = 0; ii < num_events; ii++) {
+        PJRT_Event_Await_Args await_args = {0};
+        await_args.struct_size = PJRT_Event_Await_Args_STRUCT_SIZE;
+        await_args.event = events[ii];
+        PJRT_Error *err = api->PJRT_Event_Await(&await_args);
+        PJRT_Event_Destroy_Args destroy_args = {0};
+        destroy_args.struct_size = PJRT_Event_Destroy_Args_STRUCT_SIZE;
+        destroy_args.event = events[ii];
+        api->PJRT_Event_Destroy(&destroy_args);
+        if (err == NULL) {
+            continue;
+        }
+        if (first_err == NULL) {
+            first_err = err;
+        } else {
+            PJRT_Error_Destroy_Args error_args = {0};
+            error_args.struct_size = PJRT_Error_Destroy_Args_STRUCT_SIZE;
+            error_args.error = err;
+            api->PJRT_Error_Destroy(&error_args);
+        }
+    }
+    return first_err;
+}
@@ -17,6 +17,7 @@
 #ifndef GOMLX_GOPJRT_COMMON
 #define GOMLX_GOPJRT_COMMON
 #include "pjrt_c_api.h"
+#include "dlpack.h"
 
 #ifdef __cplusplus
 extern "C" {
@@ -41,6 +42,41 @@ extern PJRT_NamedValueUnion Extract_PJRT_NamedValue_Union(PJRT_NamedValue *named
 // The one to use is based on named_value->type.
 extern void Set_PJRT_NamedValue_Union(PJRT_NamedValue *named_value, PJRT_NamedValueUnion split_value);
 
+// Calls the PJRT_CallbackError given to a callback (e.g. the key-value store callbacks) to create a PJRT_Error.
+extern PJRT_Error* call_PJRT_CallbackError(PJRT_CallbackError* callback_error, PJRT_Error_Code code,
+                                           const char* message, size_t message_size);
+
+// Deleter of the values returned by the key-value store callbacks: values are allocated with malloc().
+extern void free_PJRT_KeyValue_Value(char* value);
+
+// Key-value store callbacks, implemented in Go (see keyvaluestore.go).
+extern PJRT_Error* pjrtKeyValueGetCallback(PJRT_KeyValueGetCallback_Args* args);
+extern PJRT_Error* pjrtKeyValueTryGetCallback(PJRT_KeyValueTryGetCallback_Args* args);
+extern PJRT_Error* pjrtKeyValuePutCallback(PJRT_KeyValuePutCallback_Args* args);
+
+// Visitor of the payloads of a PJRT_Error, implemented in Go (see error.go).
+extern void pjrtErrorPayloadVisitor(char* key, size_t key_size, char* value, size_t value_size, void* user_arg);
+
+// Calls PJRT_Error_ForEachPayload with pjrtErrorPayloadVisitor, if the plugin supports it.
+// Otherwise, it returns NULL without visiting anything.
+extern PJRT_Error* call_PJRT_Error_ForEachPayload_GoVisitor(const PJRT_Api *api, PJRT_Error_ForEachPayload_Args* args);
+
+// Deleter of the DLManagedTensor exported by Buffer.ToDLPack, implemented in Go (see dlpack.go).
+extern void pjrtDLPackDeleter(DLManagedTensor* self);
+
+// Calls the deleter of the DLManagedTensor, if it has one, as a DLPack consumer does when it's done with it.
+extern void CallDLPackDeleter(DLManagedTensor* managed);
+
+// On-delete callback of the buffers imported with Client.BufferFromDLPack: it calls the deleter of the
+// DLManagedTensor given as user_arg.
+extern void OnDeleteDLPackView(void* device_buffer_ptr, void* user_arg);
+
+// Waits for all the events to be ready, and destroys them. It returns the first error, if any.
+extern PJRT_Error* AwaitEvents(const PJRT_Api *api, PJRT_Event** events, int num_events);
+
+// Callback of the completion events of asynchronous executions, implemented in Go (see loadedexecutables_async.go).
+extern void pjrtExecutionEventReady(PJRT_Error* error, void* user_arg);
+
 #ifdef __cplusplus
 }  // extern "C"
 #endif
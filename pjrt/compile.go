@@ -2,6 +2,7 @@ package pjrt
 
 import "C"
 import (
+	"context"
 	"os"
 	"runtime"
 	"unsafe"
@@ -10,6 +11,7 @@ import (
 	"github.com/gomlx/go-xla/internal/protos/compile_options"
 	"github.com/gomlx/go-xla/internal/protos/xla"
 	"github.com/gomlx/go-xla/internal/protos/xla_data"
+	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/pkg/errors"
 	"google.golang.org/protobuf/encoding/prototext"
 	"google.golang.org/protobuf/proto"
@@ -18,6 +20,9 @@ import (
 
 // EnvXlaDebugOptions is an environment variable that can be defined to set XLA DebugOptions proto when compiling
 // a program.
+//
+// Prefer the typed CompileConfig setters (e.g. CompileConfig.WithDump or CompileConfig.WithFastMath), which are
+// validated and override the corresponding fields set by the environment variable.
 const EnvXlaDebugOptions = "XLA_DEBUG_OPTIONS"
 
 // CompileConfig is created with Client.Compile, and is a "builder pattern" to configure a compilation call.
@@ -27,11 +32,20 @@ const EnvXlaDebugOptions = "XLA_DEBUG_OPTIONS"
 //
 // Once finished call CompileConfig.Done to trigger the compilation and get back a LoadedExecutable or an error.
 //
-// TODO: expose all (or more) configuration options with "WithX" methods.
+// For ahead-of-time compilation (see Plugin.CompileForTopology), call CompileConfig.DoneSerialized instead, to get
+// back the serialized executable.
+//
+// Compilation options are set with the "WithX" methods, e.g. CompileConfig.WithDump, CompileConfig.WithAutotuneLevel,
+// CompileConfig.WithFastMath or CompileConfig.WithEnvOptionOverride.
+//
+// TODO: expose more configuration options with "WithX" methods.
 type CompileConfig struct {
 	plugin *Plugin
 	client *Client
 
+	// topology is set for ahead-of-time compilation (see Plugin.CompileForTopology), in which case client is nil.
+	topology *TopologyDescription
+
 	// program can be a pointer to C/C++ data, but it must be kept alive until after CompileConfig.Done is called.
 	program []byte
 
@@ -52,14 +66,20 @@ type CompileConfig struct {
 	// Device assignment:
 	deviceAssignment []int
 
+	// parameterShapes and parameterMemoryKinds are set by WithParameterMemoryKinds, and applied to the argument
+	// layouts in Done.
+	parameterShapes      []shapes.Shape
+	parameterMemoryKinds []string
+
 	// err is the first error that occurred during setup.
 	err error
 }
 
-func newCompileConfig(client *Client) (cc *CompileConfig) {
+func newCompileConfig(plugin *Plugin, client *Client, topology *TopologyDescription) (cc *CompileConfig) {
 	cc = &CompileConfig{
-		plugin: client.plugin,
-		client: client,
+		plugin:   plugin,
+		client:   client,
+		topology: topology,
 		options: &compile_options.CompileOptionsProto{
 			ArgumentLayouts:            nil,
 			ParameterIsTupledArguments: false,
@@ -101,16 +121,137 @@ func newCompileConfig(client *Client) (cc *CompileConfig) {
 	return cc
 }
 
+// CompileForTopology compiles a program ahead-of-time for the given topology, without requiring a client or the
+// devices to be present -- e.g. to produce compiled artifacts in a CI pipeline ahead of deployment.
+//
+// It returns a CompileConfig to be configured like with Client.Compile: at the very least the program must be
+// given (see CompileConfig.WithStableHLO), and other compilation options can be set. Then
+// CompileConfig.DoneSerialized triggers the compilation and returns the serialized executable, to be loaded later
+// with Client.LoadSerializedExecutable.
+//
+// The topology can be created with Plugin.NewTopologyDescription, Plugin.DeserializeTopologyDescription or
+// Client.TopologyDescription. Not all plugins support compiling without a client.
+func (p *Plugin) CompileForTopology(topology *TopologyDescription) *CompileConfig {
+	cc := newCompileConfig(p, nil, topology)
+	if cc.err == nil && !topology.IsValid() {
+		cc.err = errors.New("Plugin.CompileForTopology given a nil or destroyed topology")
+	} else if cc.err == nil && p.api.PJRT_Compile == nil {
+		cc.err = errors.Errorf("plugin %s doesn't support compiling for a topology", p)
+	}
+	return cc
+}
+
 var compileConfigOnlyOnce = errors.Errorf("the CompileConfig can only be used once")
 
 // Done triggers the compilation of the program. If the compilation succeeds a LoadedExecutable is returned, otherwise
 // an error is returned.
 func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
+	if cc.client == nil && cc.topology != nil && cc.err == nil {
+		return nil, errors.New("CompileConfig created with Plugin.CompileForTopology has no client to load the " +
+			"executable, use DoneSerialized() instead")
+	}
 	if cc.client == nil || cc.plugin == nil {
 		return nil, errors.New("misconfigured CompileConfig, or an attempt of using it more than once, which is not supported -- call Client.Compile() again")
 	}
+	var exec *LoadedExecutable
+	numReplicas, numPartitions, err := cc.compile(func(binOptions []byte) (err error) {
+		klog.V(2).Infof("calling pjrtClientCompile()")
+		exec, err = pjrtClientCompile(cc.plugin, cc.client, cc.program, cc.programFormat, binOptions)
+		if err != nil {
+			klog.V(1).Infof("pjrtClientCompile() failed: %v", err)
+			return err
+		}
+		klog.V(2).Infof("pjrtClientCompile() succeeded")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Carry over configuration information:
+	exec.numReplicas = numReplicas
+	exec.numPartitions = numPartitions
+	exec.deviceAssignment = cc.deviceAssignment
+	exec.isPortable = cc.options.CompilePortableExecutable
+	cc.options = nil // We can make sure this is freed.
+	return exec, nil
+}
+
+// DoneContext triggers the compilation of the program, like Done, but it returns promptly with an error if the
+// context is cancelled (or its deadline is exceeded) before the compilation finishes.
+//
+// PJRT compilation can't be interrupted: it runs in a separate goroutine, and if the context is cancelled the
+// compilation is abandoned -- it runs to completion in the background, and the resulting executable is destroyed.
+func (cc *CompileConfig) DoneContext(ctx context.Context) (*LoadedExecutable, error) {
+	if ctx.Done() == nil {
+		// Context can't be cancelled.
+		return cc.Done()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "compilation not started")
+	}
+	type compileResult struct {
+		exec *LoadedExecutable
+		err  error
+	}
+	resultChan := make(chan compileResult, 1)
+	go func() {
+		exec, err := cc.Done()
+		resultChan <- compileResult{exec, err}
+	}()
+	select {
+	case result := <-resultChan:
+		return result.exec, result.err
+	case <-ctx.Done():
+	}
+	go func() {
+		result := <-resultChan
+		if result.exec != nil {
+			klog.V(1).Infof("Destroying executable %q of abandoned compilation", result.exec.Name)
+			result.exec.destroyOrLog()
+		}
+	}()
+	return nil, errors.Wrapf(context.Cause(ctx), "compilation abandoned")
+}
+
+// DoneSerialized triggers the ahead-of-time compilation of the program for the topology given to
+// Plugin.CompileForTopology, and returns the serialized executable.
+//
+// The serialized executable can be stored and later loaded with Client.LoadSerializedExecutable, by a client
+// of the same platform and plugin version, with devices matching the topology.
+func (cc *CompileConfig) DoneSerialized() ([]byte, error) {
+	if cc.topology == nil && cc.err == nil {
+		return nil, errors.New("DoneSerialized requires a CompileConfig created with Plugin.CompileForTopology, " +
+			"use LoadedExecutable.Serialize to serialize a program compiled by a client")
+	}
+	if cc.plugin == nil || !cc.topology.IsValid() {
+		return nil, errors.New("misconfigured CompileConfig, or an attempt of using it more than once, which is not supported -- call Plugin.CompileForTopology() again")
+	}
+	var serialized []byte
+	_, _, err := cc.compile(func(binOptions []byte) error {
+		exec, err := pjrtCompile(cc.plugin, cc.topology, cc.program, cc.programFormat, binOptions)
+		if err != nil {
+			return err
+		}
+		defer exec.destroyOrLog()
+		serialized, err = exec.Serialize()
+		if err != nil {
+			return errors.WithMessagef(err, "failed to serialize the compiled executable")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cc.options = nil // We can make sure this is freed.
+	return serialized, nil
+}
+
+// compile validates the configuration and calls compileFn with the serialized CompileOptionsProto, while
+// the program and the options are pinned. The CompileConfig can only be used once.
+func (cc *CompileConfig) compile(compileFn func(binOptions []byte) error) (numReplicas, numPartitions int, err error) {
 	if cc.err != nil {
-		return nil, cc.err
+		return 0, 0, cc.err
 	}
 	cc.err = compileConfigOnlyOnce
 
@@ -122,36 +263,43 @@ func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
 		// CompileConfig can only be used once.
 		cc.client = nil
 		cc.plugin = nil
+		cc.topology = nil
 	}()
 
 	// Other sanity checks.
 	if cc.programFormat == "" || len(cc.program) == 0 {
-		return nil, errors.New("no program given to Client.Compile(), use Client.Compile().WithComputation() or ClientCompile().WithSLO() " +
+		return 0, 0, errors.New("no program given to Client.Compile(), use Client.Compile().WithComputation() or ClientCompile().WithSLO() " +
 			"to specify a program, before calling Done()")
 	}
 
 	// Device assignment:
-	numReplicas := max(1, int(cc.options.ExecutableBuildOptions.NumReplicas))
-	numPartitions := max(1, int(cc.options.ExecutableBuildOptions.NumPartitions))
+	numReplicas = max(1, int(cc.options.ExecutableBuildOptions.NumReplicas))
+	numPartitions = max(1, int(cc.options.ExecutableBuildOptions.NumPartitions))
 	numDevices := numReplicas * numPartitions
 	if cc.options.CompilePortableExecutable {
 		if numDevices > 1 {
-			return nil, errors.Errorf(
+			return 0, 0, errors.Errorf(
 				"portable computations must be on one device only, it can't be a SPMD or MPMD: got NumReplicas=%d "+
 					"and NumPartitions=%d", numReplicas, numPartitions)
 		}
 		if cc.deviceAssignment != nil {
-			return nil, errors.New(
+			return 0, 0, errors.New(
 				"portable computations cannot have a device assignment: by definition they are defined to " +
 					"run on any device")
 		}
 	} else {
 		if cc.options.ExecutableBuildOptions.DeviceAssignment == nil {
-			return nil, errors.New("no device assignment given to Client.Compile(), but computation is not " +
+			return 0, 0, errors.New("no device assignment given to Client.Compile(), but computation is not " +
 				"(device) portable!?")
 		}
 	}
 
+	if cc.parameterMemoryKinds != nil {
+		if err := cc.applyParameterMemoryKinds(); err != nil {
+			return 0, 0, err
+		}
+	}
+
 	// Makes sure the GC does not move around program data during the C/C++ call.
 	var pinner runtime.Pinner
 	programPtr := unsafe.SliceData(cc.program)
@@ -162,28 +310,17 @@ func (cc *CompileConfig) Done() (*LoadedExecutable, error) {
 	// Get options and pin it.
 	binOptions, err := proto.Marshal(cc.options)
 	if err != nil {
-		return nil, errors.WithMessagef(err, "failed to marshal the CompileOptionsProto to be passed to the PJRT plugin")
+		return 0, 0, errors.WithMessagef(err, "failed to marshal the CompileOptionsProto to be passed to the PJRT plugin")
 	}
 	if klog.V(1).Enabled() {
 		klog.Infof("CompileOptions: {\n%s}\n", prototext.Format(cc.options))
 	}
 	pinner.Pin(unsafe.SliceData(binOptions))
 
-	klog.V(2).Infof("calling pjrtClientCompile()")
-	exec, err := pjrtClientCompile(cc.plugin, cc.client, cc.program, cc.programFormat, binOptions)
-	if err != nil {
-		klog.V(1).Infof("pjrtClientCompile() failed: %v", err)
-		return nil, errors.WithMessagef(err, "failed to compile the program")
+	if err = compileFn(binOptions); err != nil {
+		return 0, 0, errors.WithMessagef(err, "failed to compile the program")
 	}
-
-	// Carry over configuration information:
-	exec.numReplicas = numReplicas
-	exec.numPartitions = numPartitions
-	exec.deviceAssignment = cc.deviceAssignment
-	exec.isPortable = cc.options.CompilePortableExecutable
-	klog.V(2).Infof("pjrtClientCompile() succeeded")
-	cc.options = nil // We can make sure this is freed.
-	return exec, nil
+	return numReplicas, numPartitions, nil
 }
 
 // WithHLO configures the program to the serialized HLO (HloModule proto).
@@ -320,9 +457,14 @@ func (cc *CompileConfig) WithSPMD(numReplicas int) *CompileConfig {
 	if cc.err != nil {
 		return cc
 	}
-	if numReplicas <= 0 || numReplicas > cc.client.NumDevices() {
+	numDevices, err := cc.numDevices()
+	if err != nil {
+		cc.err = err
+		return cc
+	}
+	if numReplicas <= 0 || numReplicas > numDevices {
 		cc.err = errors.Errorf("invalid numReplicas=%d, must be >= 1 and <= %d (number of devices for the client)",
-			numReplicas, cc.client.NumDevices())
+			numReplicas, numDevices)
 		return cc
 	}
 	cc.options.ExecutableBuildOptions.UseSpmdPartitioning = true
@@ -418,13 +560,163 @@ func (cc *CompileConfig) WithDeviceAssignment(assignment []int) *CompileConfig {
 	return cc
 }
 
+// WithArgumentLayouts configures the memory layout (see shapes.Layout) of the arguments of the program,
+// given by their shapes.
+//
+// The argShapes and layouts slices must have the same length, one per argument of the program.
+// Each layout can be nil, in which case the default row-major layout is used.
+//
+// Alternatively, the layouts can be set in the program itself, see stablehlo.Function.NamedInputWithLayout.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithArgumentLayouts(argShapes []shapes.Shape, layouts []*shapes.Layout) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if len(argShapes) != len(layouts) {
+		cc.err = errors.Errorf("WithArgumentLayouts requires the same number of shapes and layouts, got %d and %d",
+			len(argShapes), len(layouts))
+		return cc
+	}
+	cc.options.ArgumentLayouts = make([]*xla_data.ShapeProto, len(argShapes))
+	for i, shape := range argShapes {
+		shapeProto, err := shapeWithLayoutToProto(shape, layouts[i])
+		if err != nil {
+			cc.err = errors.WithMessagef(err, "WithArgumentLayouts failed for argument #%d", i)
+			return cc
+		}
+		cc.options.ArgumentLayouts[i] = shapeProto
+	}
+	return cc
+}
+
+// WithResultLayouts configures the memory layout (see shapes.Layout) of the results of the program,
+// given by their shapes.
+//
+// The resultShapes and layouts slices must have the same length, one per result of the program.
+// Each layout can be nil, in which case the default row-major layout is used.
+//
+// Alternatively, the layouts can be set in the program itself, see stablehlo.Function.ReturnWithLayouts.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithResultLayouts(resultShapes []shapes.Shape, layouts []*shapes.Layout) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if len(resultShapes) != len(layouts) {
+		cc.err = errors.Errorf("WithResultLayouts requires the same number of shapes and layouts, got %d and %d",
+			len(resultShapes), len(layouts))
+		return cc
+	}
+	resultProtos := make([]*xla_data.ShapeProto, len(resultShapes))
+	for i, shape := range resultShapes {
+		shapeProto, err := shapeWithLayoutToProto(shape, layouts[i])
+		if err != nil {
+			cc.err = errors.WithMessagef(err, "WithResultLayouts failed for result #%d", i)
+			return cc
+		}
+		resultProtos[i] = shapeProto
+	}
+	if len(resultProtos) == 1 {
+		cc.options.ExecutableBuildOptions.ResultLayout = resultProtos[0]
+	} else {
+		// Multiple results are represented by a tuple.
+		cc.options.ExecutableBuildOptions.ResultLayout = &xla_data.ShapeProto{
+			ElementType: xla_data.PrimitiveType_TUPLE,
+			TupleShapes: resultProtos,
+		}
+	}
+	return cc
+}
+
+// Memory spaces of the XLA layouts, used to place the parameters of the program in a memory kind.
+const (
+	defaultMemorySpace = 0
+	hostMemorySpace    = 5
+)
+
+// WithParameterMemoryKinds configures the memory kind of each parameter of the program, given by their shapes:
+// MemoryKindDevice (the default) or MemoryKindPinnedHost, e.g. to keep the optimizer state in host memory.
+// The parameters must then be given in buffers in that memory, see BufferFromHostConfig.ToMemory and
+// Buffer.CopyToMemory.
+//
+// The argShapes and memoryKinds slices must have the same length, one per parameter of the program.
+// An empty memory kind is the same as MemoryKindDevice.
+//
+// It can be combined with WithArgumentLayouts (with the same shapes). The memory kinds of the compiled program can
+// be checked with LoadedExecutable.ParameterMemoryKinds.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithParameterMemoryKinds(argShapes []shapes.Shape, memoryKinds []string) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if len(argShapes) != len(memoryKinds) {
+		cc.err = errors.Errorf("WithParameterMemoryKinds requires the same number of shapes and memory kinds, "+
+			"got %d and %d", len(argShapes), len(memoryKinds))
+		return cc
+	}
+	for i, kind := range memoryKinds {
+		if _, err := memorySpaceForKind(kind); err != nil {
+			cc.err = errors.WithMessagef(err, "WithParameterMemoryKinds failed for parameter #%d", i)
+			return cc
+		}
+	}
+	cc.parameterShapes = argShapes
+	cc.parameterMemoryKinds = memoryKinds
+	return cc
+}
+
+// memorySpaceForKind returns the XLA layout memory space for the memory kind.
+func memorySpaceForKind(kind string) (int64, error) {
+	switch kind {
+	case "", MemoryKindDevice:
+		return defaultMemorySpace, nil
+	case MemoryKindPinnedHost:
+		return hostMemorySpace, nil
+	default:
+		return 0, errors.Errorf("memory kind %q not supported for parameters, use %q or %q",
+			kind, MemoryKindDevice, MemoryKindPinnedHost)
+	}
+}
+
+// applyParameterMemoryKinds sets the memory space of the argument layouts, creating default ones if
+// WithArgumentLayouts was not used.
+func (cc *CompileConfig) applyParameterMemoryKinds() error {
+	if cc.options.ArgumentLayouts == nil {
+		cc.options.ArgumentLayouts = make([]*xla_data.ShapeProto, len(cc.parameterShapes))
+		for i, shape := range cc.parameterShapes {
+			shapeProto, err := shapeWithLayoutToProto(shape, nil)
+			if err != nil {
+				return errors.WithMessagef(err, "WithParameterMemoryKinds failed for parameter #%d", i)
+			}
+			cc.options.ArgumentLayouts[i] = shapeProto
+		}
+	}
+	if len(cc.options.ArgumentLayouts) != len(cc.parameterMemoryKinds) {
+		return errors.Errorf("WithArgumentLayouts configured %d arguments, but WithParameterMemoryKinds %d",
+			len(cc.options.ArgumentLayouts), len(cc.parameterMemoryKinds))
+	}
+	for i, kind := range cc.parameterMemoryKinds {
+		memorySpace, _ := memorySpaceForKind(kind)
+		cc.options.ArgumentLayouts[i].Layout.MemorySpace = memorySpace
+	}
+	return nil
+}
+
 func (cc *CompileConfig) setDefaultDeviceAssignment() {
 	if cc.err != nil {
 		return
 	}
 	numReplicas := int(cc.options.ExecutableBuildOptions.NumReplicas)
 	numPartitions := int(cc.options.ExecutableBuildOptions.NumPartitions)
-	assignment, err := cc.client.DefaultDeviceAssignment(numReplicas, numPartitions)
+	var assignment []int
+	var err error
+	if cc.client != nil {
+		assignment, err = cc.client.DefaultDeviceAssignment(numReplicas, numPartitions)
+	} else {
+		assignment, err = cc.topologyDefaultDeviceAssignment(numReplicas * numPartitions)
+	}
 	if err != nil {
 		cc.err = errors.WithMessagef(err, "failed to get default device assignment %d replicas and %d partitions",
 			numReplicas, numPartitions) //
@@ -437,3 +729,24 @@ func (cc *CompileConfig) setDefaultDeviceAssignment() {
 	}
 	_ = cc.WithDeviceAssignment(assignment)
 }
+
+// numDevices returns the number of devices available to the compiled program: the addressable devices of the
+// client, or the devices of the topology.
+func (cc *CompileConfig) numDevices() (int, error) {
+	if cc.client != nil {
+		return cc.client.NumDevices(), nil
+	}
+	return cc.topology.NumDevices()
+}
+
+// topologyDefaultDeviceAssignment assigns the first numDevices devices of the topology, in the order of their IDs.
+func (cc *CompileConfig) topologyDefaultDeviceAssignment(numDevices int) ([]int, error) {
+	ids, err := cc.topology.deviceIDs()
+	if err != nil {
+		return nil, err
+	}
+	if numDevices > len(ids) {
+		return nil, errors.Errorf("topology has only %d devices, %d requested", len(ids), numDevices)
+	}
+	return ids[:numDevices], nil
+}
@@ -0,0 +1,241 @@
+package pjrt
+
+import (
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/protos/compile_options"
+	"github.com/gomlx/go-xla/internal/protos/xla"
+	"github.com/pkg/errors"
+)
+
+// DumpFormat is a format in which XLA dumps the HLO modules it compiles, see CompileConfig.WithDump.
+type DumpFormat string
+
+const (
+	// DumpAsText dumps the HLO modules in the human-readable HLO text format.
+	DumpAsText DumpFormat = "text"
+
+	// DumpAsProto dumps the HLO modules as serialized HloProto.
+	DumpAsProto DumpFormat = "proto"
+
+	// DumpAsDot dumps the HLO graphs in the GraphViz DOT format.
+	DumpAsDot DumpFormat = "dot"
+
+	// DumpAsHTML dumps the HLO graphs as an HTML page (DOT graph converted to SVG, inlined in the HTML).
+	DumpAsHTML DumpFormat = "html"
+)
+
+// ParseDumpFormat parses the name of a DumpFormat (case-insensitive).
+func ParseDumpFormat(name string) (DumpFormat, error) {
+	switch format := DumpFormat(strings.ToLower(name)); format {
+	case DumpAsText, DumpAsProto, DumpAsDot, DumpAsHTML:
+		return format, nil
+	default:
+		return "", errors.Errorf("unknown XLA dump format %q, valid formats are %q, %q, %q and %q",
+			name, DumpAsText, DumpAsProto, DumpAsDot, DumpAsHTML)
+	}
+}
+
+// FastMathFlags configures the fast-math optimizations of XLA (currently used by the CPU plugin only),
+// see CompileConfig.WithFastMath.
+//
+// Fast-math allows XLA to reduce the precision of operations (e.g. use approximate functions, or transform x/y into
+// x * (1/y)), and to assume that operations never produce or consume NaN or +/-Inf, unless the corresponding
+// "Honor" flag is set.
+type FastMathFlags int
+
+const (
+	// FastMathEnabled enables the fast-math optimizations. Without it, the other flags are ignored.
+	FastMathEnabled FastMathFlags = 1 << iota
+
+	// FastMathHonorInfs keeps the handling of +/-Inf correct.
+	FastMathHonorInfs
+
+	// FastMathHonorNaNs keeps the handling of NaN correct.
+	FastMathHonorNaNs
+
+	// FastMathHonorDivision forbids replacing a division by a multiplication by the reciprocal.
+	FastMathHonorDivision
+
+	// FastMathHonorFunctions forbids approximating the computation of functions (e.g. exp or tanh).
+	FastMathHonorFunctions
+
+	// FastMathDisabled disables fast-math optimizations.
+	FastMathDisabled FastMathFlags = 0
+)
+
+// MaxAutotuneLevel is the maximum level accepted by CompileConfig.WithAutotuneLevel.
+const MaxAutotuneLevel = 4
+
+// debugOptions returns the xla.DebugOptions of the configuration, creating it if needed.
+func (cc *CompileConfig) debugOptions() *xla.DebugOptions {
+	buildOptions := cc.options.ExecutableBuildOptions
+	if buildOptions.DebugOptions == nil {
+		buildOptions.DebugOptions = &xla.DebugOptions{}
+	}
+	return buildOptions.DebugOptions
+}
+
+// ptr returns a pointer to a copy of v, used to set optional proto fields.
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// WithDump configures XLA to dump the HLO modules it compiles (before and after optimizations) to the directory dir,
+// in the given formats. If no format is given, it defaults to DumpAsText.
+//
+// This overrides the corresponding fields set with the XLA_DEBUG_OPTIONS environment variable (see EnvXlaDebugOptions).
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithDump(dir string, formats ...DumpFormat) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if dir == "" {
+		cc.err = errors.New("WithDump requires a directory to dump to")
+		return cc
+	}
+	if len(formats) == 0 {
+		formats = []DumpFormat{DumpAsText}
+	}
+	debugOptions := cc.debugOptions()
+	debugOptions.XlaDumpTo = ptr(dir)
+	debugOptions.XlaDumpHloAsText = ptr(false)
+	debugOptions.XlaDumpHloAsProto = ptr(false)
+	debugOptions.XlaDumpHloAsDot = ptr(false)
+	debugOptions.XlaDumpHloAsHtml = ptr(false)
+	for _, format := range formats {
+		switch format {
+		case DumpAsText:
+			debugOptions.XlaDumpHloAsText = ptr(true)
+		case DumpAsProto:
+			debugOptions.XlaDumpHloAsProto = ptr(true)
+		case DumpAsDot:
+			debugOptions.XlaDumpHloAsDot = ptr(true)
+		case DumpAsHTML:
+			debugOptions.XlaDumpHloAsHtml = ptr(true)
+		default:
+			_, cc.err = ParseDumpFormat(string(format))
+			return cc
+		}
+	}
+	return cc
+}
+
+// WithAutotuneLevel sets the level of autotuning of the GPU kernels, from 0 (disabled) to MaxAutotuneLevel.
+// Higher levels also check the correctness of the autotuned kernels, see XLA's xla_gpu_autotune_level flag.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithAutotuneLevel(level int) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if level < 0 || level > MaxAutotuneLevel {
+		cc.err = errors.Errorf("invalid autotune level %d, it must be between 0 and %d", level, MaxAutotuneLevel)
+		return cc
+	}
+	cc.debugOptions().XlaGpuAutotuneLevel = ptr(int32(level))
+	return cc
+}
+
+// WithDeterministicOps configures XLA to guarantee run-to-run determinism on GPUs: it excludes non-deterministic
+// operations (e.g. atomics-based scatter) and disables autotuning.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithDeterministicOps(deterministic bool) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	cc.debugOptions().XlaGpuDeterministicOps = ptr(deterministic)
+	return cc
+}
+
+// WithFastMath configures the fast-math optimizations, see FastMathFlags.
+// Use FastMathDisabled to disable them, or for instance FastMathEnabled|FastMathHonorNaNs to enable them while
+// keeping the handling of NaNs correct.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithFastMath(flags FastMathFlags) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	const allFlags = FastMathEnabled | FastMathHonorInfs | FastMathHonorNaNs | FastMathHonorDivision | FastMathHonorFunctions
+	if flags&^allFlags != 0 {
+		cc.err = errors.Errorf("invalid FastMathFlags %#x", int(flags))
+		return cc
+	}
+	debugOptions := cc.debugOptions()
+	debugOptions.XlaCpuEnableFastMath = ptr(flags&FastMathEnabled != 0)
+	debugOptions.XlaCpuFastMathHonorInfs = ptr(flags&FastMathHonorInfs != 0)
+	debugOptions.XlaCpuFastMathHonorNans = ptr(flags&FastMathHonorNaNs != 0)
+	debugOptions.XlaCpuFastMathHonorDivision = ptr(flags&FastMathHonorDivision != 0)
+	debugOptions.XlaCpuFastMathHonorFunctions = ptr(flags&FastMathHonorFunctions != 0)
+	return cc
+}
+
+// WithMemoryLimit sets the device memory size (in bytes) the compiler should target: XLA rematerializes
+// (recomputes) intermediate values to fit the program within the limit, trading compute for memory.
+// A value of 0 means no limit.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithMemoryLimit(bytes int64) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if bytes < 0 {
+		cc.err = errors.Errorf("invalid memory limit %d, it must be >= 0", bytes)
+		return cc
+	}
+	cc.options.ExecutableBuildOptions.DeviceMemorySize = bytes
+	return cc
+}
+
+// WithAliasPassthroughParams configures whether the input and output buffers are aliased if the associated parameter
+// is passed-through the program unchanged -- it saves memory and copies.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithAliasPassthroughParams(alias bool) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	cc.options.ExecutableBuildOptions.AliasPassthroughParams = alias
+	return cc
+}
+
+// WithEnvOptionOverride sets a plugin-specific compilation option, overriding the value given by the environment
+// (e.g. XLA flags, like "xla_gpu_enable_latency_hiding_scheduler").
+//
+// The value must be a string, a bool, an int, an int64 or a float64.
+//
+// It returns itself (CompileConfig) to allow cascading configuration calls.
+func (cc *CompileConfig) WithEnvOptionOverride(name string, value any) *CompileConfig {
+	if cc.err != nil {
+		return cc
+	}
+	if name == "" {
+		cc.err = errors.New("WithEnvOptionOverride requires a non-empty option name")
+		return cc
+	}
+	override := &compile_options.OptionOverrideProto{}
+	switch v := value.(type) {
+	case string:
+		override.Value = &compile_options.OptionOverrideProto_StringField{StringField: v}
+	case bool:
+		override.Value = &compile_options.OptionOverrideProto_BoolField{BoolField: v}
+	case int:
+		override.Value = &compile_options.OptionOverrideProto_IntField{IntField: int64(v)}
+	case int64:
+		override.Value = &compile_options.OptionOverrideProto_IntField{IntField: v}
+	case float64:
+		override.Value = &compile_options.OptionOverrideProto_DoubleField{DoubleField: v}
+	default:
+		cc.err = errors.Errorf("option override %q set to unsupported type %T (value=%v): only string, bool, "+
+			"int, int64 and float64 are supported", name, value, value)
+		return cc
+	}
+	if cc.options.EnvOptionOverrides == nil {
+		cc.options.EnvOptionOverrides = make(map[string]*compile_options.OptionOverrideProto)
+	}
+	cc.options.EnvOptionOverrides[name] = override
+	return cc
+}
@@ -0,0 +1,62 @@
+package pjrt
+
+import (
+	"testing"
+
+	"github.com/gomlx/go-xla/internal/protos/compile_options"
+)
+
+func TestCompileConfig_TypedOptions(t *testing.T) {
+	newConfig := func() *CompileConfig {
+		return &CompileConfig{options: &compile_options.CompileOptionsProto{
+			ExecutableBuildOptions: &compile_options.ExecutableBuildOptionsProto{},
+		}}
+	}
+
+	cc := newConfig().
+		WithDump("/tmp/xla_dump", DumpAsProto, DumpAsHTML).
+		WithAutotuneLevel(2).
+		WithDeterministicOps(true).
+		WithFastMath(FastMathEnabled|FastMathHonorNaNs).
+		WithMemoryLimit(1<<30).
+		WithAliasPassthroughParams(true).
+		WithEnvOptionOverride("xla_gpu_enable_latency_hiding_scheduler", true).
+		WithEnvOptionOverride("some_int_option", 7)
+	requireNoError(t, cc.err)
+	debugOptions := cc.options.ExecutableBuildOptions.DebugOptions
+	assertEqual(t, "/tmp/xla_dump", debugOptions.GetXlaDumpTo())
+	assertFalse(t, debugOptions.GetXlaDumpHloAsText())
+	assertTrue(t, debugOptions.GetXlaDumpHloAsProto())
+	assertTrue(t, debugOptions.GetXlaDumpHloAsHtml())
+	assertEqual(t, int32(2), debugOptions.GetXlaGpuAutotuneLevel())
+	assertTrue(t, debugOptions.GetXlaGpuDeterministicOps())
+	assertTrue(t, debugOptions.GetXlaCpuEnableFastMath())
+	assertTrue(t, debugOptions.GetXlaCpuFastMathHonorNans())
+	assertFalse(t, debugOptions.GetXlaCpuFastMathHonorInfs())
+	assertEqual(t, int64(1<<30), cc.options.ExecutableBuildOptions.DeviceMemorySize)
+	assertTrue(t, cc.options.ExecutableBuildOptions.AliasPassthroughParams)
+	assertTrue(t, cc.options.EnvOptionOverrides["xla_gpu_enable_latency_hiding_scheduler"].GetBoolField())
+	assertEqual(t, int64(7), cc.options.EnvOptionOverrides["some_int_option"].GetIntField())
+
+	// Default dump format is text.
+	cc = newConfig().WithDump("/tmp/xla_dump")
+	requireNoError(t, cc.err)
+	assertTrue(t, cc.options.ExecutableBuildOptions.DebugOptions.GetXlaDumpHloAsText())
+
+	// Validation errors.
+	requireError(t, newConfig().WithDump("").err)
+	requireError(t, newConfig().WithDump("/tmp/xla_dump", "svg").err)
+	requireError(t, newConfig().WithAutotuneLevel(-1).err)
+	requireError(t, newConfig().WithAutotuneLevel(MaxAutotuneLevel+1).err)
+	requireError(t, newConfig().WithFastMath(FastMathHonorFunctions<<1).err)
+	requireError(t, newConfig().WithMemoryLimit(-1).err)
+	requireError(t, newConfig().WithEnvOptionOverride("", true).err)
+	requireError(t, newConfig().WithEnvOptionOverride("float32_option", float32(1)).err)
+
+	// ParseDumpFormat.
+	format, err := ParseDumpFormat("HTML")
+	requireNoError(t, err)
+	assertEqual(t, DumpAsHTML, format)
+	_, err = ParseDumpFormat("svg")
+	requireError(t, err)
+}
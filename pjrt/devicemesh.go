@@ -0,0 +1,170 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"cmp"
+	"slices"
+
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// topologyDevice holds the information used to order the devices by their physical topology.
+type topologyDevice struct {
+	id, processIndex int
+
+	// sliceIndex is the "slice_index" attribute of multi-slice platforms (e.g. TPU pods), or -1.
+	sliceIndex int
+
+	// coords is the "coords" attribute, the physical position of the chip (e.g. TPUs), or nil.
+	coords []int64
+
+	// coreOnChip is the "core_on_chip" attribute, for chips with multiple cores, or -1.
+	coreOnChip int
+}
+
+// newTopologyDevice creates a topologyDevice from the device attributes.
+func newTopologyDevice(id, processIndex int, attributes NamedValuesMap) topologyDevice {
+	device := topologyDevice{id: id, processIndex: processIndex, sliceIndex: -1, coreOnChip: -1}
+	if v, ok := attributes["slice_index"].(int64); ok {
+		device.sliceIndex = int(v)
+	}
+	if v, ok := attributes["coords"].([]int64); ok {
+		device.coords = v
+	}
+	if v, ok := attributes["core_on_chip"].(int64); ok {
+		device.coreOnChip = int(v)
+	}
+	return device
+}
+
+// compareTopologyDevices orders devices such that devices physically close are adjacent: by process, slice,
+// physical coordinates (the last coordinate is the most significant) and core on the chip.
+// Devices without topology attributes are ordered by their ID.
+func compareTopologyDevices(a, b topologyDevice) int {
+	if c := cmp.Compare(a.processIndex, b.processIndex); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.sliceIndex, b.sliceIndex); c != 0 {
+		return c
+	}
+	if len(a.coords) == len(b.coords) {
+		for i := len(a.coords) - 1; i >= 0; i-- {
+			if c := cmp.Compare(a.coords[i], b.coords[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	if c := cmp.Compare(a.coreOnChip, b.coreOnChip); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.id, b.id)
+}
+
+// topologyDevices returns the topology information of the addressable devices of the client, in the same order.
+//
+// It uses the attributes of the client's topology description (PJRT_Client_TopologyDescription) if available, and
+// otherwise the attributes of the device descriptions. If no attributes are available, devices are ordered by ID.
+func (c *Client) topologyDevices() ([]topologyDevice, error) {
+	topologyAttributes := make(map[int]NamedValuesMap)
+	var descriptions []*DeviceDescription
+	clientTopology, err := c.TopologyDescription()
+	if err == nil {
+		descriptions, err = clientTopology.DeviceDescriptions()
+	}
+	if err != nil {
+		klog.V(1).Infof("Topology description not available for %s, using the devices attributes: %v", c, err)
+	} else {
+		for _, desc := range descriptions {
+			id, err := desc.ID()
+			if err != nil {
+				continue
+			}
+			if attributes, err := desc.Attributes(); err == nil {
+				topologyAttributes[id] = attributes
+			}
+		}
+	}
+
+	devices := c.AddressableDevices()
+	topology := make([]topologyDevice, len(devices))
+	for i, device := range devices {
+		desc, err := device.GetDescription()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "while getting the description of device #%d", i)
+		}
+		id, err := desc.ID()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "while getting the ID of device #%d", i)
+		}
+		attributes, found := topologyAttributes[id]
+		if !found {
+			attributes, err = desc.Attributes()
+			if err != nil {
+				klog.V(1).Infof("Attributes not available for device #%d of %s: %v", i, c, err)
+			}
+		}
+		topology[i] = newTopologyDevice(id, desc.ProcessIndex(), attributes)
+	}
+	return topology, nil
+}
+
+// NewDeviceMesh creates a shardy.DeviceMesh with the given axes over the addressable devices of the client,
+// with the devices ordered by their physical topology: devices physically close (e.g. on the same chip or
+// adjacent in the interconnect) are adjacent along the last (minor) axes of the mesh, which usually hold the
+// most communication-intensive sharding (e.g. "model").
+//
+// The topology is taken from the attributes of the client's topology description or of the devices (e.g. TPUs
+// "coords" and "core_on_chip"). If not available, devices are used in order of their IDs.
+//
+// It returns the mesh, with its logical device assignment (see shardy.DeviceMesh.SetLogicalDeviceAssignment)
+// set according to the topology, and the device assignment (device IDs) to use with
+// CompileConfig.WithDeviceAssignment: the logical device i of the mesh is the device deviceAssignment[i].
+//
+// It returns an error if the mesh has more devices than the client's addressable devices. If it has fewer, the
+// first devices in topology order are used.
+func (c *Client) NewDeviceMesh(name string, axesSizes []int, axesNames []string) (
+	mesh *shardy.DeviceMesh, deviceAssignment []int, err error) {
+	mesh, err = shardy.NewDeviceMesh(name, axesSizes, axesNames)
+	if err != nil {
+		return nil, nil, err
+	}
+	numDevices := mesh.NumDevices()
+	if numDevices > c.NumDevices() {
+		return nil, nil, errors.Errorf("mesh %q requires %d devices, but client %s has only %d addressable devices",
+			name, numDevices, c, c.NumDevices())
+	}
+	topology, err := c.topologyDevices()
+	if err != nil {
+		return nil, nil, errors.WithMessagef(err, "while reading the topology for mesh %q", name)
+	}
+	slices.SortStableFunc(topology, compareTopologyDevices)
+	topology = topology[:numDevices]
+	deviceAssignment, logicalAssignment := topologyAssignment(topology)
+	if err = mesh.SetLogicalDeviceAssignment(logicalAssignment...); err != nil {
+		return nil, nil, err
+	}
+	return mesh, deviceAssignment, nil
+}
+
+// topologyAssignment returns the device IDs sorted, to be used as the compilation device assignment, and
+// the logical device assignment of the mesh: the indices of the devices (given in topology order) in
+// deviceAssignment.
+func topologyAssignment(topology []topologyDevice) (deviceAssignment, logicalAssignment []int) {
+	deviceAssignment = make([]int, len(topology))
+	for i, device := range topology {
+		deviceAssignment[i] = device.id
+	}
+	slices.Sort(deviceAssignment)
+	logicalAssignment = make([]int, len(topology))
+	for i, device := range topology {
+		logicalAssignment[i], _ = slices.BinarySearch(deviceAssignment, device.id)
+	}
+	return
+}
@@ -8,6 +8,7 @@ package pjrt
 import "C"
 import (
 	"fmt"
+	"unsafe"
 
 	"k8s.io/klog/v2"
 )
@@ -23,6 +24,29 @@ func pjrtDeviceLocalHardwareId(device *Device) (int, error) {
 	return int(args.local_hardware_id), nil
 }
 
+func pjrtDeviceDescriptionId(dDesc *DeviceDescription) (int, error) {
+	args := C.new_PJRT_DeviceDescription_Id_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Id(dDesc.plugin.api, args))
+	if err != nil {
+		return -1, err
+	}
+	return int(args.id), nil
+}
+
+func pjrtDeviceDescriptionAttributes(dDesc *DeviceDescription) (NamedValuesMap, error) {
+	args := C.new_PJRT_DeviceDescription_Attributes_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Attributes(dDesc.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	namedValues := cDataToSlice[C.PJRT_NamedValue](unsafe.Pointer(args.attributes), int(args.num_attributes))
+	return pjrtNamedValuesToMap(namedValues), nil
+}
+
 func pjrtDeviceDescriptionProcessIndex(dDesc *DeviceDescription) (int, error) {
 	args := C.new_PJRT_DeviceDescription_ProcessIndex_Args()
 	defer cFree(args)
@@ -53,13 +77,14 @@ func pjrtDeviceDescriptionProcessIndex(dDesc *DeviceDescription) (int, error) {
 // are device-specific and operate on individual PjrtDevice objects (obtained from the PjrtClient_Devices list).
 type Device struct {
 	plugin          *Plugin
+	client          *Client
 	cDevice         *C.PJRT_Device // (PJRT) `device` has the same lifetime as (PJRT) `client`. It is owned by (PJRT) `client`.
 	localHardwareId int
 }
 
 // newDevice create a new Device reference.
 func newDevice(client *Client, device *C.PJRT_Device) *Device {
-	d := &Device{plugin: client.plugin, cDevice: device}
+	d := &Device{plugin: client.plugin, client: client, cDevice: device}
 	var err error
 	d.localHardwareId, err = pjrtDeviceLocalHardwareId(d)
 	if err != nil {
@@ -130,8 +155,31 @@ func (dDesc *DeviceDescription) ProcessIndex() int {
 	return dDesc.processIndex
 }
 
-// A vendor-dependent string that uniquely identifies the kind of device,
+// ID returns the ID of the device. IDs are unique among devices of the same type (e.g. GPUs), and on multi-host
+// platforms they are unique across all hosts' devices. These are the IDs used in device assignments
+// (see CompileConfig.WithDeviceAssignment).
+func (dDesc *DeviceDescription) ID() (int, error) {
+	return pjrtDeviceDescriptionId(dDesc)
+}
+
+// Attributes returns the device specific attributes, e.g. "coords" and "core_on_chip" for TPUs, or
+// "compute_capability" for GPUs. The set of attributes depends on the plugin.
+func (dDesc *DeviceDescription) Attributes() (NamedValuesMap, error) {
+	return pjrtDeviceDescriptionAttributes(dDesc)
+}
+
+// Kind returns a vendor-dependent string that uniquely identifies the kind of device,
 // e.g., "Tesla V100-SXM2-16GB".
+func (dDesc *DeviceDescription) Kind() string {
+	args := C.new_PJRT_DeviceDescription_Kind_Args()
+	defer cFree(args)
+	args.device_description = dDesc.deviceDescription
+	err := toError(dDesc.plugin, C.call_PJRT_DeviceDescription_Kind(dDesc.plugin.api, args))
+	if err != nil {
+		return fmt.Sprintf("DeviceDescription failed to retrieve kind: %v", err)
+	}
+	return cCharArray(args.device_kind, args.device_kind_size)
+}
 
 // DebugString suitable for logging when errors occur.
 // Should be verbose enough to describe the current device unambiguously.
@@ -2,6 +2,7 @@ package pjrt
 
 import (
 	"fmt"
+	"slices"
 	"testing"
 )
 
@@ -40,3 +41,53 @@ func TestClient_Devices(t *testing.T) {
 	assertEqual(t, countAddressable, len(addressableDevices))
 	requireNoError(t, client.Destroy())
 }
+
+func TestTopologyAssignment(t *testing.T) {
+	// 4 TPU-like chips with 2 cores each on a 2x2 grid, listed with IDs in "x-major" order.
+	var devices []topologyDevice
+	for id := range 8 {
+		chip := id / 2
+		devices = append(devices, newTopologyDevice(id, 0, NamedValuesMap{
+			"coords":       []int64{int64(chip / 2), int64(chip % 2), 0},
+			"core_on_chip": int64(id % 2),
+		}))
+	}
+	slices.SortStableFunc(devices, compareTopologyDevices)
+	ids := make([]int, len(devices))
+	for i, device := range devices {
+		ids[i] = device.id
+	}
+	// Cores of the same chip are adjacent, then chips adjacent along x.
+	assertEqualSlice(t, []int{0, 1, 4, 5, 2, 3, 6, 7}, ids)
+
+	deviceAssignment, logicalAssignment := topologyAssignment(devices)
+	assertEqualSlice(t, []int{0, 1, 2, 3, 4, 5, 6, 7}, deviceAssignment)
+	assertEqualSlice(t, []int{0, 1, 4, 5, 2, 3, 6, 7}, logicalAssignment)
+
+	// A mesh using only the first 2 chips.
+	deviceAssignment, logicalAssignment = topologyAssignment(devices[:4])
+	assertEqualSlice(t, []int{0, 1, 4, 5}, deviceAssignment)
+	assertEqualSlice(t, []int{0, 1, 2, 3}, logicalAssignment)
+
+	// Without attributes, devices are ordered by ID.
+	devices = []topologyDevice{newTopologyDevice(2, 0, nil), newTopologyDevice(0, 0, nil), newTopologyDevice(1, 0, nil)}
+	slices.SortStableFunc(devices, compareTopologyDevices)
+	deviceAssignment, logicalAssignment = topologyAssignment(devices)
+	assertEqualSlice(t, []int{0, 1, 2}, deviceAssignment)
+	assertEqualSlice(t, []int{0, 1, 2}, logicalAssignment)
+}
+
+func TestClient_NewDeviceMesh(t *testing.T) {
+	client := getPJRTClient(t)
+	numDevices := client.NumDevices()
+	mesh, deviceAssignment, err := client.NewDeviceMesh("mesh", []int{numDevices}, []string{"data"})
+	requireNoError(t, err)
+	assertEqual(t, numDevices, mesh.NumDevices())
+	assertLen(t, deviceAssignment, numDevices)
+	fmt.Printf("\tmesh %s: logical assignment %v, device assignment %v\n", mesh, mesh.LogicalDeviceAssignment(),
+		deviceAssignment)
+
+	_, _, err = client.NewDeviceMesh("mesh", []int{numDevices + 1}, []string{"data"})
+	requireErrorContains(t, err, "addressable devices")
+	requireNoError(t, client.Destroy())
+}
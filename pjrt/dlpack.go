@@ -0,0 +1,268 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include "pjrt_c_api.h"
+#include "dlpack.h"
+#include "common.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"strings"
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// DLPack (https://github.com/dmlc/dlpack) is the common in-memory tensor structure used to exchange tensors
+// between frameworks and native libraries in the same process, without copying.
+//
+// Buffer.ToDLPack exports a Buffer as a DLManagedTensor, and Client.BufferFromDLPack imports a DLManagedTensor as a
+// Buffer. In both cases the DLManagedTensor is handled as an unsafe.Pointer, to be converted to the C type
+// (DLManagedTensor*) of the library (or binding) on the other side.
+
+// dlpackDTypes maps the dtypes supported by DLPack to their DLDataType code and bits.
+var dlpackDTypes = map[dtypes.DType]struct{ code, bits uint8 }{
+	dtypes.Bool:       {C.kDLBool, 8},
+	dtypes.Int8:       {C.kDLInt, 8},
+	dtypes.Int16:      {C.kDLInt, 16},
+	dtypes.Int32:      {C.kDLInt, 32},
+	dtypes.Int64:      {C.kDLInt, 64},
+	dtypes.Uint8:      {C.kDLUInt, 8},
+	dtypes.Uint16:     {C.kDLUInt, 16},
+	dtypes.Uint32:     {C.kDLUInt, 32},
+	dtypes.Uint64:     {C.kDLUInt, 64},
+	dtypes.Float16:    {C.kDLFloat, 16},
+	dtypes.Float32:    {C.kDLFloat, 32},
+	dtypes.Float64:    {C.kDLFloat, 64},
+	dtypes.BFloat16:   {C.kDLBfloat, 16},
+	dtypes.Complex64:  {C.kDLComplex, 64},
+	dtypes.Complex128: {C.kDLComplex, 128},
+}
+
+// dtypeToDLPack converts the dtype to a DLDataType.
+func dtypeToDLPack(dtype dtypes.DType) (C.DLDataType, error) {
+	dlDType, found := dlpackDTypes[dtype]
+	if !found {
+		return C.DLDataType{}, errors.Errorf("dtype %s is not supported by DLPack", dtype)
+	}
+	return C.DLDataType{code: C.uint8_t(dlDType.code), bits: C.uint8_t(dlDType.bits), lanes: 1}, nil
+}
+
+// dtypeFromDLPack converts a DLDataType to a dtype.
+func dtypeFromDLPack(dlDType C.DLDataType) (dtypes.DType, error) {
+	if dlDType.lanes != 1 {
+		return dtypes.InvalidDType, errors.Errorf("DLPack vectorized dtypes (lanes=%d) are not supported", int(dlDType.lanes))
+	}
+	for dtype, candidate := range dlpackDTypes {
+		if candidate.code == uint8(dlDType.code) && candidate.bits == uint8(dlDType.bits) {
+			return dtype, nil
+		}
+	}
+	return dtypes.InvalidDType, errors.Errorf("DLPack dtype (code=%d, bits=%d) is not supported",
+		int(dlDType.code), int(dlDType.bits))
+}
+
+// dlpackDeviceType returns the DLDeviceType of the client's platform.
+func (c *Client) dlpackDeviceType() (C.int32_t, error) {
+	switch platform := strings.ToLower(c.Platform()); platform {
+	case "cpu":
+		return C.kDLCPU, nil
+	case "cuda", "gpu":
+		return C.kDLCUDA, nil
+	case "rocm":
+		return C.kDLROCM, nil
+	default:
+		return 0, errors.Errorf("DLPack is not supported for platform %q", platform)
+	}
+}
+
+// dlpackExport holds the Buffer exported with ToDLPack, so it is not garbage collected while the DLManagedTensor
+// is in use.
+type dlpackExport struct {
+	buffer *Buffer
+}
+
+// ToDLPack exports the buffer as a DLPack DLManagedTensor (returned as an unsafe.Pointer), sharing (not copying)
+// the buffer's memory: this only works for plugins that support Buffer.UnsafePointer (e.g. CPU).
+//
+// The consumer of the DLManagedTensor must call its deleter when done with it. Until then, the buffer is kept alive
+// and PJRT won't delete or move its memory: don't Destroy it, nor donate it to an execution, and don't mutate the
+// memory while the buffer is used by an execution.
+func (b *Buffer) ToDLPack() (unsafe.Pointer, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	dtype, err := b.DType()
+	if err != nil {
+		return nil, err
+	}
+	dlDType, err := dtypeToDLPack(dtype)
+	if err != nil {
+		return nil, errors.WithMessage(err, "Buffer.ToDLPack")
+	}
+	dims, err := b.Dimensions()
+	if err != nil {
+		return nil, err
+	}
+	deviceType, err := b.Client().dlpackDeviceType()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Buffer.ToDLPack")
+	}
+	var deviceID C.int32_t
+	if deviceType != C.kDLCPU {
+		device, err := b.Device()
+		if err != nil {
+			return nil, err
+		}
+		deviceID = C.int32_t(device.LocalHardwareID())
+	}
+	data, err := b.UnsafePointer()
+	if err != nil {
+		return nil, errors.WithMessage(err, "Buffer.ToDLPack failed to get the pointer to the buffer's memory")
+	}
+	if err = pjrtBufferIncreaseExternalReferenceCount(plugin, b); err != nil {
+		return nil, errors.WithMessage(err, "Buffer.ToDLPack")
+	}
+
+	managed := cMalloc[C.DLManagedTensor]()
+	tensor := &managed.dl_tensor
+	tensor.data = data
+	tensor.device = C.DLDevice{device_type: deviceType, device_id: deviceID}
+	tensor.ndim = C.int32_t(len(dims))
+	tensor.dtype = dlDType
+	if len(dims) > 0 {
+		tensor.shape = cMallocArrayAndSet[C.int64_t](len(dims), func(i int) C.int64_t { return C.int64_t(dims[i]) })
+	}
+	handle := cMalloc[C.uintptr_t]()
+	*handle = C.uintptr_t(cgo.NewHandle(&dlpackExport{buffer: b}))
+	managed.manager_ctx = unsafe.Pointer(handle)
+	managed.deleter = (*[0]byte)(C.pjrtDLPackDeleter)
+	return unsafe.Pointer(managed), nil
+}
+
+//export pjrtDLPackDeleter
+func pjrtDLPackDeleter(managed *C.DLManagedTensor) {
+	if managed == nil {
+		return
+	}
+	handle := (*C.uintptr_t)(managed.manager_ctx)
+	export := cgo.Handle(*handle).Value().(*dlpackExport)
+	cgo.Handle(*handle).Delete()
+	cFree(handle)
+	if managed.dl_tensor.shape != nil {
+		cFree(managed.dl_tensor.shape)
+	}
+	cFree(managed)
+
+	buffer := export.buffer
+	plugin, err := buffer.getPlugin()
+	if err != nil {
+		// Buffer or client already destroyed: nothing to release.
+		return
+	}
+	if err = pjrtBufferDecreaseExternalReferenceCount(plugin, buffer); err != nil {
+		klog.Errorf("Failed to release buffer exported with DLPack: %v", err)
+	}
+}
+
+// callDLPackDeleter calls the deleter of a DLManagedTensor (given as an unsafe.Pointer), as a DLPack consumer does
+// when it's done with the tensor.
+func callDLPackDeleter(managedTensor unsafe.Pointer) {
+	C.CallDLPackDeleter((*C.DLManagedTensor)(managedTensor))
+}
+
+// pjrtBufferIncreaseExternalReferenceCount calls C.PJRT_Buffer_IncreaseExternalReferenceCount.
+func pjrtBufferIncreaseExternalReferenceCount(plugin *Plugin, buffer *Buffer) error {
+	args := C.new_PJRT_Buffer_IncreaseExternalReferenceCount_Args()
+	defer cFree(args)
+	args.buffer = buffer.wrapper.c
+	return toError(plugin, C.call_PJRT_Buffer_IncreaseExternalReferenceCount(plugin.api, args))
+}
+
+// pjrtBufferDecreaseExternalReferenceCount calls C.PJRT_Buffer_DecreaseExternalReferenceCount.
+func pjrtBufferDecreaseExternalReferenceCount(plugin *Plugin, buffer *Buffer) error {
+	args := C.new_PJRT_Buffer_DecreaseExternalReferenceCount_Args()
+	defer cFree(args)
+	args.buffer = buffer.wrapper.c
+	return toError(plugin, C.call_PJRT_Buffer_DecreaseExternalReferenceCount(plugin.api, args))
+}
+
+// BufferFromDLPack imports a DLPack DLManagedTensor (given as an unsafe.Pointer to the C DLManagedTensor) as a
+// Buffer that shares (doesn't copy) its memory, see Client.CreateViewOfDeviceBuffer.
+//
+// On success, the Buffer takes ownership of the DLManagedTensor: its deleter is called when the Buffer is destroyed
+// (or garbage collected). On failure, the ownership remains with the caller.
+//
+// The tensor must be on a device of the client's platform, and it must be compact and in row-major order. The
+// plugin may also have alignment requirements (see BufferAlignment).
+// Like shared buffers, the returned buffer cannot be donated to executions.
+func (c *Client) BufferFromDLPack(managedTensor unsafe.Pointer) (*Buffer, error) {
+	if !c.IsValid() {
+		return nil, errors.New("client is nil or it has been destroyed already")
+	}
+	if managedTensor == nil {
+		return nil, errors.New("BufferFromDLPack given a nil DLManagedTensor")
+	}
+	managed := (*C.DLManagedTensor)(managedTensor)
+	tensor := &managed.dl_tensor
+	dtype, err := dtypeFromDLPack(tensor.dtype)
+	if err != nil {
+		return nil, errors.WithMessage(err, "BufferFromDLPack")
+	}
+	dims := make([]int, int(tensor.ndim))
+	if len(dims) > 0 {
+		for axis, dim := range unsafe.Slice(tensor.shape, len(dims)) {
+			dims[axis] = int(dim)
+		}
+	}
+	if tensor.strides != nil && len(dims) > 0 {
+		expectedStride := 1
+		strides := unsafe.Slice(tensor.strides, len(dims))
+		for axis := len(dims) - 1; axis >= 0; axis-- {
+			if dims[axis] != 1 && int(strides[axis]) != expectedStride {
+				return nil, errors.Errorf("BufferFromDLPack only supports compact row-major tensors, got "+
+					"dimensions %v and strides %v", dims, strides)
+			}
+			expectedStride *= dims[axis]
+		}
+	}
+
+	deviceType, err := c.dlpackDeviceType()
+	if err != nil {
+		return nil, errors.WithMessage(err, "BufferFromDLPack")
+	}
+	if tensor.device.device_type != deviceType {
+		return nil, errors.Errorf("BufferFromDLPack given a tensor with DLPack device type %d, but client %s "+
+			"requires device type %d", int(tensor.device.device_type), c, int(deviceType))
+	}
+	var device *Device
+	if deviceType == C.kDLCPU {
+		device = c.addressableDevices[0]
+	} else {
+		for _, candidate := range c.addressableDevices {
+			if candidate.LocalHardwareID() == int(tensor.device.device_id) {
+				device = candidate
+				break
+			}
+		}
+		if device == nil {
+			return nil, errors.Errorf("BufferFromDLPack given a tensor on device %d, which is not addressable "+
+				"by client %s", int(tensor.device.device_id), c)
+		}
+	}
+
+	data := unsafe.Add(tensor.data, uintptr(tensor.byte_offset))
+	buffer, err := c.createViewOfDeviceBuffer(data, dtype, dims,
+		unsafe.Pointer(C.OnDeleteDLPackView), managedTensor, device)
+	if err != nil {
+		return nil, errors.WithMessage(err, "BufferFromDLPack")
+	}
+	return buffer, nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Subset of the DLPack header (https://github.com/dmlc/dlpack, include/dlpack/dlpack.h, v0.8), with the
+ * structures used to exchange tensors: it is ABI-compatible with the original.
+ *
+ * Copyright by Contributors (DLPack), licensed under the Apache License, Version 2.0.
+ */
+
+#ifndef GOMLX_GOPJRT_DLPACK
+#define GOMLX_GOPJRT_DLPACK
+
+#include <stdint.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+typedef enum {
+  kDLCPU = 1,
+  kDLCUDA = 2,
+  kDLCUDAHost = 3,
+  kDLROCM = 10,
+} DLDeviceType;
+
+typedef struct {
+  int32_t device_type;  // DLDeviceType.
+  int32_t device_id;
+} DLDevice;
+
+typedef enum {
+  kDLInt = 0U,
+  kDLUInt = 1U,
+  kDLFloat = 2U,
+  kDLBfloat = 4U,
+  kDLComplex = 5U,
+  kDLBool = 6U,
+} DLDataTypeCode;
+
+typedef struct {
+  uint8_t code;  // DLDataTypeCode.
+  uint8_t bits;
+  uint16_t lanes;
+} DLDataType;
+
+typedef struct {
+  void* data;
+  DLDevice device;
+  int32_t ndim;
+  DLDataType dtype;
+  int64_t* shape;
+  int64_t* strides;  // NULL for compact row-major tensors.
+  uint64_t byte_offset;
+} DLTensor;
+
+typedef struct DLManagedTensor {
+  DLTensor dl_tensor;
+  void* manager_ctx;
+  void (*deleter)(struct DLManagedTensor* self);
+} DLManagedTensor;
+
+#ifdef __cplusplus
+}  // extern "C"
+#endif
+
+#endif  // GOMLX_GOPJRT_DLPACK
@@ -0,0 +1,94 @@
+package pjrt
+
+import (
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestDLPack(t *testing.T) {
+	if *FlagPluginName != "cpu" && !*flagForceSharedBuffer {
+		t.Skip("Skipping TestDLPack because -plugin != \"cpu\". " +
+			"Set --force_create_view to force executing the test anyway")
+	}
+	client := getPJRTClient(t)
+	buffer := must1(ArrayToBuffer(client, []float32{1, 2, 3, 4, 5, 6}, 2, 3))
+
+	// Export and import back: both buffers share the same memory.
+	managed, err := buffer.ToDLPack()
+	requireNoError(t, err)
+	imported, err := client.BufferFromDLPack(managed)
+	requireNoError(t, err)
+	assertTrue(t, imported.IsShared())
+	assertEqual(t, must1(buffer.UnsafePointer()), must1(imported.UnsafePointer()))
+	flat, dims, err := BufferToArray[float32](imported)
+	requireNoError(t, err)
+	assertEqualSlice(t, []int{2, 3}, dims)
+	assertEqualSlice(t, []float32{1, 2, 3, 4, 5, 6}, flat)
+
+	// Mutations through the imported buffer are visible in the original one.
+	importedFlat := must1(imported.Data()).([]float32)
+	importedFlat[0] = 7
+	flat, _, err = BufferToArray[float32](buffer)
+	requireNoError(t, err)
+	assertEqual(t, float32(7), flat[0])
+
+	// While exported, the buffer holds an external reference, and PJRT refuses to donate it.
+	double := compileDouble(t, client)
+	defer func() { requireNoError(t, double.Destroy()) }()
+	_, err = double.Execute(buffer).DonateAll().Done()
+	requireError(t, err, "donating a buffer exported with DLPack should fail")
+
+	// Destroying the imported buffer calls the DLPack deleter, which releases the original buffer: it can then be
+	// donated.
+	requireNoError(t, imported.Destroy())
+	outputs, err := double.Execute(buffer).DonateAll().Done()
+	requireNoError(t, err, "donating the buffer after the DLPack import was destroyed")
+	flat, _, err = BufferToArray[float32](outputs[0])
+	requireNoError(t, err)
+	assertEqualSlice(t, []float32{14, 4, 6, 8, 10, 12}, flat)
+
+	// A consumer (other than BufferFromDLPack) calls the deleter directly, which also releases the buffer.
+	buffer = outputs[0]
+	managed, err = buffer.ToDLPack()
+	requireNoError(t, err)
+	_, err = double.Execute(buffer).DonateAll().Done()
+	requireError(t, err, "donating a buffer exported with DLPack should fail")
+	callDLPackDeleter(managed)
+	outputs, err = double.Execute(buffer).DonateAll().Done()
+	requireNoError(t, err, "donating the buffer after the DLPack deleter was called")
+	flat, _, err = BufferToArray[float32](outputs[0])
+	requireNoError(t, err)
+	assertEqualSlice(t, []float32{28, 8, 12, 16, 20, 24}, flat)
+	requireNoError(t, outputs[0].Destroy())
+
+	// Unsupported dtypes.
+	packed := must1(client.BufferFromHost().FromRawData([]byte{0x12}, dtypes.Int4, []int{2}).Done())
+	_, err = packed.ToDLPack()
+	requireError(t, err)
+	requireNoError(t, packed.Destroy())
+	requireError(t, func() error { _, err := client.BufferFromDLPack(nil); return err }())
+}
+
+func TestDLPackDTypes(t *testing.T) {
+	for dtype := range dlpackDTypes {
+		dlDType, err := dtypeToDLPack(dtype)
+		requireNoError(t, err)
+		back, err := dtypeFromDLPack(dlDType)
+		requireNoError(t, err)
+		assertEqual(t, dtype, back)
+	}
+	_, err := dtypeToDLPack(dtypes.Int4)
+	requireError(t, err)
+}
+
+// compileDouble compiles f(x) = x + x, for x of shape float32[2, 3].
+func compileDouble(t *testing.T, client *Client) *LoadedExecutable {
+	builder := stablehlo.New(t.Name())
+	mainFn := builder.Main()
+	x := must1(mainFn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+	requireNoError(t, mainFn.Return(must1(stablehlo.Add(x, x))))
+	return must1(client.Compile().WithStableHLO(must1(builder.Build())).Done())
+}
@@ -3,16 +3,109 @@ package pjrt
 /*
 #include <stdlib.h>
 #include "pjrt_c_api.h"
+#include "common.h"
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
 */
 import "C"
 import (
+	"fmt"
+	"runtime/cgo"
+	"strings"
 	"unsafe"
 
 	"github.com/pkg/errors"
 )
 
+// Error is the error returned by the PJRT plugin: it carries the PJRT_Error_Code, the message and the payloads
+// (extra information attached by the plugin, keyed by a type URL) of the error.
+//
+// The errors returned by this package are wrapped with a stack trace (see github.com/pkg/errors), so use errors.As
+// to access it, or errors.Is to compare it with one of the sentinel errors of the codes, e.g.:
+//
+//	outputs, err := exec.Execute(inputs...).Done()
+//	if errors.Is(err, pjrt.ErrResourceExhausted) {
+//		// Out-of-memory: retry with a smaller batch.
+//	}
+type Error struct {
+	// Code of the error.
+	Code PJRT_Error_Code
+
+	// Message of the error.
+	Message string
+
+	// Payloads attached to the error, if any. Nil if there are no payloads, or if the plugin doesn't support
+	// PJRT_Error_ForEachPayload.
+	Payloads map[string][]byte
+}
+
+// Sentinel errors matching (with errors.Is) the Error with the corresponding code.
+var (
+	ErrCancelled          = errors.New("cancelled")
+	ErrUnknown            = errors.New("unknown")
+	ErrInvalidArgument    = errors.New("invalid argument")
+	ErrDeadlineExceeded   = errors.New("deadline exceeded")
+	ErrNotFound           = errors.New("not found")
+	ErrAlreadyExists      = errors.New("already exists")
+	ErrPermissionDenied   = errors.New("permission denied")
+	ErrResourceExhausted  = errors.New("resource exhausted")
+	ErrFailedPrecondition = errors.New("failed precondition")
+	ErrAborted            = errors.New("aborted")
+	ErrOutOfRange         = errors.New("out of range")
+	ErrUnimplemented      = errors.New("unimplemented")
+	ErrInternal           = errors.New("internal")
+	ErrUnavailable        = errors.New("unavailable")
+	ErrDataLoss           = errors.New("data loss")
+	ErrUnauthenticated    = errors.New("unauthenticated")
+)
+
+// errorCodeSentinels maps the error codes to their sentinel errors.
+var errorCodeSentinels = map[PJRT_Error_Code]error{
+	PJRT_Error_Code_CANCELLED:           ErrCancelled,
+	PJRT_Error_Code_UNKNOWN:             ErrUnknown,
+	PJRT_Error_Code_INVALID_ARGUMENT:    ErrInvalidArgument,
+	PJRT_Error_Code_DEADLINE_EXCEEDED:   ErrDeadlineExceeded,
+	PJRT_Error_Code_NOT_FOUND:           ErrNotFound,
+	PJRT_Error_Code_ALREADY_EXISTS:      ErrAlreadyExists,
+	PJRT_Error_Code_PERMISSION_DENIED:   ErrPermissionDenied,
+	PJRT_Error_Code_RESOURCE_EXHAUSTED:  ErrResourceExhausted,
+	PJRT_Error_Code_FAILED_PRECONDITION: ErrFailedPrecondition,
+	PJRT_Error_Code_ABORTED:             ErrAborted,
+	PJRT_Error_Code_OUT_OF_RANGE:        ErrOutOfRange,
+	PJRT_Error_Code_UNIMPLEMENTED:       ErrUnimplemented,
+	PJRT_Error_Code_INTERNAL:            ErrInternal,
+	PJRT_Error_Code_UNAVAILABLE:         ErrUnavailable,
+	PJRT_Error_Code_DATA_LOSS:           ErrDataLoss,
+	PJRT_Error_Code_UNAUTHENTICATED:     ErrUnauthenticated,
+}
+
+// String implements fmt.Stringer. It returns the name of the code as in the C API, e.g. "RESOURCE_EXHAUSTED".
+func (code PJRT_Error_Code) String() string {
+	if code == PJRT_Error_Code_OK {
+		return "OK"
+	}
+	sentinel, found := errorCodeSentinels[code]
+	if !found {
+		return fmt.Sprintf("PJRT_Error_Code(%d)", int(code))
+	}
+	return strings.ToUpper(strings.ReplaceAll(sentinel.Error(), " ", "_"))
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("PJRT error (code=%s): %s", e.Code, e.Message)
+}
+
+// Is implements the interface used by errors.Is: it matches the sentinel error of the code (e.g. ErrResourceExhausted)
+// and any *Error with the same code.
+func (e *Error) Is(target error) bool {
+	if other, ok := target.(*Error); ok {
+		return other.Code == e.Code
+	}
+	sentinel, found := errorCodeSentinels[e.Code]
+	return found && target == sentinel
+}
+
 // pjrtErrorDestroy calls C.PJRT_Error_Destroy.
 func pjrtErrorDestroy(plugin *Plugin, pErr *C.PJRT_Error) {
 	args := C.new_PJRT_Error_Destroy_Args()
@@ -43,15 +136,47 @@ func pjrtErrorGetCode(plugin *Plugin, pErr *C.PJRT_Error) PJRT_Error_Code {
 	return code
 }
 
-// toError converts a *C.PJRT_Error to a Go error, with a stack trace (see 	github.com/pkg/errors package).
+// pjrtErrorForEachPayload calls C.PJRT_Error_ForEachPayload and returns the payloads of the error, or nil if there
+// are none or if the plugin doesn't support it.
+func pjrtErrorForEachPayload(plugin *Plugin, pErr *C.PJRT_Error) map[string][]byte {
+	payloads := make(map[string][]byte)
+	handle := cMalloc[C.uintptr_t]()
+	defer cFree(handle)
+	*handle = C.uintptr_t(cgo.NewHandle(payloads))
+	defer cgo.Handle(*handle).Delete()
+
+	args := C.new_PJRT_Error_ForEachPayload_Args()
+	defer cFree(args)
+	args.error = pErr
+	args.user_arg = unsafe.Pointer(handle)
+	if visitErr := C.call_PJRT_Error_ForEachPayload_GoVisitor(plugin.api, args); visitErr != nil {
+		// Failing to read the payloads shouldn't hide the original error.
+		pjrtErrorDestroy(plugin, visitErr)
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+	return payloads
+}
+
+//export pjrtErrorPayloadVisitor
+func pjrtErrorPayloadVisitor(key *C.char, keySize C.size_t, value *C.char, valueSize C.size_t, userArg unsafe.Pointer) {
+	payloads := cgo.Handle(*(*C.uintptr_t)(userArg)).Value().(map[string][]byte)
+	payloads[cCharArray(key, keySize)] = []byte(cCharArray(value, valueSize))
+}
+
+// toError converts a *C.PJRT_Error to a Go *Error, wrapped with a stack trace (see github.com/pkg/errors package).
 // If the incoming error is nil or not an error, it returns nil as well.
 // At the end this frees the returned error.
 func toError(plugin *Plugin, pErr *C.PJRT_Error) error {
 	if pErr == nil {
 		return nil
 	}
-	msg := pjrtErrorMessage(plugin, pErr)
-	code := pjrtErrorGetCode(plugin, pErr)
+	pjrtErr := &Error{
+		Code:     pjrtErrorGetCode(plugin, pErr),
+		Message:  pjrtErrorMessage(plugin, pErr),
+		Payloads: pjrtErrorForEachPayload(plugin, pErr),
+	}
 	pjrtErrorDestroy(plugin, pErr)
-	return errors.Errorf("PJRT error (code=%d): %s", code, msg)
+	return errors.WithStack(pjrtErr)
 }
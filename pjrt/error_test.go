@@ -7,6 +7,7 @@ import (
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/go-xla/stablehlo"
 	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
 )
 
 func TestError(t *testing.T) {
@@ -31,5 +32,32 @@ func TestError(t *testing.T) {
 	_, err = exec.Execute().Done()
 	requireErrorContains(t, err, "PJRT error")
 	requireErrorContains(t, err, "Execution supplied 0 buffers but compiled program expected 2 buffers")
+	assertTrue(t, errors.Is(err, ErrInvalidArgument), "expected ErrInvalidArgument, got %v", err)
+	var pjrtErr *Error
+	assertTrue(t, errors.As(err, &pjrtErr), "expected a *pjrt.Error, got %T", err)
+	assertEqual(t, PJRT_Error_Code_INVALID_ARGUMENT, pjrtErr.Code)
 	fmt.Printf("Received expected error: %s", err)
 }
+
+func TestError_Is(t *testing.T) {
+	err := errors.WithMessage(errors.WithStack(&Error{
+		Code:     PJRT_Error_Code_RESOURCE_EXHAUSTED,
+		Message:  "out of memory allocating 1GB",
+		Payloads: map[string][]byte{"type.googleapis.com/some.Payload": []byte("details")},
+	}), "executing program")
+	assertTrue(t, errors.Is(err, ErrResourceExhausted))
+	assertTrue(t, errors.Is(err, &Error{Code: PJRT_Error_Code_RESOURCE_EXHAUSTED}))
+	assertFalse(t, errors.Is(err, ErrInvalidArgument))
+	assertFalse(t, errors.Is(err, ErrKeyNotFound))
+	requireErrorContains(t, err, "PJRT error (code=RESOURCE_EXHAUSTED): out of memory allocating 1GB")
+
+	var pjrtErr *Error
+	assertTrue(t, errors.As(err, &pjrtErr))
+	assertEqual(t, PJRT_Error_Code_RESOURCE_EXHAUSTED, pjrtErr.Code)
+	assertEqual(t, "details", string(pjrtErr.Payloads["type.googleapis.com/some.Payload"]))
+
+	assertEqual(t, "OK", PJRT_Error_Code_OK.String())
+	assertEqual(t, "INVALID_ARGUMENT", PJRT_Error_Code_INVALID_ARGUMENT.String())
+	assertEqual(t, "UNAUTHENTICATED", PJRT_Error_Code_UNAUTHENTICATED.String())
+	assertEqual(t, "PJRT_Error_Code(100)", PJRT_Error_Code(100).String())
+}
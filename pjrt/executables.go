@@ -4,12 +4,29 @@ package pjrt
 #include "pjrt_c_api.h"
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
+
+// call_PJRT_SerializedExecutable_Deleter calls the deleter returned by PJRT_Executable_Serialize.
+static void call_PJRT_SerializedExecutable_Deleter(void (*deleter)(PJRT_SerializedExecutable*), PJRT_SerializedExecutable* serialized) {
+	if (deleter != NULL) {
+		deleter(serialized);
+	}
+}
+
+// call_PJRT_SerializedCompileOptions_Deleter calls the deleter returned by PJRT_Executable_GetCompileOptions.
+static void call_PJRT_SerializedCompileOptions_Deleter(void (*deleter)(PJRT_SerializedCompileOptions*), PJRT_SerializedCompileOptions* serialized) {
+	if (deleter != NULL) {
+		deleter(serialized);
+	}
+}
 */
 import "C"
 import (
 	"runtime"
+	"unsafe"
 
+	"github.com/gomlx/go-xla/internal/protos/compile_options"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/proto"
 	"k8s.io/klog/v2"
 )
 
@@ -168,3 +185,47 @@ func (e *Executable) GetMemoryStats() (onDevice, onHost ExecutableMemoryUsageSta
 	}
 	return
 }
+
+// Serialize the executable, so it can be loaded later with Client.LoadSerializedExecutable.
+//
+// The serialization is platform-specific, and it is not guaranteed to be stable across versions of the plugin:
+// it must be loaded by the same platform and plugin version.
+func (e *Executable) Serialize() ([]byte, error) {
+	if e == nil || !e.wrapper.IsValid() {
+		return nil, errors.New("Executable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_Serialize_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_Serialize(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	serialized := C.GoBytes(unsafe.Pointer(args.serialized_bytes), C.int(args.serialized_bytes_size))
+	C.call_PJRT_SerializedExecutable_Deleter(args.serialized_executable_deleter, args.serialized_executable)
+	return serialized, nil
+}
+
+// compileOptions returns the CompileOptionsProto used to compile the executable.
+func (e *Executable) compileOptions() (*compile_options.CompileOptionsProto, error) {
+	if e == nil || !e.wrapper.IsValid() {
+		return nil, errors.New("Executable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_GetCompileOptions_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_GetCompileOptions(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	serialized := cDataToSlice[byte](unsafe.Pointer(args.serialized_bytes), int(args.serialized_bytes_size))
+	options := &compile_options.CompileOptionsProto{}
+	err = proto.Unmarshal(serialized, options)
+	C.call_PJRT_SerializedCompileOptions_Deleter(args.serialized_compile_options_deleter, args.serialized_compile_options)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse the CompileOptionsProto of the executable")
+	}
+	return options, nil
+}
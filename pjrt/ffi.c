@@ -0,0 +1,98 @@
+#include <stdlib.h>
+#include <string.h>
+#include "ffi_c_api.h"
+
+PJRT_FFI_Extension* goxla_ffi_extension(const PJRT_Api* api) {
+    for (PJRT_Extension_Base* ext = api->extension_start; ext != NULL; ext = ext->next) {
+        if (ext->type != PJRT_Extension_Type_FFI) {
+            continue;
+        }
+        PJRT_FFI_Extension* ffi = (PJRT_FFI_Extension*)ext;
+        if (ffi->base.struct_size < offsetof(PJRT_FFI_Extension, register_handler) + sizeof(void*) ||
+            ffi->register_handler == NULL) {
+            return NULL;
+        }
+        return ffi;
+    }
+    return NULL;
+}
+
+// Reports the API version if the call frame is a metadata query, and returns whether it was one.
+static int goxla_ffi_metadata(XLA_FFI_CallFrame* call_frame) {
+    for (XLA_FFI_Extension_Base* ext = call_frame->extension_start; ext != NULL; ext = ext->next) {
+        if (ext->type != XLA_FFI_Extension_Metadata) {
+            continue;
+        }
+        XLA_FFI_Metadata* metadata = ((XLA_FFI_Metadata_Extension*)ext)->metadata;
+        metadata->api_version.major_version = XLA_FFI_API_MAJOR;
+        metadata->api_version.minor_version = XLA_FFI_API_MINOR;
+        metadata->traits = 0;
+        return 1;
+    }
+    return 0;
+}
+
+static XLA_FFI_Error* goxla_ffi_call(int index, XLA_FFI_CallFrame* call_frame) {
+    if (goxla_ffi_metadata(call_frame)) {
+        return NULL;
+    }
+    if (call_frame->stage != XLA_FFI_ExecutionStage_EXECUTE) {
+        return NULL;
+    }
+    int errc = 0;
+    char* message = goxlaFFIHandler(index, call_frame, &errc);
+    if (message == NULL) {
+        return NULL;
+    }
+    XLA_FFI_Error_Create_Args args;
+    memset(&args, 0, sizeof(args));
+    args.struct_size = sizeof(args);
+    args.message = message;
+    args.errc = errc;
+    XLA_FFI_Error* err = call_frame->api->XLA_FFI_Error_Create(&args);
+    free(message);
+    return err;
+}
+
+#define GOXLA_FFI_TRAMPOLINE(G, K) \
+    static XLA_FFI_Error* goxla_ffi_handler_##G##_##K(XLA_FFI_CallFrame* call_frame) { \
+        return goxla_ffi_call(G * 8 + K, call_frame); \
+    }
+
+#define GOXLA_FFI_TRAMPOLINES_8(G) \
+    GOXLA_FFI_TRAMPOLINE(G, 0) GOXLA_FFI_TRAMPOLINE(G, 1) GOXLA_FFI_TRAMPOLINE(G, 2) GOXLA_FFI_TRAMPOLINE(G, 3) \
+    GOXLA_FFI_TRAMPOLINE(G, 4) GOXLA_FFI_TRAMPOLINE(G, 5) GOXLA_FFI_TRAMPOLINE(G, 6) GOXLA_FFI_TRAMPOLINE(G, 7)
+
+// The trampoline of handler #N is goxla_ffi_handler_<N/8>_<N%8>.
+GOXLA_FFI_TRAMPOLINES_8(0)
+GOXLA_FFI_TRAMPOLINES_8(1)
+GOXLA_FFI_TRAMPOLINES_8(2)
+GOXLA_FFI_TRAMPOLINES_8(3)
+GOXLA_FFI_TRAMPOLINES_8(4)
+GOXLA_FFI_TRAMPOLINES_8(5)
+GOXLA_FFI_TRAMPOLINES_8(6)
+GOXLA_FFI_TRAMPOLINES_8(7)
+
+#define GOXLA_FFI_TRAMPOLINE_PTRS_8(G) \
+    goxla_ffi_handler_##G##_0, goxla_ffi_handler_##G##_1, goxla_ffi_handler_##G##_2, goxla_ffi_handler_##G##_3, \
+    goxla_ffi_handler_##G##_4, goxla_ffi_handler_##G##_5, goxla_ffi_handler_##G##_6, goxla_ffi_handler_##G##_7
+
+static XLA_FFI_Handler* goxla_ffi_handlers[GOXLA_MAX_FFI_HANDLERS] = {
+    GOXLA_FFI_TRAMPOLINE_PTRS_8(0), GOXLA_FFI_TRAMPOLINE_PTRS_8(1), GOXLA_FFI_TRAMPOLINE_PTRS_8(2),
+    GOXLA_FFI_TRAMPOLINE_PTRS_8(3), GOXLA_FFI_TRAMPOLINE_PTRS_8(4), GOXLA_FFI_TRAMPOLINE_PTRS_8(5),
+    GOXLA_FFI_TRAMPOLINE_PTRS_8(6), GOXLA_FFI_TRAMPOLINE_PTRS_8(7),
+};
+
+PJRT_Error* goxla_ffi_register_handler(PJRT_FFI_Extension* ext, int index, const char* target_name,
+                                       size_t target_name_size, const char* platform_name,
+                                       size_t platform_name_size) {
+    PJRT_FFI_Register_Handler_Args args;
+    memset(&args, 0, sizeof(args));
+    args.struct_size = sizeof(args);
+    args.target_name = target_name;
+    args.target_name_size = target_name_size;
+    args.handler = (void*)goxla_ffi_handlers[index];
+    args.platform_name = platform_name;
+    args.platform_name_size = platform_name_size;
+    return ext->register_handler(&args);
+}
@@ -0,0 +1,307 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include "pjrt_c_api.h"
+#include "ffi_c_api.h"
+*/
+import "C"
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/protos/xla_data"
+	"github.com/gomlx/go-xla/types/ffi"
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// MaxFFIHandlers is the maximum number of Go FFI handlers that can be registered in the process (across all
+// plugins), including the host callbacks dispatcher registered for each plugin.
+const MaxFFIHandlers = C.GOXLA_MAX_FFI_HANDLERS
+
+var (
+	// ffiHandlersMu protects ffiHandlers and ffiRegistrations.
+	ffiHandlersMu sync.RWMutex
+
+	// ffiHandlers are indexed by the C trampoline that calls them.
+	ffiHandlers []ffi.Handler
+
+	// ffiRegistrations maps the "<platform>:<target>" registered to the index of their handlers.
+	ffiRegistrations = make(map[string]int)
+)
+
+// ffiPlatformName returns the name of the platform of the plugin used by the XLA FFI handlers registry.
+func (p *Plugin) ffiPlatformName() string {
+	switch {
+	case p.IsCPU():
+		return "Host"
+	case p.IsCUDA():
+		return "CUDA"
+	default:
+		return p.name
+	}
+}
+
+// HasFFI returns whether the plugin supports registering Go FFI handlers (see RegisterFFIHandler).
+func (p *Plugin) HasFFI() bool {
+	return C.goxla_ffi_extension(p.api) != nil
+}
+
+// RegisterFFIHandler registers the Go handler for the XLA FFI custom-call target name (see
+// stablehlo.CustomCallFFI), for the platform of the plugin.
+//
+// It requires the plugin to support the PJRT FFI extension (see HasFFI): currently it is tested with the CPU
+// plugin, where the buffers given to the handler are in host memory.
+//
+// Handlers are registered for the lifetime of the process, they must be registered before compiling the
+// programs that use them, and a target name can only be registered once per platform. At most MaxFFIHandlers
+// handlers can be registered.
+func (p *Plugin) RegisterFFIHandler(target string, handler ffi.Handler) error {
+	if handler == nil {
+		return errors.Errorf("RegisterFFIHandler(%q): handler is nil", target)
+	}
+	ext := C.goxla_ffi_extension(p.api)
+	if ext == nil {
+		return errors.Errorf("plugin %s doesn't support the FFI extension, it cannot register FFI handler %q",
+			p, target)
+	}
+	platform := p.ffiPlatformName()
+	key := platform + ":" + target
+
+	ffiHandlersMu.Lock()
+	defer ffiHandlersMu.Unlock()
+	if _, found := ffiRegistrations[key]; found {
+		return errors.Errorf("FFI handler %q already registered for platform %q", target, platform)
+	}
+	if len(ffiHandlers) >= MaxFFIHandlers {
+		return errors.Errorf("cannot register FFI handler %q: the maximum of %d Go FFI handlers was reached",
+			target, MaxFFIHandlers)
+	}
+	index := len(ffiHandlers)
+	cTarget := C.CString(target)
+	defer cFree(cTarget)
+	cPlatform := C.CString(platform)
+	defer cFree(cPlatform)
+	err := toError(p, C.goxla_ffi_register_handler(ext, C.int(index), cTarget, C.size_t(len(target)),
+		cPlatform, C.size_t(len(platform))))
+	if err != nil {
+		return errors.WithMessagef(err, "failed to register FFI handler %q for platform %q", target, platform)
+	}
+	ffiHandlers = append(ffiHandlers, handler)
+	ffiRegistrations[key] = index
+	return nil
+}
+
+// registerHostCallbackHandler registers the ffi.HostCallbackTarget handler, used by stablehlo.HostCallback,
+// if the plugin supports FFI. It is called once when the plugin is loaded.
+func (p *Plugin) registerHostCallbackHandler() {
+	if !p.HasFFI() {
+		return
+	}
+	err := p.RegisterFFIHandler(ffi.HostCallbackTarget, ffi.DispatchHostCallback)
+	if err != nil {
+		klog.V(1).Infof("Host callbacks not available for %s: %v", p, err)
+	}
+}
+
+//export goxlaFFIHandler
+func goxlaFFIHandler(index C.int, callFrame *C.XLA_FFI_CallFrame, errc *C.int) (message *C.char) {
+	ffiHandlersMu.RLock()
+	handler := ffiHandlers[index]
+	ffiHandlersMu.RUnlock()
+
+	// Panics can't cross the C boundary: they are converted to errors.
+	defer func() {
+		if r := recover(); r != nil {
+			*errc = C.int(PJRT_Error_Code_INTERNAL)
+			message = C.CString(fmt.Sprintf("panic in Go FFI handler: %v", r))
+		}
+	}()
+	call, err := newFFICallFrame(callFrame)
+	if err == nil {
+		err = handler(call)
+	}
+	if err != nil {
+		*errc = C.int(PJRT_Error_Code_UNKNOWN)
+		return C.CString(fmt.Sprintf("%+v", err))
+	}
+	return nil
+}
+
+// newFFICallFrame converts the C call frame to an ffi.CallFrame.
+func newFFICallFrame(callFrame *C.XLA_FFI_CallFrame) (*ffi.CallFrame, error) {
+	call := &ffi.CallFrame{}
+	numArgs := int(callFrame.args.size)
+	argTypes := cDataToSlice[C.XLA_FFI_ArgType](unsafe.Pointer(callFrame.args.types), numArgs)
+	args := cDataToSlice[unsafe.Pointer](unsafe.Pointer(callFrame.args.args), numArgs)
+	call.Inputs = make([]*ffi.Buffer, numArgs)
+	for i, arg := range args {
+		if argTypes[i] != C.XLA_FFI_ArgType_BUFFER {
+			return nil, errors.Errorf("FFI input #%d has unsupported type %d", i, argTypes[i])
+		}
+		buffer, err := newFFIBuffer((*C.XLA_FFI_Buffer)(arg))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "FFI input #%d", i)
+		}
+		call.Inputs[i] = buffer
+	}
+
+	numRets := int(callFrame.rets.size)
+	retTypes := cDataToSlice[C.XLA_FFI_RetType](unsafe.Pointer(callFrame.rets.types), numRets)
+	rets := cDataToSlice[unsafe.Pointer](unsafe.Pointer(callFrame.rets.rets), numRets)
+	call.Outputs = make([]*ffi.Buffer, numRets)
+	for i, ret := range rets {
+		if retTypes[i] != C.XLA_FFI_RetType_BUFFER {
+			return nil, errors.Errorf("FFI output #%d has unsupported type %d", i, retTypes[i])
+		}
+		buffer, err := newFFIBuffer((*C.XLA_FFI_Buffer)(ret))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "FFI output #%d", i)
+		}
+		call.Outputs[i] = buffer
+	}
+
+	var err error
+	call.Attributes, err = ffiAttributesToMap(&callFrame.attrs)
+	if err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// primitiveTypeToDType is the inverse of dtypeToPrimitiveType: XLA FFI data types are XLA PrimitiveType values.
+var primitiveTypeToDType = sync.OnceValue(func() map[xla_data.PrimitiveType]dtypes.DType {
+	m := make(map[xla_data.PrimitiveType]dtypes.DType, len(dtypeToPrimitiveType))
+	for dtype, primitiveType := range dtypeToPrimitiveType {
+		m[primitiveType] = dtype
+	}
+	return m
+})
+
+// ffiDType converts an XLA FFI data type to a DType.
+func ffiDType(dataType C.XLA_FFI_DataType) (dtypes.DType, error) {
+	dtype, found := primitiveTypeToDType()[xla_data.PrimitiveType(dataType)]
+	if !found {
+		return dtypes.InvalidDType, errors.Errorf("FFI data type %s not supported",
+			xla_data.PrimitiveType(dataType))
+	}
+	return dtype, nil
+}
+
+// newFFIBuffer converts an XLA FFI buffer to an ffi.Buffer pointing to the same data.
+func newFFIBuffer(buffer *C.XLA_FFI_Buffer) (*ffi.Buffer, error) {
+	dtype, err := ffiDType(buffer.dtype)
+	if err != nil {
+		return nil, err
+	}
+	cDims := cDataToSlice[C.int64_t](unsafe.Pointer(buffer.dims), int(buffer.rank))
+	dims := make([]int, len(cDims))
+	for i, dim := range cDims {
+		dims[i] = int(dim)
+	}
+	return ffi.NewBuffer(dtype, dims, buffer.data), nil
+}
+
+// ffiAttributesToMap converts the attributes of an FFI call to a map, see ffi.CallFrame.Attributes.
+func ffiAttributesToMap(attrs *C.XLA_FFI_Attrs) (map[string]any, error) {
+	numAttrs := int(attrs.size)
+	attrTypes := cDataToSlice[C.XLA_FFI_AttrType](unsafe.Pointer(attrs.types), numAttrs)
+	names := cDataToSlice[*C.XLA_FFI_ByteSpan](unsafe.Pointer(attrs.names), numAttrs)
+	values := cDataToSlice[unsafe.Pointer](unsafe.Pointer(attrs.attrs), numAttrs)
+	m := make(map[string]any, numAttrs)
+	for i := range numAttrs {
+		name := cCharArray(names[i].ptr, names[i].len)
+		var value any
+		var err error
+		switch attrTypes[i] {
+		case C.XLA_FFI_AttrType_SCALAR:
+			scalar := (*C.XLA_FFI_Scalar)(values[i])
+			value, err = ffiScalarToGo(scalar.dtype, scalar.value)
+		case C.XLA_FFI_AttrType_ARRAY:
+			array := (*C.XLA_FFI_Array)(values[i])
+			value, err = ffiArrayToGo(array.dtype, array.data, int(array.size))
+		case C.XLA_FFI_AttrType_STRING:
+			span := (*C.XLA_FFI_ByteSpan)(values[i])
+			value = cCharArray(span.ptr, span.len)
+		case C.XLA_FFI_AttrType_DICTIONARY:
+			value, err = ffiAttributesToMap((*C.XLA_FFI_Attrs)(values[i]))
+		default:
+			err = errors.Errorf("unsupported attribute type %d", attrTypes[i])
+		}
+		if err != nil {
+			return nil, errors.WithMessagef(err, "FFI attribute %q", name)
+		}
+		m[name] = value
+	}
+	return m, nil
+}
+
+// ffiScalarToGo converts an FFI scalar attribute to a Go value.
+func ffiScalarToGo(dataType C.XLA_FFI_DataType, ptr unsafe.Pointer) (any, error) {
+	value, err := ffiArrayToGo(dataType, ptr, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case []bool:
+		return v[0], nil
+	case []int8:
+		return v[0], nil
+	case []int16:
+		return v[0], nil
+	case []int32:
+		return v[0], nil
+	case []int64:
+		return v[0], nil
+	case []uint8:
+		return v[0], nil
+	case []uint16:
+		return v[0], nil
+	case []uint32:
+		return v[0], nil
+	case []uint64:
+		return v[0], nil
+	case []float32:
+		return v[0], nil
+	case []float64:
+		return v[0], nil
+	}
+	return nil, errors.Errorf("unsupported scalar %T", value)
+}
+
+// ffiArrayToGo converts (copies) an FFI array attribute to a Go slice.
+func ffiArrayToGo(dataType C.XLA_FFI_DataType, ptr unsafe.Pointer, size int) (any, error) {
+	dtype, err := ffiDType(dataType)
+	if err != nil {
+		return nil, err
+	}
+	switch dtype {
+	case dtypes.Bool:
+		return slices.Clone(cDataToSlice[bool](ptr, size)), nil
+	case dtypes.Int8:
+		return slices.Clone(cDataToSlice[int8](ptr, size)), nil
+	case dtypes.Int16:
+		return slices.Clone(cDataToSlice[int16](ptr, size)), nil
+	case dtypes.Int32:
+		return slices.Clone(cDataToSlice[int32](ptr, size)), nil
+	case dtypes.Int64:
+		return slices.Clone(cDataToSlice[int64](ptr, size)), nil
+	case dtypes.Uint8:
+		return slices.Clone(cDataToSlice[uint8](ptr, size)), nil
+	case dtypes.Uint16:
+		return slices.Clone(cDataToSlice[uint16](ptr, size)), nil
+	case dtypes.Uint32:
+		return slices.Clone(cDataToSlice[uint32](ptr, size)), nil
+	case dtypes.Uint64:
+		return slices.Clone(cDataToSlice[uint64](ptr, size)), nil
+	case dtypes.Float32:
+		return slices.Clone(cDataToSlice[float32](ptr, size)), nil
+	case dtypes.Float64:
+		return slices.Clone(cDataToSlice[float64](ptr, size)), nil
+	}
+	return nil, errors.Errorf("attributes of dtype %s not supported", dtype)
+}
@@ -0,0 +1,227 @@
+/*
+ *	Copyright 2024 Jan Pfeifer
+ *
+ *	Licensed under the Apache License, Version 2.0 (the "License");
+ *	you may not use this file except in compliance with the License.
+ *	You may obtain a copy of the License at
+ *
+ *	http://www.apache.org/licenses/LICENSE-2.0
+ *
+ *	Unless required by applicable law or agreed to in writing, software
+ *	distributed under the License is distributed on an "AS IS" BASIS,
+ *	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ *	See the License for the specific language governing permissions and
+ *	limitations under the License.
+ */
+
+// Subset of the XLA FFI C API (xla/ffi/api/c_api.h) and of the PJRT FFI extension
+// (xla/pjrt/c/pjrt_c_api_ffi_extension.h) used to register Go functions as FFI handlers.
+//
+// Only the structures used by go-xla are declared, and their layouts must match the XLA ones: structures are
+// versioned by their struct_size, so new fields are only ever appended.
+
+#ifndef GOMLX_GOPJRT_FFI_C_API
+#define GOMLX_GOPJRT_FFI_C_API
+#include <stddef.h>
+#include <stdint.h>
+#include "pjrt_c_api.h"
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// XLA FFI C API ---------------------------------------------------------------------------------------------
+
+#define XLA_FFI_API_MAJOR 0
+#define XLA_FFI_API_MINOR 1
+
+typedef struct XLA_FFI_Api XLA_FFI_Api;
+typedef struct XLA_FFI_Error XLA_FFI_Error;
+typedef struct XLA_FFI_ExecutionContext XLA_FFI_ExecutionContext;
+
+typedef enum {
+  XLA_FFI_Extension_Metadata = 1,
+} XLA_FFI_Extension_Type;
+
+typedef struct XLA_FFI_Extension_Base {
+  size_t struct_size;
+  XLA_FFI_Extension_Type type;
+  struct XLA_FFI_Extension_Base* next;
+} XLA_FFI_Extension_Base;
+
+typedef struct XLA_FFI_Api_Version {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int major_version;
+  int minor_version;
+} XLA_FFI_Api_Version;
+
+// Error codes are the same as absl::StatusCode (and PJRT_Error_Code).
+typedef int XLA_FFI_Error_Code;
+
+typedef struct XLA_FFI_Error_Create_Args {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  const char* message;
+  XLA_FFI_Error_Code errc;
+} XLA_FFI_Error_Create_Args;
+
+typedef XLA_FFI_Error* XLA_FFI_Error_Create(XLA_FFI_Error_Create_Args* args);
+
+// Data types have the same values as the XLA PrimitiveType enum.
+typedef int XLA_FFI_DataType;
+
+typedef enum {
+  XLA_FFI_ArgType_BUFFER = 1,
+} XLA_FFI_ArgType;
+
+typedef enum {
+  XLA_FFI_RetType_BUFFER = 1,
+} XLA_FFI_RetType;
+
+typedef enum {
+  XLA_FFI_AttrType_ARRAY = 1,
+  XLA_FFI_AttrType_DICTIONARY = 2,
+  XLA_FFI_AttrType_SCALAR = 3,
+  XLA_FFI_AttrType_STRING = 4,
+} XLA_FFI_AttrType;
+
+typedef enum {
+  XLA_FFI_ExecutionStage_INSTANTIATE = 0,
+  XLA_FFI_ExecutionStage_PREPARE = 1,
+  XLA_FFI_ExecutionStage_INITIALIZE = 2,
+  XLA_FFI_ExecutionStage_EXECUTE = 3,
+} XLA_FFI_ExecutionStage;
+
+typedef uint32_t XLA_FFI_Handler_Traits;
+
+typedef struct XLA_FFI_Buffer {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_DataType dtype;
+  void* data;
+  int64_t rank;
+  int64_t* dims;  // length == rank
+} XLA_FFI_Buffer;
+
+typedef struct XLA_FFI_ByteSpan {
+  const char* ptr;
+  size_t len;
+} XLA_FFI_ByteSpan;
+
+typedef struct XLA_FFI_Scalar {
+  XLA_FFI_DataType dtype;
+  void* value;
+} XLA_FFI_Scalar;
+
+typedef struct XLA_FFI_Array {
+  XLA_FFI_DataType dtype;
+  size_t size;
+  void* data;
+} XLA_FFI_Array;
+
+typedef struct XLA_FFI_Args {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_ArgType* types;  // length == size
+  void** args;             // length == size
+} XLA_FFI_Args;
+
+typedef struct XLA_FFI_Rets {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_RetType* types;  // length == size
+  void** rets;             // length == size
+} XLA_FFI_Rets;
+
+typedef struct XLA_FFI_Attrs {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  int64_t size;
+  XLA_FFI_AttrType* types;   // length == size
+  XLA_FFI_ByteSpan** names;  // length == size
+  void** attrs;              // length == size
+} XLA_FFI_Attrs;
+
+typedef struct XLA_FFI_CallFrame {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  const XLA_FFI_Api* api;
+  XLA_FFI_ExecutionContext* ctx;
+  XLA_FFI_ExecutionStage stage;
+  XLA_FFI_Args args;
+  XLA_FFI_Rets rets;
+  XLA_FFI_Attrs attrs;
+} XLA_FFI_CallFrame;
+
+// Metadata extension: when present in the call frame, the handler is not executed, instead it must report
+// the version of the API it was compiled with.
+typedef struct XLA_FFI_Metadata {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_Api_Version api_version;
+  XLA_FFI_Handler_Traits traits;
+} XLA_FFI_Metadata;
+
+typedef struct XLA_FFI_Metadata_Extension {
+  XLA_FFI_Extension_Base extension_base;
+  XLA_FFI_Metadata* metadata;
+} XLA_FFI_Metadata_Extension;
+
+typedef XLA_FFI_Error* XLA_FFI_Handler(XLA_FFI_CallFrame* call_frame);
+
+// Only the beginning of XLA_FFI_Api is declared: go-xla only uses it to create errors.
+struct XLA_FFI_Api {
+  size_t struct_size;
+  XLA_FFI_Extension_Base* extension_start;
+  XLA_FFI_Api_Version api_version;
+  void* internal_api;
+  XLA_FFI_Error_Create* XLA_FFI_Error_Create;
+};
+
+// PJRT FFI extension ----------------------------------------------------------------------------------------
+
+typedef struct PJRT_FFI_Register_Handler_Args {
+  size_t struct_size;
+  const char* target_name;
+  size_t target_name_size;
+  void* handler;  // XLA_FFI_Handler*
+  const char* platform_name;
+  size_t platform_name_size;
+  uint32_t traits;
+} PJRT_FFI_Register_Handler_Args;
+
+typedef PJRT_Error* PJRT_FFI_Register_Handler(PJRT_FFI_Register_Handler_Args* args);
+
+typedef struct PJRT_FFI_Extension {
+  PJRT_Extension_Base base;
+  void* type_id_register;
+  void* user_data_add;
+  PJRT_FFI_Register_Handler* register_handler;
+} PJRT_FFI_Extension;
+
+// go-xla helpers (ffi.c) ------------------------------------------------------------------------------------
+
+// Maximum number of Go FFI handlers that can be registered: each one uses a C trampoline function.
+#define GOXLA_MAX_FFI_HANDLERS 64
+
+// Returns the FFI extension of the plugin, or NULL if it doesn't have one (or it doesn't support
+// registering handlers).
+extern PJRT_FFI_Extension* goxla_ffi_extension(const PJRT_Api* api);
+
+// Registers the trampoline handler #index, that calls the Go handler with the same index, for the target name.
+extern PJRT_Error* goxla_ffi_register_handler(PJRT_FFI_Extension* ext, int index, const char* target_name,
+                                              size_t target_name_size, const char* platform_name,
+                                              size_t platform_name_size);
+
+// Go implementation of the handlers (see ffi.go): it returns NULL on success, or an error message allocated with
+// malloc (freed by the caller) and sets errc.
+extern char* goxlaFFIHandler(int index, XLA_FFI_CallFrame* call_frame, int* errc);
+
+#ifdef __cplusplus
+}  // extern "C"
+#endif
+
+#endif  // GOMLX_GOPJRT_FFI_C_API
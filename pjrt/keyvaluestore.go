@@ -0,0 +1,205 @@
+package pjrt
+
+/*
+#include <stdlib.h>
+#include "pjrt_c_api.h"
+#include "common.h"
+*/
+import "C"
+import (
+	"context"
+	"runtime/cgo"
+	"slices"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// KeyValueStore is a key-value store shared by the processes (possibly on different hosts) of a multi-process job.
+//
+// PJRT clients use it during their creation to exchange information (e.g. the topology and the devices of each
+// process), see Plugin.NewClientWithKeyValueStore.
+//
+// Implementations must be safe for concurrent use.
+//
+// This package provides MemoryKeyValueStore, for processes sharing the same store in memory (e.g. tests), and
+// KeyValueStoreServer and DialKeyValueStore to share a store across processes over TCP.
+type KeyValueStore interface {
+	// Get returns the value of the key, blocking until the key is set or the timeout expires.
+	// On timeout, it must return an error wrapping context.DeadlineExceeded.
+	Get(key string, timeout time.Duration) ([]byte, error)
+
+	// TryGet returns the value of the key if it is set, or an error wrapping ErrKeyNotFound otherwise.
+	TryGet(key string) ([]byte, error)
+
+	// Put sets the value of the key.
+	Put(key string, value []byte) error
+}
+
+// ErrKeyNotFound is returned (wrapped) by KeyValueStore.TryGet if the key is not set.
+var ErrKeyNotFound = errors.New("key not found in KeyValueStore")
+
+// keyValueStoreHandle holds the cgo.Handle of a KeyValueStore used by a client, in C memory, so it can be given
+// as the "user_arg" of the PJRT key-value callbacks.
+type keyValueStoreHandle struct {
+	c *C.uintptr_t
+}
+
+// newKeyValueStoreHandle creates a handle to the store. It must be freed with Free.
+func newKeyValueStoreHandle(store KeyValueStore) *keyValueStoreHandle {
+	h := &keyValueStoreHandle{c: cMalloc[C.uintptr_t]()}
+	*h.c = C.uintptr_t(cgo.NewHandle(store))
+	return h
+}
+
+// Free releases the handle: the store can no longer be used by the callbacks.
+func (h *keyValueStoreHandle) Free() {
+	if h == nil || h.c == nil {
+		return
+	}
+	cgo.Handle(*h.c).Delete()
+	cFree(h.c)
+	h.c = nil
+}
+
+// setCallbacks configures the key-value callbacks of the client creation args.
+func (h *keyValueStoreHandle) setCallbacks(args *C.PJRT_Client_Create_Args) {
+	userArg := unsafe.Pointer(h.c)
+	args.kv_get_callback = C.PJRT_KeyValueGetCallback(C.pjrtKeyValueGetCallback)
+	args.kv_get_user_arg = userArg
+	args.kv_try_get_callback = C.PJRT_KeyValueTryGetCallback(C.pjrtKeyValueTryGetCallback)
+	args.kv_try_get_user_arg = userArg
+	args.kv_put_callback = C.PJRT_KeyValuePutCallback(C.pjrtKeyValuePutCallback)
+	args.kv_put_user_arg = userArg
+}
+
+// keyValueStoreFromUserArg returns the KeyValueStore of the "user_arg" of a callback.
+func keyValueStoreFromUserArg(userArg unsafe.Pointer) KeyValueStore {
+	return cgo.Handle(*(*C.uintptr_t)(userArg)).Value().(KeyValueStore)
+}
+
+// keyValueCallbackError converts err to a PJRT_Error using the callbackError given to the callback.
+func keyValueCallbackError(callbackError *C.PJRT_CallbackError, err error) *C.PJRT_Error {
+	code := C.PJRT_Error_Code(PJRT_Error_Code_UNKNOWN)
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		code = C.PJRT_Error_Code(PJRT_Error_Code_NOT_FOUND)
+	case errors.Is(err, context.DeadlineExceeded):
+		code = C.PJRT_Error_Code(PJRT_Error_Code_DEADLINE_EXCEEDED)
+	}
+	msg := err.Error()
+	cMsg := C.CString(msg)
+	defer cFree(cMsg)
+	return C.call_PJRT_CallbackError(callbackError, code, cMsg, C.size_t(len(msg)))
+}
+
+// keyValueToC returns the value allocated in C memory, to be freed by free_PJRT_KeyValue_Value.
+func keyValueToC(value []byte) (*C.char, C.size_t) {
+	cValue := (*C.char)(C.malloc(C.size_t(max(len(value), 1))))
+	if len(value) > 0 {
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(cValue)), len(value)), value)
+	}
+	return cValue, C.size_t(len(value))
+}
+
+//export pjrtKeyValueGetCallback
+func pjrtKeyValueGetCallback(args *C.PJRT_KeyValueGetCallback_Args) *C.PJRT_Error {
+	store := keyValueStoreFromUserArg(args.user_arg)
+	key := cCharArray(args.key, args.key_size)
+	value, err := store.Get(key, time.Duration(args.timeout_in_ms)*time.Millisecond)
+	if err != nil {
+		return keyValueCallbackError(args.callback_error, errors.WithMessagef(err, "KeyValueStore.Get(%q)", key))
+	}
+	args.value, args.value_size = keyValueToC(value)
+	args.value_deleter_callback = C.PJRT_KeyValueGetCallback_ValueDeleter(C.free_PJRT_KeyValue_Value)
+	return nil
+}
+
+//export pjrtKeyValueTryGetCallback
+func pjrtKeyValueTryGetCallback(args *C.PJRT_KeyValueTryGetCallback_Args) *C.PJRT_Error {
+	store := keyValueStoreFromUserArg(args.user_arg)
+	key := cCharArray(args.key, args.key_size)
+	value, err := store.TryGet(key)
+	if err != nil {
+		return keyValueCallbackError(args.callback_error, errors.WithMessagef(err, "KeyValueStore.TryGet(%q)", key))
+	}
+	args.value, args.value_size = keyValueToC(value)
+	args.value_deleter_callback = C.PJRT_KeyValueTryGetCallback_ValueDeleter(C.free_PJRT_KeyValue_Value)
+	return nil
+}
+
+//export pjrtKeyValuePutCallback
+func pjrtKeyValuePutCallback(args *C.PJRT_KeyValuePutCallback_Args) *C.PJRT_Error {
+	store := keyValueStoreFromUserArg(args.user_arg)
+	key := cCharArray(args.key, args.key_size)
+	value := []byte(cCharArray(args.value, args.value_size))
+	if err := store.Put(key, value); err != nil {
+		return keyValueCallbackError(args.callback_error, errors.WithMessagef(err, "KeyValueStore.Put(%q)", key))
+	}
+	return nil
+}
+
+// MemoryKeyValueStore is a KeyValueStore kept in memory.
+//
+// It can be shared by clients created in the same process, or served to other processes with
+// KeyValueStoreServer. Values are copied when set and when returned, so callers can't modify the stored values.
+type MemoryKeyValueStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+
+	// changed is closed (and replaced) whenever a value is set, to wake up blocked Get calls.
+	changed chan struct{}
+}
+
+var _ KeyValueStore = (*MemoryKeyValueStore)(nil)
+
+// NewMemoryKeyValueStore creates an empty MemoryKeyValueStore.
+func NewMemoryKeyValueStore() *MemoryKeyValueStore {
+	return &MemoryKeyValueStore{
+		values:  make(map[string][]byte),
+		changed: make(chan struct{}),
+	}
+}
+
+// Get implements KeyValueStore.
+func (s *MemoryKeyValueStore) Get(key string, timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		value, found := s.values[key]
+		changed := s.changed
+		s.mu.Unlock()
+		if found {
+			return slices.Clone(value), nil
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, errors.Wrapf(context.DeadlineExceeded, "key %q not set after %s", key, timeout)
+		}
+	}
+}
+
+// TryGet implements KeyValueStore.
+func (s *MemoryKeyValueStore) TryGet(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, found := s.values[key]
+	if !found {
+		return nil, errors.Wrapf(ErrKeyNotFound, "key %q", key)
+	}
+	return slices.Clone(value), nil
+}
+
+// Put implements KeyValueStore.
+func (s *MemoryKeyValueStore) Put(key string, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = slices.Clone(value)
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return nil
+}
@@ -0,0 +1,253 @@
+package pjrt
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// KeyValueStoreServer serves a KeyValueStore over TCP, so that the processes of a multi-process job can share it
+// (see DialKeyValueStore). It works as the coordinator of the job: it is usually started by the process with
+// "node_id" 0, or by a separate process.
+//
+// It uses the standard library net/rpc package, there is no authentication nor encryption: only use it in
+// trusted networks (e.g. to test multi-process jobs in a single machine).
+type KeyValueStoreServer struct {
+	listener net.Listener
+	server   *rpc.Server
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+
+	// closed is closed by Close, to interrupt the pending calls to Get.
+	closed chan struct{}
+}
+
+// keyValueRPC is the net/rpc service of KeyValueStoreServer.
+type keyValueRPC struct {
+	store  KeyValueStore
+	closed chan struct{}
+}
+
+// errKeyValueStoreServerClosed is returned to the pending calls to Get when the server is closed.
+var errKeyValueStoreServerClosed = errors.New("KeyValueStoreServer closed")
+
+// KeyValueStoreRequest is the request of the KeyValueStoreServer protocol (it is exported because net/rpc requires
+// it), it is not meant to be used directly.
+type KeyValueStoreRequest struct {
+	Key     string
+	Value   []byte
+	Timeout time.Duration
+}
+
+// KeyValueStoreReply is the reply of the KeyValueStoreServer protocol (it is exported because net/rpc requires
+// it), it is not meant to be used directly.
+// The errors ErrKeyNotFound and context.DeadlineExceeded are transmitted as flags, so they can be recreated by
+// the client.
+type KeyValueStoreReply struct {
+	Value            []byte
+	NotFound         bool
+	DeadlineExceeded bool
+}
+
+// Get implements the "KeyValueStore.Get" method.
+//
+// If the server is closed while waiting for the key, it returns an error right away, and the call to the store
+// finishes in the background.
+func (s *keyValueRPC) Get(args KeyValueStoreRequest, reply *KeyValueStoreReply) error {
+	type getResult struct {
+		value []byte
+		err   error
+	}
+	resultChan := make(chan getResult, 1)
+	go func() {
+		value, err := s.store.Get(args.Key, args.Timeout)
+		resultChan <- getResult{value, err}
+	}()
+	select {
+	case result := <-resultChan:
+		return reply.set(result.value, result.err)
+	case <-s.closed:
+		return errors.Wrapf(errKeyValueStoreServerClosed, "Get(%q) interrupted", args.Key)
+	}
+}
+
+// TryGet implements the "KeyValueStore.TryGet" method.
+func (s *keyValueRPC) TryGet(args KeyValueStoreRequest, reply *KeyValueStoreReply) error {
+	value, err := s.store.TryGet(args.Key)
+	return reply.set(value, err)
+}
+
+// Put implements the "KeyValueStore.Put" method.
+func (s *keyValueRPC) Put(args KeyValueStoreRequest, reply *KeyValueStoreReply) error {
+	return reply.set(nil, s.store.Put(args.Key, args.Value))
+}
+
+// set the reply with the result of a KeyValueStore method.
+func (r *KeyValueStoreReply) set(value []byte, err error) error {
+	switch {
+	case err == nil:
+		r.Value = value
+		return nil
+	case errors.Is(err, ErrKeyNotFound):
+		r.NotFound = true
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		r.DeadlineExceeded = true
+		return nil
+	default:
+		return err
+	}
+}
+
+// NewKeyValueStoreServer starts serving the store at the given TCP address (e.g. "localhost:12345", or
+// "localhost:0" to pick a free port, see KeyValueStoreServer.Addr).
+// If store is nil, a new MemoryKeyValueStore is used.
+//
+// Call KeyValueStoreServer.Close to stop it.
+func NewKeyValueStoreServer(address string, store KeyValueStore) (*KeyValueStoreServer, error) {
+	if store == nil {
+		store = NewMemoryKeyValueStore()
+	}
+	closed := make(chan struct{})
+	server := rpc.NewServer()
+	if err := server.RegisterName("KeyValueStore", &keyValueRPC{store: store, closed: closed}); err != nil {
+		return nil, errors.Wrap(err, "failed to register KeyValueStore RPC service")
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to listen on %q for the KeyValueStoreServer", address)
+	}
+	s := &KeyValueStoreServer{
+		listener: listener,
+		server:   server,
+		conns:    make(map[net.Conn]bool),
+		closed:   closed,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// serve accepts connections until the server is closed.
+func (s *KeyValueStoreServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if !s.isClosed() {
+				klog.Errorf("KeyValueStoreServer failed to accept connections on %s: %v", s.Addr(), err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.isClosed() {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.server.ServeConn(conn)
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on, to be used with DialKeyValueStore.
+func (s *KeyValueStoreServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// isClosed returns whether Close was called.
+func (s *KeyValueStoreServer) isClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the server and closes all its connections.
+// Pending calls to KeyValueStore.Get are interrupted with an error, so Close returns promptly.
+func (s *KeyValueStoreServer) Close() error {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return nil
+	}
+	close(s.closed)
+	err := s.listener.Close()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// KeyValueStoreClient is a KeyValueStore served by a KeyValueStoreServer, see DialKeyValueStore.
+type KeyValueStoreClient struct {
+	client *rpc.Client
+}
+
+var _ KeyValueStore = (*KeyValueStoreClient)(nil)
+
+// DialKeyValueStore connects to the KeyValueStoreServer at the given address.
+// The returned client can be used with Plugin.NewClientWithKeyValueStore, and it should be closed
+// (KeyValueStoreClient.Close) only after the PJRT client is destroyed.
+func DialKeyValueStore(address string) (*KeyValueStoreClient, error) {
+	client, err := rpc.Dial("tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to KeyValueStoreServer at %q", address)
+	}
+	return &KeyValueStoreClient{client: client}, nil
+}
+
+// call the method of the server, and converts the reply flags back to errors.
+func (c *KeyValueStoreClient) call(method string, args KeyValueStoreRequest) ([]byte, error) {
+	var reply KeyValueStoreReply
+	if err := c.client.Call("KeyValueStore."+method, args, &reply); err != nil {
+		return nil, errors.Wrapf(err, "KeyValueStoreClient.%s(%q) failed", method, args.Key)
+	}
+	switch {
+	case reply.NotFound:
+		return nil, errors.Wrapf(ErrKeyNotFound, "key %q", args.Key)
+	case reply.DeadlineExceeded:
+		return nil, errors.Wrapf(context.DeadlineExceeded, "key %q not set after %s", args.Key, args.Timeout)
+	}
+	return reply.Value, nil
+}
+
+// Get implements KeyValueStore.
+func (c *KeyValueStoreClient) Get(key string, timeout time.Duration) ([]byte, error) {
+	return c.call("Get", KeyValueStoreRequest{Key: key, Timeout: timeout})
+}
+
+// TryGet implements KeyValueStore.
+func (c *KeyValueStoreClient) TryGet(key string) ([]byte, error) {
+	return c.call("TryGet", KeyValueStoreRequest{Key: key})
+}
+
+// Put implements KeyValueStore.
+func (c *KeyValueStoreClient) Put(key string, value []byte) error {
+	_, err := c.call("Put", KeyValueStoreRequest{Key: key, Value: value})
+	return err
+}
+
+// Close the connection to the server.
+func (c *KeyValueStoreClient) Close() error {
+	return c.client.Close()
+}
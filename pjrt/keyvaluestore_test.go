@@ -0,0 +1,218 @@
+package pjrt
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testKeyValueStore runs the KeyValueStore semantics tests on store.
+func testKeyValueStore(t *testing.T, store KeyValueStore) {
+	_, err := store.TryGet("missing")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("TryGet of a missing key should return ErrKeyNotFound, got %v", err)
+	}
+	_, err = store.Get("missing", 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get of a missing key should time out with context.DeadlineExceeded, got %v", err)
+	}
+
+	requireNoError(t, store.Put("a", []byte("value_a")))
+	value, err := store.TryGet("a")
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("value_a"), value)
+
+	// Get blocks until the key is set by another goroutine.
+	done := make(chan error, 1)
+	go func() {
+		value, err := store.Get("b", 10*time.Second)
+		if err == nil && string(value) != "value_b" {
+			err = errors.Errorf("got value %q for key \"b\"", value)
+		}
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	requireNoError(t, store.Put("b", []byte("value_b")))
+	requireNoError(t, <-done)
+
+	// Empty values are valid.
+	requireNoError(t, store.Put("empty", nil))
+	value, err = store.Get("empty", time.Second)
+	requireNoError(t, err)
+	if len(value) != 0 {
+		t.Fatalf("expected empty value, got %q", value)
+	}
+}
+
+func TestMemoryKeyValueStore(t *testing.T) {
+	store := NewMemoryKeyValueStore()
+	testKeyValueStore(t, store)
+
+	// Modifying the values given to Put, or returned by Get and TryGet, doesn't change the stored value.
+	value := []byte("value_c")
+	requireNoError(t, store.Put("c", value))
+	value[0] = 'X'
+	got, err := store.Get("c", time.Second)
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("value_c"), got)
+	got[0] = 'X'
+	got, err = store.TryGet("c")
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("value_c"), got)
+	got[0] = 'X'
+	got, err = store.Get("c", time.Second)
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("value_c"), got)
+}
+
+func TestKeyValueStoreServer(t *testing.T) {
+	server, err := NewKeyValueStoreServer("localhost:0", nil)
+	requireNoError(t, err)
+	defer func() { requireNoError(t, server.Close()) }()
+
+	client, err := DialKeyValueStore(server.Addr())
+	requireNoError(t, err)
+	testKeyValueStore(t, client)
+
+	// A second client sees the values of the first one.
+	client2, err := DialKeyValueStore(server.Addr())
+	requireNoError(t, err)
+	value, err := client2.Get("a", time.Second)
+	requireNoError(t, err)
+	assertEqualSlice(t, []byte("value_a"), value)
+	requireNoError(t, client2.Close())
+	requireNoError(t, client.Close())
+}
+
+func TestKeyValueStoreServer_CloseInterruptsGet(t *testing.T) {
+	server, err := NewKeyValueStoreServer("localhost:0", nil)
+	requireNoError(t, err)
+	client, err := DialKeyValueStore(server.Addr())
+	requireNoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	// Get blocks waiting for a key that is never set.
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Get("never_set", time.Hour)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Close must not wait for the pending Get to time out.
+	start := time.Now()
+	requireNoError(t, server.Close())
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("KeyValueStoreServer.Close took %s with a pending Get", elapsed)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("Get of a key never set should fail when the server is closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Get still blocked after KeyValueStoreServer.Close")
+	}
+}
+
+// TestMultiNodeClients creates two CPU clients (nodes) sharing a KeyValueStore, as if they were in different
+// processes: PJRT exchanges the devices of each node through the store callbacks.
+func TestMultiNodeClients(t *testing.T) {
+	if *FlagPluginName != "cpu" {
+		t.Skipf("Skipping multi-node test on plugin %q: it requires the CPU plugin", *FlagPluginName)
+	}
+	plugin, err := GetPlugin(*FlagPluginName)
+	requireNoError(t, err)
+
+	t.Run("MemoryKeyValueStore", func(t *testing.T) {
+		store := NewMemoryKeyValueStore()
+		testMultiNodeClients(t, plugin, []KeyValueStore{store, store})
+	})
+
+	t.Run("KeyValueStoreServer", func(t *testing.T) {
+		server, err := NewKeyValueStoreServer("localhost:0", nil)
+		requireNoError(t, err)
+		defer func() { requireNoError(t, server.Close()) }()
+		var stores []KeyValueStore
+		for range 2 {
+			store, err := DialKeyValueStore(server.Addr())
+			requireNoError(t, err)
+			defer func() { requireNoError(t, store.Close()) }()
+			stores = append(stores, store)
+		}
+		testMultiNodeClients(t, plugin, stores)
+	})
+}
+
+// testMultiNodeClients creates one client per store, each with its own "node_id", concurrently (the creation of a
+// client only returns once all nodes have joined), and checks that all of them see the devices of all nodes.
+func testMultiNodeClients(t *testing.T, plugin *Plugin, stores []KeyValueStore) {
+	numNodes := len(stores)
+	clients := make([]*Client, numNodes)
+	errs := make([]error, numNodes)
+	var wg sync.WaitGroup
+	for nodeID := range numNodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[nodeID], errs[nodeID] = plugin.NewClientWithKeyValueStore(NamedValuesMap{
+				"num_nodes": int64(numNodes),
+				"node_id":   int64(nodeID),
+			}, stores[nodeID])
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatalf("timed out waiting for the clients of the %d nodes to be created", numNodes)
+	}
+	defer func() {
+		// Clients of a multi-node job are destroyed concurrently, since their shutdown may wait for each other.
+		var wg sync.WaitGroup
+		for _, client := range clients {
+			if client == nil {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := client.Destroy(); err != nil {
+					t.Errorf("failed to destroy client: %+v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	}()
+	for nodeID, err := range errs {
+		requireNoError(t, err, "failed to create client for node #%d", nodeID)
+	}
+
+	numLocalDevices := clients[0].NumDevices()
+	for nodeID, client := range clients {
+		fmt.Printf("\tnode #%d: %s\n", nodeID, client)
+		assertEqual(t, nodeID, client.ProcessIndex())
+		assertEqual(t, numLocalDevices, client.NumDevices())
+		allDevices, err := client.AllDevices()
+		requireNoError(t, err)
+		assertLen(t, allDevices, numNodes*numLocalDevices, "node #%d should see the devices of all nodes", nodeID)
+		devicesPerNode := make(map[int]int)
+		for _, device := range allDevices {
+			description, err := device.GetDescription()
+			requireNoError(t, err)
+			devicesPerNode[description.ProcessIndex()]++
+		}
+		for otherNodeID := range numNodes {
+			assertEqual(t, numLocalDevices, devicesPerNode[otherNodeID],
+				"number of devices of node #%d seen by node #%d", otherNodeID, nodeID)
+		}
+	}
+}
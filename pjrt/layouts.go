@@ -0,0 +1,140 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// SetTiledMemoryLayout configures layout as a tiled layout: the union in PJRT_Buffer_MemoryLayout is not
+// accessible from Go.
+void SetTiledMemoryLayout(PJRT_Buffer_MemoryLayout *layout, const int64_t *minor_to_major, size_t rank,
+		const int64_t *tile_dims, const size_t *tile_dim_sizes, size_t num_tiles) {
+	layout->struct_size = PJRT_Buffer_MemoryLayout_STRUCT_SIZE;
+	layout->extension_start = NULL;
+	layout->type = PJRT_Buffer_MemoryLayout_Type_Tiled;
+	layout->tiled.struct_size = PJRT_Buffer_MemoryLayout_Tiled_STRUCT_SIZE;
+	layout->tiled.extension_start = NULL;
+	layout->tiled.minor_to_major = minor_to_major;
+	layout->tiled.minor_to_major_size = rank;
+	layout->tiled.tile_dims = tile_dims;
+	layout->tiled.tile_dim_sizes = tile_dim_sizes;
+	layout->tiled.num_tiles = num_tiles;
+}
+*/
+import "C"
+import (
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/protos/xla_data"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// newCMemoryLayout allocates in the arena a tiled PJRT_Buffer_MemoryLayout for the given layout.
+// All the data is allocated in the arena, so it is valid until the arena is returned.
+func newCMemoryLayout(arena *arenaContainer, layout *shapes.Layout) *C.PJRT_Buffer_MemoryLayout {
+	cLayout := arenaAlloc[C.PJRT_Buffer_MemoryLayout](arena)
+	rank := len(layout.MinorToMajor)
+	var minorToMajor *C.int64_t
+	if rank > 0 {
+		cMinorToMajor := arenaAllocSlice[C.int64_t](arena, rank)
+		for i, axis := range layout.MinorToMajor {
+			cMinorToMajor[i] = C.int64_t(axis)
+		}
+		minorToMajor = unsafe.SliceData(cMinorToMajor)
+	}
+	var tileDims *C.int64_t
+	var tileDimSizes *C.size_t
+	if len(layout.Tiles) > 0 {
+		numTileDims := 0
+		for _, tile := range layout.Tiles {
+			numTileDims += len(tile)
+		}
+		cTileDims := arenaAllocSlice[C.int64_t](arena, numTileDims)
+		cTileDimSizes := arenaAllocSlice[C.size_t](arena, len(layout.Tiles))
+		pos := 0
+		for i, tile := range layout.Tiles {
+			cTileDimSizes[i] = C.size_t(len(tile))
+			for _, dim := range tile {
+				cTileDims[pos] = C.int64_t(dim)
+				pos++
+			}
+		}
+		tileDims = unsafe.SliceData(cTileDims)
+		tileDimSizes = unsafe.SliceData(cTileDimSizes)
+	}
+	C.SetTiledMemoryLayout(cLayout, minorToMajor, C.size_t(rank), tileDims, tileDimSizes, C.size_t(len(layout.Tiles)))
+	return cLayout
+}
+
+// dtypeToPrimitiveType maps a DType to the XLA PrimitiveType used in the protos. They are not the same
+// as the PJRT_Buffer_Type used by the C API (and DType).
+var dtypeToPrimitiveType = map[dtypes.DType]xla_data.PrimitiveType{
+	dtypes.Bool:          xla_data.PrimitiveType_PRED,
+	dtypes.Int2:          xla_data.PrimitiveType_S2,
+	dtypes.Int4:          xla_data.PrimitiveType_S4,
+	dtypes.Int8:          xla_data.PrimitiveType_S8,
+	dtypes.Int16:         xla_data.PrimitiveType_S16,
+	dtypes.Int32:         xla_data.PrimitiveType_S32,
+	dtypes.Int64:         xla_data.PrimitiveType_S64,
+	dtypes.Uint2:         xla_data.PrimitiveType_U2,
+	dtypes.Uint4:         xla_data.PrimitiveType_U4,
+	dtypes.Uint8:         xla_data.PrimitiveType_U8,
+	dtypes.Uint16:        xla_data.PrimitiveType_U16,
+	dtypes.Uint32:        xla_data.PrimitiveType_U32,
+	dtypes.Uint64:        xla_data.PrimitiveType_U64,
+	dtypes.Float16:       xla_data.PrimitiveType_F16,
+	dtypes.Float32:       xla_data.PrimitiveType_F32,
+	dtypes.Float64:       xla_data.PrimitiveType_F64,
+	dtypes.BFloat16:      xla_data.PrimitiveType_BF16,
+	dtypes.Complex64:     xla_data.PrimitiveType_C64,
+	dtypes.Complex128:    xla_data.PrimitiveType_C128,
+	dtypes.F8E5M2:        xla_data.PrimitiveType_F8E5M2,
+	dtypes.F8E4M3FN:      xla_data.PrimitiveType_F8E4M3FN,
+	dtypes.F8E4M3:        xla_data.PrimitiveType_F8E4M3,
+	dtypes.F8E4M3FNUZ:    xla_data.PrimitiveType_F8E4M3FNUZ,
+	dtypes.F8E5M2FNUZ:    xla_data.PrimitiveType_F8E5M2FNUZ,
+	dtypes.F8E4M3B11FNUZ: xla_data.PrimitiveType_F8E4M3B11FNUZ,
+}
+
+// shapeWithLayoutToProto converts the shape and its layout to a xla_data.ShapeProto.
+// If layout is nil, the default row-major layout is used.
+func shapeWithLayoutToProto(shape shapes.Shape, layout *shapes.Layout) (*xla_data.ShapeProto, error) {
+	if shape.IsTuple() {
+		return nil, errors.Errorf("layouts of tuple shapes (%s) are not supported", shape)
+	}
+	elementType, found := dtypeToPrimitiveType[shape.DType]
+	if !found {
+		return nil, errors.Errorf("dtype %s not supported for layouts", shape.DType)
+	}
+	if layout == nil {
+		layout = shapes.RowMajorLayout(shape.Rank())
+	}
+	if err := layout.Validate(shape.Rank()); err != nil {
+		return nil, errors.WithMessagef(err, "invalid layout for shape %s", shape)
+	}
+	shapeProto := &xla_data.ShapeProto{
+		ElementType:        elementType,
+		Dimensions:         make([]int64, shape.Rank()),
+		IsDynamicDimension: make([]bool, shape.Rank()),
+		Layout:             &xla_data.LayoutProto{MinorToMajor: make([]int64, shape.Rank())},
+	}
+	for i, dim := range shape.Dimensions {
+		if dim < 0 {
+			return nil, errors.Errorf("layouts of dynamic shapes (%s) are not supported", shape)
+		}
+		shapeProto.Dimensions[i] = int64(dim)
+	}
+	for i, axis := range layout.MinorToMajor {
+		shapeProto.Layout.MinorToMajor[i] = int64(axis)
+	}
+	for _, tile := range layout.Tiles {
+		tileProto := &xla_data.TileProto{Dimensions: make([]int64, len(tile))}
+		for i, dim := range tile {
+			tileProto.Dimensions[i] = int64(dim)
+		}
+		shapeProto.Layout.Tiles = append(shapeProto.Layout.Tiles, tileProto)
+	}
+	return shapeProto, nil
+}
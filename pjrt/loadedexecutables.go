@@ -5,35 +5,19 @@ package pjrt
 #include "gen_api_calls.h"
 #include "gen_new_struct.h"
 
-PJRT_Error* ExecuteAndWait(const PJRT_Api *api, PJRT_LoadedExecutable_Execute_Args* args) {
-	PJRT_Error *err = api->PJRT_LoadedExecutable_Execute(args);
-	if (err) {
-		return err;
-	}
-
-	if (args->device_complete_events) {
-		// Wait for devices to complete executions.
-		for (int ii = 0; ii < args->num_devices; ii++) {
-			PJRT_Event_Await_Args event_args = {0};
-			event_args.struct_size = PJRT_Event_Await_Args_STRUCT_SIZE;
-			event_args.event = args->device_complete_events[ii];
-			err = api->PJRT_Event_Await(&event_args);
-			PJRT_Event_Destroy_Args efree_args;
-			efree_args.struct_size = PJRT_Event_Destroy_Args_STRUCT_SIZE;
-			efree_args.event = args->device_complete_events[ii];
-			api->PJRT_Event_Destroy(&efree_args);
-			if (err) {
-				return err;
-			}
-		}
+// PoisonExecution calls PJRT_Device_PoisonExecution, if the plugin supports it. Otherwise, it returns NULL and
+// leaves args->poisoned as false.
+PJRT_Error* PoisonExecution(const PJRT_Api *api, PJRT_Device_PoisonExecution_Args* args) {
+	if (api->struct_size <= offsetof(PJRT_Api, PJRT_Device_PoisonExecution) || api->PJRT_Device_PoisonExecution == NULL) {
+		return NULL;
 	}
-	return NULL;
+	return api->PJRT_Device_PoisonExecution(args);
 }
 
-
 */
 import "C"
 import (
+	"context"
 	"runtime"
 	"slices"
 	"sync/atomic"
@@ -74,6 +58,9 @@ type LoadedExecutable struct {
 type loadedExecutableC struct {
 	c      *C.PJRT_LoadedExecutable
 	plugin *Plugin
+
+	// inFlight defers the destruction of the C executable while asynchronous executions are using it.
+	inFlight inFlightGuard
 }
 
 func (wrapper *loadedExecutableC) Destroy() error {
@@ -82,12 +69,26 @@ func (wrapper *loadedExecutableC) Destroy() error {
 		return nil
 	}
 	defer runtime.KeepAlive(wrapper)
-	args := C.new_PJRT_LoadedExecutable_Destroy_Args()
-	defer cFree(args)
-	args.executable = wrapper.c
-	err := toError(wrapper.plugin, C.call_PJRT_LoadedExecutable_Destroy(wrapper.plugin.api, args))
+	plugin, cExecutable := wrapper.plugin, wrapper.c
 	wrapper.plugin = nil
 	wrapper.c = nil
+	deferred := wrapper.inFlight.deferDestroy(func() {
+		if err := destroyLoadedExecutableC(plugin, cExecutable); err != nil {
+			klog.Errorf("Failed to destroy pjrt.LoadedExecutable after its executions finished: %+v", err)
+		}
+	})
+	if deferred {
+		return nil
+	}
+	return destroyLoadedExecutableC(plugin, cExecutable)
+}
+
+// destroyLoadedExecutableC destroys the C executable.
+func destroyLoadedExecutableC(plugin *Plugin, cExecutable *C.PJRT_LoadedExecutable) error {
+	args := C.new_PJRT_LoadedExecutable_Destroy_Args()
+	defer cFree(args)
+	args.executable = cExecutable
+	err := toError(plugin, C.call_PJRT_LoadedExecutable_Destroy(plugin.api, args))
 	numLoadedExecutables.Add(-1)
 	return err
 }
@@ -183,6 +184,64 @@ func (e *LoadedExecutable) getExecutable() (*Executable, error) {
 	return newExecutable(e.plugin, args.executable), nil
 }
 
+// Serialize the compiled executable, so it can be loaded later with Client.LoadSerializedExecutable, skipping
+// the compilation.
+//
+// The serialization is platform-specific, and it is not guaranteed to be stable across versions of the plugin.
+func (e *LoadedExecutable) Serialize() ([]byte, error) {
+	if e == nil || e.executable == nil {
+		return nil, errors.New("LoadedExecutable is nil or it has been destroyed already")
+	}
+	return e.executable.Serialize()
+}
+
+// LoadSerializedExecutable loads an executable serialized with LoadedExecutable.Serialize, or compiled ahead-of-time
+// with Plugin.CompileForTopology.
+//
+// The executable must have been serialized by the same platform and plugin version. The device assignment
+// (see LoadedExecutable.GetDeviceAssignment) is restored from the compilation options of the executable.
+func (c *Client) LoadSerializedExecutable(serialized []byte) (*LoadedExecutable, error) {
+	if !c.IsValid() {
+		return nil, errors.New("client is nil or it has been destroyed already")
+	}
+	if len(serialized) == 0 {
+		return nil, errors.New("LoadSerializedExecutable given an empty serialized executable")
+	}
+	exec, err := pjrtExecutableDeserializeAndLoad(c.plugin, c, serialized)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to load serialized executable")
+	}
+	options, err := exec.executable.compileOptions()
+	if err != nil {
+		exec.destroyOrLog()
+		return nil, errors.WithMessagef(err, "failed to get the compile options of the serialized executable")
+	}
+	exec.isPortable = options.CompilePortableExecutable
+	if buildOptions := options.ExecutableBuildOptions; buildOptions != nil {
+		exec.numReplicas = max(1, int(buildOptions.NumReplicas))
+		exec.numPartitions = max(1, int(buildOptions.NumPartitions))
+		if assignmentProto := buildOptions.DeviceAssignment; assignmentProto != nil && !exec.isPortable {
+			if len(assignmentProto.ComputationDevices) != exec.numPartitions {
+				exec.destroyOrLog()
+				return nil, errors.Errorf("serialized executable has %d partitions, but its device assignment has %d",
+					exec.numPartitions, len(assignmentProto.ComputationDevices))
+			}
+			exec.deviceAssignment = make([]int, exec.numReplicas*exec.numPartitions)
+			for partitionIdx, computationDevices := range assignmentProto.ComputationDevices {
+				if len(computationDevices.ReplicaDeviceIds) != exec.numReplicas {
+					exec.destroyOrLog()
+					return nil, errors.Errorf("serialized executable has %d replicas, but its device assignment has %d",
+						exec.numReplicas, len(computationDevices.ReplicaDeviceIds))
+				}
+				for replicaIdx, deviceID := range computationDevices.ReplicaDeviceIds {
+					exec.deviceAssignment[replicaIdx*exec.numPartitions+partitionIdx] = int(deviceID) // replica-major order.
+				}
+			}
+		}
+	}
+	return exec, nil
+}
+
 // GetDeviceAssignment returns the device assignment of the executable.
 //
 // This is used when using multiple-devices. The assignment is a list of device indices, ordered by replica first
@@ -229,6 +288,8 @@ func (e *LoadedExecutable) IsPortable() bool {
 //
 // Example: if executing f(x,y) on two replicas, you should call Execute(x_0, y_0, x_1, y_1), where f(x_0, y_0)
 // will be executed on the first replica and f(x_1, y_1) on the second replica.
+//
+// See ExecutePerDevice for an alternative that takes the inputs grouped per device.
 func (e *LoadedExecutable) Execute(inputs ...*Buffer) *ExecutionConfig {
 	c := &ExecutionConfig{
 		executable: e,
@@ -247,16 +308,25 @@ func (e *LoadedExecutable) Execute(inputs ...*Buffer) *ExecutionConfig {
 //
 // After configuring it, call Done to actually trigger the execution.
 //
-// TODO: add support for multi-device execution, with some inputs shared across devices, and some per-device specific.
+// For multi-device execution, see also LoadedExecutable.ExecutePerDevice.
 type ExecutionConfig struct {
 	executable         *LoadedExecutable
 	onDevice           *Device
 	inputs             []*Buffer
 	nonDonatableInputs []int
 
+	// numDevices and numArgsPerDevice are set by LoadedExecutable.ExecutePerDevice, otherwise they are 0.
+	numDevices, numArgsPerDevice int
+
+	// broadcastInputs maps the argument index to the buffer to broadcast to all devices, see BroadcastInput.
+	broadcastInputs map[int]*Buffer
+
 	// portableDevice is the device to execute the computation on, if it is portable.
 	portableDevice int
 
+	// launchID, if not zero, identifies the execution, so it can be poisoned (see DoneContext).
+	launchID int32
+
 	// err saves an error during the configuration.
 	err error
 }
@@ -368,8 +438,21 @@ func (c *ExecutionConfig) SetDonate(donate []bool) *ExecutionConfig {
 	return c
 }
 
-// Done triggers the execution of the compiled computation.
+// Done triggers the execution of the compiled computation, and waits for it to finish.
+// See DoneContext for a version that can be cancelled.
 func (c *ExecutionConfig) Done() ([]*Buffer, error) {
+	execution, err := c.launch()
+	if err != nil {
+		return nil, err
+	}
+	return execution.readyOutputs(execution.await())
+}
+
+// launch triggers the execution of the compiled computation, without waiting for it to finish.
+//
+// Donated inputs are destroyed, and the executable and the inputs can be destroyed by the caller: the release of
+// their C resources is deferred until the execution finishes (see pendingExecution).
+func (c *ExecutionConfig) launch() (*pendingExecution, error) {
 	if c.err != nil {
 		return nil, c.err
 	}
@@ -383,6 +466,15 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 
 	// Dimensions of inputs/outputs.
 	numDevices := e.numReplicas * e.numPartitions
+	if c.numDevices != 0 && c.numDevices != numDevices {
+		return nil, errors.Errorf("LoadedExecutable.ExecutePerDevice() given inputs for %d devices, but the "+
+			"executable runs on %d devices", c.numDevices, numDevices)
+	}
+	broadcastCopies, err := c.resolveBroadcastInputs()
+	if err != nil {
+		return nil, err
+	}
+	defer destroyBuffers(broadcastCopies) // No-op for the donated copies, that are already destroyed.
 	numInputs := len(c.inputs)
 	if numInputs%numDevices != 0 {
 		return nil, errors.Errorf("LoadedExecutable.Execute() requires that the number of inputs be "+
@@ -425,6 +517,7 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	var options *C.PJRT_ExecuteOptions
 	options = arenaAlloc[C.PJRT_ExecuteOptions](arena) // Extra args that for some reason(?) go on a separate struct.
 	options.struct_size = C.PJRT_ExecuteOptions_STRUCT_SIZE
+	options.launch_id = C.int(c.launchID)
 	args.options = options
 
 	// Configure (non-)donatable inputs.
@@ -456,29 +549,133 @@ func (c *ExecutionConfig) Done() ([]*Buffer, error) {
 	perDeviceEvents := arenaAllocSlice[*C.PJRT_Event](arena, numDevices)
 	args.device_complete_events = (**C.PJRT_Event)(unsafe.SliceData(perDeviceEvents))
 
-	err := toError(e.plugin, C.ExecuteAndWait(e.plugin.api, args))
+	execution := &pendingExecution{
+		plugin:     plugin,
+		client:     e.client,
+		name:       e.Name,
+		launchID:   c.launchID,
+		executable: e.wrapper,
+	}
+	for _, input := range c.inputs {
+		if input != nil && input.wrapper != nil {
+			execution.inputs = append(execution.inputs, input.wrapper)
+		}
+	}
+	execution.acquire()
+	err = toError(plugin, C.call_PJRT_LoadedExecutable_Execute(plugin.api, args))
 	if err != nil {
+		execution.release()
 		return nil, err
 	}
+	execution.events = slices.Clone(perDeviceEvents)
 
-	// We only support one device for now, so we return the results from the first device.
-	outputs := make([]*Buffer, numOutputs)
-	outputBuffers := unsafe.Slice(*args.output_lists, numOutputs)
-	for ii := range outputs {
-		outputs[ii] = newBuffer(e.client, outputBuffers[ii])
+	// Outputs are returned flat, in device-major order: each device has its own list of outputs.
+	execution.outputs = make([]*Buffer, 0, numOutputs)
+	if numOutputs > 0 {
+		for _, deviceOutputs := range unsafe.Slice(args.output_lists, numDevices) {
+			for _, output := range unsafe.Slice(deviceOutputs, numOutputsPerDevice) {
+				execution.outputs = append(execution.outputs, newBuffer(e.client, output))
+			}
+		}
 	}
 
-	// Destroy donated inputs, since they are no longer valid.
+	// Destroy donated inputs, since they are no longer valid: their C buffers are only released once the
+	// execution finishes.
 	for idx, input := range c.inputs {
 		if c.nonDonatableInputs == nil || slices.Index(c.nonDonatableInputs, idx) == -1 {
-			err := input.Destroy()
-			if err != nil {
-				err = errors.WithMessagef(err, "LoadedExecutable.Execute().Done() failed to destroy donated input %d: %v", idx, err)
-				return nil, err
+			if err := input.Destroy(); err != nil {
+				klog.Errorf("LoadedExecutable.Execute() failed to destroy donated input %d: %+v", idx, err)
 			}
 		}
 	}
-	return outputs, nil
+	return execution, nil
+}
+
+// lastLaunchID is used to generate unique launch IDs for DoneContext.
+var lastLaunchID atomic.Int32
+
+// nextLaunchID returns a new (positive) launch ID.
+func nextLaunchID() int32 {
+	launchID := lastLaunchID.Add(1)
+	if launchID <= 0 {
+		// Wrapped around: zero means no launch ID.
+		lastLaunchID.Store(1)
+		launchID = 1
+	}
+	return launchID
+}
+
+// DoneContext triggers the execution of the compiled computation, like Done, but it returns promptly if the context
+// is cancelled (or its deadline is exceeded) before the execution finishes.
+//
+// On cancellation, the execution is poisoned on the client's devices (if the plugin supports
+// PJRT_Device_PoisonExecution), so that pending work is aborted, and it returns the output buffers along with the
+// error: the outputs of a poisoned execution are set to an error state, and reading them returns the cancellation
+// error. If the execution couldn't be poisoned (e.g. it was already running on the device), the outputs hold its
+// results once it finishes. Either way, the caller owns the outputs and should destroy them.
+//
+// The executable and the inputs can be destroyed right after DoneContext returns: the release of their resources is
+// deferred until the execution finishes. Donated inputs are consumed either way.
+func (c *ExecutionConfig) DoneContext(ctx context.Context) ([]*Buffer, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if ctx.Done() == nil {
+		// Context can't be cancelled.
+		return c.Done()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrapf(err, "LoadedExecutable.Execute().DoneContext() not executed")
+	}
+	c.launchID = nextLaunchID()
+	execution, err := c.launch()
+	if err != nil {
+		return nil, err
+	}
+	ready := execution.onReady()
+	select {
+	case err = <-ready:
+		return execution.readyOutputs(err)
+	case <-ctx.Done():
+	}
+	select {
+	case err = <-ready:
+		// Finished anyway.
+		return execution.readyOutputs(err)
+	default:
+	}
+
+	// Cancelled: poison the execution, whose outputs will carry the error.
+	cause := context.Cause(ctx)
+	poisoned := execution.client.poisonExecution(execution.launchID, cause)
+	return execution.outputs, errors.Wrapf(cause, "execution of %q cancelled (poisoned=%v)", execution.name, poisoned)
+}
+
+// poisonExecution poisons the execution with the given launchID on all addressable devices, setting its outputs to
+// an error state with the cause. It returns whether any execution was poisoned.
+func (c *Client) poisonExecution(launchID int32, cause error) (poisoned bool) {
+	if !c.IsValid() {
+		return false
+	}
+	args := C.new_PJRT_Device_PoisonExecution_Args()
+	defer cFree(args)
+	msg := cause.Error()
+	args.launch_id = C.int32_t(launchID)
+	args.error_code = C.PJRT_Error_Code_CANCELLED
+	args.error_message = C.CString(msg)
+	defer cFree(args.error_message)
+	args.error_message_size = C.size_t(len(msg))
+	for _, device := range c.addressableDevices {
+		args.device = device.cDevice
+		args.poisoned = false
+		err := toError(c.plugin, C.PoisonExecution(c.plugin.api, args))
+		if err != nil {
+			klog.Warningf("Failed to poison execution (launch_id=%d) on device %d: %v", launchID, device.LocalHardwareID(), err)
+			continue
+		}
+		poisoned = poisoned || bool(args.poisoned)
+	}
+	return poisoned
 }
 
 // Allocate [numDevices][numBuffers]*Buffer C 2D-array to be used by PJRT C API.
@@ -0,0 +1,189 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "common.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"sync"
+	"unsafe"
+
+	"k8s.io/klog/v2"
+)
+
+// inFlightGuard defers the destruction of a C object (a loaded executable or a buffer) while executions that
+// use it are still running on the device(s).
+//
+// The Go object is invalidated immediately when destroyed, only the release of the C object is deferred.
+type inFlightGuard struct {
+	mu       sync.Mutex
+	count    int
+	deferred func()
+}
+
+// acquire marks one more execution in flight.
+func (g *inFlightGuard) acquire() {
+	g.mu.Lock()
+	g.count++
+	g.mu.Unlock()
+}
+
+// release marks the end of one execution in flight, and runs the deferred destruction if it was the last one.
+func (g *inFlightGuard) release() {
+	g.mu.Lock()
+	g.count--
+	var deferred func()
+	if g.count == 0 {
+		deferred, g.deferred = g.deferred, nil
+	}
+	g.mu.Unlock()
+	if deferred != nil {
+		deferred()
+	}
+}
+
+// deferDestroy returns true, and saves destroy to be called by the last release, if there are executions in flight.
+// Otherwise, it returns false, and the caller should destroy the object right away.
+func (g *inFlightGuard) deferDestroy(destroy func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.count == 0 {
+		return false
+	}
+	g.deferred = destroy
+	return true
+}
+
+// pendingExecution is an execution launched by ExecutionConfig.launch, that may still be running on the device(s).
+//
+// It holds a reference (see inFlightGuard) to the executable and to the inputs until the execution finishes, so
+// they can be destroyed by the user in the meantime.
+type pendingExecution struct {
+	plugin   *Plugin
+	client   *Client
+	name     string
+	launchID int32
+
+	// outputs of the execution, owned by the caller.
+	outputs []*Buffer
+
+	// events, one per device, that become ready when the execution finishes.
+	events []*C.PJRT_Event
+
+	executable *loadedExecutableC
+	inputs     []*bufferWrapper
+}
+
+// acquire references to the executable and the inputs of the execution.
+func (p *pendingExecution) acquire() {
+	p.executable.inFlight.acquire()
+	for _, input := range p.inputs {
+		input.inFlight.acquire()
+	}
+}
+
+// release the references to the executable and to the inputs, destroying the ones whose destruction was deferred.
+func (p *pendingExecution) release() {
+	for _, input := range p.inputs {
+		input.inFlight.release()
+	}
+	p.executable.inFlight.release()
+}
+
+// await blocks until the execution finishes, destroys its events and releases its references.
+// It returns the error of the execution, if any.
+func (p *pendingExecution) await() error {
+	defer p.release()
+	return awaitEvents(p.plugin, p.events)
+}
+
+// readyOutputs returns the outputs of the finished execution, or destroys them and returns err if it failed.
+func (p *pendingExecution) readyOutputs(err error) ([]*Buffer, error) {
+	if err != nil {
+		destroyBuffers(p.outputs)
+		return nil, err
+	}
+	return p.outputs, nil
+}
+
+// awaitEvents blocks until all events are ready, and destroys them. It returns the first error, if any.
+func awaitEvents(plugin *Plugin, events []*C.PJRT_Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return toError(plugin, C.AwaitEvents(plugin.api, (**C.PJRT_Event)(unsafe.SliceData(events)), C.int(len(events))))
+}
+
+// destroyEvents destroys the events, logging any errors.
+func destroyEvents(plugin *Plugin, events []*C.PJRT_Event) {
+	args := C.new_PJRT_Event_Destroy_Args()
+	defer cFree(args)
+	for _, event := range events {
+		args.event = event
+		if err := toError(plugin, C.call_PJRT_Event_Destroy(plugin.api, args)); err != nil {
+			klog.Errorf("Failed to destroy execution completion event: %+v", err)
+		}
+	}
+}
+
+// executionReadyCallback is the "user_arg" of the PJRT_Event_OnReady callbacks of a pendingExecution.
+type executionReadyCallback struct {
+	plugin *Plugin
+	ready  chan error
+}
+
+// onReady returns a channel that receives the error of the execution (or nil) once it finishes on all devices.
+//
+// It doesn't block any thread while waiting: it registers callbacks on the completion events. Once they are all
+// called, the events are destroyed and the references to the executable and inputs are released.
+func (p *pendingExecution) onReady() <-chan error {
+	callback := &executionReadyCallback{plugin: p.plugin, ready: make(chan error, len(p.events))}
+	handle := cMalloc[C.uintptr_t]()
+	*handle = C.uintptr_t(cgo.NewHandle(callback))
+	args := C.new_PJRT_Event_OnReady_Args()
+	defer cFree(args)
+	args.callback = C.PJRT_Event_OnReadyCallback(C.pjrtExecutionEventReady)
+	args.user_arg = unsafe.Pointer(handle)
+	registered := make([]*C.PJRT_Event, 0, len(p.events))
+	var unregistered []*C.PJRT_Event
+	for _, event := range p.events {
+		args.event = event
+		if err := toError(p.plugin, C.call_PJRT_Event_OnReady(p.plugin.api, args)); err != nil {
+			klog.Warningf("Failed to register callback on the completion of the execution of %q, "+
+				"waiting on it instead: %v", p.name, err)
+			unregistered = append(unregistered, event)
+			continue
+		}
+		registered = append(registered, event)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// Only if registering a callback failed, this blocks a thread waiting.
+		err := awaitEvents(p.plugin, unregistered)
+		for range registered {
+			if eventErr := <-callback.ready; err == nil {
+				err = eventErr
+			}
+		}
+		cgo.Handle(*handle).Delete()
+		cFree(handle)
+		destroyEvents(p.plugin, registered)
+		p.release()
+		done <- err
+	}()
+	return done
+}
+
+// pjrtExecutionEventReady is the PJRT_Event_OnReady callback registered by pendingExecution.onReady.
+// It takes the ownership of cErr.
+//
+//export pjrtExecutionEventReady
+func pjrtExecutionEventReady(cErr *C.PJRT_Error, userArg unsafe.Pointer) {
+	callback := cgo.Handle(*(*C.uintptr_t)(userArg)).Value().(*executionReadyCallback)
+	callback.ready <- toError(callback.plugin, cErr)
+}
@@ -0,0 +1,213 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"context"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// AddressableDevices returns the devices the executable runs on, in the order of execution: the per-device inputs
+// (see ExecutePerDevice) and outputs (see ExecutionConfig.DonePerDevice) follow this order.
+//
+// For portable executables (see IsPortable) it is empty: the device is chosen at execution time (see
+// ExecutionConfig.OnDevice).
+func (e *LoadedExecutable) AddressableDevices() ([]*Device, error) {
+	if e == nil || e.plugin == nil || e.wrapper == nil {
+		return nil, errors.New("LoadedExecutable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_LoadedExecutable_AddressableDevices_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.plugin, C.call_PJRT_LoadedExecutable_AddressableDevices(e.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cDevices := cDataToSlice[*C.PJRT_Device](unsafe.Pointer(args.addressable_devices), int(args.num_addressable_devices))
+	devices := make([]*Device, len(cDevices))
+	for ii, cDevice := range cDevices {
+		devices[ii] = e.client.deviceFromC(cDevice)
+	}
+	return devices, nil
+}
+
+// deviceFromC returns the addressable Device of the client that wraps cDevice, or a new Device reference if not found.
+func (c *Client) deviceFromC(cDevice *C.PJRT_Device) *Device {
+	for _, device := range c.addressableDevices {
+		if device.cDevice == cDevice {
+			return device
+		}
+	}
+	return newDevice(c, cDevice)
+}
+
+// ExecutePerDevice is like Execute, but the inputs are given per device: perDeviceInputs[deviceIdx][argIdx] is the
+// argument argIdx for the device deviceIdx, in the order given by AddressableDevices.
+//
+// All devices must have the same number of arguments. Arguments set with ExecutionConfig.BroadcastInput must be
+// left nil.
+//
+// The donation indices (see ExecutionConfig.Donate) refer to the flattened inputs, in device-major order: argument
+// argIdx of device deviceIdx has the index deviceIdx*numArgs+argIdx.
+//
+// Use ExecutionConfig.DonePerDevice to get the outputs grouped per device.
+//
+// Example: if executing f(x, w) with 2 replicas, where w is the same for all replicas:
+//
+//	outputs, err := loadedExec.ExecutePerDevice([][]*Buffer{{x0, nil}, {x1, nil}}).
+//		BroadcastInput(1, w).
+//		DonePerDevice()
+//	// outputs[0] has the outputs of f(x0, w) and outputs[1] has the outputs of f(x1, w).
+func (e *LoadedExecutable) ExecutePerDevice(perDeviceInputs [][]*Buffer) *ExecutionConfig {
+	var inputs []*Buffer
+	numArgs := 0
+	if len(perDeviceInputs) > 0 {
+		numArgs = len(perDeviceInputs[0])
+	}
+	for deviceIdx, deviceInputs := range perDeviceInputs {
+		if len(deviceInputs) != numArgs {
+			c := e.Execute()
+			c.err = errors.Errorf("LoadedExecutable.ExecutePerDevice() requires the same number of inputs for all "+
+				"devices, but device #0 has %d inputs and device #%d has %d", numArgs, deviceIdx, len(deviceInputs))
+			return c
+		}
+		inputs = append(inputs, deviceInputs...)
+	}
+	c := e.Execute(inputs...)
+	c.numDevices = len(perDeviceInputs)
+	c.numArgsPerDevice = numArgs
+	return c
+}
+
+// BroadcastInput sets the input argIdx of all devices to the buffer: it is copied to each device it is not already
+// stored on. It can only be used with ExecutePerDevice, and the corresponding inputs must be left nil.
+//
+// The buffer itself is used as input for the device it is stored on: if the input is donated, it is destroyed
+// after execution. The copies to the other devices are temporary and destroyed after execution.
+func (c *ExecutionConfig) BroadcastInput(argIdx int, buffer *Buffer) *ExecutionConfig {
+	if c.err != nil {
+		return c
+	}
+	if c.numDevices == 0 {
+		c.err = errors.New("LoadedExecutable.Execute().BroadcastInput() can only be used with LoadedExecutable.ExecutePerDevice()")
+		return c
+	}
+	if argIdx < 0 || argIdx >= c.numArgsPerDevice {
+		c.err = errors.Errorf("LoadedExecutable.Execute().BroadcastInput() given invalid argIdx=%d, there are %d "+
+			"inputs per device", argIdx, c.numArgsPerDevice)
+		return c
+	}
+	if err := buffer.Check(); err != nil {
+		c.err = errors.WithMessagef(err, "LoadedExecutable.Execute().BroadcastInput() for argIdx=%d", argIdx)
+		return c
+	}
+	for deviceIdx := range c.numDevices {
+		if c.inputs[deviceIdx*c.numArgsPerDevice+argIdx] != nil {
+			c.err = errors.Errorf("LoadedExecutable.Execute().BroadcastInput() for argIdx=%d, but device #%d "+
+				"already has a (non-nil) input for it", argIdx, deviceIdx)
+			return c
+		}
+	}
+	if c.broadcastInputs == nil {
+		c.broadcastInputs = make(map[int]*Buffer)
+	}
+	c.broadcastInputs[argIdx] = buffer
+	return c
+}
+
+// resolveBroadcastInputs copies the broadcast inputs (see BroadcastInput) to the devices of the execution, and sets
+// them as inputs. It returns the temporary copies, to be destroyed after the execution.
+func (c *ExecutionConfig) resolveBroadcastInputs() (copies []*Buffer, err error) {
+	if len(c.broadcastInputs) == 0 {
+		return nil, nil
+	}
+	e := c.executable
+	var devices []*Device
+	if e.isPortable {
+		if c.onDevice == nil {
+			return nil, errors.New("LoadedExecutable.Execute() requires that OnDevice to be set to non-nil device before Done")
+		}
+		devices = []*Device{c.onDevice}
+	} else {
+		devices, err = e.AddressableDevices()
+		if err != nil {
+			return nil, errors.WithMessagef(err, "LoadedExecutable.Execute().BroadcastInput() failed to get the devices of the executable")
+		}
+	}
+	if len(devices) != c.numDevices {
+		return nil, errors.Errorf("LoadedExecutable.ExecutePerDevice() given inputs for %d devices, but the executable "+
+			"runs on %d addressable devices", c.numDevices, len(devices))
+	}
+	for argIdx, buffer := range c.broadcastInputs {
+		bufferDevice, err := buffer.Device()
+		if err != nil {
+			destroyBuffers(copies)
+			return nil, errors.WithMessagef(err, "LoadedExecutable.Execute().BroadcastInput() failed to get the device of input #%d", argIdx)
+		}
+		for deviceIdx, device := range devices {
+			input := buffer
+			if device.cDevice != bufferDevice.cDevice {
+				input, err = buffer.CopyToDevice(device)
+				if err != nil {
+					destroyBuffers(copies)
+					return nil, errors.WithMessagef(err, "LoadedExecutable.Execute().BroadcastInput() failed to "+
+						"copy input #%d to device #%d", argIdx, deviceIdx)
+				}
+				copies = append(copies, input)
+			}
+			c.inputs[deviceIdx*c.numArgsPerDevice+argIdx] = input
+		}
+	}
+	c.broadcastInputs = nil
+	return copies, nil
+}
+
+// destroyBuffers destroys the buffers, logging any errors.
+func destroyBuffers(buffers []*Buffer) {
+	for _, buffer := range buffers {
+		if err := buffer.Destroy(); err != nil {
+			klog.Errorf("Failed to destroy buffer: %v", err)
+		}
+	}
+}
+
+// DonePerDevice is like Done, but it returns the outputs grouped per device: outputs[deviceIdx][outputIdx], in
+// the order given by LoadedExecutable.AddressableDevices.
+func (c *ExecutionConfig) DonePerDevice() ([][]*Buffer, error) {
+	outputs, err := c.Done()
+	if err != nil {
+		return nil, err
+	}
+	return c.executable.splitPerDevice(outputs), nil
+}
+
+// DonePerDeviceContext is like DoneContext, but it returns the outputs grouped per device, see DonePerDevice.
+//
+// As with DoneContext, if the context is cancelled it returns the outputs of the cancelled execution along with the
+// error.
+func (c *ExecutionConfig) DonePerDeviceContext(ctx context.Context) ([][]*Buffer, error) {
+	outputs, err := c.DoneContext(ctx)
+	if outputs == nil {
+		return nil, err
+	}
+	return c.executable.splitPerDevice(outputs), err
+}
+
+// splitPerDevice splits the flat list of outputs of an execution into per-device lists.
+func (e *LoadedExecutable) splitPerDevice(outputs []*Buffer) [][]*Buffer {
+	numDevices := e.numReplicas * e.numPartitions
+	perDevice := make([][]*Buffer, numDevices)
+	for deviceIdx := range perDevice {
+		perDevice[deviceIdx] = outputs[deviceIdx*e.NumOutputs : (deviceIdx+1)*e.NumOutputs]
+	}
+	return perDevice
+}
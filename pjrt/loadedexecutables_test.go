@@ -46,3 +46,27 @@ func TestDonatableConfig(t *testing.T) {
 	err = client.Destroy()
 	requireNoError(t, err, "Failed to destroy the client")
 }
+
+func TestInFlightGuard(t *testing.T) {
+	var guard inFlightGuard
+	numDestroyed := 0
+	destroy := func() { numDestroyed++ }
+
+	// Without executions in flight, destruction is not deferred.
+	assertEqual(t, false, guard.deferDestroy(destroy))
+	assertEqual(t, 0, numDestroyed)
+
+	// With executions in flight, destruction only happens on the last release.
+	guard.acquire()
+	guard.acquire()
+	assertEqual(t, true, guard.deferDestroy(destroy))
+	guard.release()
+	assertEqual(t, 0, numDestroyed)
+	guard.release()
+	assertEqual(t, 1, numDestroyed)
+
+	// The deferred destruction is only called once.
+	guard.acquire()
+	guard.release()
+	assertEqual(t, 1, numDestroyed)
+}
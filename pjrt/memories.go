@@ -0,0 +1,297 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// Memory kinds commonly supported by the PJRT plugins. The kinds available are platform dependent, see
+// Client.AddressableMemories and Memory.Kind.
+const (
+	// MemoryKindDevice is the default memory of accelerators (e.g. GPU HBM).
+	MemoryKindDevice = "device"
+
+	// MemoryKindPinnedHost is page-locked host memory, that can be accessed directly by the accelerators (e.g.
+	// to offload optimizer state to host memory).
+	MemoryKindPinnedHost = "pinned_host"
+
+	// MemoryKindUnpinnedHost is regular host memory.
+	MemoryKindUnpinnedHost = "unpinned_host"
+)
+
+// Memory is a lightweight reference to a memory space (e.g. device memory or pinned host memory) managed by a
+// Client -- it doesn't own the underlying object, which has the same lifetime as the client.
+//
+// Memories are used to place buffers (see BufferFromHostConfig.ToMemory and Buffer.CopyToMemory).
+type Memory struct {
+	client  *Client
+	cMemory *C.PJRT_Memory
+}
+
+// newMemory creates a new Memory reference.
+func newMemory(client *Client, memory *C.PJRT_Memory) *Memory {
+	return &Memory{client: client, cMemory: memory}
+}
+
+// ID of the memory, unique among the memories of the same kind.
+func (m *Memory) ID() (int, error) {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_Id_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_Id(plugin.api, args))
+	if err != nil {
+		return -1, err
+	}
+	return int(args.id), nil
+}
+
+// Kind returns the platform-dependent kind of the memory, e.g. MemoryKindDevice or MemoryKindPinnedHost.
+func (m *Memory) Kind() (string, error) {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_Kind_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_Kind(plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.kind, args.kind_size), nil
+}
+
+// KindID returns the platform-dependent ID of the kind of the memory.
+func (m *Memory) KindID() (int, error) {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_Kind_Id_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_Kind_Id(plugin.api, args))
+	if err != nil {
+		return -1, err
+	}
+	return int(args.kind_id), nil
+}
+
+// DebugString returns a verbose description of the memory.
+func (m *Memory) DebugString() string {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_DebugString_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_DebugString(plugin.api, args))
+	if err != nil {
+		return fmt.Sprintf("<failed to get Memory.DebugString: %v>", err)
+	}
+	return cCharArray(args.debug_string, args.debug_string_size)
+}
+
+// String implements fmt.Stringer, it returns a terse description of the memory.
+func (m *Memory) String() string {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_ToString_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_ToString(plugin.api, args))
+	if err != nil {
+		return fmt.Sprintf("<failed to get Memory.String: %v>", err)
+	}
+	return cCharArray(args.to_string, args.to_string_size)
+}
+
+// AddressableByDevices returns the devices that can address the memory.
+func (m *Memory) AddressableByDevices() ([]*Device, error) {
+	plugin := m.client.plugin
+	args := C.new_PJRT_Memory_AddressableByDevices_Args()
+	defer cFree(args)
+	args.memory = m.cMemory
+	err := toError(plugin, C.call_PJRT_Memory_AddressableByDevices(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cDevices := cDataToSlice[*C.PJRT_Device](unsafe.Pointer(args.devices), int(args.num_devices))
+	devices := make([]*Device, len(cDevices))
+	for i, cDevice := range cDevices {
+		devices[i] = newDevice(m.client, cDevice)
+	}
+	return devices, nil
+}
+
+// memoriesFromC converts a C array of memories to Memory references.
+func memoriesFromC(client *Client, cMemories **C.PJRT_Memory, numMemories C.size_t) []*Memory {
+	cSlice := cDataToSlice[*C.PJRT_Memory](unsafe.Pointer(cMemories), int(numMemories))
+	memories := make([]*Memory, len(cSlice))
+	for i, cMemory := range cSlice {
+		memories[i] = newMemory(client, cMemory)
+	}
+	return memories
+}
+
+// AddressableMemories returns the memories the client can directly transfer data to and from.
+// All memories are addressable in a single-process environment.
+func (c *Client) AddressableMemories() ([]*Memory, error) {
+	args := C.new_PJRT_Client_AddressableMemories_Args()
+	defer cFree(args)
+	args.client = c.client.c
+	err := toError(c.plugin, C.call_PJRT_Client_AddressableMemories(c.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return memoriesFromC(c, args.addressable_memories, args.num_addressable_memories), nil
+}
+
+// DefaultMemory returns the memory where the data processed by the device is stored by default.
+func (d *Device) DefaultMemory() (*Memory, error) {
+	args := C.new_PJRT_Device_DefaultMemory_Args()
+	defer cFree(args)
+	args.device = d.cDevice
+	err := toError(d.plugin, C.call_PJRT_Device_DefaultMemory(d.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newMemory(d.client, args.memory), nil
+}
+
+// AddressableMemories returns the memories the device can address.
+func (d *Device) AddressableMemories() ([]*Memory, error) {
+	args := C.new_PJRT_Device_AddressableMemories_Args()
+	defer cFree(args)
+	args.device = d.cDevice
+	err := toError(d.plugin, C.call_PJRT_Device_AddressableMemories(d.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return memoriesFromC(d.client, args.memories, args.num_memories), nil
+}
+
+// MemoryByKind returns the memory of the given kind (e.g. MemoryKindPinnedHost) addressable by the device, or an
+// error if there is none.
+func (d *Device) MemoryByKind(kind string) (*Memory, error) {
+	memories, err := d.AddressableMemories()
+	if err != nil {
+		return nil, err
+	}
+	for _, memory := range memories {
+		memoryKind, err := memory.Kind()
+		if err != nil {
+			return nil, err
+		}
+		if memoryKind == kind {
+			return memory, nil
+		}
+	}
+	return nil, errors.Errorf("no memory of kind %q addressable by device %d", kind, d.LocalHardwareID())
+}
+
+// Memory returns the memory where the buffer is stored.
+func (b *Buffer) Memory() (*Memory, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(b)
+	args := C.new_PJRT_Buffer_Memory_Args()
+	defer cFree(args)
+	args.buffer = b.wrapper.c
+	err = toError(plugin, C.call_PJRT_Buffer_Memory(plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newMemory(b.wrapper.client, args.memory), nil
+}
+
+// CopyToMemory copies the buffer to the given memory (e.g. pinned host memory) of the same client, and returns
+// a new buffer. The original buffer is not affected.
+//
+// It returns an error if the buffer is already stored in dstMemory.
+func (b *Buffer) CopyToMemory(dstMemory *Memory) (*Buffer, error) {
+	plugin, err := b.getPlugin()
+	if err != nil {
+		return nil, err
+	}
+	if dstMemory == nil || dstMemory.cMemory == nil {
+		return nil, errors.New("destination memory is nil")
+	}
+	defer runtime.KeepAlive(b)
+	defer runtime.KeepAlive(dstMemory)
+	args := C.new_PJRT_Buffer_CopyToMemory_Args()
+	defer cFree(args)
+	args.buffer = b.wrapper.c
+	args.dst_memory = dstMemory.cMemory
+	err = toError(plugin, C.call_PJRT_Buffer_CopyToMemory(plugin.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to copy buffer to memory %s", dstMemory)
+	}
+	return newBuffer(b.wrapper.client, args.dst_buffer), nil
+}
+
+// executableMemoryKinds converts the memory kinds returned by PJRT_Executable_ParameterMemoryKinds and
+// PJRT_Executable_OutputMemoryKinds.
+func executableMemoryKinds(kinds **C.char, kindSizes *C.size_t, num C.size_t) []string {
+	cKinds := cDataToSlice[*C.char](unsafe.Pointer(kinds), int(num))
+	cSizes := cDataToSlice[C.size_t](unsafe.Pointer(kindSizes), int(num))
+	memoryKinds := make([]string, len(cKinds))
+	for i, cKind := range cKinds {
+		memoryKinds[i] = cCharArray(cKind, cSizes[i])
+	}
+	return memoryKinds
+}
+
+// ParameterMemoryKinds returns the memory kind (e.g. MemoryKindDevice or MemoryKindPinnedHost) of each parameter
+// of the executable.
+func (e *Executable) ParameterMemoryKinds() ([]string, error) {
+	if e == nil || !e.wrapper.IsValid() {
+		return nil, errors.New("Executable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_ParameterMemoryKinds_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_ParameterMemoryKinds(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return executableMemoryKinds(args.memory_kinds, args.memory_kind_sizes, args.num_parameters), nil
+}
+
+// OutputMemoryKinds returns the memory kind (e.g. MemoryKindDevice or MemoryKindPinnedHost) of each output
+// of the executable.
+func (e *Executable) OutputMemoryKinds() ([]string, error) {
+	if e == nil || !e.wrapper.IsValid() {
+		return nil, errors.New("Executable is nil, or its plugin or wrapped C representation is nil -- has it been destroyed already?")
+	}
+	defer runtime.KeepAlive(e)
+	args := C.new_PJRT_Executable_OutputMemoryKinds_Args()
+	defer cFree(args)
+	args.executable = e.wrapper.c
+	err := toError(e.wrapper.plugin, C.call_PJRT_Executable_OutputMemoryKinds(e.wrapper.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return executableMemoryKinds(args.memory_kinds, args.memory_kind_sizes, args.num_outputs), nil
+}
+
+// ParameterMemoryKinds returns the memory kind of each parameter of the program, see
+// CompileConfig.WithParameterMemoryKinds.
+func (e *LoadedExecutable) ParameterMemoryKinds() ([]string, error) {
+	if e == nil || e.executable == nil {
+		return nil, errors.New("LoadedExecutable is nil or it has been destroyed already")
+	}
+	return e.executable.ParameterMemoryKinds()
+}
+
+// OutputMemoryKinds returns the memory kind of each output of the program.
+func (e *LoadedExecutable) OutputMemoryKinds() ([]string, error) {
+	if e == nil || e.executable == nil {
+		return nil, errors.New("LoadedExecutable is nil or it has been destroyed already")
+	}
+	return e.executable.OutputMemoryKinds()
+}
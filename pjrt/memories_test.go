@@ -0,0 +1,97 @@
+package pjrt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/protos/compile_options"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestCompileConfig_WithParameterMemoryKinds(t *testing.T) {
+	argShapes := []shapes.Shape{shapes.Make(dtypes.Float32, 3), shapes.Make(dtypes.Int32)}
+	newConfig := func() *CompileConfig {
+		return &CompileConfig{options: &compile_options.CompileOptionsProto{}}
+	}
+
+	cc := newConfig().WithParameterMemoryKinds(argShapes, []string{MemoryKindPinnedHost, ""})
+	requireNoError(t, cc.err)
+	requireNoError(t, cc.applyParameterMemoryKinds())
+	assertLen(t, cc.options.ArgumentLayouts, 2)
+	assertEqual(t, int64(hostMemorySpace), cc.options.ArgumentLayouts[0].Layout.MemorySpace)
+	assertEqual(t, int64(defaultMemorySpace), cc.options.ArgumentLayouts[1].Layout.MemorySpace)
+
+	// Combined with argument layouts.
+	cc = newConfig().
+		WithArgumentLayouts(argShapes, []*shapes.Layout{shapes.ColumnMajorLayout(1), nil}).
+		WithParameterMemoryKinds(argShapes, []string{MemoryKindDevice, MemoryKindPinnedHost})
+	requireNoError(t, cc.err)
+	requireNoError(t, cc.applyParameterMemoryKinds())
+	assertEqual(t, int64(defaultMemorySpace), cc.options.ArgumentLayouts[0].Layout.MemorySpace)
+	assertEqual(t, int64(hostMemorySpace), cc.options.ArgumentLayouts[1].Layout.MemorySpace)
+
+	// Errors.
+	requireError(t, newConfig().WithParameterMemoryKinds(argShapes, []string{MemoryKindDevice}).err)
+	requireError(t, newConfig().WithParameterMemoryKinds(argShapes, []string{"device", "hbm2"}).err)
+	cc = newConfig().
+		WithArgumentLayouts(argShapes[:1], []*shapes.Layout{nil}).
+		WithParameterMemoryKinds(argShapes, []string{MemoryKindDevice, MemoryKindPinnedHost})
+	requireNoError(t, cc.err)
+	requireError(t, cc.applyParameterMemoryKinds())
+}
+
+func TestMemories(t *testing.T) {
+	client := getPJRTClient(t)
+	memories, err := client.AddressableMemories()
+	requireNoError(t, err)
+	assertNotEmpty(t, memories)
+	for _, memory := range memories {
+		kind, err := memory.Kind()
+		requireNoError(t, err)
+		id, err := memory.ID()
+		requireNoError(t, err)
+		devices, err := memory.AddressableByDevices()
+		requireNoError(t, err)
+		fmt.Printf("\tMemory #%d kind=%q (%s): addressable by %d devices\n", id, kind, memory, len(devices))
+	}
+
+	device := client.AddressableDevices()[0]
+	defaultMemory, err := device.DefaultMemory()
+	requireNoError(t, err)
+	defaultKind, err := defaultMemory.Kind()
+	requireNoError(t, err)
+
+	// Transfer to the default memory explicitly.
+	input := []float32{1, 2, 3}
+	buffer, err := client.BufferFromHost().FromFlatDataWithDimensions(input, []int{3}).ToMemory(defaultMemory).Done()
+	requireNoError(t, err)
+	bufferMemory, err := buffer.Memory()
+	requireNoError(t, err)
+	bufferKind, err := bufferMemory.Kind()
+	requireNoError(t, err)
+	assertEqual(t, defaultKind, bufferKind)
+
+	// Copy to another memory of the device, if there is one.
+	deviceMemories, err := device.AddressableMemories()
+	requireNoError(t, err)
+	for _, memory := range deviceMemories {
+		kind, err := memory.Kind()
+		requireNoError(t, err)
+		if kind == defaultKind {
+			continue
+		}
+		copied, err := buffer.CopyToMemory(memory)
+		requireNoError(t, err, "failed to copy buffer to memory %s", memory)
+		copiedMemory, err := copied.Memory()
+		requireNoError(t, err)
+		copiedKind, err := copiedMemory.Kind()
+		requireNoError(t, err)
+		assertEqual(t, kind, copiedKind)
+		output, _, err := BufferToArray[float32](copied)
+		requireNoError(t, err)
+		assertEqualSlice(t, input, output)
+		requireNoError(t, copied.Destroy())
+	}
+	requireNoError(t, buffer.Destroy())
+}
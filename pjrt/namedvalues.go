@@ -8,6 +8,7 @@ package pjrt
 import "C"
 import (
 	"fmt"
+	"slices"
 	"unsafe"
 
 	"github.com/pkg/errors"
@@ -32,7 +33,8 @@ func pjrtNamedValuesToMap(namedValues []C.PJRT_NamedValue) NamedValuesMap {
 		case PJRT_NamedValue_kInt64:
 			m[name] = int64(value.int64_value)
 		case PJRT_NamedValue_kInt64List:
-			m[name] = cDataToSlice[int64](unsafe.Pointer(value.int64_array_value), int(pair.value_size))
+			// Copy the values: the C array may not outlive the owner of the named values (e.g. a device).
+			m[name] = slices.Clone(cDataToSlice[int64](unsafe.Pointer(value.int64_array_value), int(pair.value_size)))
 		case PJRT_NamedValue_kFloat:
 			m[name] = float32(value.float_value)
 		case PJRT_NamedValue_kBool:
@@ -97,6 +97,7 @@ func newPlugin(name, pluginPath string, api *C.PJRT_Api, dllHandle dllHandleWrap
 	if err != nil {
 		return nil, errors.WithMessagef(err, "initializing PJRT getPlugin %q", name)
 	}
+	plugin.registerHostCallbackHandler()
 	return plugin, nil
 }
 
@@ -180,7 +181,23 @@ func (p *Plugin) String() string {
 // NewClient creates a new Client object to manage available devices.
 // The options (it can be left nil) are plugin specific, and should (but often aren't) documented by the plugins.
 func (p *Plugin) NewClient(options NamedValuesMap) (*Client, error) {
-	return newClient(p, options)
+	return newClient(p, options, nil)
+}
+
+// NewClientWithKeyValueStore creates a new Client that can join a multi-process (possibly multi-host) job: the
+// processes exchange information (e.g. their devices) through the shared store.
+//
+// The options identifying the process in the job are plugin specific, usually "node_id" (the index of the process)
+// and "num_nodes" (the number of processes), both int64.
+//
+// Use NewMemoryKeyValueStore if the clients are in the same process, or a KeyValueStoreServer in one of the
+// processes (or in a separate coordinator process) and DialKeyValueStore in each of the processes otherwise.
+// The store must outlive the client.
+func (p *Plugin) NewClientWithKeyValueStore(options NamedValuesMap, store KeyValueStore) (*Client, error) {
+	if store == nil {
+		return nil, errors.New("NewClientWithKeyValueStore requires a non-nil KeyValueStore")
+	}
+	return newClient(p, options, store)
 }
 
 // getDefaultArena gets an arena of the default minimum size.
@@ -0,0 +1,395 @@
+package pjrt
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/dtypes/gotype"
+	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+)
+
+// ShardedArray is a global (logical) array sharded across the devices of a shardy.DeviceMesh: it holds the
+// shardy.ShardingSpec, the global shape, and the per-device buffers with the shards. It is similar to Jax's jax.Array.
+//
+// The buffers are ordered by logical device number (see shardy.ShardingSpec.Shards): the logical device i is the
+// device with ID DeviceAssignment()[i] -- the device assignment used to compile the programs that use the array
+// (see CompileConfig.WithShardy and CompileConfig.WithDeviceAssignment, and Client.NewDeviceMesh).
+//
+// Create it from a host array with ArrayToShardedArray, from existing buffers with NewShardedArray, or as the output
+// of a sharded execution (see LoadedExecutable.ExecuteSharded and ExecutionConfig.DoneSharded).
+// Gather it back to the host with ShardedArrayToArray.
+//
+// The ShardedArray owns its buffers: Destroy destroys them.
+type ShardedArray struct {
+	spec             *shardy.ShardingSpec
+	shape            shapes.Shape
+	deviceAssignment []int
+	buffers          []*Buffer
+}
+
+// NewShardedArray creates a ShardedArray from the buffers with the shards of the global array with the given shape,
+// sharded according to spec. The ShardedArray takes ownership of the buffers.
+//
+// The buffers must be ordered by logical device number (see shardy.ShardingSpec.Shards), and the shard of the
+// logical device i must be stored on the device with ID deviceAssignment[i]. If deviceAssignment is nil, the
+// logical device i is the device with ID i.
+func NewShardedArray(spec *shardy.ShardingSpec, globalShape shapes.Shape, deviceAssignment []int,
+	buffers []*Buffer) (*ShardedArray, error) {
+	if spec == nil {
+		return nil, errors.New("NewShardedArray requires a non-nil ShardingSpec")
+	}
+	shards, err := spec.Shards(globalShape)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "NewShardedArray: invalid ShardingSpec for shape %s", globalShape)
+	}
+	numDevices := len(shards)
+	if deviceAssignment == nil {
+		deviceAssignment = make([]int, numDevices)
+		for i := range deviceAssignment {
+			deviceAssignment[i] = i
+		}
+	}
+	if len(deviceAssignment) != numDevices {
+		return nil, errors.Errorf("NewShardedArray: the mesh %s has %d devices, but the device assignment has %d",
+			spec.Mesh, numDevices, len(deviceAssignment))
+	}
+	if len(buffers) != numDevices {
+		return nil, errors.Errorf("NewShardedArray: the mesh %s has %d devices, but %d buffers were given",
+			spec.Mesh, numDevices, len(buffers))
+	}
+	for i, buffer := range buffers {
+		if err := buffer.Check(); err != nil {
+			return nil, errors.WithMessagef(err, "NewShardedArray: buffer for logical device #%d", i)
+		}
+		dtype, err := buffer.DType()
+		if err != nil {
+			return nil, err
+		}
+		dims, err := buffer.Dimensions()
+		if err != nil {
+			return nil, err
+		}
+		shardShape := shards[i].Shape
+		if dtype != shardShape.DType || !slices.Equal(dims, shardShape.Dimensions) {
+			return nil, errors.Errorf("NewShardedArray: buffer for logical device #%d has shape %s, but the shard "+
+				"of %s with %s should have shape %s", i, shapes.Make(dtype, dims...), globalShape,
+				spec.ToValueAttribute(globalShape), shardShape)
+		}
+	}
+	return &ShardedArray{
+		spec:             spec,
+		shape:            globalShape.Clone(),
+		deviceAssignment: slices.Clone(deviceAssignment),
+		buffers:          slices.Clone(buffers),
+	}, nil
+}
+
+// ArrayToShardedArray splits the global array given by its flat values and dimensions according to spec, and
+// transfers each shard to its device. See ShardedArray for the meaning of deviceAssignment, it can be nil.
+func ArrayToShardedArray[T gotype.Supported](client *Client, spec *shardy.ShardingSpec, deviceAssignment []int,
+	flatValues []T, dimensions ...int) (*ShardedArray, error) {
+	if spec == nil {
+		return nil, errors.New("ArrayToShardedArray requires a non-nil ShardingSpec")
+	}
+	globalShape := shapes.Make(dtypes.FromGenericsType[T](), dimensions...)
+	pieces, err := shardy.ShardFlatData(spec, globalShape, flatValues)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ArrayToShardedArray failed to shard %s", globalShape)
+	}
+	if deviceAssignment != nil && len(deviceAssignment) != len(pieces) {
+		return nil, errors.Errorf("ArrayToShardedArray: the mesh %s has %d devices, but the device assignment has %d",
+			spec.Mesh, len(pieces), len(deviceAssignment))
+	}
+	shardShape, err := spec.ShardShape(globalShape)
+	if err != nil {
+		return nil, err
+	}
+	buffers := make([]*Buffer, len(pieces))
+	for i, piece := range pieces {
+		deviceID := i
+		if deviceAssignment != nil {
+			deviceID = deviceAssignment[i]
+		}
+		device, err := client.deviceByID(deviceID)
+		if err == nil {
+			buffers[i], err = client.BufferFromHost().FromFlatDataWithDimensions(piece, shardShape.Dimensions).
+				ToDevice(device).Done()
+		}
+		if err != nil {
+			destroyBuffers(buffers[:i])
+			return nil, errors.WithMessagef(err, "ArrayToShardedArray failed to transfer the shard of logical device #%d", i)
+		}
+	}
+	return NewShardedArray(spec, globalShape, deviceAssignment, buffers)
+}
+
+// ShardedArrayToArray gathers the shards of the ShardedArray back to the host, and returns the flat values of the
+// global array and its dimensions.
+func ShardedArrayToArray[T gotype.Supported](a *ShardedArray) (flatValues []T, dimensions []int, err error) {
+	if err = a.Check(); err != nil {
+		return
+	}
+	pieces := make([][]T, len(a.buffers))
+	for i, buffer := range a.buffers {
+		pieces[i], _, err = BufferToArray[T](buffer)
+		if err != nil {
+			return nil, nil, errors.WithMessagef(err, "ShardedArrayToArray failed to transfer the shard of logical device #%d", i)
+		}
+	}
+	flatValues, err = shardy.UnshardFlatData(a.spec, a.shape, pieces)
+	if err != nil {
+		return nil, nil, err
+	}
+	return flatValues, slices.Clone(a.shape.Dimensions), nil
+}
+
+// Check returns an error if the ShardedArray is invalid: either it is nil, it has been destroyed, or any of its
+// buffers is invalid.
+func (a *ShardedArray) Check() error {
+	if a == nil || a.buffers == nil {
+		return errors.New("ShardedArray is nil or it has been destroyed")
+	}
+	for i, buffer := range a.buffers {
+		if err := buffer.Check(); err != nil {
+			return errors.WithMessagef(err, "ShardedArray buffer for logical device #%d", i)
+		}
+	}
+	return nil
+}
+
+// Destroy the buffers of the ShardedArray, and the ShardedArray is no longer valid.
+func (a *ShardedArray) Destroy() error {
+	if a == nil || a.buffers == nil {
+		return nil
+	}
+	var firstErr error
+	for _, buffer := range a.buffers {
+		if err := buffer.Destroy(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	a.buffers = nil
+	return firstErr
+}
+
+// Spec returns the ShardingSpec of the array.
+func (a *ShardedArray) Spec() *shardy.ShardingSpec {
+	return a.spec
+}
+
+// Shape returns the global (logical) shape of the array.
+func (a *ShardedArray) Shape() shapes.Shape {
+	return a.shape
+}
+
+// DeviceAssignment returns the device IDs of each logical device, see ShardedArray.
+func (a *ShardedArray) DeviceAssignment() []int {
+	return a.deviceAssignment
+}
+
+// NumDevices returns the number of devices (and buffers) the array is sharded across.
+func (a *ShardedArray) NumDevices() int {
+	return len(a.deviceAssignment)
+}
+
+// Buffers returns the per-device buffers of the array, ordered by logical device number.
+//
+// The ShardedArray owns the returned slice and the buffers. Don't change them.
+func (a *ShardedArray) Buffers() []*Buffer {
+	return a.buffers
+}
+
+// String implements fmt.Stringer.
+func (a *ShardedArray) String() string {
+	if a == nil || a.spec == nil {
+		return "ShardedArray(nil)"
+	}
+	return fmt.Sprintf("ShardedArray(%s, %s, %s)", a.shape, a.spec.ToValueAttribute(a.shape), a.spec.Mesh)
+}
+
+// Reshard returns a new ShardedArray with the same values, sharded according to spec, on the same devices.
+// The ShardedArray a is not affected.
+//
+// It compiles and executes a small program that moves the data across devices, so it is relatively expensive:
+// avoid calling it in a tight loop, and consider instead changing the sharding of the outputs of your programs.
+//
+// The mesh of spec must have the same number of devices as the mesh of the array.
+func (a *ShardedArray) Reshard(spec *shardy.ShardingSpec) (*ShardedArray, error) {
+	if err := a.Check(); err != nil {
+		return nil, err
+	}
+	if spec == nil {
+		return nil, errors.New("ShardedArray.Reshard requires a non-nil ShardingSpec")
+	}
+	if _, err := spec.ShardShape(a.shape); err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard: invalid ShardingSpec for shape %s", a.shape)
+	}
+	if spec.Mesh.NumDevices() != a.NumDevices() {
+		return nil, errors.Errorf("ShardedArray.Reshard: the array is sharded across %d devices, but the mesh %s "+
+			"of the new spec has %d devices", a.NumDevices(), spec.Mesh, spec.Mesh.NumDevices())
+	}
+	meshes := []*shardy.DeviceMesh{a.spec.Mesh}
+	if spec.Mesh.ToStableHLO() != a.spec.Mesh.ToStableHLO() {
+		if spec.Mesh.Name() == a.spec.Mesh.Name() {
+			return nil, errors.Errorf("ShardedArray.Reshard: the mesh of the new spec is different from the mesh of the "+
+				"array, but they have the same name %q", spec.Mesh.Name())
+		}
+		meshes = append(meshes, spec.Mesh)
+	}
+
+	// Program that changes the sharding of its input.
+	builder := stablehlo.New("reshard").WithShardy(meshes...)
+	fn := builder.Main()
+	x, err := fn.NamedInputWithSharding("x", a.shape, a.spec)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard failed to build the resharding program")
+	}
+	if err = fn.ReturnWithShardingAndAttributes([]*stablehlo.Value{x}, []*shardy.ShardingSpec{spec}, nil); err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard failed to build the resharding program")
+	}
+	program, err := builder.Build()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard failed to build the resharding program")
+	}
+	client := a.buffers[0].Client()
+	exec, err := client.Compile().
+		WithStableHLO(program).
+		WithShardy(a.NumDevices()).
+		WithDeviceAssignment(a.deviceAssignment).
+		Done()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard failed to compile the resharding program")
+	}
+	defer exec.destroyOrLog()
+	outputs, err := exec.ExecuteSharded(a).DoneSharded(spec)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "ShardedArray.Reshard failed to execute the resharding program")
+	}
+	return outputs[0], nil
+}
+
+// deviceByID returns the addressable device with the given device ID (see DeviceDescription.ID).
+func (c *Client) deviceByID(id int) (*Device, error) {
+	for _, device := range c.addressableDevices {
+		desc, err := device.GetDescription()
+		if err != nil {
+			return nil, err
+		}
+		deviceID, err := desc.ID()
+		if err != nil {
+			return nil, err
+		}
+		if deviceID == id {
+			return device, nil
+		}
+	}
+	return nil, errors.Errorf("device with ID %d is not addressable by client %s", id, c)
+}
+
+// ExecuteSharded is like ExecutePerDevice, but the inputs are ShardedArrays: their buffers are fed to the
+// corresponding devices. The executable must have been compiled for the same devices as the inputs (see
+// CompileConfig.WithShardy and CompileConfig.WithDeviceAssignment).
+//
+// Use ExecutionConfig.DoneSharded to get the outputs as ShardedArrays.
+//
+// By default, the inputs are not donated. If they are donated (see ExecutionConfig.Donate, the index of the argument
+// argIdx on device deviceIdx is deviceIdx*len(inputs)+argIdx), the ShardedArrays are invalid after the execution.
+func (e *LoadedExecutable) ExecuteSharded(inputs ...*ShardedArray) *ExecutionConfig {
+	numReplicas, numPartitions, deviceAssignment, err := e.GetDeviceAssignment()
+	if err == nil {
+		for argIdx, input := range inputs {
+			err = input.Check()
+			if err != nil {
+				err = errors.WithMessagef(err, "LoadedExecutable.ExecuteSharded() input #%d", argIdx)
+				break
+			}
+			if input.NumDevices() != numReplicas*numPartitions {
+				err = errors.Errorf("LoadedExecutable.ExecuteSharded() input #%d is sharded across %d devices, but "+
+					"the executable runs on %d devices", argIdx, input.NumDevices(), numReplicas*numPartitions)
+				break
+			}
+			if deviceAssignment != nil && !slices.Equal(input.deviceAssignment, deviceAssignment) {
+				err = errors.Errorf("LoadedExecutable.ExecuteSharded() input #%d has device assignment %v, but the "+
+					"executable was compiled with the device assignment %v", argIdx, input.deviceAssignment, deviceAssignment)
+				break
+			}
+		}
+	}
+	if err != nil {
+		c := e.Execute()
+		c.err = err
+		return c
+	}
+	perDeviceInputs := make([][]*Buffer, numReplicas*numPartitions)
+	for deviceIdx := range perDeviceInputs {
+		perDeviceInputs[deviceIdx] = make([]*Buffer, len(inputs))
+		for argIdx, input := range inputs {
+			perDeviceInputs[deviceIdx][argIdx] = input.buffers[deviceIdx]
+		}
+	}
+	return e.ExecutePerDevice(perDeviceInputs)
+}
+
+// DoneSharded is like DonePerDevice, but it returns the outputs as ShardedArrays, one per output of the program,
+// sharded according to the given outputSpecs -- they must match the sharding of the outputs of the program.
+func (c *ExecutionConfig) DoneSharded(outputSpecs ...*shardy.ShardingSpec) ([]*ShardedArray, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	e := c.executable
+	if len(outputSpecs) != e.NumOutputs {
+		return nil, errors.Errorf("ExecutionConfig.DoneSharded() given %d output specs, but the program has %d outputs",
+			len(outputSpecs), e.NumOutputs)
+	}
+	perDeviceOutputs, err := c.DonePerDevice()
+	if err != nil {
+		return nil, err
+	}
+	outputs := make([]*ShardedArray, len(outputSpecs))
+	for outputIdx, spec := range outputSpecs {
+		buffers := make([]*Buffer, len(perDeviceOutputs))
+		for deviceIdx, deviceOutputs := range perDeviceOutputs {
+			buffers[deviceIdx] = deviceOutputs[outputIdx]
+		}
+		outputs[outputIdx], err = newShardedArrayFromShards(spec, e.deviceAssignment, buffers)
+		if err != nil {
+			for _, deviceOutputs := range perDeviceOutputs {
+				destroyBuffers(deviceOutputs)
+			}
+			return nil, errors.WithMessagef(err, "ExecutionConfig.DoneSharded() output #%d", outputIdx)
+		}
+	}
+	return outputs, nil
+}
+
+// newShardedArrayFromShards creates a ShardedArray from the buffers with the shards, inferring the global shape
+// from the shape of the shards and the spec.
+func newShardedArrayFromShards(spec *shardy.ShardingSpec, deviceAssignment []int, buffers []*Buffer) (*ShardedArray, error) {
+	if spec == nil {
+		return nil, errors.New("nil ShardingSpec")
+	}
+	if len(buffers) == 0 {
+		return nil, errors.New("no buffers given")
+	}
+	dtype, err := buffers[0].DType()
+	if err != nil {
+		return nil, err
+	}
+	dims, err := buffers[0].Dimensions()
+	if err != nil {
+		return nil, err
+	}
+	numShards, err := spec.NumShards(len(dims))
+	if err != nil {
+		return nil, err
+	}
+	globalShape := shapes.Make(dtype, dims...)
+	for axis, n := range numShards {
+		globalShape.Dimensions[axis] *= n
+	}
+	return NewShardedArray(spec, globalShape, deviceAssignment, buffers)
+}
@@ -0,0 +1,339 @@
+package pjrt
+
+/*
+#include "pjrt_c_api.h"
+#include "gen_api_calls.h"
+#include "gen_new_struct.h"
+
+// call_PJRT_SerializedTopology_Deleter calls the deleter returned by PJRT_TopologyDescription_Serialize.
+static void call_PJRT_SerializedTopology_Deleter(void (*deleter)(PJRT_SerializedTopology*), PJRT_SerializedTopology* serialized) {
+	if (deleter != NULL) {
+		deleter(serialized);
+	}
+}
+*/
+import "C"
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"k8s.io/klog/v2"
+)
+
+// TopologyDescription describes the devices of a platform (e.g. a TPU pod slice or a set of GPUs), without requiring
+// the devices to be present.
+//
+// It can be used to compile programs ahead-of-time (see Plugin.CompileForTopology), and it can be serialized
+// (see TopologyDescription.Serialize) to be shipped to the machine where the compilation happens.
+//
+// Create it with Client.TopologyDescription (the topology of a live client), Plugin.NewTopologyDescription or
+// Plugin.DeserializeTopologyDescription.
+type TopologyDescription struct {
+	plugin  *Plugin
+	wrapper *topologyC
+
+	// client, if set, is the owner of the topology: it is kept alive while the topology is in use.
+	client *Client
+}
+
+// topologyC wraps the C pointer, so we can use runtime.AddCleanup.
+type topologyC struct {
+	c      *C.PJRT_TopologyDescription
+	plugin *Plugin
+
+	// owned is true if the topology must be destroyed -- it is false for topologies owned by a client.
+	owned bool
+}
+
+func (wrapper *topologyC) Destroy() error {
+	if wrapper == nil || wrapper.plugin == nil || wrapper.plugin.api == nil || wrapper.c == nil {
+		// Already destroyed, no-op.
+		return nil
+	}
+	defer runtime.KeepAlive(wrapper)
+	var err error
+	if wrapper.owned {
+		args := C.new_PJRT_TopologyDescription_Destroy_Args()
+		defer cFree(args)
+		args.topology = wrapper.c
+		err = toError(wrapper.plugin, C.call_PJRT_TopologyDescription_Destroy(wrapper.plugin.api, args))
+	}
+	wrapper.plugin = nil
+	wrapper.c = nil
+	return err
+}
+
+// newTopologyDescription creates a TopologyDescription and, if it is owned, registers it for freeing.
+func newTopologyDescription(plugin *Plugin, client *Client, cTopology *C.PJRT_TopologyDescription) *TopologyDescription {
+	t := &TopologyDescription{
+		plugin:  plugin,
+		client:  client,
+		wrapper: &topologyC{c: cTopology, plugin: plugin, owned: client == nil},
+	}
+	if client == nil {
+		runtime.AddCleanup(t, func(wrapper *topologyC) {
+			err := wrapper.Destroy()
+			if err != nil {
+				klog.Errorf("Failed to destroy pjrt.TopologyDescription: %+v", err)
+			}
+		}, t.wrapper)
+	}
+	return t
+}
+
+// NewTopologyDescription creates the description of a topology of the given name, without requiring any device.
+//
+// The topology names and the options are platform-specific (e.g. TPU plugins accept names like "v5e:2x2"), and
+// not all plugins support it: use Client.TopologyDescription to get the topology of a live client instead.
+func (p *Plugin) NewTopologyDescription(name string, options NamedValuesMap) (*TopologyDescription, error) {
+	if p.api.PJRT_TopologyDescription_Create == nil {
+		return nil, errors.Errorf("plugin %s doesn't support creating topologies", p)
+	}
+	args := C.new_PJRT_TopologyDescription_Create_Args()
+	defer cFree(args)
+	cName := C.CString(name)
+	defer cFree(cName)
+	args.topology_name = cName
+	args.topology_name_size = C.size_t(len(name))
+	var err error
+	args.create_options, args.num_options, err = options.mallocArrayPJRT_NamedValue()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "invalid options when creating a new pjrt.TopologyDescription")
+	}
+	defer destroyPJRT_NamedValue(args.create_options, args.num_options)
+	err = toError(p, C.call_PJRT_TopologyDescription_Create(p.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to create topology %q for plugin %s", name, p)
+	}
+	return newTopologyDescription(p, nil, args.topology), nil
+}
+
+// DeserializeTopologyDescription re-creates a topology serialized with TopologyDescription.Serialize.
+// It must be serialized by the same platform and plugin version.
+func (p *Plugin) DeserializeTopologyDescription(serialized []byte) (*TopologyDescription, error) {
+	if len(serialized) == 0 {
+		return nil, errors.New("DeserializeTopologyDescription given empty serialized topology")
+	}
+	if p.api.PJRT_TopologyDescription_Deserialize == nil {
+		return nil, errors.Errorf("plugin %s doesn't support deserializing topologies", p)
+	}
+	args := C.new_PJRT_TopologyDescription_Deserialize_Args()
+	defer cFree(args)
+	args.serialized_topology = (*C.char)(C.CBytes(serialized))
+	defer cFree(args.serialized_topology)
+	args.serialized_topology_size = C.size_t(len(serialized))
+	err := toError(p, C.call_PJRT_TopologyDescription_Deserialize(p.api, args))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to deserialize topology for plugin %s", p)
+	}
+	return newTopologyDescription(p, nil, args.topology), nil
+}
+
+// TopologyDescription returns the runtime topology of the client's devices.
+// The topology is owned by the client.
+func (c *Client) TopologyDescription() (*TopologyDescription, error) {
+	if !c.IsValid() {
+		return nil, errors.New("client is nil or it has been destroyed already")
+	}
+	args := C.new_PJRT_Client_TopologyDescription_Args()
+	defer cFree(args)
+	args.client = c.client.c
+	err := toError(c.plugin, C.call_PJRT_Client_TopologyDescription(c.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	return newTopologyDescription(c.plugin, c, args.topology), nil
+}
+
+// IsValid returns whether the topology is valid: not nil and not destroyed.
+func (t *TopologyDescription) IsValid() bool {
+	return t != nil && t.wrapper != nil && t.wrapper.c != nil && t.plugin != nil
+}
+
+// checkValid returns an error if the topology is not valid.
+func (t *TopologyDescription) checkValid() error {
+	if !t.IsValid() {
+		return errors.New("TopologyDescription is nil, or it has been destroyed already")
+	}
+	return nil
+}
+
+// Destroy the TopologyDescription, releasing its resources.
+// This is automatically called if TopologyDescription is garbage collected.
+// It is a no-op for topologies owned by a client (see Client.TopologyDescription).
+func (t *TopologyDescription) Destroy() error {
+	if !t.IsValid() {
+		return nil
+	}
+	err := t.wrapper.Destroy()
+	t.wrapper = nil
+	t.client = nil
+	return err
+}
+
+// PlatformName returns the name of the platform of the topology (e.g. "cpu", "cuda" or "tpu").
+func (t *TopologyDescription) PlatformName() (string, error) {
+	if err := t.checkValid(); err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_PlatformName_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_PlatformName(t.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.platform_name, args.platform_name_size), nil
+}
+
+// PlatformVersion returns human-readable, platform-specific version information (e.g. the CUDA version).
+func (t *TopologyDescription) PlatformVersion() (string, error) {
+	if err := t.checkValid(); err != nil {
+		return "", err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_PlatformVersion_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_PlatformVersion(t.plugin.api, args))
+	if err != nil {
+		return "", err
+	}
+	return cCharArray(args.platform_version, args.platform_version_size), nil
+}
+
+// DeviceDescriptions returns the descriptions of all devices in the topology.
+// The descriptions are owned by the topology, and are only valid while the topology is alive.
+func (t *TopologyDescription) DeviceDescriptions() ([]*DeviceDescription, error) {
+	if err := t.checkValid(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_GetDeviceDescriptions_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_GetDeviceDescriptions(t.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	cDescriptions := cDataToSlice[*C.PJRT_DeviceDescription](
+		unsafe.Pointer(args.descriptions), int(args.num_descriptions))
+	descriptions := make([]*DeviceDescription, len(cDescriptions))
+	for i, cDesc := range cDescriptions {
+		descriptions[i] = newDeviceDescription(t.plugin, cDesc)
+	}
+	return descriptions, nil
+}
+
+// NumDevices returns the number of devices in the topology.
+func (t *TopologyDescription) NumDevices() (int, error) {
+	descriptions, err := t.DeviceDescriptions()
+	if err != nil {
+		return 0, err
+	}
+	return len(descriptions), nil
+}
+
+// DeviceKinds returns the number of devices of each kind (see DeviceDescription.Kind) in the topology.
+func (t *TopologyDescription) DeviceKinds() (map[string]int, error) {
+	descriptions, err := t.DeviceDescriptions()
+	if err != nil {
+		return nil, err
+	}
+	kinds := make(map[string]int)
+	for _, desc := range descriptions {
+		kinds[desc.Kind()]++
+	}
+	return kinds, nil
+}
+
+// deviceIDs returns the IDs of the devices in the topology, sorted.
+func (t *TopologyDescription) deviceIDs() ([]int, error) {
+	descriptions, err := t.DeviceDescriptions()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(descriptions))
+	for i, desc := range descriptions {
+		ids[i], err = desc.ID()
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// Attributes returns the platform-specific attributes of the topology.
+func (t *TopologyDescription) Attributes() (NamedValuesMap, error) {
+	if err := t.checkValid(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_Attributes_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_Attributes(t.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	namedValues := cDataToSlice[C.PJRT_NamedValue](unsafe.Pointer(args.attributes), int(args.num_attributes))
+	return pjrtNamedValuesToMap(namedValues), nil
+}
+
+// Fingerprint returns a hash of the topology, that can be used as part of a cache key.
+func (t *TopologyDescription) Fingerprint() (uint64, error) {
+	if err := t.checkValid(); err != nil {
+		return 0, err
+	}
+	defer runtime.KeepAlive(t)
+	args := C.new_PJRT_TopologyDescription_Fingerprint_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_Fingerprint(t.plugin.api, args))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(args.fingerprint), nil
+}
+
+// Serialize the topology, so it can be re-created with Plugin.DeserializeTopologyDescription.
+func (t *TopologyDescription) Serialize() ([]byte, error) {
+	if err := t.checkValid(); err != nil {
+		return nil, err
+	}
+	defer runtime.KeepAlive(t)
+	if t.plugin.api.PJRT_TopologyDescription_Serialize == nil {
+		return nil, errors.Errorf("plugin %s doesn't support serializing topologies", t.plugin)
+	}
+	args := C.new_PJRT_TopologyDescription_Serialize_Args()
+	defer cFree(args)
+	args.topology = t.wrapper.c
+	err := toError(t.plugin, C.call_PJRT_TopologyDescription_Serialize(t.plugin.api, args))
+	if err != nil {
+		return nil, err
+	}
+	serialized := C.GoBytes(unsafe.Pointer(args.serialized_bytes), C.int(args.serialized_bytes_size))
+	C.call_PJRT_SerializedTopology_Deleter(args.serialized_topology_deleter, args.serialized_topology)
+	return serialized, nil
+}
+
+// String implements fmt.Stringer.
+func (t *TopologyDescription) String() string {
+	if !t.IsValid() {
+		return "TopologyDescription(invalid)"
+	}
+	platform, err := t.PlatformName()
+	if err != nil {
+		return fmt.Sprintf("TopologyDescription(<failed to get platform name: %v>)", err)
+	}
+	numDevices, err := t.NumDevices()
+	if err != nil {
+		return fmt.Sprintf("TopologyDescription(%s, <failed to get devices: %v>)", platform, err)
+	}
+	return fmt.Sprintf("TopologyDescription(%s, %d devices)", platform, numDevices)
+}
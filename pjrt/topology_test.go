@@ -0,0 +1,102 @@
+package pjrt
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/stablehlo"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestTopologyDescription(t *testing.T) {
+	client := getPJRTClient(t)
+	topology, err := client.TopologyDescription()
+	requireNoError(t, err)
+	fmt.Printf("\t%s\n", topology)
+
+	platform, err := topology.PlatformName()
+	requireNoError(t, err)
+	assertTrue(t, platform != "", "empty platform name")
+	numDevices, err := topology.NumDevices()
+	requireNoError(t, err)
+	assertTrue(t, numDevices >= client.NumDevices(), "topology has %d devices, client has %d addressable devices",
+		numDevices, client.NumDevices())
+	kinds, err := topology.DeviceKinds()
+	requireNoError(t, err)
+	countKinds := 0
+	for kind, count := range kinds {
+		fmt.Printf("\t- %d devices of kind %q\n", count, kind)
+		countKinds += count
+	}
+	assertEqual(t, numDevices, countKinds)
+
+	// Serialization round-trip, if supported by the plugin.
+	serialized, err := topology.Serialize()
+	if err != nil {
+		t.Logf("TopologyDescription.Serialize not supported: %v", err)
+	} else {
+		restored, err := client.Plugin().DeserializeTopologyDescription(serialized)
+		if err != nil {
+			t.Logf("Plugin.DeserializeTopologyDescription not supported: %v", err)
+		} else {
+			restoredNumDevices, err := restored.NumDevices()
+			requireNoError(t, err)
+			assertEqual(t, numDevices, restoredNumDevices)
+			requireNoError(t, restored.Destroy())
+		}
+	}
+
+	// Topologies owned by the client are not destroyed.
+	requireNoError(t, topology.Destroy())
+	assertFalse(t, topology.IsValid())
+	requireNoError(t, client.Destroy())
+}
+
+func TestCompileForTopology(t *testing.T) {
+	client := getPJRTClient(t)
+
+	// f(x) = 2*x + 1
+	builder := stablehlo.New(t.Name())
+	fn := builder.Main()
+	x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 3)))
+	two := must1(fn.ConstantFromScalar(float32(2)))
+	one := must1(fn.ConstantFromScalar(float32(1)))
+	y := must1(stablehlo.Multiply(x, must1(stablehlo.BroadcastInDim(two, x.Shape(), nil))))
+	y = must1(stablehlo.Add(y, must1(stablehlo.BroadcastInDim(one, x.Shape(), nil))))
+	must(fn.Return(y))
+	program := must1(builder.Build())
+
+	// Compile ahead-of-time, without using the client.
+	topology, err := client.TopologyDescription()
+	requireNoError(t, err)
+	serialized, err := client.Plugin().CompileForTopology(topology).WithStableHLO(program).DoneSerialized()
+	requireNoError(t, err, "failed to compile for topology %s", topology)
+	assertNotEmpty(t, serialized)
+	fmt.Printf("\tserialized executable: %d bytes\n", len(serialized))
+
+	// Load and execute.
+	exec, err := client.LoadSerializedExecutable(serialized)
+	requireNoError(t, err)
+	assertTrue(t, exec.IsPortable(), "executable compiled without device assignment should be portable")
+	flat, dims := execWithSlices(t, client, exec, []float32{1, 2, 3})
+	assertEqualSlice(t, []int{3}, dims)
+	assertEqualSlice(t, []float32{3, 5, 7}, flat)
+
+	// Round-trip of a LoadedExecutable.
+	serialized, err = exec.Serialize()
+	requireNoError(t, err)
+	requireNoError(t, exec.Destroy())
+	exec, err = client.LoadSerializedExecutable(serialized)
+	requireNoError(t, err)
+	flat, _ = execWithSlices(t, client, exec, []float32{-1, 0, 1})
+	assertEqualSlice(t, []float32{-1, 1, 3}, flat)
+	requireNoError(t, exec.Destroy())
+
+	// CompileForTopology configs can't be used with Done, and Client.Compile configs can't be used with DoneSerialized.
+	_, err = client.Plugin().CompileForTopology(topology).WithStableHLO(program).Done()
+	requireError(t, err)
+	_, err = client.Compile().WithStableHLO(program).DoneSerialized()
+	requireError(t, err)
+	requireNoError(t, client.Destroy())
+}
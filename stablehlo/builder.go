@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"io"
 	"slices"
+	"strings"
 
 	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/gomlx/go-xla/types/shardy"
 	"github.com/pkg/errors"
 )
@@ -36,6 +38,13 @@ type Builder struct {
 	// nextChannelID is the next ID to be assigned in channel handles.
 	// It is just a Unique ID.
 	nextChannelID int
+
+	// shardingGroupsDims holds the dimensions of the values of each sharding group, indexed by the group ID.
+	// It is nil for groups without values yet.
+	shardingGroupsDims [][]int
+
+	// lowerQuantization is set by WithQuantizationLowering.
+	lowerQuantization bool
 }
 
 // New creates a new Builder object holding a computation graph in construction.
@@ -195,6 +204,11 @@ func (b *Builder) Build() ([]byte, error) {
 	if !hasMain {
 		return nil, errors.New("program must have a main function")
 	}
+	if b.lowerQuantization {
+		if err := b.LowerQuantization(); err != nil {
+			return nil, err
+		}
+	}
 
 	var buf bytes.Buffer
 	err := b.Write(&buf)
@@ -299,3 +313,17 @@ func (b *Builder) NewShardingSpecByMeshIx(meshIdx int) *shardy.ShardingSpec {
 	}
 	return shardy.NewShardingSpec(b.meshes[meshIdx])
 }
+
+// validateShardingSpec checks that the shardingSpec uses one of the meshes configured with WithShardy, and that
+// it is valid for the given shape.
+func (b *Builder) validateShardingSpec(shardingSpec *shardy.ShardingSpec, shape shapes.Shape) error {
+	if slices.Index(b.meshes, shardingSpec.Mesh) == -1 {
+		meshesNames := make([]string, 0, len(b.meshes))
+		for _, mesh := range b.meshes {
+			meshesNames = append(meshesNames, mesh.Name())
+		}
+		return errors.Errorf("sharding spec meshe %q doesn't match any of the stablehlo.Builder meshes (%s)",
+			shardingSpec.Mesh, strings.Join(meshesNames, ", "))
+	}
+	return shardingSpec.ValidateShape(shape)
+}
@@ -0,0 +1,149 @@
+package stablehlo
+
+import (
+	"maps"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// This file implements the cloning of statements from one function into another, and other helpers used by
+// program transformations (e.g. VJP).
+
+// valueRef returns a value usable in fn that refers to v.
+//
+// If v belongs to an ancestor of fn, it returns a reference to it (see Function.UseParentValue).
+func (fn *Function) valueRef(v *Value) (*Value, error) {
+	if v.fn == fn {
+		return v, nil
+	}
+	if !isAncestor(v.fn, fn) {
+		return nil, errors.Errorf("value %s of function %q is not visible in function %q", v, v.fn.Name, fn.Name)
+	}
+	return &Value{
+		fn:    fn,
+		name:  v.name,
+		shape: v.shape,
+	}, nil
+}
+
+// cloneStatement re-creates stmt in fn.
+//
+// The values map the names of the values used by stmt (in its original function) to the values in fn (or
+// in one of its ancestors). The outputs of the new statement are added to values, under the names of the
+// original outputs.
+//
+// Closures used by stmt are cloned as closures of fn. If stmt is a return statement, fn returns the
+// corresponding values, and it returns a nil statement.
+func (fn *Function) cloneStatement(stmt *Statement, values map[string]*Value) (*Statement, error) {
+	inputs := make([]*Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		mapped, found := values[input.name]
+		if !found {
+			return nil, errors.Errorf("value %s used by %s is not mapped while cloning it into function %q",
+				input, stmt.OpType, fn.Name)
+		}
+		var err error
+		inputs[i], err = fn.valueRef(mapped)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if stmt.OpType == optypes.FuncReturn {
+		var attributes []map[string]any
+		for i, output := range stmt.Function.Outputs {
+			if output.Attributes != nil {
+				if attributes == nil {
+					attributes = make([]map[string]any, len(stmt.Function.Outputs))
+				}
+				attributes[i] = output.Attributes
+			}
+		}
+		return nil, fn.ReturnWithAttributes(inputs, attributes)
+	}
+
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q", stmt.OpType, fn.Name)
+	}
+	newStmt := fn.addMultiOp(stmt.OpType, valuesToShapes(stmt.Outputs), inputs)
+	newStmt.Attributes = maps.Clone(stmt.Attributes)
+	newStmt.params = stmt.params
+	for i, output := range stmt.Outputs {
+		newStmt.Outputs[i].Attributes = maps.Clone(output.Attributes)
+		newStmt.Outputs[i].sharding = output.sharding
+		values[output.name] = newStmt.Outputs[i]
+	}
+	for i, closure := range stmt.FunctionParameters {
+		newClosure, err := fn.cloneClosure(closure, values)
+		if err != nil {
+			return nil, err
+		}
+		newStmt.AddFunctionParameter(stmt.FunctionParametersNames[i], newClosure)
+	}
+	return newStmt, nil
+}
+
+// cloneClosure creates a new closure of fn with a copy of the closure src.
+// See cloneStatement for the meaning of values.
+func (fn *Function) cloneClosure(src *Function, values map[string]*Value) (*Function, error) {
+	closure := fn.Closure()
+	closure.isManualComputationBody = src.isManualComputationBody
+	for _, input := range src.Inputs {
+		newInput, err := closure.InputWithAttributes(input.shape, maps.Clone(input.Attributes))
+		if err != nil {
+			return nil, err
+		}
+		values[input.name] = newInput
+	}
+	for _, stmt := range src.Statements {
+		if _, err := closure.cloneStatement(stmt, values); err != nil {
+			return nil, err
+		}
+	}
+	return closure, nil
+}
+
+// transformError is used to pass errors (with panic) while building the function of a program
+// transformation, see transformMust.
+type transformError struct {
+	err error
+}
+
+// transformMust panics with a transformError if err != nil, otherwise it returns the value.
+// The panic is recovered with Builder.recoverTransform and converted back to an error.
+func transformMust[T any](value T, err error) T {
+	if err != nil {
+		panic(transformError{err: err})
+	}
+	return value
+}
+
+// recoverTransform converts the value recovered from a panic while building a transformed function back to an
+// error, and removes from the builder the (partially built) functions created since it had numFunctions functions.
+//
+// Panics that are not a transformError are re-raised.
+func (b *Builder) recoverTransform(recovered any, numFunctions int) error {
+	tErr, ok := recovered.(transformError)
+	if !ok {
+		panic(recovered)
+	}
+	b.functions = b.functions[:numFunctions]
+	return tErr.err
+}
+
+// scalarBinaryClosure returns a new closure of fn that applies op to two scalars of the given dtype.
+// If op is FuncReturn, the closure returns its second operand.
+func scalarBinaryClosure(fn *Function, op optypes.OpType, dtype dtypes.DType) *Function {
+	closure := fn.Closure()
+	lhs := transformMust(closure.Input(shapes.Make(dtype)))
+	rhs := transformMust(closure.Input(shapes.Make(dtype)))
+	result := rhs
+	if op != optypes.FuncReturn {
+		result = transformMust(closure.binaryOp(op, lhs, rhs))
+	}
+	transformMust[any](nil, closure.Return(result))
+	return closure
+}
@@ -1,10 +1,13 @@
 package stablehlo
 
 import (
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 
 	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/ffi"
 	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/pkg/errors"
 )
@@ -119,3 +122,184 @@ func CustomCallV2(
 	stmt.Attributes = attrs
 	return stmt.Outputs, nil
 }
+
+// CustomCallAPIVersionTypedFFI is XLA custom-call API version 4: the custom-call target is an XLA FFI handler,
+// and its attributes are given as a dictionary in the backend_config.
+const CustomCallAPIVersionTypedFFI = 4
+
+// CustomCallFFI emits a stablehlo.custom_call (API version 4 = TYPED_FFI) to the XLA FFI handler registered with
+// the given target name (e.g. Go handlers registered with pjrt.Plugin.RegisterFFIHandler).
+//
+//   - attributes: given to the handler (see ffi.CallFrame.Attributes). Values can be strings, bool, Go integer
+//     and float scalars, slices of them, or nested map[string]any dictionaries. Nil omits the attributes.
+//   - outputShapes: one shape per result, it can be empty.
+//
+// Returns one output Value per outputShape, in order.
+func CustomCallFFI(target string, operands []*Value, outputShapes []shapes.Shape, attributes map[string]any) (
+	[]*Value, error) {
+	op := optypes.CustomCall
+	if len(operands) == 0 {
+		return nil, errors.Errorf("%s requires at least one operand", op)
+	}
+	fn, err := innerMostFunction(operands...)
+	if err != nil {
+		return nil, err
+	}
+	return fn.customCallFFI(target, operands, outputShapes, attributes, false)
+}
+
+// HostCallback emits to fn a call to the Go function callback during the execution of the program: it receives
+// the operands as inputs (there can be none) and must fill the outputs with the given outputShapes
+// (see ffi.CallFrame).
+//
+// The callback is registered with ffi.RegisterHostCallback, and called through the ffi.HostCallbackTarget FFI
+// handler, which the PJRT bindings register for plugins that support FFI (e.g. the CPU plugin). The call is marked
+// as having side effects, so it is not removed even if its outputs are not used (e.g. for logging).
+//
+// It returns the unregister function, to be called once the programs using the callback are no longer executed:
+// until then the callback (and anything it references) is kept alive.
+//
+// The program refers to the callback by an ID (the ffi.HostCallbackIDAttribute) that is only valid in the current
+// process: programs with host callbacks can't be serialized to be loaded in another process (e.g. ahead-of-time
+// compilation or a compilation cache).
+//
+// Returns one output Value per outputShape, in order.
+func HostCallback(fn *Function, callback ffi.Handler, operands []*Value, outputShapes []shapes.Shape) (
+	outputs []*Value, unregister func(), err error) {
+	if fn == nil {
+		return nil, nil, errors.New("HostCallback requires a non-nil function")
+	}
+	if callback == nil {
+		return nil, nil, errors.New("HostCallback requires a non-nil callback")
+	}
+	for i, operand := range operands {
+		if !isAncestor(operand.fn, fn) {
+			return nil, nil, errors.Errorf("HostCallback operand #%d is from function %q, which is not function %q "+
+				"nor one of its parents", i, operand.fn.Name, fn.Name)
+		}
+	}
+	id := ffi.RegisterHostCallback(callback)
+	outputs, err = fn.customCallFFI(ffi.HostCallbackTarget, operands, outputShapes,
+		map[string]any{ffi.HostCallbackIDAttribute: id}, true)
+	if err != nil {
+		ffi.UnregisterHostCallback(id)
+		return nil, nil, err
+	}
+	return outputs, func() { ffi.UnregisterHostCallback(id) }, nil
+}
+
+// customCallFFI implements CustomCallFFI and HostCallback.
+func (fn *Function) customCallFFI(target string, operands []*Value, outputShapes []shapes.Shape,
+	attributes map[string]any, hasSideEffect bool) ([]*Value, error) {
+	op := optypes.CustomCall
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q", op, fn.Name)
+	}
+	for i, shape := range outputShapes {
+		if !shape.Ok() || shape.IsTuple() {
+			return nil, errors.Errorf("%s to %q: invalid output shape #%d %s", op, target, i, shape)
+		}
+	}
+	var backendConfig literalStr
+	if attributes != nil {
+		var err error
+		backendConfig, err = ffiAttributesToStableHLO(attributes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "%s to %q", op, target)
+		}
+	}
+
+	stmt := fn.addMultiOp(op, outputShapes, operands)
+	stmt.Attributes = map[string]any{
+		"call_target_name": target,
+		"api_version":      int32(CustomCallAPIVersionTypedFFI),
+	}
+	if backendConfig != "" {
+		stmt.Attributes["backend_config"] = backendConfig
+	}
+	if hasSideEffect {
+		stmt.Attributes["has_side_effect"] = true
+	}
+	return stmt.Outputs, nil
+}
+
+// ffiAttributesToStableHLO renders the attributes of an FFI custom call as a dictionary attribute, e.g.
+// `{n = 3 : i64, name = "x", sizes = array<i64: 1, 2>}`.
+func ffiAttributesToStableHLO(attributes map[string]any) (literalStr, error) {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	keys := slices.Collect(maps.Keys(attributes))
+	slices.Sort(keys)
+	for i, key := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		if isBareIdentifier(key) {
+			sb.WriteString(key)
+		} else {
+			sb.WriteString(strconv.Quote(key))
+		}
+		sb.WriteString(" = ")
+		switch v := attributes[key].(type) {
+		case string, bool, float32, float64, int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+			sb.WriteString(literalToStableHLO(v))
+		case map[string]any:
+			dict, err := ffiAttributesToStableHLO(v)
+			if err != nil {
+				return "", errors.WithMessagef(err, "attribute %q", key)
+			}
+			sb.WriteString(string(dict))
+		case []bool:
+			writeFFIArray(&sb, "i1", v)
+		case []int8:
+			writeFFIArray(&sb, "i8", v)
+		case []int16:
+			writeFFIArray(&sb, "i16", v)
+		case []int32:
+			writeFFIArray(&sb, "i32", v)
+		case []int64:
+			writeFFIArray(&sb, "i64", v)
+		case []int:
+			writeFFIArray(&sb, "i64", v)
+		case []float32:
+			writeFFIArray(&sb, "f32", v)
+		case []float64:
+			writeFFIArray(&sb, "f64", v)
+		default:
+			return "", errors.Errorf("attribute %q has unsupported type %T", key, v)
+		}
+	}
+	sb.WriteByte('}')
+	return literalStr(sb.String()), nil
+}
+
+// writeFFIArray writes a dense array attribute, e.g. `array<i64: 1, 2>`.
+func writeFFIArray[T any](sb *strings.Builder, elementType string, values []T) {
+	sb.WriteString("array<")
+	sb.WriteString(elementType)
+	for i, v := range values {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(podToStableHLO(v))
+	}
+	sb.WriteByte('>')
+}
+
+// isBareIdentifier returns whether name can be used unquoted as a dictionary attribute key.
+func isBareIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case i > 0 && (r == '$' || r == '.' || (r >= '0' && r <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
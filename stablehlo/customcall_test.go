@@ -5,7 +5,9 @@ import (
 	"testing"
 
 	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/ffi"
 	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRenderLayouts(t *testing.T) {
@@ -94,3 +96,84 @@ func TestCustomCallFMHA(t *testing.T) {
 		}
 	}
 }
+
+func TestCustomCallFFI(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	x := must1(fn.Input(shapes.Make(dtypes.Float32, 3)))
+	results, err := CustomCallFFI("my_handler", []*Value{x}, []shapes.Shape{x.Shape()}, map[string]any{
+		"n":       int64(3),
+		"name":    "lookup",
+		"scale":   float32(0.5),
+		"enabled": true,
+		"sizes":   []int64{1, 2},
+		"nested":  map[string]any{"k": int32(7)},
+		"my-key":  []float64{0.25},
+	})
+	if err != nil {
+		t.Fatalf("CustomCallFFI: %+v", err)
+	}
+	require.NoError(t, fn.Return(results[0]))
+	program := string(must1(b.Build()))
+	t.Logf("program:\n%s", program)
+	for _, want := range []string{
+		`call_target_name = "my_handler"`,
+		`api_version = 4 : i32`,
+		`backend_config = {enabled = true, "my-key" = array<f64: 0.25>, n = 3 : i64, name = "lookup", ` +
+			`nested = {k = 7 : i32}, scale = 0.5 : f32, sizes = array<i64: 1, 2>}`,
+	} {
+		if !strings.Contains(program, want) {
+			t.Errorf("rendered StableHLO missing %q", want)
+		}
+	}
+	if strings.Contains(program, "has_side_effect") {
+		t.Errorf("CustomCallFFI should not have side effects")
+	}
+
+	// Unsupported attribute types.
+	_, err = CustomCallFFI("my_handler", []*Value{x}, nil, map[string]any{"x": []string{"a"}})
+	if err == nil {
+		t.Errorf("CustomCallFFI should fail for unsupported attribute types")
+	}
+}
+
+func TestHostCallback(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	x := must1(fn.Input(shapes.Make(dtypes.Float32, 3)))
+	results, unregister, err := HostCallback(fn, func(call *ffi.CallFrame) error { return nil },
+		[]*Value{x}, []shapes.Shape{x.Shape()})
+	if err != nil {
+		t.Fatalf("HostCallback: %+v", err)
+	}
+	defer unregister()
+
+	// Callbacks without operands, e.g. to generate values on the host.
+	generated, unregisterGenerator, err := HostCallback(fn, func(call *ffi.CallFrame) error { return nil },
+		nil, []shapes.Shape{x.Shape()})
+	if err != nil {
+		t.Fatalf("HostCallback without operands: %+v", err)
+	}
+	defer unregisterGenerator()
+	require.NoError(t, fn.Return(must1(Add(results[0], generated[0]))))
+	program := string(must1(b.Build()))
+	t.Logf("program:\n%s", program)
+	for _, want := range []string{
+		`call_target_name = "` + ffi.HostCallbackTarget + `"`,
+		`api_version = 4 : i32`,
+		`backend_config = {callback_id = `,
+		`has_side_effect = true`,
+	} {
+		if !strings.Contains(program, want) {
+			t.Errorf("rendered StableHLO missing %q", want)
+		}
+	}
+
+	fn2 := b.NewFunction("other")
+	if _, _, err := HostCallback(fn2, func(call *ffi.CallFrame) error { return nil }, []*Value{x}, nil); err == nil {
+		t.Errorf("HostCallback with operands from another function should fail")
+	}
+	if _, _, err := HostCallback(fn2, nil, nil, nil); err == nil {
+		t.Errorf("HostCallback with a nil callback should fail")
+	}
+}
@@ -0,0 +1,240 @@
+package stablehlo
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/pkg/errors"
+)
+
+// DOTOptions configures the Graphviz DOT output of Builder.WriteDOTWithOptions.
+type DOTOptions struct {
+	// CollapseScopes renders each closure (the nested scopes used as bodies of While, If, Reduce, Sort, etc.)
+	// as part of the single node of the statement that owns it, instead of expanding it as a nested cluster.
+	//
+	// This makes large programs with many loops or reductions much easier to read.
+	CollapseScopes bool
+
+	// RankDir is the Graphviz "rankdir" attribute of the graph. If empty, it defaults to "TB" (top to bottom).
+	RankDir string
+}
+
+// WriteDOT writes the program as a Graphviz DOT graph to the given writer.
+//
+// Each function is rendered as a cluster, with one node per input parameter and one node per operation, showing
+// the operation name and its output shapes. Edges connect the values produced by one operation to the
+// operations that use them.
+// Closures (the bodies of While, If, Reduce, etc.) are rendered as clusters nested in the function that
+// uses them.
+//
+// Like Builder.Write, it writes incomplete programs without an error, to help debugging.
+//
+// The output can be rendered with, for instance, `dot -Tsvg program.dot -o program.svg`.
+//
+// See WriteDOTWithOptions to configure the output.
+func (b *Builder) WriteDOT(writer io.Writer) error {
+	return b.WriteDOTWithOptions(writer, DOTOptions{})
+}
+
+// WriteDOTWithOptions writes the program as a Graphviz DOT graph to the given writer, configured by options.
+//
+// See WriteDOT for details.
+func (b *Builder) WriteDOTWithOptions(writer io.Writer, options DOTOptions) error {
+	dw := &dotWriter{
+		writer:     writer,
+		options:    options,
+		stmtNodes:  make(map[*Statement]string),
+		valueNodes: make(map[*Function]map[string]string),
+	}
+	rankDir := options.RankDir
+	if rankDir == "" {
+		rankDir = "TB"
+	}
+	dw.w("digraph %s {\n", dotQuote(b.name))
+	dw.w("%scompound=true;\n", IndentationStep)
+	dw.w("%srankdir=%s;\n", IndentationStep, rankDir)
+	dw.w("%snode [shape=box, style=rounded, fontname=\"monospace\", fontsize=10];\n", IndentationStep)
+	dw.w("%sedge [fontname=\"monospace\", fontsize=8];\n", IndentationStep)
+	for _, fn := range b.functions {
+		if fn.Parent != nil {
+			continue
+		}
+		dw.writeFunction(fn, "", IndentationStep)
+	}
+	dw.w("}\n")
+	return dw.err
+}
+
+// dotWriter holds the state of a DOT graph being written.
+type dotWriter struct {
+	writer  io.Writer
+	options DOTOptions
+	err     error
+
+	// nextNodeID is used to generate unique node ids.
+	nextNodeID int
+
+	// stmtNodes maps statements to their node ids.
+	stmtNodes map[*Statement]string
+
+	// valueNodes maps, for each root function, the values names to the node ids that produce them.
+	// Value names are unique within a root function and its closures.
+	valueNodes map[*Function]map[string]string
+}
+
+// w writes to the underlying writer, and it is a no-op if an error was encountered earlier.
+func (dw *dotWriter) w(format string, args ...any) {
+	if dw.err != nil {
+		return
+	}
+	_, dw.err = fmt.Fprintf(dw.writer, format, args...)
+}
+
+// newNodeID returns a new unique node id.
+func (dw *dotWriter) newNodeID(prefix string) string {
+	id := fmt.Sprintf("%s%d", prefix, dw.nextNodeID)
+	dw.nextNodeID++
+	return id
+}
+
+// registerValue records that the value name is produced by the given node id.
+func (dw *dotWriter) registerValue(fn *Function, name, nodeID string) {
+	rootFn := fn.findRootFn()
+	names, found := dw.valueNodes[rootFn]
+	if !found {
+		names = make(map[string]string)
+		dw.valueNodes[rootFn] = names
+	}
+	names[name] = nodeID
+}
+
+// valueNode returns the node id of the producer of the value, or "" if it is not known.
+func (dw *dotWriter) valueNode(v *Value) string {
+	if v.stmt != nil {
+		if id, found := dw.stmtNodes[v.stmt]; found {
+			return id
+		}
+	}
+	return dw.valueNodes[v.fn.findRootFn()][v.name]
+}
+
+// writeFunction writes the function (or closure) as a cluster.
+// The label is used for closures, to show the name of the parameter in the statement using it.
+func (dw *dotWriter) writeFunction(fn *Function, label string, indentation string) {
+	clusterID := dw.newNodeID("cluster_")
+	if label == "" {
+		label = "func @" + fn.Name
+	}
+	nextIndentation := indentation + IndentationStep
+	dw.w("%ssubgraph %s {\n", indentation, clusterID)
+	dw.w("%slabel=%s;\n", nextIndentation, dotQuote(label))
+	if fn.Parent != nil {
+		dw.w("%sstyle=dashed;\n", nextIndentation)
+	}
+
+	// Input parameters.
+	for _, input := range fn.Inputs {
+		nodeID := dw.newNodeID("n")
+		dw.registerValue(fn, input.name, nodeID)
+		dw.w("%s%s [shape=ellipse, style=solid, label=%s];\n", nextIndentation, nodeID,
+			dotQuote(fmt.Sprintf("%s\n%s", input, input.shape)))
+	}
+
+	// Statements: first all nodes (including the nested closures), then the edges.
+	for _, stmt := range fn.Statements {
+		dw.writeStatementNode(stmt, nextIndentation)
+	}
+	for _, stmt := range fn.Statements {
+		dw.writeStatementEdges(stmt, indentation)
+	}
+	dw.w("%s}\n", indentation)
+}
+
+// writeStatementNode writes the node for the statement, and the clusters of its closures, if not collapsed.
+func (dw *dotWriter) writeStatementNode(stmt *Statement, indentation string) {
+	nodeID := dw.newNodeID("n")
+	dw.stmtNodes[stmt] = nodeID
+	for _, output := range stmt.Outputs {
+		dw.registerValue(stmt.Function, output.name, nodeID)
+	}
+
+	var label strings.Builder
+	if len(stmt.Outputs) > 0 {
+		for i, output := range stmt.Outputs {
+			if i > 0 {
+				label.WriteString(", ")
+			}
+			label.WriteString(output.String())
+		}
+		label.WriteString(" = ")
+	}
+	label.WriteString(stmt.OpType.ToStableHLO())
+	if callee, ok := stmt.Attributes["callee"].(symbolRef); ok {
+		label.WriteString(" " + callee.ToStableHLO())
+	}
+	if dw.options.CollapseScopes && len(stmt.FunctionParameters) > 0 {
+		fmt.Fprintf(&label, "\n{%s}", strings.Join(stmt.FunctionParametersNames, ", "))
+	}
+	for _, output := range stmt.Outputs {
+		label.WriteString("\n" + output.shape.String())
+	}
+	attrs := ""
+	switch stmt.OpType {
+	case optypes.FuncReturn:
+		attrs = ", shape=oval, style=filled, fillcolor=lightgray"
+	case optypes.Constant:
+		attrs = ", style=\"rounded,filled\", fillcolor=lightyellow"
+	}
+	dw.w("%s%s [label=%s%s];\n", indentation, nodeID, dotQuote(label.String()), attrs)
+
+	if dw.options.CollapseScopes {
+		return
+	}
+	for i, param := range stmt.FunctionParameters {
+		dw.writeFunction(param, "^"+stmt.FunctionParametersNames[i], indentation)
+	}
+}
+
+// writeStatementEdges writes the edges from the producers of the statement inputs to the statement, and from
+// the return statement of its closures (if not collapsed) to the statement.
+func (dw *dotWriter) writeStatementEdges(stmt *Statement, indentation string) {
+	nodeID := dw.stmtNodes[stmt]
+	for _, input := range stmt.Inputs {
+		from := dw.valueNode(input)
+		if from == "" {
+			if dw.err == nil {
+				dw.err = errors.Errorf("unknown value %s used by %s in function %q",
+					input, stmt.OpType, stmt.Function.Name)
+			}
+			return
+		}
+		label := ""
+		if input.stmt != nil && len(input.stmt.Outputs) > 1 {
+			label = fmt.Sprintf(" [label=%s]", dotQuote(fmt.Sprintf("#%d", input.outputIndex)))
+		}
+		dw.w("%s%s -> %s%s;\n", indentation+IndentationStep, from, nodeID, label)
+	}
+	if dw.options.CollapseScopes {
+		return
+	}
+	for _, param := range stmt.FunctionParameters {
+		if len(param.Statements) == 0 {
+			continue
+		}
+		last := param.Statements[len(param.Statements)-1]
+		if last.OpType != optypes.FuncReturn {
+			continue
+		}
+		dw.w("%s%s -> %s [style=dashed];\n", indentation+IndentationStep, dw.stmtNodes[last], nodeID)
+	}
+}
+
+// dotQuote returns the string quoted as a DOT identifier, with newlines converted to DOT line breaks.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
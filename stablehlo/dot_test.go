@@ -0,0 +1,79 @@
+package stablehlo
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestWriteDOT(t *testing.T) {
+	buildProgram := func(name string) *Builder {
+		b := New(name)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		reductionFn := fn.Closure()
+		lhs := must1(reductionFn.NamedInput("lhs", zero.Shape()))
+		rhs := must1(reductionFn.NamedInput("rhs", zero.Shape()))
+		if err := reductionFn.Return(must1(Add(lhs, rhs))); err != nil {
+			t.Fatalf("reductionFn.Return: %v", err)
+		}
+		sum := must1(Reduce(x, zero, reductionFn, 1))
+		if err := fn.Return(sum); err != nil {
+			t.Fatalf("fn.Return: %v", err)
+		}
+		return b
+	}
+
+	t.Run("expanded", func(t *testing.T) {
+		b := buildProgram(t.Name())
+		var buf bytes.Buffer
+		if err := b.WriteDOT(&buf); err != nil {
+			t.Fatalf("WriteDOT: %v", err)
+		}
+		dot := buf.String()
+		fmt.Printf("%s:\n%s", t.Name(), dot)
+		for _, want := range []string{
+			"digraph \"TestWriteDOT/expanded\" {",
+			"label=\"func @main\";",
+			"label=\"^reductionFn\";",
+			"%x\\n(Float32)[2 3]",
+			"stablehlo.reduce\\n(Float32)[2]",
+			"stablehlo.add\\n(Float32)",
+			"[style=dashed];",
+		} {
+			if !strings.Contains(dot, want) {
+				t.Errorf("DOT output missing %q", want)
+			}
+		}
+		if got := strings.Count(dot, "subgraph cluster_"); got != 2 {
+			t.Errorf("expected 2 clusters, got %d", got)
+		}
+	})
+
+	t.Run("collapsed", func(t *testing.T) {
+		b := buildProgram(t.Name())
+		var buf bytes.Buffer
+		if err := b.WriteDOTWithOptions(&buf, DOTOptions{CollapseScopes: true, RankDir: "LR"}); err != nil {
+			t.Fatalf("WriteDOTWithOptions: %v", err)
+		}
+		dot := buf.String()
+		fmt.Printf("%s:\n%s", t.Name(), dot)
+		if !strings.Contains(dot, "rankdir=LR;") {
+			t.Errorf("DOT output missing rankdir=LR")
+		}
+		if !strings.Contains(dot, "stablehlo.reduce\\n{reductionFn}") {
+			t.Errorf("DOT output missing collapsed closure names")
+		}
+		if strings.Contains(dot, "stablehlo.add") {
+			t.Errorf("collapsed DOT output should not include closure statements")
+		}
+		if got := strings.Count(dot, "subgraph cluster_"); got != 1 {
+			t.Errorf("expected 1 cluster, got %d", got)
+		}
+	})
+}
@@ -4,9 +4,7 @@ import (
 	"fmt"
 	"io"
 	"reflect"
-	"slices"
 	"strconv"
-	"strings"
 
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/go-xla/internal/optypes"
@@ -49,6 +47,9 @@ type Function struct {
 
 	// Returned indicates if the function has a return statement, so it can no longer be changed.
 	Returned bool
+
+	// isManualComputationBody is set for the body closure of a ManualComputation, which returns with "sdy.return".
+	isManualComputationBody bool
 }
 
 // findRootFn returns the root function of a function tree.
@@ -163,17 +164,10 @@ func (fn *Function) NamedInputWithShardingAndAttributes(name string, shape shape
 			value.Attributes = make(map[string]any)
 		}
 		value.Attributes["sdy.sharding"] = literalStr(shardingSpec.ToValueAttribute(value.shape))
-		if slices.Index(fn.Builder.meshes, shardingSpec.Mesh) == -1 {
-			meshesNames := make([]string, len(fn.Builder.meshes))
-			for _, mesh := range fn.Builder.meshes {
-				meshesNames = append(meshesNames, mesh.Name())
-			}
-			return nil, errors.Errorf("sharding spec meshe %q doesn't match any of the stablehlo.Builder meshes (%s)",
-				shardingSpec.Mesh, strings.Join(meshesNames, ", "))
-		}
-		if err := shardingSpec.ValidateShape(shape); err != nil {
+		if err := fn.Builder.validateShardingSpec(shardingSpec, shape); err != nil {
 			return nil, err
 		}
+		value.sharding = shardingSpec
 	}
 	fn.Inputs = append(fn.Inputs, value)
 
@@ -187,6 +181,35 @@ func (fn *Function) NamedInputWithShardingAndAttributes(name string, shape shape
 	return value, nil
 }
 
+// layoutModeAttribute is the argument/result attribute used by XLA to set the memory layout
+// of the inputs and outputs of the main function.
+const layoutModeAttribute = "mhlo.layout_mode"
+
+// InputWithLayout creates a new input with the given memory layout (see shapes.Layout).
+//
+// The layout defines how the input buffer is expected to be laid out in device memory.
+// If layout is nil, the default (row-major) layout is used.
+func (fn *Function) InputWithLayout(shape shapes.Shape, layout *shapes.Layout) (*Value, error) {
+	rootFn := fn.findRootFn()
+	return fn.NamedInputWithLayout(fmt.Sprintf("arg%d", rootFn.nextArgID), shape, layout)
+}
+
+// NamedInputWithLayout creates a new input parameter for a function with the given name -- it
+// must be a unique input name -- and memory layout (see shapes.Layout).
+//
+// The layout defines how the input buffer is expected to be laid out in device memory.
+// If layout is nil, the default (row-major) layout is used.
+func (fn *Function) NamedInputWithLayout(name string, shape shapes.Shape, layout *shapes.Layout) (*Value, error) {
+	var attributes map[string]any
+	if layout != nil {
+		if err := layout.Validate(shape.Rank()); err != nil {
+			return nil, errors.WithMessagef(err, "invalid layout for input %q", name)
+		}
+		attributes = map[string]any{layoutModeAttribute: layout.String()}
+	}
+	return fn.NamedInputWithShardingAndAttributes(name, shape, nil, attributes)
+}
+
 // ConstantFromScalar creates a new constant statement and returns the resulting value.
 func (fn *Function) ConstantFromScalar(value any) (*Value, error) {
 	if fn.Returned {
@@ -235,10 +258,20 @@ func (fn *Function) ConstantFromFlatAndDimensions(flat any, dimensions ...int) (
 }
 
 // ConstantFromFlatAndShape creates a new constant statement from a flat slice with the raw values of the given shape.
+//
+// Values of sub-byte dtypes (Int4, Uint4, Int2, Uint2) can be given either packed (as in the device buffers, see
+// pjrt.BufferFromHostConfig.FromRawData), in a []uint8, or with one value per element, in a []int8 for the signed
+// dtypes and a []uint8 for the unsigned ones. If the packed and unpacked lengths are the same (a single element),
+// the value is taken as unpacked, and it must fit the dtype's bit width.
+// Values of 8-bit float dtypes (e.g. F8E4M3FN, F8E5M2) are given as their raw bits, in a []uint8.
 func (fn *Function) ConstantFromFlatAndShape(flat any, shape shapes.Shape) (*Value, error) {
 	if fn.Returned {
 		return nil, errors.Errorf("Function.Return already called for %q", fn.Name)
 	}
+	flat, err := normalizeConstantFlat(flat, shape)
+	if err != nil {
+		return nil, err
+	}
 	c := &Statement{
 		Builder:    fn.Builder,
 		Function:   fn,
@@ -295,6 +328,38 @@ func (fn *Function) ReturnWithShardingAndAttributes(values []*Value, shardingSpe
 			}
 		}
 	}
+	if err := fn.ReturnWithAttributes(values, attributes); err != nil {
+		return err
+	}
+	for i, output := range fn.Outputs {
+		output.sharding = shardingSpecs[i]
+	}
+	return nil
+}
+
+// ReturnWithLayouts is a convenience function to call ReturnWithAttributes with the memory layout
+// (see shapes.Layout) of each of the outputs.
+//
+// The layouts slice must have the same length as the values slice, and each layout can be nil,
+// in which case the default (row-major) layout is used.
+func (fn *Function) ReturnWithLayouts(values []*Value, layouts []*shapes.Layout) error {
+	if len(layouts) == 0 {
+		return fn.Return(values...)
+	}
+	if len(values) != len(layouts) {
+		return errors.Errorf("Function.ReturnWithLayouts requires the same number of values and layouts, got %d and %d",
+			len(values), len(layouts))
+	}
+	attributes := make([]map[string]any, len(values))
+	for i, layout := range layouts {
+		if layout == nil {
+			continue
+		}
+		if err := layout.Validate(values[i].shape.Rank()); err != nil {
+			return errors.WithMessagef(err, "invalid layout for output #%d", i)
+		}
+		attributes[i] = map[string]any{layoutModeAttribute: layout.String()}
+	}
 	return fn.ReturnWithAttributes(values, attributes)
 }
 
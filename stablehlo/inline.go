@@ -0,0 +1,239 @@
+package stablehlo
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/pkg/errors"
+)
+
+// CallGraph describes the calls (see Call) between the top-level functions of a Builder.
+// It is a snapshot: it is not updated when the functions change. See Builder.CallGraph.
+type CallGraph struct {
+	// Functions lists the top-level functions (not closures) of the builder, in the order they were created.
+	Functions []*Function
+
+	// Callees maps each function to the functions it calls (from its body or its closures), in the order
+	// of their first call.
+	Callees map[*Function][]*Function
+
+	// Callers maps each function to the functions that call it, in the order they were created.
+	Callers map[*Function][]*Function
+
+	// CallSites maps each function to the Call statements that call it.
+	CallSites map[*Function][]*Statement
+
+	// Unused lists the functions that are not reachable from the main function, and hence are not
+	// needed to execute the program. It is empty if there is no main function.
+	Unused []*Function
+}
+
+// CallGraph returns the graph of calls between the top-level functions of the builder.
+//
+// It returns an error if a Call refers to a function that is not defined in the builder.
+func (b *Builder) CallGraph() (*CallGraph, error) {
+	g := &CallGraph{
+		Callees:   make(map[*Function][]*Function),
+		Callers:   make(map[*Function][]*Function),
+		CallSites: make(map[*Function][]*Statement),
+	}
+	byName := make(map[string]*Function)
+	for _, fn := range b.functions {
+		if fn.Parent == nil {
+			g.Functions = append(g.Functions, fn)
+			byName[fn.Name] = fn
+		}
+	}
+	for _, caller := range g.Functions {
+		var err error
+		caller.walkStatementsInOrder(true, func(stmt *Statement) {
+			if stmt.OpType != optypes.Call || err != nil {
+				return
+			}
+			ref, _ := stmt.Attributes["callee"].(symbolRef)
+			callee, found := byName[ref.name]
+			if !found {
+				err = errors.Errorf("function %q calls undefined function %q", caller.Name, ref.name)
+				return
+			}
+			g.CallSites[callee] = append(g.CallSites[callee], stmt)
+			if !slices.Contains(g.Callees[caller], callee) {
+				g.Callees[caller] = append(g.Callees[caller], callee)
+				g.Callers[callee] = append(g.Callers[callee], caller)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	mainFn, found := byName[MainFunctionName]
+	if !found {
+		return g, nil
+	}
+	reachable := g.reachableFrom(mainFn)
+	reachable[mainFn] = true
+	for _, fn := range g.Functions {
+		if !reachable[fn] {
+			g.Unused = append(g.Unused, fn)
+		}
+	}
+	return g, nil
+}
+
+// reachableFrom returns the set of functions called, directly or indirectly, by fn.
+// fn itself is only included if it is (directly or indirectly) recursive.
+func (g *CallGraph) reachableFrom(fn *Function) map[*Function]bool {
+	reachable := make(map[*Function]bool)
+	toVisit := slices.Clone(g.Callees[fn])
+	for len(toVisit) > 0 {
+		next := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if reachable[next] {
+			continue
+		}
+		reachable[next] = true
+		toVisit = append(toVisit, g.Callees[next]...)
+	}
+	return reachable
+}
+
+// IsRecursive returns whether fn calls itself, directly or indirectly.
+func (g *CallGraph) IsRecursive(fn *Function) bool {
+	return g.reachableFrom(fn)[fn]
+}
+
+// PruneUnusedFunctions removes from the builder the top-level functions (and their closures) that are
+// not reachable from the main function, see CallGraph.Unused.
+//
+// It returns the functions removed, and an error if the builder has no main function.
+func (b *Builder) PruneUnusedFunctions() ([]*Function, error) {
+	g, err := b.CallGraph()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(g.Functions, func(fn *Function) bool { return fn.Name == MainFunctionName }) {
+		return nil, errors.New("PruneUnusedFunctions requires a main function")
+	}
+	b.removeFunctions(g.Unused)
+	return g.Unused, nil
+}
+
+// removeFunctions removes the given top-level functions, and their closures, from the builder.
+func (b *Builder) removeFunctions(functions []*Function) {
+	if len(functions) == 0 {
+		return
+	}
+	b.functions = slices.DeleteFunc(b.functions, func(fn *Function) bool {
+		return slices.Contains(functions, fn.findRootFn())
+	})
+}
+
+// Inline replaces all the calls to fn, in all the functions of the builder, by a copy of its statements.
+//
+// fn must be a top-level function that has already returned, and it must not be recursive.
+// fn itself is kept in the builder: use Builder.PruneUnusedFunctions to remove it if it is no longer used.
+func (b *Builder) Inline(fn *Function) error {
+	if fn.Builder != b {
+		return errors.Errorf("cannot inline function %q, it belongs to a different builder", fn.Name)
+	}
+	if fn.Parent != nil {
+		return errors.Errorf("cannot inline closure %q, only top-level functions can be inlined", fn.Name)
+	}
+	if !fn.Returned {
+		return errors.Errorf("cannot inline function %q before it returns", fn.Name)
+	}
+	g, err := b.CallGraph()
+	if err != nil {
+		return err
+	}
+	if g.IsRecursive(fn) {
+		return errors.Errorf("cannot inline function %q, it is recursive", fn.Name)
+	}
+	for _, call := range g.CallSites[fn] {
+		if err := inlineCall(call, fn); err != nil {
+			return errors.WithMessagef(err, "while inlining function %q into %q", fn.Name, call.Function.Name)
+		}
+	}
+	return nil
+}
+
+// InlineFunctions inlines the functions that are called only once, or that have at most maxStatements
+// statements (including the statements of their closures, but not the return statement).
+// If maxStatements is negative, all functions are inlined, and the program is flattened into the main function.
+//
+// It repeats until there are no more functions to inline, and functions inlined into all of their callers
+// are removed from the builder. The main function and recursive functions are never inlined.
+//
+// It returns the functions that were inlined (and removed).
+func (b *Builder) InlineFunctions(maxStatements int) ([]*Function, error) {
+	var inlined []*Function
+	for {
+		g, err := b.CallGraph()
+		if err != nil {
+			return inlined, err
+		}
+		idx := slices.IndexFunc(g.Functions, func(fn *Function) bool {
+			if fn.Name == MainFunctionName || !fn.Returned || len(g.CallSites[fn]) == 0 || g.IsRecursive(fn) {
+				return false
+			}
+			return maxStatements < 0 || len(g.CallSites[fn]) == 1 || fn.numStatements() <= maxStatements
+		})
+		if idx == -1 {
+			return inlined, nil
+		}
+		fn := g.Functions[idx]
+		for _, call := range g.CallSites[fn] {
+			if err := inlineCall(call, fn); err != nil {
+				return inlined, errors.WithMessagef(err, "while inlining function %q into %q", fn.Name, call.Function.Name)
+			}
+		}
+		b.removeFunctions([]*Function{fn})
+		inlined = append(inlined, fn)
+	}
+}
+
+// numStatements returns the number of statements of fn and of its closures, not counting return statements.
+func (fn *Function) numStatements() int {
+	var count int
+	fn.walkStatementsInOrder(true, func(stmt *Statement) {
+		if stmt.OpType != optypes.FuncReturn {
+			count++
+		}
+	})
+	return count
+}
+
+// inlineCall replaces the call statement by a copy of the statements of callee.
+func inlineCall(call *Statement, callee *Function) error {
+	caller := call.Function
+	values := make(map[string]*Value, len(callee.Inputs))
+	for i, input := range callee.Inputs {
+		values[input.name] = call.Inputs[i]
+	}
+	var results []*Value
+	err := caller.InsertBefore(call, func() error {
+		for _, stmt := range callee.Statements {
+			if stmt.OpType == optypes.FuncReturn {
+				results = make([]*Value, len(stmt.Inputs))
+				for i, input := range stmt.Inputs {
+					results[i] = values[input.name]
+				}
+				return nil
+			}
+			if _, err := caller.cloneStatement(stmt, values); err != nil {
+				return err
+			}
+		}
+		return errors.Errorf("function %q has no return statement", callee.Name)
+	})
+	if err != nil {
+		return err
+	}
+	for i, output := range call.Outputs {
+		if err := output.ReplaceAllUsesWith(results[i]); err != nil {
+			return err
+		}
+	}
+	return caller.DeleteStatement(call)
+}
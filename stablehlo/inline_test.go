@@ -0,0 +1,118 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInline(t *testing.T) {
+	scalarF32 := shapes.Make(dtypes.Float32)
+
+	// buildProgram creates: square(x) = x*x; sumOfSquares(x, y) = square(x) + square(y);
+	// unused(x) = -x; main(x, y) = sumOfSquares(x, y) called from within an If branch and directly.
+	buildProgram := func(t *testing.T) (b *Builder, square, sumOfSquares, unused *Function) {
+		b = New(t.Name())
+		square = b.NewFunction("square")
+		x := must1(square.NamedInput("x", scalarF32))
+		require.NoError(t, square.Return(must1(Multiply(x, x))))
+
+		sumOfSquares = b.NewFunction("sumOfSquares")
+		x = must1(sumOfSquares.NamedInput("x", scalarF32))
+		y := must1(sumOfSquares.NamedInput("y", scalarF32))
+		x2 := must1(Call(square, x))[0]
+		y2 := must1(Call(square, y))[0]
+		require.NoError(t, sumOfSquares.Return(must1(Add(x2, y2))))
+
+		unused = b.NewFunction("unused")
+		x = must1(unused.NamedInput("x", scalarF32))
+		require.NoError(t, unused.Return(must1(Negate(x))))
+
+		mainFn := b.Main()
+		x = must1(mainFn.NamedInput("x", scalarF32))
+		y = must1(mainFn.NamedInput("y", scalarF32))
+		pred := must1(mainFn.NamedInput("pred", shapes.Make(dtypes.Bool)))
+		trueBranch := mainFn.Closure()
+		require.NoError(t, trueBranch.Return(must1(Call(sumOfSquares, must1(trueBranch.UseParentValue(x)),
+			must1(trueBranch.UseParentValue(y))))...))
+		falseBranch := mainFn.Closure()
+		require.NoError(t, falseBranch.Return(must1(falseBranch.UseParentValue(x))))
+		branchResult := must1(If(pred, trueBranch, falseBranch))[0]
+		direct := must1(Call(sumOfSquares, x, y))[0]
+		require.NoError(t, mainFn.Return(must1(Add(branchResult, direct))))
+		return
+	}
+
+	t.Run("CallGraph", func(t *testing.T) {
+		b, square, sumOfSquares, unused := buildProgram(t)
+		g := must1(b.CallGraph())
+		mainFn := g.Functions[3]
+		require.Equal(t, []*Function{square}, g.Callees[sumOfSquares])
+		require.Equal(t, []*Function{sumOfSquares}, g.Callees[mainFn])
+		require.Equal(t, []*Function{mainFn}, g.Callers[sumOfSquares])
+		require.Len(t, g.CallSites[square], 2)
+		require.Len(t, g.CallSites[sumOfSquares], 2)
+		require.Equal(t, []*Function{unused}, g.Unused)
+		require.False(t, g.IsRecursive(sumOfSquares))
+
+		removed := must1(b.PruneUnusedFunctions())
+		require.Equal(t, []*Function{unused}, removed)
+		program := string(must1(b.Build()))
+		require.NotContains(t, program, "@unused")
+	})
+
+	t.Run("Inline", func(t *testing.T) {
+		b, square, _, _ := buildProgram(t)
+		require.NoError(t, b.Inline(square))
+		g := must1(b.CallGraph())
+		require.Empty(t, g.CallSites[square])
+		require.Contains(t, g.Unused, square)
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, "stablehlo.multiply")
+	})
+
+	t.Run("InlineFunctions", func(t *testing.T) {
+		b, square, sumOfSquares, _ := buildProgram(t)
+
+		// square is small, but sumOfSquares has 3 statements and is called twice.
+		inlined := must1(b.InlineFunctions(1))
+		require.Equal(t, []*Function{square}, inlined)
+
+		// Flatten everything.
+		inlined = must1(b.InlineFunctions(-1))
+		require.Equal(t, []*Function{sumOfSquares}, inlined)
+		g := must1(b.CallGraph())
+		require.Len(t, g.Functions, 2) // main and unused.
+		for _, fn := range g.Functions {
+			fn.walkStatementsInOrder(true, func(stmt *Statement) {
+				require.NotEqual(t, optypes.Call, stmt.OpType)
+			})
+		}
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.NotContains(t, program, "func.call")
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.NewFunction("recursive")
+		x := must1(fn.NamedInput("x", scalarF32))
+		require.NoError(t, fn.Return(must1(Negate(x))))
+		other := b.NewFunction("other")
+		y := must1(other.NamedInput("y", scalarF32))
+		require.NoError(t, other.Return(must1(Call(fn, y))...))
+		// Make fn call other (and hence itself, indirectly).
+		require.NoError(t, fn.InsertBefore(fn.Statements[0], func() error {
+			_, err := Call(other, x)
+			return err
+		}))
+		require.ErrorContains(t, b.Inline(fn), "recursive")
+		inlined := must1(b.InlineFunctions(-1))
+		require.Empty(t, inlined)
+	})
+}
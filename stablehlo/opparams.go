@@ -0,0 +1,106 @@
+package stablehlo
+
+import (
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shardy"
+)
+
+// The structures in this file hold the parameters of ops (as given by the user) in Statement.params.
+//
+// The Statement.Attributes hold the rendered StableHLO attributes, which are not easy to interpret back.
+// The parameters are used by transformations of the program, like VJP, that need to create new ops based on
+// the parameters of the existing ones.
+
+// dotGeneralParams holds the parameters of a DotGeneral op.
+type dotGeneralParams struct {
+	lhsContractingAxes, lhsBatchAxes []int
+	rhsContractingAxes, rhsBatchAxes []int
+	precision                        [2]types.DotGeneralPrecisionType
+	outputDType                      dtypes.DType
+	algorithm                        *types.DotGeneralAlgorithm
+}
+
+// broadcastInDimParams holds the parameters of a BroadcastInDim op.
+type broadcastInDimParams struct {
+	axesMapping []int
+}
+
+// transposeParams holds the parameters of a Transpose op.
+type transposeParams struct {
+	permutation []int
+}
+
+// sliceParams holds the parameters of a Slice op.
+type sliceParams struct {
+	starts, limits, strides []int
+}
+
+// padParams holds the parameters of a Pad op.
+type padParams struct {
+	start, end, interior []int
+}
+
+// concatenateParams holds the parameters of a Concatenate op.
+type concatenateParams struct {
+	axis int
+}
+
+// reduceParams holds the parameters of a Reduce op.
+type reduceParams struct {
+	axes []int
+}
+
+// reverseParams holds the parameters of a Reverse op.
+type reverseParams struct {
+	axes []int
+}
+
+// gatherParams holds the parameters of a Gather op.
+type gatherParams struct {
+	indexVectorAxis                                              int
+	offsetOutputAxes, collapsedSliceAxes                         []int
+	operandBatchingAxes, startIndicesBatchingAxes, startIndexMap []int
+	sliceSizes                                                   []int
+	indicesAreSorted                                             bool
+}
+
+// scatterParams holds the parameters of a Scatter op.
+type scatterParams struct {
+	updateWindowAxes, insertedWindowAxes          []int
+	inputBatchingAxes, scatterIndicesBatchingAxes []int
+	indexedInputAxes                              []int
+	indexVectorAxis                               int
+	indicesAreSorted, uniqueIndices               bool
+}
+
+// convolutionParams holds the parameters of a Convolution op, after the defaults are set and negative axes adjusted.
+type convolutionParams struct {
+	strides                                           []int
+	paddings                                          [][2]int
+	inputDilations, kernelDilations                   []int
+	inputBatchAxis, inputChannelsAxis                 int
+	inputSpatialAxes                                  []int
+	kernelInputChannelsAxis, kernelOutputChannelsAxis int
+	kernelSpatialAxes                                 []int
+	outputBatchAxis, outputChannelsAxis               int
+	outputSpatialAxes                                 []int
+	channelGroupCount, batchGroupCount                int
+	inputPrecision, kernelPrecision                   types.DotGeneralPrecisionType
+}
+
+// shardingParams holds the parameters of the ShardingConstraint and Reshard ops.
+type shardingParams struct {
+	shardingSpec *shardy.ShardingSpec
+}
+
+// shardingGroupParams holds the parameters of a ShardingGroup op.
+type shardingGroupParams struct {
+	groupID int64
+}
+
+// manualComputationParams holds the parameters of a ManualComputation op.
+type manualComputationParams struct {
+	manualAxes                []string
+	inShardings, outShardings []*shardy.ShardingSpec
+}
@@ -397,6 +397,15 @@ func (b *DotGeneralBuilder) Done() (*Value, error) {
 			b.algorithm.NumPrimitiveOperations,
 			b.algorithm.AllowImpreciseAccumulation)
 	}
+	stmt.params = &dotGeneralParams{
+		lhsContractingAxes: b.lhsContractingAxes,
+		lhsBatchAxes:       b.lhsBatchAxes,
+		rhsContractingAxes: b.rhsContractingAxes,
+		rhsBatchAxes:       b.rhsBatchAxes,
+		precision:          b.precision,
+		outputDType:        b.outputDType,
+		algorithm:          b.algorithm,
+	}
 	return stmt.Outputs[0], nil
 }
 
@@ -445,6 +454,7 @@ func BroadcastInDim(operand *Value, target shapes.Shape, axesMapping []int) (*Va
 	}
 	stmt := fn.addOp(op, target, operand)
 	stmt.Attributes = map[string]any{"broadcast_dimensions": intSliceToArrayI64StableHLO(axesMapping)}
+	stmt.params = &broadcastInDimParams{axesMapping: axesMapping}
 	return stmt.Outputs[0], nil
 }
 
@@ -552,6 +562,16 @@ func Gather(operand, startIndices *Value, indexVectorAxis int,
 		"slice_sizes":        intSliceToArrayI64StableHLO(sliceSizes),
 		"indices_are_sorted": indicesAreSorted,
 	}
+	stmt.params = &gatherParams{
+		indexVectorAxis:          indexVectorAxis,
+		offsetOutputAxes:         offsetOutputAxes,
+		collapsedSliceAxes:       collapsedSliceAxes,
+		operandBatchingAxes:      operandBatchingAxes,
+		startIndicesBatchingAxes: startIndicesBatchingAxes,
+		startIndexMap:            startIndexMap,
+		sliceSizes:               sliceSizes,
+		indicesAreSorted:         indicesAreSorted,
+	}
 	return stmt.Outputs[0], nil
 }
 
@@ -587,6 +607,7 @@ func Slice(x *Value, starts, limits, strides []int) (*Value, error) {
 		"limit_indices": intSliceToArrayI64StableHLO(limits),
 		"strides":       intSliceToArrayI64StableHLO(strides),
 	}
+	stmt.params = &sliceParams{starts: starts, limits: limits, strides: strides}
 	return stmt.Outputs[0], nil
 }
 
@@ -707,6 +728,7 @@ func Concatenate(axis int, operands ...*Value) (*Value, error) {
 	stmt.Attributes = map[string]any{
 		"dimension": int64(adjustedAxis),
 	}
+	stmt.params = &concatenateParams{axis: adjustedAxis}
 	return stmt.Outputs[0], nil
 }
 
@@ -780,6 +802,7 @@ func MultiReduce(inputs, initialValues []*Value, reductionFn *Function, axes ...
 	stmt.Attributes = map[string]any{
 		"dimensions": intSliceToArrayI64StableHLO(axes),
 	}
+	stmt.params = &reduceParams{axes: axes}
 	stmt.AddFunctionParameter("reductionFn", reductionFn)
 	return stmt.Outputs, nil
 }
@@ -855,6 +878,7 @@ func Transpose(x *Value, permutation ...int) (*Value, error) {
 	stmt.Attributes = map[string]any{
 		"permutation": intSliceToArrayI64StableHLO(permutation),
 	}
+	stmt.params = &transposeParams{permutation: permutation}
 	return stmt.Outputs[0], nil
 }
 
@@ -1003,6 +1027,16 @@ func MultiScatter(inputs []*Value, scatterIndices *Value, updates []*Value,
 		"indices_are_sorted": indicesAreSorted,
 		"unique_indices":     uniqueIndices,
 	}
+	stmt.params = &scatterParams{
+		updateWindowAxes:           updateWindowAxes,
+		insertedWindowAxes:         insertedWindowAxes,
+		inputBatchingAxes:          inputBatchingAxes,
+		scatterIndicesBatchingAxes: scatterIndicesBatchingAxes,
+		indexedInputAxes:           indexedInputAxes,
+		indexVectorAxis:            indexVectorAxis,
+		indicesAreSorted:           indicesAreSorted,
+		uniqueIndices:              uniqueIndices,
+	}
 	stmt.AddFunctionParameter("updateFn", updateComputationFn)
 	return stmt.Outputs, nil
 }
@@ -1022,8 +1056,10 @@ func Convert(x *Value, dtype dtypes.DType) (*Value, error) {
 		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
 			op, fn.Name)
 	}
-	outputShape := x.shape.Clone()
-	outputShape.DType = dtype
+	outputShape, err := shapeinference.Convert(x.shape, dtype)
+	if err != nil {
+		return nil, err
+	}
 	stmt := fn.addOp(op, outputShape, x)
 	return stmt.Outputs[0], nil
 }
@@ -1078,6 +1114,7 @@ func Pad(x, fill *Value, paddingStart, paddingEnd, paddingInterior []int) (*Valu
 		"edge_padding_high": intSliceToArrayI64StableHLO(paddingEnd),
 		"interior_padding":  intSliceToArrayI64StableHLO(paddingInterior),
 	}
+	stmt.params = &padParams{start: paddingStart, end: paddingEnd, interior: paddingInterior}
 	return stmt.Outputs[0], nil
 }
 
@@ -1176,6 +1213,25 @@ func Convolution(input, kernel *Value,
 		"batch_group_count":   int64(batchGroupCount),
 		"precision_config":    precisionConfig,
 	}
+	stmt.params = &convolutionParams{
+		strides:                  strides,
+		paddings:                 paddings,
+		inputDilations:           inputDilations,
+		kernelDilations:          kernelDilations,
+		inputBatchAxis:           inputBatchAxis,
+		inputChannelsAxis:        inputChannelsAxis,
+		inputSpatialAxes:         inputSpatialAxes,
+		kernelInputChannelsAxis:  kernelInputChannelsAxis,
+		kernelOutputChannelsAxis: kernelOutputChannelsAxis,
+		kernelSpatialAxes:        kernelSpatialAxes,
+		outputBatchAxis:          outputBatchAxis,
+		outputChannelsAxis:       outputChannelsAxis,
+		outputSpatialAxes:        outputSpatialAxes,
+		channelGroupCount:        channelGroupCount,
+		batchGroupCount:          batchGroupCount,
+		inputPrecision:           inputPrecision,
+		kernelPrecision:          kernelPrecision,
+	}
 	return stmt.Outputs[0], nil
 }
 
@@ -1239,6 +1295,7 @@ func Reverse(x *Value, axes ...int) (*Value, error) {
 	stmt.Attributes = map[string]any{
 		"dimensions": intSliceToArrayI64StableHLO(axes),
 	}
+	stmt.params = &reverseParams{axes: axes}
 	return stmt.Outputs[0], nil
 }
 
@@ -0,0 +1,407 @@
+package stablehlo
+
+import (
+	"maps"
+	"math"
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// This file implements the lowering of quantized types and ops to plain integer and float arithmetic,
+// see Builder.LowerQuantization.
+
+// WithQuantizationLowering configures the builder to lower quantized types and ops to plain integer and
+// float arithmetic when Build is called, see Builder.LowerQuantization.
+//
+// Use it to run quantized programs on PJRT plugins that don't support quantization (e.g. the default CPU plugin).
+func (b *Builder) WithQuantizationLowering() *Builder {
+	b.lowerQuantization = true
+	return b
+}
+
+// LowerQuantization rewrites the functions of the builder that use quantized types (see shapes.Quantization),
+// replacing them with plain integer and float arithmetic:
+//
+//   - Quantized values are represented by their storage integer type (e.g. Int8): including inputs and outputs
+//     of the functions. So the program signature changes accordingly.
+//   - UniformQuantize becomes clamp(round_nearest_even(x / scale) + zeroPoint) converted to the storage type.
+//   - UniformDequantize becomes (convert(x) - zeroPoint) * scale, in the expressed type.
+//   - Any other op (e.g. DotGeneral, Convolution, Add) with quantized operands or outputs is evaluated in the
+//     expressed type: its quantized operands are dequantized, and its quantized outputs are quantized back.
+//     Call is the exception: quantized values are passed to (and returned from) the callee in their storage type.
+//
+// Per-tensor, per-axis and blockwise quantization are supported.
+// Closures (e.g. of While or If) can use quantized values, but not take quantized inputs (e.g. the reduction
+// function of a Reduce of a quantized value).
+//
+// It is called automatically by Build if the builder was configured with WithQuantizationLowering.
+// It is a no-op for programs without quantized types.
+func (b *Builder) LowerQuantization() error {
+	for _, fn := range slices.Clone(b.functions) {
+		if fn.Parent != nil || !fn.hasQuantization() {
+			continue
+		}
+		if !fn.Returned {
+			return errors.Errorf("cannot lower quantization of function %q before it returns", fn.Name)
+		}
+		l := &quantizationLowering{quantized: make(map[string]*shapes.Quantization)}
+		if err := l.lowerFunction(fn); err != nil {
+			return errors.WithMessagef(err, "while lowering quantization of function %q", fn.Name)
+		}
+	}
+	return nil
+}
+
+// hasQuantization returns whether fn (or any of its closures) uses quantized types.
+func (fn *Function) hasQuantization() bool {
+	isQuantized := func(v *Value) bool { return v.shape.Quantization != nil }
+	if slices.ContainsFunc(fn.Inputs, isQuantized) {
+		return true
+	}
+	found := false
+	fn.walkStatementsInOrder(true, func(stmt *Statement) {
+		found = found || slices.ContainsFunc(stmt.Inputs, isQuantized) || slices.ContainsFunc(stmt.Outputs, isQuantized)
+	})
+	return found
+}
+
+// quantizationLowering holds the state of the lowering of a top-level function and its closures.
+type quantizationLowering struct {
+	// quantized maps the names of the lowered values that hold quantized values (in their storage type) to
+	// their quantization.
+	quantized map[string]*shapes.Quantization
+}
+
+// storageShape returns the shape used to store the quantized values of shape.
+func storageShape(shape shapes.Shape) shapes.Shape {
+	storage := shape.Clone()
+	storage.DType = shape.Quantization.StorageType
+	storage.Quantization = nil
+	return storage
+}
+
+// lowerFunction re-creates the statements of fn (in place), lowering the quantized values and ops.
+func (l *quantizationLowering) lowerFunction(fn *Function) error {
+	for _, input := range fn.Inputs {
+		if q := input.shape.Quantization; q != nil {
+			if fn.Parent != nil {
+				return errors.Errorf("closure %q has quantized input %s (shape %s), which is not supported",
+					fn.Name, input, input.shape)
+			}
+			input.shape = storageShape(input.shape)
+			l.quantized[input.name] = q
+		}
+	}
+	statements := fn.Statements
+	outputs := fn.Outputs
+	fn.Statements = nil
+	fn.Outputs = nil
+	fn.Returned = false
+	values := make(map[string]*Value)
+	for _, input := range fn.Inputs {
+		values[input.name] = input
+	}
+	for _, stmt := range statements {
+		for _, input := range stmt.Inputs {
+			if _, found := values[input.name]; !found {
+				// Reference to a (already lowered) value of a parent function.
+				values[input.name] = input
+			}
+		}
+		if stmt.OpType == optypes.FuncReturn {
+			results := make([]*Value, len(stmt.Inputs))
+			var attributes []map[string]any
+			for i, input := range stmt.Inputs {
+				results[i] = values[input.name]
+				if outputs[i].Attributes != nil {
+					if attributes == nil {
+						attributes = make([]map[string]any, len(outputs))
+					}
+					attributes[i] = outputs[i].Attributes
+				}
+			}
+			return fn.ReturnWithAttributes(results, attributes)
+		}
+		if err := l.lowerStatement(fn, stmt, values); err != nil {
+			return errors.WithMessagef(err, "lowering %s", stmt.OpType)
+		}
+	}
+	return errors.Errorf("function %q has no return statement", fn.Name)
+}
+
+// lowerStatement re-creates stmt in fn, lowering its quantized operands and outputs.
+func (l *quantizationLowering) lowerStatement(fn *Function, stmt *Statement, values map[string]*Value) error {
+	inputs := make([]*Value, len(stmt.Inputs))
+	hasQuantization := false
+	for i, input := range stmt.Inputs {
+		inputs[i] = values[input.name]
+		hasQuantization = hasQuantization || l.quantized[inputs[i].name] != nil
+	}
+	for _, output := range stmt.Outputs {
+		hasQuantization = hasQuantization || output.shape.Quantization != nil
+	}
+
+	switch {
+	case !hasQuantization:
+		newStmt, err := fn.cloneStatement(stmt, values)
+		if err != nil {
+			return err
+		}
+		return l.lowerClosures(newStmt)
+
+	case stmt.OpType == optypes.UniformQuantize:
+		x := inputs[0]
+		if q := l.quantized[x.name]; q != nil {
+			var err error
+			if x, err = l.dequantize(fn, x, q); err != nil {
+				return err
+			}
+		}
+		q := stmt.Outputs[0].shape.Quantization
+		result, err := l.quantize(fn, x, q)
+		if err != nil {
+			return err
+		}
+		values[stmt.Outputs[0].name] = result
+		return nil
+
+	case stmt.OpType == optypes.UniformDequantize:
+		result, err := l.dequantize(fn, inputs[0], l.quantized[inputs[0].name])
+		if err != nil {
+			return err
+		}
+		values[stmt.Outputs[0].name] = result
+		return nil
+
+	case stmt.OpType == optypes.Call:
+		// Quantized values are passed to the callee in their storage type.
+		outputShapes := make([]shapes.Shape, len(stmt.Outputs))
+		for i, output := range stmt.Outputs {
+			outputShapes[i] = output.shape
+			if output.shape.Quantization != nil {
+				outputShapes[i] = storageShape(output.shape)
+			}
+		}
+		newStmt := fn.addMultiOp(stmt.OpType, outputShapes, inputs)
+		newStmt.Attributes = maps.Clone(stmt.Attributes)
+		for i, output := range stmt.Outputs {
+			if output.shape.Quantization != nil {
+				l.quantized[newStmt.Outputs[i].name] = output.shape.Quantization
+			}
+			values[output.name] = newStmt.Outputs[i]
+		}
+		return nil
+	}
+
+	// Any other op is evaluated in the expressed type.
+	for i, input := range inputs {
+		if q := l.quantized[input.name]; q != nil {
+			var err error
+			if inputs[i], err = l.dequantize(fn, input, q); err != nil {
+				return err
+			}
+		}
+	}
+	outputShapes := make([]shapes.Shape, len(stmt.Outputs))
+	for i, output := range stmt.Outputs {
+		outputShapes[i] = output.shape.Clone()
+		outputShapes[i].Quantization = nil
+	}
+	newStmt := fn.addMultiOp(stmt.OpType, outputShapes, inputs)
+	newStmt.Attributes = maps.Clone(stmt.Attributes)
+	newStmt.params = stmt.params
+	for i, closure := range stmt.FunctionParameters {
+		newClosure, err := fn.cloneClosure(closure, values)
+		if err != nil {
+			return err
+		}
+		newStmt.AddFunctionParameter(stmt.FunctionParametersNames[i], newClosure)
+	}
+	if err := l.lowerClosures(newStmt); err != nil {
+		return err
+	}
+	for i, output := range stmt.Outputs {
+		result := newStmt.Outputs[i]
+		result.Attributes = maps.Clone(output.Attributes)
+		if q := output.shape.Quantization; q != nil {
+			var err error
+			if result, err = l.quantize(fn, result, q); err != nil {
+				return err
+			}
+		}
+		values[output.name] = result
+	}
+	return nil
+}
+
+// lowerClosures lowers the closures of stmt that use quantized types.
+func (l *quantizationLowering) lowerClosures(stmt *Statement) error {
+	for _, closure := range stmt.FunctionParameters {
+		if !closure.hasQuantization() && !l.usesQuantizedParentValues(closure) {
+			continue
+		}
+		if err := l.lowerFunction(closure); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usesQuantizedParentValues returns whether the closure uses lowered quantized values of its parent functions.
+func (l *quantizationLowering) usesQuantizedParentValues(closure *Function) bool {
+	found := false
+	closure.walkStatementsInOrder(true, func(stmt *Statement) {
+		for _, input := range stmt.Inputs {
+			found = found || (input.stmt == nil && l.quantized[input.name] != nil)
+		}
+	})
+	return found
+}
+
+// quantizationParams returns the scale and zero point of the quantization q, broadcast to the given shape, in
+// the expressed dtype.
+func quantizationParams(fn *Function, q *shapes.Quantization, shape shapes.Shape) (scale, zeroPoint *Value, err error) {
+	if len(q.Scales) != len(q.ZeroPoints) || len(q.Scales) == 0 {
+		return nil, nil, errors.Errorf("quantization %s must have the same (non-zero) number of scales and zero points", q)
+	}
+	zeroPoints := make([]float64, len(q.ZeroPoints))
+	for i, zp := range q.ZeroPoints {
+		zeroPoints[i] = float64(zp)
+	}
+
+	// Shape of the parameters: one axis per quantized axis, with the number of blocks in the axis.
+	// And the mapping of these axes to a shape where each quantized axis is split into (numBlocks, blockSize).
+	var paramsDims, mapping []int
+	expandedShape := shapes.Make(q.ExpressedType)
+	for axis, dim := range shape.Dimensions {
+		idx := slices.Index(q.QuantizedAxes, axis)
+		if idx == -1 {
+			expandedShape.Dimensions = append(expandedShape.Dimensions, dim)
+			continue
+		}
+		blockSize := 1
+		if idx < len(q.BlockSizes) {
+			blockSize = int(q.BlockSizes[idx])
+		}
+		if blockSize <= 0 || dim%blockSize != 0 {
+			return nil, nil, errors.Errorf("quantization %s block size %d doesn't divide axis %d of shape %s",
+				q, blockSize, axis, shape)
+		}
+		expandedShape.Dimensions = append(expandedShape.Dimensions, dim/blockSize, blockSize)
+	}
+	for _, axis := range q.QuantizedAxes {
+		if axis < 0 || axis >= shape.Rank() {
+			return nil, nil, errors.Errorf("quantization %s axis %d out of range for shape %s", q, axis, shape)
+		}
+		// Position of the numBlocks axis in the expanded shape.
+		pos := axis
+		for _, otherAxis := range q.QuantizedAxes {
+			if otherAxis < axis {
+				pos++
+			}
+		}
+		mapping = append(mapping, pos)
+		paramsDims = append(paramsDims, expandedShape.Dimensions[pos])
+	}
+	paramsSize := 1
+	for _, dim := range paramsDims {
+		paramsSize *= dim
+	}
+	if paramsSize != len(q.Scales) {
+		return nil, nil, errors.Errorf("quantization %s has %d scales, but shape %s requires %d",
+			q, len(q.Scales), shape, paramsSize)
+	}
+
+	toParam := func(flat []float64) (*Value, error) {
+		var value *Value
+		var err error
+		if len(paramsDims) == 0 {
+			// Per-tensor quantization.
+			value, err = fn.ConstantFromScalar(shapes.CastAsDType(flat[0], q.ExpressedType))
+			if err != nil || shape.IsScalar() {
+				return value, err
+			}
+			return BroadcastInDim(value, shapes.Make(q.ExpressedType, shape.Dimensions...), nil)
+		}
+		value, err = fn.ConstantFromFlatAndDimensions(shapes.CastAsDType(flat, q.ExpressedType), paramsDims...)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = BroadcastInDim(value, expandedShape, mapping); err != nil {
+			return nil, err
+		}
+		if expandedShape.Rank() == shape.Rank() {
+			// All block sizes are 1.
+			return value, nil
+		}
+		return Reshape(value, shapes.Make(q.ExpressedType, shape.Dimensions...))
+	}
+	if scale, err = toParam(q.Scales); err != nil {
+		return nil, nil, err
+	}
+	if zeroPoint, err = toParam(zeroPoints); err != nil {
+		return nil, nil, err
+	}
+	return scale, zeroPoint, nil
+}
+
+// quantize returns x (in the expressed dtype) quantized with q, in the storage dtype.
+func (l *quantizationLowering) quantize(fn *Function, x *Value, q *shapes.Quantization) (*Value, error) {
+	scale, zeroPoint, err := quantizationParams(fn, q, x.shape)
+	if err != nil {
+		return nil, err
+	}
+	bits := q.StorageType.Bits()
+	lowest, highest := -math.Exp2(float64(bits-1)), math.Exp2(float64(bits-1))-1
+	if q.StorageType.IsUnsigned() {
+		lowest, highest = 0, math.Exp2(float64(bits))-1
+	}
+	lowestValue, err := fn.ConstantFromScalar(shapes.CastAsDType(lowest, q.ExpressedType))
+	if err != nil {
+		return nil, err
+	}
+	highestValue, err := fn.ConstantFromScalar(shapes.CastAsDType(highest, q.ExpressedType))
+	if err != nil {
+		return nil, err
+	}
+	y, err := Divide(x, scale)
+	if err != nil {
+		return nil, err
+	}
+	if y, err = RoundNearestEven(y); err != nil {
+		return nil, err
+	}
+	if y, err = Add(y, zeroPoint); err != nil {
+		return nil, err
+	}
+	if y, err = Clamp(lowestValue, y, highestValue); err != nil {
+		return nil, err
+	}
+	if y, err = Convert(y, q.StorageType); err != nil {
+		return nil, err
+	}
+	l.quantized[y.name] = q
+	return y, nil
+}
+
+// dequantize returns x (in the storage dtype) dequantized with q, in the expressed dtype.
+func (l *quantizationLowering) dequantize(fn *Function, x *Value, q *shapes.Quantization) (*Value, error) {
+	if q == nil {
+		return nil, errors.Errorf("value %s is not quantized", x)
+	}
+	scale, zeroPoint, err := quantizationParams(fn, q, x.shape)
+	if err != nil {
+		return nil, err
+	}
+	y, err := Convert(x, q.ExpressedType)
+	if err != nil {
+		return nil, err
+	}
+	if y, err = Subtract(y, zeroPoint); err != nil {
+		return nil, err
+	}
+	return Multiply(y, scale)
+}
@@ -0,0 +1,91 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLowerQuantization(t *testing.T) {
+	t.Run("per-tensor", func(t *testing.T) {
+		builder := New(t.Name()).WithQuantizationLowering()
+		fn := builder.Main()
+		c1 := must1(fn.ConstantFromScalar(float32(2.0)))
+		c2 := must1(fn.ConstantFromScalar(float32(3.0)))
+		product := must1(Multiply(c1, c2))
+		quantization := shapes.UniformQuantization(dtypes.Int8, dtypes.Float32, 0.025, 0)
+		product = must1(product.WithQuantization(quantization))
+		require.NoError(t, fn.Return(product))
+		program := string(must1(builder.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.NotContains(t, program, "!quant")
+		require.Contains(t, program, "func.func @main() -> tensor<i8>")
+		require.Contains(t, program, "stablehlo.round_nearest_even")
+		require.Contains(t, program, "stablehlo.clamp")
+	})
+
+	t.Run("quantized inputs and dot", func(t *testing.T) {
+		builder := New(t.Name())
+		fn := builder.Main()
+		// Per-axis quantized weights, blockwise quantized input.
+		wQuantization := &shapes.Quantization{
+			StorageType:   dtypes.Int8,
+			ExpressedType: dtypes.Float32,
+			Scales:        []float64{0.1, 0.5},
+			ZeroPoints:    []int64{-30, -20},
+			QuantizedAxes: []int{1},
+		}
+		xQuantization := &shapes.Quantization{
+			StorageType:   dtypes.Uint8,
+			ExpressedType: dtypes.Float32,
+			Scales:        []float64{0.1, 0.2, 0.3},
+			ZeroPoints:    []int64{128, 128, 128},
+			QuantizedAxes: []int{0},
+			BlockSizes:    []int64{2},
+		}
+		w := must1(fn.NamedInput("w", shapes.Make(dtypes.Float32, 6, 2).WithQuantization(wQuantization)))
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 6)))
+		x = must1(UniformQuantize(x, x.Shape().WithQuantization(xQuantization)))
+		y := must1(DotGeneral(x, []int{0}, nil, w, []int{0}, nil).Done())
+		require.NoError(t, fn.Return(must1(UniformDequantize(must1(UniformQuantize(y,
+			y.Shape().WithQuantization(shapes.UniformQuantization(dtypes.Int8, dtypes.Float32, 0.5, 0))))))))
+		require.NoError(t, builder.LowerQuantization())
+
+		require.True(t, fn.Inputs[0].Shape().Equal(shapes.Make(dtypes.Int8, 6, 2)))
+		require.True(t, fn.Outputs[0].Shape().Equal(shapes.Make(dtypes.Float32, 2)))
+		ops := make(map[optypes.OpType]bool)
+		fn.walkStatementsInOrder(true, func(stmt *Statement) {
+			ops[stmt.OpType] = true
+		})
+		require.False(t, ops[optypes.UniformQuantize])
+		require.False(t, ops[optypes.UniformDequantize])
+		require.True(t, ops[optypes.DotGeneral])
+		require.False(t, fn.hasQuantization())
+
+		// LowerQuantization is idempotent.
+		require.NoError(t, builder.LowerQuantization())
+		program := string(must1(builder.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.NotContains(t, program, "!quant")
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		builder := New(t.Name()).WithQuantizationLowering()
+		fn := builder.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		q := &shapes.Quantization{
+			StorageType:   dtypes.Int8,
+			ExpressedType: dtypes.Float32,
+			Scales:        []float64{0.1, 0.5},
+			ZeroPoints:    []int64{0, 0},
+			QuantizedAxes: []int{0},
+		}
+		require.NoError(t, fn.Return(must1(UniformQuantize(x, x.Shape().WithQuantization(q)))))
+		_, err := builder.Build()
+		require.ErrorContains(t, err, "requires 3")
+	})
+}
@@ -0,0 +1,470 @@
+package stablehlo
+
+import (
+	"container/heap"
+	"slices"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/internal/shapeinference"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// This file implements a small API to traverse and rewrite programs after they are built, which can be used
+// to implement custom passes (e.g.: precision casting, op substitution, instrumentation).
+
+// Statement returns the statement that created this value, or nil if the value is an input parameter of
+// a function (or a reference to a value of a parent function, see Function.UseParentValue).
+func (v *Value) Statement() *Statement {
+	return v.stmt
+}
+
+// Function returns the function (or closure) that owns this value.
+func (v *Value) Function() *Function {
+	return v.fn
+}
+
+// refersTo returns whether the value used as an input refers to the SSA value v: either it is v itself, or it is
+// a reference created with Function.UseParentValue.
+//
+// Value names are unique within a root function and its closures, so the name identifies the SSA value.
+func (v *Value) refersTo(target *Value) bool {
+	return v == target || (v.stmt == nil && v.name == target.name && v.fn != target.fn && isAncestor(target.fn, v.fn))
+}
+
+// walkStatementsInOrder calls visit for each statement of fn in the order they are stored, recursing into the
+// closures of each statement (before the statement itself) if recursive is true.
+func (fn *Function) walkStatementsInOrder(recursive bool, visit func(stmt *Statement)) {
+	for _, stmt := range fn.Statements {
+		if recursive {
+			for _, closure := range stmt.FunctionParameters {
+				closure.walkStatementsInOrder(true, visit)
+			}
+		}
+		visit(stmt)
+	}
+}
+
+// Users returns the statements that use the value as an input, including statements of closures that refer
+// to it (see Function.UseParentValue).
+//
+// The statements are returned in program order, with the statements of closures coming before the
+// statement that owns the closure.
+func (v *Value) Users() []*Statement {
+	var users []*Statement
+	v.fn.walkStatementsInOrder(true, func(stmt *Statement) {
+		for _, input := range stmt.Inputs {
+			if input.refersTo(v) {
+				users = append(users, stmt)
+				return
+			}
+		}
+	})
+	return users
+}
+
+// ReplaceAllUsesWith replaces all uses of the value v by newValue, including uses in closures and in the
+// return statement of the function.
+//
+// The statement that created newValue (if any) is not changed, so one can create a new value from v (for
+// instance, with Convert) and then use it to replace all the other uses of v.
+//
+// If newValue has a different shape than v, the statements using it are marked for shape inference:
+// call Function.InferShapes after all edits are done to update the shapes of the program.
+//
+// It returns an error if newValue is not visible to some of the users of v: newValue must belong to the
+// same function as the user, or to one of its ancestors.
+func (v *Value) ReplaceAllUsesWith(newValue *Value) error {
+	if newValue == nil {
+		return errors.New("ReplaceAllUsesWith: newValue is nil")
+	}
+	if newValue == v {
+		return nil
+	}
+	users := v.Users()
+	for _, stmt := range users {
+		if stmt == newValue.stmt {
+			continue
+		}
+		if !isAncestor(newValue.fn, stmt.Function) {
+			return errors.Errorf("ReplaceAllUsesWith: value %s (of function %q) is not visible to %s in function %q",
+				newValue, newValue.fn.Name, stmt.OpType, stmt.Function.Name)
+		}
+	}
+	shapeChanged := !v.shape.Equal(newValue.shape)
+	for _, stmt := range users {
+		if stmt == newValue.stmt {
+			continue
+		}
+		replacement := newValue
+		if newValue.fn != stmt.Function {
+			// Reference to a value of a parent function, see Function.UseParentValue.
+			replacement = &Value{
+				fn:    stmt.Function,
+				name:  newValue.name,
+				shape: newValue.shape,
+			}
+		}
+		// The inputs slice may be shared with the caller of the op (e.g. variadic arguments), so it's copied
+		// before being changed.
+		stmt.Inputs = slices.Clone(stmt.Inputs)
+		for i, input := range stmt.Inputs {
+			if !input.refersTo(v) {
+				continue
+			}
+			stmt.Inputs[i] = replacement
+			if stmt.OpType == optypes.FuncReturn && i < len(stmt.Function.Outputs) {
+				output := stmt.Function.Outputs[i]
+				output.name = newValue.name
+				output.shape = newValue.shape
+			}
+		}
+		if shapeChanged {
+			stmt.needsShapeInference = true
+		}
+	}
+	return nil
+}
+
+// Index returns the position of the statement in its function, or -1 if it is not part of the function
+// (e.g.: if it has been deleted).
+func (s *Statement) Index() int {
+	return slices.Index(s.Function.Statements, s)
+}
+
+// TopologicalOrder returns the statements of the function sorted such that every statement comes after the
+// statements that produce its inputs -- including values of the function used inside the statement's closures.
+//
+// Among statements with no dependencies between them, the current order of the statements is preserved.
+// So for a program that was built with the usual ops it returns the statements in their current order.
+//
+// It returns an error if there is a cycle or if a statement uses a value that is not defined.
+func (fn *Function) TopologicalOrder() ([]*Statement, error) {
+	// Map values names to the index of the statement that produces them.
+	producers := make(map[string]int)
+	for ii, stmt := range fn.Statements {
+		for _, output := range stmt.Outputs {
+			producers[output.name] = ii
+		}
+	}
+	known := make(map[string]bool)
+	for _, input := range fn.Inputs {
+		known[input.name] = true
+	}
+
+	// Collect the dependencies of each statement.
+	numStatements := len(fn.Statements)
+	numPending := make([]int, numStatements)
+	dependents := make([][]int, numStatements)
+	for ii, stmt := range fn.Statements {
+		deps := make(map[int]bool)
+		var err error
+		addDep := func(input *Value) {
+			if producerIdx, found := producers[input.name]; found {
+				if producerIdx != ii {
+					deps[producerIdx] = true
+				}
+				return
+			}
+			if known[input.name] || input.fn != fn || input.stmt == nil {
+				// Input of fn, or a value of a closure or of an ancestor function.
+				return
+			}
+			if err == nil {
+				err = errors.Errorf("statement #%d (%s) of function %q uses undefined value %s",
+					ii, stmt.OpType, fn.Name, input)
+			}
+		}
+		for _, input := range stmt.Inputs {
+			addDep(input)
+		}
+		for _, closure := range stmt.FunctionParameters {
+			closure.walkStatementsInOrder(true, func(closureStmt *Statement) {
+				for _, input := range closureStmt.Inputs {
+					// Only references to values of fn matter: names are unique across fn and its closures.
+					if _, found := producers[input.name]; found {
+						addDep(input)
+					}
+				}
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		numPending[ii] = len(deps)
+		for depIdx := range deps {
+			dependents[depIdx] = append(dependents[depIdx], ii)
+		}
+	}
+
+	// Kahn's algorithm, always taking the ready statement with the lowest index, to preserve the current order.
+	order := make([]*Statement, 0, numStatements)
+	ready := &intMinHeap{}
+	for ii := range numStatements {
+		if numPending[ii] == 0 {
+			heap.Push(ready, ii)
+		}
+	}
+	for ready.Len() > 0 {
+		next := heap.Pop(ready).(int)
+		order = append(order, fn.Statements[next])
+		for _, dependent := range dependents[next] {
+			numPending[dependent]--
+			if numPending[dependent] == 0 {
+				heap.Push(ready, dependent)
+			}
+		}
+	}
+	if len(order) < numStatements {
+		return nil, errors.Errorf("function %q has a cycle in its statements", fn.Name)
+	}
+	return order, nil
+}
+
+// intMinHeap implements heap.Interface for ints.
+type intMinHeap []int
+
+func (h intMinHeap) Len() int           { return len(h) }
+func (h intMinHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intMinHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intMinHeap) Push(x any)        { *h = append(*h, x.(int)) }
+func (h *intMinHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// SortStatements reorders the statements of the function (not of its closures) in topological order.
+// See TopologicalOrder.
+func (fn *Function) SortStatements() error {
+	order, err := fn.TopologicalOrder()
+	if err != nil {
+		return err
+	}
+	fn.Statements = order
+	return nil
+}
+
+// Walk calls visitor for each statement of the function in topological order (see TopologicalOrder).
+//
+// The statements of the closures of a statement (e.g.: the body of a While) are visited, also in topological
+// order, just before the statement itself.
+//
+// The visitor must not insert or delete statements: collect the statements to edit first, and edit them
+// after Walk returns. If visitor returns an error, the walk is interrupted and the error is returned.
+func (fn *Function) Walk(visitor func(stmt *Statement) error) error {
+	order, err := fn.TopologicalOrder()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range order {
+		for _, closure := range stmt.FunctionParameters {
+			if err := closure.Walk(visitor); err != nil {
+				return err
+			}
+		}
+		if err := visitor(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertBefore calls build to create new operations in the function, and moves the statements created
+// just before the anchor statement.
+//
+// It can be used in functions that have already returned, and it is the way to create new ops in
+// a program being rewritten. Example that doubles the value x just before it is used by stmt:
+//
+//	err := fn.InsertBefore(stmt, func() error {
+//		doubled, err := stablehlo.Add(x, x)
+//		if err != nil {
+//			return err
+//		}
+//		return x.ReplaceAllUsesWith(doubled)
+//	})
+func (fn *Function) InsertBefore(anchor *Statement, build func() error) error {
+	return fn.insertAt(anchor, 0, build)
+}
+
+// InsertAfter calls build to create new operations in the function, and moves the statements created
+// just after the anchor statement.
+//
+// See InsertBefore for details.
+func (fn *Function) InsertAfter(anchor *Statement, build func() error) error {
+	return fn.insertAt(anchor, 1, build)
+}
+
+// insertAt implements InsertBefore (offset=0) and InsertAfter (offset=1).
+func (fn *Function) insertAt(anchor *Statement, offset int, build func() error) error {
+	if anchor == nil || anchor.Function != fn {
+		return errors.Errorf("anchor statement is not part of function %q", fn.Name)
+	}
+	if offset == 1 && anchor.OpType == optypes.FuncReturn {
+		return errors.Errorf("cannot insert statements after the return statement of function %q", fn.Name)
+	}
+	if anchor.Index() < 0 {
+		return errors.Errorf("anchor statement %s is no longer part of function %q", anchor.OpType, fn.Name)
+	}
+	returned := fn.Returned
+	numStatements := len(fn.Statements)
+	fn.Returned = false
+	err := build()
+	fn.Returned = returned
+	newStatements := slices.Clone(fn.Statements[numStatements:])
+	fn.Statements = fn.Statements[:numStatements]
+	pos := anchor.Index() + offset
+	fn.Statements = slices.Insert(fn.Statements, pos, newStatements...)
+	if err != nil {
+		return errors.WithMessagef(err, "while inserting statements in function %q", fn.Name)
+	}
+	return nil
+}
+
+// DeleteStatement removes the statement from the function.
+//
+// It returns an error if any of its outputs are still used, or if it is the return statement of the function.
+func (fn *Function) DeleteStatement(stmt *Statement) error {
+	if stmt.Function != fn {
+		return errors.Errorf("statement %s is not part of function %q", stmt.OpType, fn.Name)
+	}
+	if stmt.OpType == optypes.FuncReturn {
+		return errors.Errorf("cannot delete the return statement of function %q", fn.Name)
+	}
+	idx := stmt.Index()
+	if idx < 0 {
+		return errors.Errorf("statement %s was already deleted from function %q", stmt.OpType, fn.Name)
+	}
+	for _, output := range stmt.Outputs {
+		if users := output.Users(); len(users) > 0 {
+			return errors.Errorf("cannot delete statement %s of function %q, its output %s is still used by %d statement(s), "+
+				"first one is %s", stmt.OpType, fn.Name, output, len(users), users[0].OpType)
+		}
+	}
+	fn.Statements = slices.Delete(fn.Statements, idx, idx+1)
+	fn.values = slices.DeleteFunc(fn.values, func(v *Value) bool { return v.stmt == stmt })
+	return nil
+}
+
+// InferShapes re-runs shape inference on the statements affected by edits that changed the shapes of their
+// operands (see Value.ReplaceAllUsesWith), and propagates the changes to the statements using their outputs.
+//
+// The output shapes can only be re-inferred for ops whose output shapes are determined by the shapes of their
+// operands: the standard unary and binary ops, Compare, Select, Clamp, Convert (which keeps its target dtype),
+// IsFinite, Complex, Real, Imag, OptimizationBarrier, ShardingConstraint, Reshard, ShardingGroup (which has no
+// outputs) and the return statement (which updates the outputs of the function).
+// For any other op affected by a shape change, it returns an error: in that case, rebuild the op with
+// the corresponding function (e.g.: Reshape, DotGeneral) and replace the old one.
+//
+// Notice changing the outputs of the "main" function changes the signature of the program.
+func (fn *Function) InferShapes() error {
+	return fn.Walk(func(stmt *Statement) error {
+		if !stmt.needsShapeInference {
+			return nil
+		}
+		outputShapes, err := inferStatementShapes(stmt)
+		if err != nil {
+			return errors.WithMessagef(err, "while re-inferring shapes of %s in function %q", stmt.OpType, stmt.Function.Name)
+		}
+		stmt.needsShapeInference = false
+		if stmt.OpType == optypes.FuncReturn {
+			for i, input := range stmt.Inputs {
+				if i < len(stmt.Function.Outputs) {
+					stmt.Function.Outputs[i].shape = input.shape
+				}
+			}
+			return nil
+		}
+		for i, output := range stmt.Outputs {
+			if output.shape.Equal(outputShapes[i]) {
+				continue
+			}
+			output.shape = outputShapes[i]
+			for _, user := range output.Users() {
+				for _, input := range user.Inputs {
+					if input != output && input.refersTo(output) {
+						input.shape = output.shape
+					}
+				}
+				user.needsShapeInference = true
+			}
+		}
+		return nil
+	})
+}
+
+// inferStatementShapes returns the output shapes of the statement, given the current shapes of its inputs.
+func inferStatementShapes(stmt *Statement) ([]shapes.Shape, error) {
+	inputShapes := valuesToShapes(stmt.Inputs)
+	var output shapes.Shape
+	var err error
+	switch {
+	case stmt.OpType == optypes.FuncReturn || stmt.OpType == optypes.ShardingGroup:
+		return nil, nil
+	case stmt.OpType == optypes.OptimizationBarrier:
+		return inputShapes, nil
+	case stmt.OpType == optypes.ShardingConstraint || stmt.OpType == optypes.Reshard:
+		// The sharding attribute depends on the rank of the operand, so it is rendered again.
+		params := stmt.params.(*shardingParams)
+		if err = params.shardingSpec.ValidateShape(inputShapes[0]); err != nil {
+			return nil, err
+		}
+		stmt.Attributes["sharding"] = literalStr(params.shardingSpec.ToValueAttribute(inputShapes[0]))
+		return inputShapes, nil
+	case stmt.OpType == optypes.Compare:
+		if len(inputShapes) != 2 {
+			return nil, errors.Errorf("expected 2 operands, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.BinaryOp(stmt.OpType, inputShapes[0], inputShapes[1])
+		output.DType = dtypes.Bool
+	case shapeinference.StandardBinaryOperations.Has(stmt.OpType):
+		if len(inputShapes) != 2 {
+			return nil, errors.Errorf("expected 2 operands, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.BinaryOp(stmt.OpType, inputShapes[0], inputShapes[1])
+	case shapeinference.StandardUnaryOperations.Has(stmt.OpType):
+		if len(inputShapes) != 1 {
+			return nil, errors.Errorf("expected 1 operand, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.UnaryOp(stmt.OpType, inputShapes[0])
+	case stmt.OpType == optypes.Convert:
+		if len(inputShapes) != 1 {
+			return nil, errors.Errorf("expected 1 operand, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.Convert(inputShapes[0], stmt.Outputs[0].shape.DType)
+	case stmt.OpType == optypes.Select:
+		if len(inputShapes) != 3 {
+			return nil, errors.Errorf("expected 3 operands, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.Select(inputShapes[0], inputShapes[1], inputShapes[2])
+	case stmt.OpType == optypes.Clamp:
+		if len(inputShapes) != 3 {
+			return nil, errors.Errorf("expected 3 operands, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.Clamp(inputShapes[0], inputShapes[1], inputShapes[2])
+	case stmt.OpType == optypes.IsFinite:
+		if len(inputShapes) != 1 {
+			return nil, errors.Errorf("expected 1 operand, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.IsFinite(inputShapes[0])
+	case stmt.OpType == optypes.Complex:
+		if len(inputShapes) != 2 {
+			return nil, errors.Errorf("expected 2 operands, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.Complex(inputShapes[0], inputShapes[1])
+	case stmt.OpType == optypes.Real || stmt.OpType == optypes.Imag:
+		if len(inputShapes) != 1 {
+			return nil, errors.Errorf("expected 1 operand, got %d", len(inputShapes))
+		}
+		output, err = shapeinference.RealOrImag(inputShapes[0])
+	default:
+		return nil, errors.Errorf("shape inference of %s after its operands changed shape is not supported, "+
+			"rebuild the op instead", stmt.OpType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []shapes.Shape{output}, nil
+}
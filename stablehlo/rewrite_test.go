@@ -0,0 +1,116 @@
+package stablehlo
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewrite(t *testing.T) {
+	t.Run("precision casting", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		y := must1(Multiply(x, x))
+		z := must1(Add(y, y))
+		require.NoError(t, fn.Return(z))
+
+		require.Len(t, x.Users(), 1)
+		require.Len(t, y.Users(), 1)
+		require.Equal(t, y.Statement(), x.Users()[0])
+
+		// Insert a conversion of x to Float16 before its first use, and replace all other uses of x.
+		err := fn.InsertBefore(y.Statement(), func() error {
+			xHalf, err := Convert(x, dtypes.Float16)
+			if err != nil {
+				return err
+			}
+			return x.ReplaceAllUsesWith(xHalf)
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, y.Statement().Index())
+		require.NoError(t, fn.InferShapes())
+		require.Equal(t, dtypes.Float16, z.Shape().DType)
+		require.Equal(t, dtypes.Float16, fn.Outputs[0].Shape().DType)
+
+		var visited []string
+		require.NoError(t, fn.Walk(func(stmt *Statement) error {
+			visited = append(visited, stmt.OpType.String())
+			return nil
+		}))
+		require.Equal(t, []string{"Convert", "Multiply", "Add", "FuncReturn"}, visited)
+
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `"stablehlo.convert"(%x) : (tensor<3xf32>) -> tensor<3xf16>`)
+		require.Contains(t, program, `-> tensor<3xf16> {`)
+	})
+
+	t.Run("delete and sort", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		neg := must1(Negate(x))
+		unused := must1(Abs(x))
+		require.NoError(t, fn.Return(neg))
+
+		err := fn.DeleteStatement(neg.Statement())
+		require.ErrorContains(t, err, "still used")
+		require.NoError(t, fn.DeleteStatement(unused.Statement()))
+		require.Len(t, fn.Statements, 2)
+
+		// Put the return statement first: TopologicalOrder should still return it last.
+		slices.Reverse(fn.Statements)
+		require.NoError(t, fn.SortStatements())
+		require.Equal(t, neg.Statement(), fn.Statements[0])
+		program := string(must1(b.Build()))
+		require.False(t, strings.Contains(program, "stablehlo.abs"))
+	})
+
+	t.Run("closures", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		pred := must1(fn.NamedInput("pred", shapes.Make(dtypes.Bool)))
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32)))
+		y := must1(Negate(x))
+
+		trueBranch := fn.Closure()
+		require.NoError(t, trueBranch.Return(must1(Abs(must1(trueBranch.UseParentValue(y))))))
+		falseBranch := fn.Closure()
+		require.NoError(t, falseBranch.Return(must1(falseBranch.UseParentValue(x))))
+		results := must1(If(pred, trueBranch, falseBranch))
+		require.NoError(t, fn.Return(results...))
+
+		// y is only used inside the trueBranch closure.
+		users := y.Users()
+		require.Len(t, users, 1)
+		require.Equal(t, trueBranch, users[0].Function)
+
+		// Moving y after the If statement breaks the order, and TopologicalOrder must fix it.
+		fn.Statements[0], fn.Statements[1] = fn.Statements[1], fn.Statements[0]
+		order := must1(fn.TopologicalOrder())
+		require.Equal(t, y.Statement(), order[0])
+
+		// Replace y by x inside the closure.
+		require.NoError(t, y.ReplaceAllUsesWith(x))
+		require.Empty(t, y.Users())
+		require.Len(t, x.Users(), 3) // Negate, Abs in trueBranch and Return in falseBranch.
+	})
+
+	t.Run("unsupported shape inference", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+		r := must1(Reshape(x, shapes.Make(dtypes.Float32, 6)))
+		require.NoError(t, fn.Return(r))
+		require.NoError(t, fn.InsertBefore(r.Statement(), func() error {
+			return x.ReplaceAllUsesWith(must1(Convert(x, dtypes.Float64)))
+		}))
+		require.ErrorContains(t, fn.InferShapes(), "not supported")
+	})
+}
@@ -0,0 +1,391 @@
+package stablehlo
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+)
+
+// ShardingConstraint returns the operand unchanged, but constrains its sharding (for distributed computation) to
+// the given shardingSpec.
+//
+// Shardy propagates the constraint to the values around it, and it's typically used to pin the sharding of
+// intermediary values (e.g. activations) for which the automatic propagation picks a poor layout.
+// Use Reshard instead if the value must be resharded exactly at this point of the program.
+//
+// The shardingSpec must use one of the meshes configured with Builder.WithShardy.
+func ShardingConstraint(operand *Value, shardingSpec *shardy.ShardingSpec) (*Value, error) {
+	return shardingOp(optypes.ShardingConstraint, operand, shardingSpec)
+}
+
+// Reshard returns the operand unchanged, but resharded (for distributed computation) to the given shardingSpec.
+//
+// Unlike ShardingConstraint, the sharding of the operand is not affected: the result is explicitly
+// resharded, which may require communication between the devices.
+//
+// The shardingSpec must use one of the meshes configured with Builder.WithShardy.
+func Reshard(operand *Value, shardingSpec *shardy.ShardingSpec) (*Value, error) {
+	return shardingOp(optypes.Reshard, operand, shardingSpec)
+}
+
+// shardingOp implements ShardingConstraint and Reshard.
+func shardingOp(op optypes.OpType, operand *Value, shardingSpec *shardy.ShardingSpec) (*Value, error) {
+	if shardingSpec == nil {
+		return nil, errors.Errorf("%s requires a non-nil sharding spec", op)
+	}
+	fn := operand.fn
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	if err := fn.Builder.validateShardingSpec(shardingSpec, operand.shape); err != nil {
+		return nil, errors.WithMessagef(err, "invalid sharding spec for %s of %s", op, operand.shape)
+	}
+	stmt := fn.addOp(op, operand.shape, operand)
+	stmt.Attributes = map[string]any{
+		"sharding": literalStr(shardingSpec.ToValueAttribute(operand.shape)),
+	}
+	stmt.params = &shardingParams{shardingSpec: shardingSpec}
+	return stmt.Outputs[0], nil
+}
+
+// NewShardingGroup creates a new sharding group (for distributed computation) with the given values, and
+// returns its ID. More values can be added later with AddToShardingGroup.
+//
+// Shardy gives all the values of a sharding group the same sharding, e.g. to make a parameter and its
+// optimizer state share the sharding chosen by the propagation. The values must have the same dimensions,
+// but they can have different dtypes.
+func (b *Builder) NewShardingGroup(values ...*Value) (groupID int64, err error) {
+	groupID = int64(len(b.shardingGroupsDims))
+	b.shardingGroupsDims = append(b.shardingGroupsDims, nil)
+	if err = b.AddToShardingGroup(groupID, values...); err != nil {
+		b.shardingGroupsDims = b.shardingGroupsDims[:groupID]
+		return 0, err
+	}
+	return groupID, nil
+}
+
+// AddToShardingGroup adds the values to the sharding group groupID, see NewShardingGroup.
+// Each value is added with a "sdy.sharding_group" op in its function.
+func (b *Builder) AddToShardingGroup(groupID int64, values ...*Value) error {
+	if len(b.meshes) == 0 {
+		return errors.New("sharding groups require a program configured with Builder.WithShardy")
+	}
+	if groupID < 0 || groupID >= int64(len(b.shardingGroupsDims)) {
+		return errors.Errorf("invalid sharding group ID %d, it must be created with Builder.NewShardingGroup",
+			groupID)
+	}
+	dims := b.shardingGroupsDims[groupID]
+	for i, value := range values {
+		if value.fn.Builder != b {
+			return errors.Errorf("value #%d added to sharding group %d belongs to a different builder", i, groupID)
+		}
+		if value.fn.Returned {
+			return errors.Errorf("cannot add value #%d to sharding group %d after returning, in function %q",
+				i, groupID, value.fn.Name)
+		}
+		if dims == nil {
+			dims = append([]int{}, value.shape.Dimensions...)
+		} else if !slices.Equal(dims, value.shape.Dimensions) {
+			return errors.Errorf("values of sharding group %d must have the same dimensions, got %v and %s",
+				groupID, dims, value.shape)
+		}
+	}
+	for _, value := range values {
+		shardingGroup(groupID, value)
+	}
+	if len(values) > 0 {
+		b.shardingGroupsDims[groupID] = slices.Clone(dims)
+	}
+	return nil
+}
+
+// shardingGroup adds the "sdy.sharding_group" op for value.
+func shardingGroup(groupID int64, value *Value) {
+	stmt := value.fn.addMultiOp(optypes.ShardingGroup, nil, []*Value{value})
+	stmt.Attributes = map[string]any{"group_id": groupID}
+	stmt.params = &shardingGroupParams{groupID: groupID}
+}
+
+// WithSharding annotates the value with the given sharding (for distributed computation).
+// It sets the "sdy.sharding" attribute of the op that created the value, and the other outputs
+// of the op not annotated are left open for Shardy to decide.
+//
+// This method can only be called on values that were created by operations: for function input
+// parameters, use Function.InputWithSharding (or Function.NamedInputWithSharding) instead.
+//
+// The shardingSpec must use one of the meshes configured with Builder.WithShardy.
+// It returns the value itself, so it can be chained.
+func (v *Value) WithSharding(shardingSpec *shardy.ShardingSpec) (*Value, error) {
+	if v.stmt == nil {
+		return nil, errors.Errorf("cannot annotate the sharding of parameter value %s, use Function.InputWithSharding instead", v.name)
+	}
+	if shardingSpec == nil {
+		return nil, errors.New("sharding spec cannot be nil")
+	}
+	if err := v.fn.Builder.validateShardingSpec(shardingSpec, v.shape); err != nil {
+		return nil, errors.WithMessagef(err, "invalid sharding spec for value %s", v.name)
+	}
+	v.sharding = shardingSpec
+	stmt := v.stmt
+	if stmt.Attributes == nil {
+		stmt.Attributes = make(map[string]any)
+	}
+	stmt.Attributes["sdy.sharding"] = stmt.outputShardingsAttribute(shardingSpec.Mesh)
+	return v, nil
+}
+
+// Sharding returns the sharding annotated with Value.WithSharding, or the one given for a function input or
+// output (e.g. with Function.InputWithSharding). It returns nil if not set.
+func (v *Value) Sharding() *shardy.ShardingSpec {
+	return v.sharding
+}
+
+// outputShardingsAttribute renders the "sdy.sharding" attribute of the statement, with the sharding of each of its
+// outputs. The outputs not annotated are fully open on the defaultMesh.
+func (s *Statement) outputShardingsAttribute(defaultMesh *shardy.DeviceMesh) literalStr {
+	specs := make([]*shardy.ShardingSpec, len(s.Outputs))
+	for i, output := range s.Outputs {
+		specs[i] = output.sharding
+		if specs[i] == nil {
+			specs[i] = shardy.NewShardingSpec(defaultMesh)
+			for range output.shape.Rank() {
+				specs[i].Axes = append(specs[i].Axes, shardy.TensorAxisSpec{Opened: true})
+			}
+		}
+	}
+	return shardingPerValueAttribute(specs, valuesToShapes(s.Outputs))
+}
+
+// shardingPerValueAttribute renders the list of shardings for the values with the given shapes, as
+// used by the "sdy.sharding" attribute of ops and by the shardings of ManualComputation.
+func shardingPerValueAttribute(specs []*shardy.ShardingSpec, valuesShapes []shapes.Shape) literalStr {
+	parts := make([]string, len(specs))
+	for i, spec := range specs {
+		parts[i] = strings.TrimPrefix(spec.ToValueAttribute(valuesShapes[i]), "#sdy.sharding")
+	}
+	return literalStr("#sdy.sharding_per_value<[" + strings.Join(parts, ", ") + "]>")
+}
+
+// ManualComputationBuilder is used to build a ManualComputation: see details in ManualComputation.
+type ManualComputationBuilder struct {
+	fn          *Function
+	body        *Function
+	mesh        *shardy.DeviceMesh
+	manualAxes  []string
+	inShardings []*shardy.ShardingSpec
+	operands    []*Value
+}
+
+// ManualComputation opens a region of the program (a "sdy.manual_computation") written at per-device granularity
+// over the given manual mesh axes, inside an otherwise automatically sharded program.
+//
+// Each operand is given to the body with the shape of its shard on each device, according to its sharding spec
+// in inShardings (see shardy.ShardingSpec.ManualShardShape): only the manual axes split the operands, the other
+// (free) mesh axes are still handled by Shardy.
+// All sharding specs must use the same mesh, one of the meshes configured with Builder.WithShardy.
+//
+// It returns a ManualComputationBuilder: define the computation with ops on the closure returned by
+// ManualComputationBuilder.Body, whose inputs are the local shards of the operands, and then call
+// ManualComputationBuilder.Done with the outputs and their shardings.
+// Collectives in the body can use ManualComputationBuilder.ReplicaGroups to communicate along the mesh axes.
+//
+// The body is isolated from the enclosing function: it can't use its values, except through the operands.
+//
+// Example (sum of the shards of x along the "data" axis):
+//
+//	spec := builder.NewShardingSpec().AddShardedAxis("data")
+//	mc := must(ManualComputation([]string{"data"}, []*shardy.ShardingSpec{spec}, x))
+//	body := mc.Body()
+//	groups := must(mc.ReplicaGroups("data"))
+//	config := &types.CollectiveConfig{ChannelType: types.CrossPartition, UseGlobalDeviceIDs: true}
+//	sum := must(AllReduce(body.Inputs, groups, addFn, config))
+//	outputs := must(mc.Done([]*shardy.ShardingSpec{spec}, sum...))
+func ManualComputation(manualAxes []string, inShardings []*shardy.ShardingSpec, operands ...*Value) (
+	*ManualComputationBuilder, error) {
+	op := optypes.ManualComputation
+	if len(operands) == 0 {
+		return nil, errors.Errorf("%s requires at least one operand", op)
+	}
+	if len(inShardings) != len(operands) {
+		return nil, errors.Errorf("%s requires one sharding spec per operand, got %d operands and %d sharding specs",
+			op, len(operands), len(inShardings))
+	}
+	fn, err := innerMostFunction(operands...)
+	if err != nil {
+		return nil, err
+	}
+	if fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, fn.Name)
+	}
+	b := &ManualComputationBuilder{
+		fn:          fn,
+		manualAxes:  manualAxes,
+		inShardings: inShardings,
+		operands:    operands,
+	}
+	localShapes := make([]shapes.Shape, len(operands))
+	for i, operand := range operands {
+		if err := b.validateShardingSpec(inShardings[i], operand.shape); err != nil {
+			return nil, errors.WithMessagef(err, "invalid sharding spec for %s operand #%d", op, i)
+		}
+		localShapes[i], err = inShardings[i].ManualShardShape(operand.shape, manualAxes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "while computing the local shape of %s operand #%d", op, i)
+		}
+	}
+	for i, axisName := range manualAxes {
+		if _, err := b.mesh.AxisSize(axisName); err != nil {
+			return nil, errors.WithMessagef(err, "invalid manual axis for %s", op)
+		}
+		if slices.Index(manualAxes, axisName) != i {
+			return nil, errors.Errorf("%s manual axis %q given more than once", op, axisName)
+		}
+	}
+
+	b.body = fn.Closure()
+	b.body.isManualComputationBody = true
+	for _, shape := range localShapes {
+		if _, err := b.body.Input(shape); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// validateShardingSpec checks that the spec is valid for the shape, and that it uses the same mesh as the other
+// specs of the manual computation.
+func (b *ManualComputationBuilder) validateShardingSpec(spec *shardy.ShardingSpec, shape shapes.Shape) error {
+	if spec == nil {
+		return errors.New("sharding spec cannot be nil")
+	}
+	if b.mesh == nil {
+		b.mesh = spec.Mesh
+	} else if spec.Mesh != b.mesh {
+		return errors.Errorf("all sharding specs must use the same mesh, got %q and %q", b.mesh.Name(), spec.Mesh.Name())
+	}
+	return b.fn.Builder.validateShardingSpec(spec, shape)
+}
+
+// Body returns the closure with the per-device computation.
+// Its inputs hold the local shards of the operands of the ManualComputation.
+func (b *ManualComputationBuilder) Body() *Function {
+	return b.body
+}
+
+// ReplicaGroups returns the replica groups for collectives in the body communicating along the given mesh axes:
+// each group holds the devices that differ only in their position along these axes.
+//
+// The groups hold logical device ids (see shardy.DeviceMesh.SetLogicalDeviceAssignment), so the collectives
+// should be configured with types.CollectiveConfig{ChannelType: types.CrossPartition, UseGlobalDeviceIDs: true}.
+func (b *ManualComputationBuilder) ReplicaGroups(meshAxes ...string) ([][]int, error) {
+	for _, axisName := range meshAxes {
+		if !slices.Contains(b.manualAxes, axisName) {
+			return nil, errors.Errorf("mesh axis %q is not one of the manual axes %v", axisName, b.manualAxes)
+		}
+	}
+	groups, err := b.mesh.ComputeReplicaGroups(meshAxes)
+	if err != nil {
+		return nil, err
+	}
+	if assignment := b.mesh.LogicalDeviceAssignment(); assignment != nil {
+		for _, group := range groups {
+			for i, flatPosition := range group {
+				group[i] = assignment[flatPosition]
+			}
+		}
+	}
+	return groups, nil
+}
+
+// Done returns the given outputs from the body and creates the "sdy.manual_computation" op.
+//
+// The outputs are the local shards computed on each device, and outShardings (one per output) define how
+// they are assembled into the global results returned.
+func (b *ManualComputationBuilder) Done(outShardings []*shardy.ShardingSpec, outputs ...*Value) ([]*Value, error) {
+	op := optypes.ManualComputation
+	if len(outShardings) != len(outputs) {
+		return nil, errors.Errorf("%s requires one sharding spec per output, got %d outputs and %d sharding specs",
+			op, len(outputs), len(outShardings))
+	}
+	if b.fn.Returned {
+		return nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			op, b.fn.Name)
+	}
+	if err := b.body.Return(outputs...); err != nil {
+		return nil, err
+	}
+	if err := b.body.checkIsolated(); err != nil {
+		return nil, errors.WithMessagef(err, "invalid %s body", op)
+	}
+	outputShapes := make([]shapes.Shape, len(outputs))
+	for i, output := range outputs {
+		var err error
+		outputShapes[i], err = outShardings[i].ManualGlobalShape(output.shape, b.manualAxes)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "while computing the global shape of %s output #%d", op, i)
+		}
+		if err = b.validateShardingSpec(outShardings[i], outputShapes[i]); err != nil {
+			return nil, errors.WithMessagef(err, "invalid sharding spec for %s output #%d", op, i)
+		}
+	}
+
+	// Manual axes are listed in the order of the mesh.
+	manualAxes := slices.Clone(b.manualAxes)
+	meshAxesNames := b.mesh.AxesNames()
+	slices.SortFunc(manualAxes, func(a, b string) int {
+		return slices.Index(meshAxesNames, a) - slices.Index(meshAxesNames, b)
+	})
+	quotedAxes := make([]string, len(manualAxes))
+	for i, axisName := range manualAxes {
+		quotedAxes[i] = strconv.Quote(axisName)
+	}
+
+	stmt := b.fn.addMultiOp(op, outputShapes, b.operands)
+	stmt.Attributes = map[string]any{
+		"in_shardings":  shardingPerValueAttribute(b.inShardings, valuesToShapes(b.operands)),
+		"out_shardings": shardingPerValueAttribute(outShardings, outputShapes),
+		"manual_axes":   literalStr("#sdy<manual_axes{" + strings.Join(quotedAxes, ", ") + "}>"),
+	}
+	stmt.params = &manualComputationParams{
+		manualAxes:   b.manualAxes,
+		inShardings:  b.inShardings,
+		outShardings: outShardings,
+	}
+	stmt.AddFunctionParameter("body", b.body)
+	return stmt.Outputs, nil
+}
+
+// checkIsolated returns an error if the function (or any of its closures) uses values of its parent functions.
+func (fn *Function) checkIsolated() error {
+	defined := make(map[string]bool)
+	fn.walkStatementsInOrder(true, func(stmt *Statement) {
+		for _, closure := range stmt.FunctionParameters {
+			for _, input := range closure.Inputs {
+				defined[input.name] = true
+			}
+		}
+		for _, output := range stmt.Outputs {
+			defined[output.name] = true
+		}
+	})
+	for _, input := range fn.Inputs {
+		defined[input.name] = true
+	}
+	var err error
+	fn.walkStatementsInOrder(true, func(stmt *Statement) {
+		for _, input := range stmt.Inputs {
+			if err == nil && !defined[input.name] {
+				err = errors.Errorf("%s uses value %%%s from an enclosing function, pass it as an operand instead",
+					stmt.OpType, input.name)
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,689 @@
+package stablehlo
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+)
+
+// This file implements a best-effort preview of the sharding propagation done by Shardy, see Builder.PreviewShardings.
+
+// Kinds of communication reported by ShardingPreview.
+const (
+	// CommunicationAllGather is used when a value must be (partially) replicated: each device gathers the
+	// shards it doesn't have.
+	CommunicationAllGather = "all-gather"
+
+	// CommunicationAllToAll is used when a value must be resharded along different mesh axes.
+	CommunicationAllToAll = "all-to-all"
+
+	// CommunicationAllReduce is used for partial results that must be summed (or reduced) across devices, e.g.
+	// a DotGeneral whose contracting axes are sharded.
+	CommunicationAllReduce = "all-reduce"
+)
+
+// ShardingCommunication is a collective communication estimated by Builder.PreviewShardings.
+type ShardingCommunication struct {
+	// Statement that requires the communication.
+	Statement *Statement
+
+	// Value communicated: an operand of the statement that needs to be resharded, or an output of the statement
+	// (for CommunicationAllReduce).
+	Value *Value
+
+	// Kind of communication: CommunicationAllGather, CommunicationAllToAll or CommunicationAllReduce.
+	Kind string
+
+	// From and To are the shardings of Value before and after the communication.
+	// For CommunicationAllReduce they are the same.
+	From, To *shardy.ShardingSpec
+
+	// Bytes is the estimated number of bytes each device receives.
+	Bytes int64
+}
+
+// ShardingPreview is the result of Builder.PreviewShardings.
+type ShardingPreview struct {
+	// Function analyzed: the "main" function of the program.
+	Function *Function
+
+	// Communications lists the estimated collective communications, in program order.
+	Communications []ShardingCommunication
+
+	// values lists the values of Function (inputs first, then the outputs of each statement) in program order.
+	values []*Value
+
+	// specs holds the inferred sharding of each value, by name.
+	specs map[string]*shardy.ShardingSpec
+}
+
+// PreviewShardings runs a best-effort sharding propagation over the "main" function of the program, and estimates
+// the collective communication required by the resulting shardings.
+//
+// It is meant for debugging Shardy programs (configured with Builder.WithShardy): it doesn't reproduce the XLA
+// propagation exactly, but it shows how the shardings of the inputs, outputs, ShardingConstraint, Reshard and
+// Value.WithSharding annotations flow through the program, and where large tensors end up replicated.
+// Inputs without a sharding spec are considered replicated, as in Function.NamedInputWithSharding.
+//
+// Values inside closures (e.g. the bodies of While or Reduce) are not analyzed, and ops without a propagation
+// rule (e.g. Gather or While) produce replicated values.
+func (b *Builder) PreviewShardings() (*ShardingPreview, error) {
+	if len(b.meshes) == 0 {
+		return nil, errors.New("PreviewShardings requires a program configured with Builder.WithShardy")
+	}
+	idx := slices.IndexFunc(b.functions, func(fn *Function) bool { return fn.Name == MainFunctionName })
+	if idx == -1 {
+		return nil, errors.Errorf("PreviewShardings requires a %q function", MainFunctionName)
+	}
+	fn := b.functions[idx]
+	if !fn.Returned {
+		return nil, errors.Errorf("PreviewShardings requires function %q to have returned", fn.Name)
+	}
+	p := &shardingPropagator{
+		fn:          fn,
+		defaultMesh: b.meshes[0],
+		specs:       make(map[string]*shardy.ShardingSpec),
+		fixed:       make(map[string]bool),
+	}
+	p.seed()
+	p.forward()
+	p.backward()
+	p.record = true
+	p.forward()
+
+	preview := &ShardingPreview{
+		Function:       fn,
+		Communications: p.communications,
+		specs:          p.specs,
+	}
+	preview.values = append(preview.values, fn.Inputs...)
+	for _, stmt := range fn.Statements {
+		preview.values = append(preview.values, stmt.Outputs...)
+	}
+	for _, v := range preview.values {
+		if p.specs[v.name] == nil {
+			// Values without information are replicated.
+			p.specs[v.name] = emptyShardingSpec(p.defaultMesh, v.shape.Rank())
+		}
+	}
+	return preview, nil
+}
+
+// Sharding returns the inferred sharding of a value of the analyzed function, or nil if the value is not part of it.
+func (p *ShardingPreview) Sharding(v *Value) *shardy.ShardingSpec {
+	return p.specs[v.name]
+}
+
+// CommunicationBytes returns the total estimated number of bytes received by each device.
+func (p *ShardingPreview) CommunicationBytes() int64 {
+	var total int64
+	for _, comm := range p.Communications {
+		total += comm.Bytes
+	}
+	return total
+}
+
+// ReplicatedValues returns the values (in program order) that are fully replicated across the devices and
+// use at least minBytes of memory -- they are usually accidental, and use minBytes in every device.
+func (p *ShardingPreview) ReplicatedValues(minBytes int64) []*Value {
+	var replicated []*Value
+	for _, v := range p.values {
+		if p.specs[v.name].IsReplicated() && shapeBytes(v.shape) >= minBytes {
+			replicated = append(replicated, v)
+		}
+	}
+	return replicated
+}
+
+// Report returns a text report with the inferred sharding of each value and the estimated communications.
+func (p *ShardingPreview) Report() string {
+	var buf strings.Builder
+	_ = p.WriteReport(&buf)
+	return buf.String()
+}
+
+// WriteReport writes the text report (see Report) to the writer.
+func (p *ShardingPreview) WriteReport(writer io.Writer) error {
+	var err error
+	w := func(format string, args ...any) {
+		if err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(writer, format, args...)
+	}
+	w("Sharding preview of function %q:\n", p.Function.Name)
+	for _, v := range p.values {
+		spec := p.specs[v.name]
+		w("  %%%s", v.name)
+		if v.stmt != nil {
+			w(" = %s", v.stmt.OpType.ToStableHLO())
+		}
+		w(": %s %s, %s per device\n", v.shape.ShortString(), shardingToString(spec, v.shape),
+			formatBytes(shardBytes(v.shape, spec)))
+	}
+	if len(p.Communications) == 0 {
+		w("No communication.\n")
+		return err
+	}
+	w("Communications (estimated bytes received per device):\n")
+	for _, comm := range p.Communications {
+		w("  %s: %s of %%%s %s", comm.Statement.OpType.ToStableHLO(), comm.Kind, comm.Value.name,
+			comm.Value.shape.ShortString())
+		if comm.Kind != CommunicationAllReduce {
+			w(" from %s to %s", shardingToString(comm.From, comm.Value.shape),
+				shardingToString(comm.To, comm.Value.shape))
+		}
+		w(": %s\n", formatBytes(comm.Bytes))
+	}
+	w("Total communication: %s per device\n", formatBytes(p.CommunicationBytes()))
+	return err
+}
+
+// shardingToString returns the sharding in the format used by the "sdy.sharding_per_value" attribute.
+func shardingToString(spec *shardy.ShardingSpec, shape shapes.Shape) string {
+	return strings.TrimPrefix(spec.ToValueAttribute(shape), "#sdy.sharding")
+}
+
+// formatBytes returns a human-readable number of bytes.
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes)
+	for _, suffix := range []string{"KiB", "MiB", "GiB"} {
+		value /= unit
+		if value < unit || suffix == "GiB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return ""
+}
+
+// shapeBytes returns the memory used by the shape, or 0 for dynamic or tuple shapes.
+func shapeBytes(shape shapes.Shape) int64 {
+	if shape.IsTuple() || slices.Contains(shape.Dimensions, shapes.DimUnknown) {
+		return 0
+	}
+	return int64(shape.Memory())
+}
+
+// shardBytes returns the memory used by each shard of a value with the given shape and sharding.
+func shardBytes(shape shapes.Shape, spec *shardy.ShardingSpec) int64 {
+	bytes := shapeBytes(shape)
+	if spec == nil {
+		return bytes
+	}
+	numShards, err := spec.NumShards(shape.Rank())
+	if err != nil {
+		return bytes
+	}
+	for _, n := range numShards {
+		bytes /= int64(n)
+	}
+	return bytes
+}
+
+// emptyShardingSpec returns a replicated spec with rank axes.
+func emptyShardingSpec(mesh *shardy.DeviceMesh, rank int) *shardy.ShardingSpec {
+	return &shardy.ShardingSpec{Mesh: mesh, Axes: make([]shardy.TensorAxisSpec, rank)}
+}
+
+// normalizedShardingSpec returns a copy of spec with exactly rank axes, and without the "open" marks.
+func normalizedShardingSpec(spec *shardy.ShardingSpec, rank int) *shardy.ShardingSpec {
+	normalized := emptyShardingSpec(spec.Mesh, rank)
+	for axis := range min(rank, len(spec.Axes)) {
+		normalized.Axes[axis].MeshAxes = slices.Clone(spec.Axes[axis].MeshAxes)
+	}
+	return normalized
+}
+
+// sameSharding returns whether the normalized specs a and b shard the tensor in the same way.
+func sameSharding(a, b *shardy.ShardingSpec) bool {
+	if a.Mesh != b.Mesh || len(a.Axes) != len(b.Axes) {
+		return false
+	}
+	for axis := range a.Axes {
+		if !slices.Equal(a.Axes[axis].MeshAxes, b.Axes[axis].MeshAxes) {
+			return false
+		}
+	}
+	return true
+}
+
+// usesMeshAxis returns whether any of the axes of the spec is sharded along the given mesh axis.
+func usesMeshAxis(spec *shardy.ShardingSpec, axisName string) bool {
+	for _, axisSpec := range spec.Axes {
+		for _, meshAxisSpec := range axisSpec.MeshAxes {
+			if meshAxisSpec.AxisName == axisName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shardingPropagator implements Builder.PreviewShardings.
+type shardingPropagator struct {
+	fn          *Function
+	defaultMesh *shardy.DeviceMesh
+
+	// specs holds the normalized sharding of the values, by name. Values not in specs are not known yet.
+	specs map[string]*shardy.ShardingSpec
+
+	// fixed marks the values whose sharding was given by the user, and is not changed by the propagation.
+	fixed map[string]bool
+
+	// record enables recording the communications, only done in the last pass.
+	record         bool
+	communications []ShardingCommunication
+}
+
+// seed sets the shardings given by the user: inputs, sharding ops and values annotated with Value.WithSharding.
+func (p *shardingPropagator) seed() {
+	for _, input := range p.fn.Inputs {
+		p.fixed[input.name] = true
+		if input.sharding == nil {
+			p.specs[input.name] = emptyShardingSpec(p.defaultMesh, input.shape.Rank())
+		} else {
+			p.specs[input.name] = normalizedShardingSpec(input.sharding, input.shape.Rank())
+		}
+	}
+	for _, stmt := range p.fn.Statements {
+		if params, ok := stmt.params.(*shardingParams); ok {
+			output := stmt.Outputs[0]
+			p.fixed[output.name] = true
+			p.specs[output.name] = normalizedShardingSpec(params.shardingSpec, output.shape.Rank())
+		}
+		for _, output := range stmt.Outputs {
+			if output.sharding != nil {
+				p.fixed[output.name] = true
+				p.specs[output.name] = normalizedShardingSpec(output.sharding, output.shape.Rank())
+			}
+		}
+	}
+}
+
+// set sets the sharding of v, if it is not fixed.
+func (p *shardingPropagator) set(v *Value, spec *shardy.ShardingSpec) {
+	if spec == nil || p.fixed[v.name] {
+		return
+	}
+	p.specs[v.name] = spec
+}
+
+// reshard records the communication needed to change the sharding of v, an operand of stmt, from "from" to "to".
+func (p *shardingPropagator) reshard(stmt *Statement, v *Value, from, to *shardy.ShardingSpec) {
+	if !p.record || from == nil || to == nil || sameSharding(from, to) {
+		return
+	}
+	kind := CommunicationAllToAll
+	bytes := shardBytes(v.shape, to)
+	if from.Mesh == to.Mesh {
+		isRefinement, isCoarsening := true, true
+		for axis := range from.Axes {
+			fromAxes, toAxes := from.Axes[axis].MeshAxes, to.Axes[axis].MeshAxes
+			isRefinement = isRefinement && len(fromAxes) <= len(toAxes) && slices.Equal(fromAxes, toAxes[:len(fromAxes)])
+			isCoarsening = isCoarsening && len(toAxes) <= len(fromAxes) && slices.Equal(toAxes, fromAxes[:len(toAxes)])
+		}
+		if isRefinement {
+			// Each device only needs to slice its own shard.
+			return
+		}
+		if isCoarsening {
+			kind = CommunicationAllGather
+			bytes -= shardBytes(v.shape, from)
+		}
+	}
+	p.communications = append(p.communications, ShardingCommunication{
+		Statement: stmt, Value: v, Kind: kind, From: from, To: to, Bytes: bytes})
+}
+
+// allReduce records the all-reduce of the output v of stmt, with partial results in numShards devices.
+func (p *shardingPropagator) allReduce(stmt *Statement, v *Value, spec *shardy.ShardingSpec, numShards int) {
+	if !p.record || numShards <= 1 {
+		return
+	}
+	// A ring all-reduce sends and receives 2*(n-1)/n of the data.
+	bytes := 2 * shardBytes(v.shape, spec) * int64(numShards-1) / int64(numShards)
+	p.communications = append(p.communications, ShardingCommunication{
+		Statement: stmt, Value: v, Kind: CommunicationAllReduce, From: spec, To: spec, Bytes: bytes})
+}
+
+// mergeShardings returns a sharding for a tensor of the given rank combining the given specs: for each axis, the
+// sharding of the first spec that shards it (without reusing mesh axes). It returns nil if all specs are nil.
+func mergeShardings(rank int, specs ...*shardy.ShardingSpec) *shardy.ShardingSpec {
+	var merged *shardy.ShardingSpec
+	for _, spec := range specs {
+		if spec == nil {
+			continue
+		}
+		if merged == nil {
+			merged = emptyShardingSpec(spec.Mesh, rank)
+		}
+		if spec.Mesh != merged.Mesh || len(spec.Axes) != rank {
+			continue
+		}
+		for axis, axisSpec := range spec.Axes {
+			if len(merged.Axes[axis].MeshAxes) > 0 || len(axisSpec.MeshAxes) == 0 {
+				continue
+			}
+			if slices.ContainsFunc(axisSpec.MeshAxes, func(m shardy.MeshAxisSpec) bool {
+				return usesMeshAxis(merged, m.AxisName)
+			}) {
+				continue
+			}
+			merged.Axes[axis].MeshAxes = slices.Clone(axisSpec.MeshAxes)
+		}
+	}
+	return merged
+}
+
+// forward propagates the shardings from the inputs to the outputs of each statement.
+func (p *shardingPropagator) forward() {
+	for _, stmt := range p.fn.Statements {
+		inputSpecs := make([]*shardy.ShardingSpec, len(stmt.Inputs))
+		for i, input := range stmt.Inputs {
+			inputSpecs[i] = p.specs[input.name]
+		}
+		if stmt.OpType == optypes.FuncReturn {
+			for i, input := range stmt.Inputs {
+				if i < len(p.fn.Outputs) && p.fn.Outputs[i].sharding != nil {
+					p.reshard(stmt, input, inputSpecs[i],
+						normalizedShardingSpec(p.fn.Outputs[i].sharding, input.shape.Rank()))
+				}
+			}
+			continue
+		}
+		outputSpecs := p.inferOutputs(stmt, inputSpecs)
+		for i, output := range stmt.Outputs {
+			if outputSpecs == nil || outputSpecs[i] == nil {
+				continue
+			}
+			if p.fixed[output.name] {
+				// The inferred sharding is resharded to the one given by the user.
+				p.reshard(stmt, output, outputSpecs[i], p.specs[output.name])
+				continue
+			}
+			p.specs[output.name] = outputSpecs[i]
+		}
+	}
+}
+
+// inferOutputs returns the sharding of the outputs of stmt, given the shardings of its inputs (which may be nil if
+// not known). It returns nil if the outputs shardings are not known.
+func (p *shardingPropagator) inferOutputs(stmt *Statement, inputSpecs []*shardy.ShardingSpec) []*shardy.ShardingSpec {
+	x := stmt.Inputs
+	if len(stmt.Outputs) == 0 {
+		return nil
+	}
+	output := stmt.Outputs[0]
+	rank := output.shape.Rank()
+	switch {
+	case stmt.OpType == optypes.ShardingConstraint || stmt.OpType == optypes.Reshard:
+		params := stmt.params.(*shardingParams)
+		spec := normalizedShardingSpec(params.shardingSpec, rank)
+		p.reshard(stmt, x[0], inputSpecs[0], spec)
+		return []*shardy.ShardingSpec{spec}
+
+	case stmt.OpType == optypes.OptimizationBarrier:
+		return inputSpecs
+
+	case vmapElementwiseOps[stmt.OpType]:
+		var candidates []*shardy.ShardingSpec
+		for i, input := range x {
+			if input.shape.Rank() == rank {
+				candidates = append(candidates, inputSpecs[i])
+			}
+		}
+		merged := mergeShardings(rank, candidates...)
+		if merged == nil {
+			return nil
+		}
+		for i, input := range x {
+			if input.shape.Rank() == rank {
+				p.reshard(stmt, input, inputSpecs[i], merged)
+			}
+		}
+		return []*shardy.ShardingSpec{merged}
+	}
+
+	if len(inputSpecs) == 0 || inputSpecs[0] == nil {
+		return nil
+	}
+	operandSpec := inputSpecs[0]
+	spec := emptyShardingSpec(operandSpec.Mesh, rank)
+	switch stmt.OpType {
+	case optypes.Transpose:
+		params := stmt.params.(*transposeParams)
+		for axis, operandAxis := range params.permutation {
+			spec.Axes[axis] = operandSpec.Axes[operandAxis]
+		}
+
+	case optypes.Reverse:
+		spec = operandSpec
+
+	case optypes.BroadcastInDim:
+		params := stmt.params.(*broadcastInDimParams)
+		for operandAxis, axis := range params.axesMapping {
+			if x[0].shape.Dimensions[operandAxis] == output.shape.Dimensions[axis] {
+				spec.Axes[axis] = operandSpec.Axes[operandAxis]
+			}
+		}
+
+	case optypes.Reshape:
+		// Axes that are kept (same dimension and same number of elements before them) keep their sharding.
+		operandDims, dims := x[0].shape.Dimensions, output.shape.Dimensions
+		kept := normalizedShardingSpec(operandSpec, len(operandDims))
+		operandPrefix, operandAxis := 1, 0
+		prefix := 1
+		for axis, dim := range dims {
+			for operandAxis < len(operandDims) && operandPrefix < prefix {
+				kept.Axes[operandAxis] = shardy.TensorAxisSpec{}
+				operandPrefix *= operandDims[operandAxis]
+				operandAxis++
+			}
+			if operandAxis < len(operandDims) && operandPrefix == prefix && operandDims[operandAxis] == dim {
+				spec.Axes[axis] = kept.Axes[operandAxis]
+				operandPrefix *= dim
+				operandAxis++
+			}
+			prefix *= dim
+		}
+		for ; operandAxis < len(operandDims); operandAxis++ {
+			kept.Axes[operandAxis] = shardy.TensorAxisSpec{}
+		}
+		p.reshard(stmt, x[0], operandSpec, kept)
+
+	case optypes.Slice, optypes.Pad, optypes.DynamicSlice, optypes.DynamicUpdateSlice, optypes.Concatenate:
+		// Axes that change dimension are not sharded.
+		var candidates []*shardy.ShardingSpec
+		for i, input := range x {
+			if input.shape.Rank() == rank {
+				candidates = append(candidates, inputSpecs[i])
+			}
+		}
+		spec = mergeShardings(rank, candidates...)
+		for axis := range rank {
+			if x[0].shape.Dimensions[axis] != output.shape.Dimensions[axis] {
+				spec.Axes[axis] = shardy.TensorAxisSpec{}
+			}
+		}
+		if params, ok := stmt.params.(*concatenateParams); ok {
+			spec.Axes[params.axis] = shardy.TensorAxisSpec{}
+		}
+		for i, input := range x {
+			if input.shape.Rank() == rank {
+				p.reshard(stmt, input, inputSpecs[i], spec)
+			}
+		}
+
+	case optypes.DotGeneral:
+		return p.inferDotGeneral(stmt, inputSpecs)
+
+	case optypes.Reduce:
+		return p.inferReduce(stmt, inputSpecs)
+
+	default:
+		return nil
+	}
+	return []*shardy.ShardingSpec{spec}
+}
+
+// inferDotGeneral implements inferOutputs for DotGeneral: the output is sharded as the batch and free axes of the
+// operands, and sharded contracting axes require an all-reduce of the output.
+func (p *shardingPropagator) inferDotGeneral(stmt *Statement, inputSpecs []*shardy.ShardingSpec) []*shardy.ShardingSpec {
+	params := stmt.params.(*dotGeneralParams)
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	lhsSpec, rhsSpec := inputSpecs[0], inputSpecs[1]
+	mesh := p.defaultMesh
+	if lhsSpec != nil {
+		mesh = lhsSpec.Mesh
+	} else if rhsSpec != nil {
+		mesh = rhsSpec.Mesh
+	}
+	if lhsSpec == nil || lhsSpec.Mesh != mesh {
+		lhsSpec = emptyShardingSpec(mesh, lhs.shape.Rank())
+	}
+	if rhsSpec == nil || rhsSpec.Mesh != mesh {
+		rhsSpec = emptyShardingSpec(mesh, rhs.shape.Rank())
+	}
+
+	// Contracting axes sharded in the same way on both sides produce partial results: other contracting axes
+	// sharded on one side need to be gathered.
+	newLHSSpec := normalizedShardingSpec(lhsSpec, lhs.shape.Rank())
+	newRHSSpec := normalizedShardingSpec(rhsSpec, rhs.shape.Rank())
+	partialShards := 1
+	for i, lhsAxis := range params.lhsContractingAxes {
+		rhsAxis := params.rhsContractingAxes[i]
+		lhsAxes, rhsAxes := lhsSpec.Axes[lhsAxis].MeshAxes, rhsSpec.Axes[rhsAxis].MeshAxes
+		if len(lhsAxes) > 0 && slices.Equal(lhsAxes, rhsAxes) {
+			numShards, _ := lhsSpec.NumShards(lhs.shape.Rank())
+			partialShards *= numShards[lhsAxis]
+			continue
+		}
+		newLHSSpec.Axes[lhsAxis] = shardy.TensorAxisSpec{}
+		newRHSSpec.Axes[rhsAxis] = shardy.TensorAxisSpec{}
+	}
+
+	// Output axes: batch axes, then lhs free axes, then rhs free axes.
+	outputSpec := emptyShardingSpec(mesh, stmt.Outputs[0].shape.Rank())
+	outputAxis := 0
+	for i, lhsAxis := range params.lhsBatchAxes {
+		axisSpec := newLHSSpec.Axes[lhsAxis]
+		if len(axisSpec.MeshAxes) == 0 {
+			axisSpec = newRHSSpec.Axes[params.rhsBatchAxes[i]]
+		}
+		outputSpec.Axes[outputAxis] = axisSpec
+		outputAxis++
+	}
+	addFreeAxes := func(spec *shardy.ShardingSpec, rank int, contractingAxes, batchAxes []int) {
+		for axis := range rank {
+			if slices.Contains(contractingAxes, axis) || slices.Contains(batchAxes, axis) {
+				continue
+			}
+			axisSpec := spec.Axes[axis]
+			if slices.ContainsFunc(axisSpec.MeshAxes, func(m shardy.MeshAxisSpec) bool {
+				return usesMeshAxis(outputSpec, m.AxisName)
+			}) {
+				// A mesh axis can only be used once: this operand axis must be gathered.
+				spec.Axes[axis] = shardy.TensorAxisSpec{}
+			} else {
+				outputSpec.Axes[outputAxis] = axisSpec
+			}
+			outputAxis++
+		}
+	}
+	addFreeAxes(newLHSSpec, lhs.shape.Rank(), params.lhsContractingAxes, params.lhsBatchAxes)
+	addFreeAxes(newRHSSpec, rhs.shape.Rank(), params.rhsContractingAxes, params.rhsBatchAxes)
+
+	p.reshard(stmt, lhs, inputSpecs[0], newLHSSpec)
+	p.reshard(stmt, rhs, inputSpecs[1], newRHSSpec)
+	p.allReduce(stmt, stmt.Outputs[0], outputSpec, partialShards)
+	return []*shardy.ShardingSpec{outputSpec}
+}
+
+// inferReduce implements inferOutputs for Reduce: the reduced axes are removed from the sharding, and if they were
+// sharded the outputs require an all-reduce.
+func (p *shardingPropagator) inferReduce(stmt *Statement, inputSpecs []*shardy.ShardingSpec) []*shardy.ShardingSpec {
+	params := stmt.params.(*reduceParams)
+	numInputs := len(stmt.Outputs)
+	outputSpecs := make([]*shardy.ShardingSpec, numInputs)
+	for i := range numInputs {
+		input, spec := stmt.Inputs[i], inputSpecs[i]
+		if spec == nil {
+			continue
+		}
+		numShards, _ := spec.NumShards(input.shape.Rank())
+		outputSpecs[i] = emptyShardingSpec(spec.Mesh, stmt.Outputs[i].shape.Rank())
+		outputAxis, partialShards := 0, 1
+		for axis, axisSpec := range spec.Axes {
+			if slices.Contains(params.axes, axis) {
+				if numShards != nil {
+					partialShards *= numShards[axis]
+				}
+				continue
+			}
+			outputSpecs[i].Axes[outputAxis] = axisSpec
+			outputAxis++
+		}
+		p.allReduce(stmt, stmt.Outputs[i], outputSpecs[i], partialShards)
+	}
+	return outputSpecs
+}
+
+// backward propagates the shardings from the outputs to the inputs of the statements, for the inputs whose
+// sharding is not known yet (e.g. constants).
+func (p *shardingPropagator) backward() {
+	for _, stmt := range slices.Backward(p.fn.Statements) {
+		x := stmt.Inputs
+		switch {
+		case stmt.OpType == optypes.FuncReturn:
+			for i, input := range x {
+				if i < len(p.fn.Outputs) && p.fn.Outputs[i].sharding != nil && p.specs[input.name] == nil {
+					p.set(input, normalizedShardingSpec(p.fn.Outputs[i].sharding, input.shape.Rank()))
+				}
+			}
+			continue
+		case len(stmt.Outputs) == 0:
+			continue
+		}
+		outputSpec := p.specs[stmt.Outputs[0].name]
+		if outputSpec == nil {
+			continue
+		}
+		switch {
+		case stmt.OpType == optypes.ShardingConstraint, stmt.OpType == optypes.Reverse:
+			if p.specs[x[0].name] == nil {
+				p.set(x[0], outputSpec)
+			}
+		case stmt.OpType == optypes.OptimizationBarrier:
+			for i, input := range x {
+				if p.specs[input.name] == nil {
+					p.set(input, p.specs[stmt.Outputs[i].name])
+				}
+			}
+		case stmt.OpType == optypes.Transpose:
+			if p.specs[x[0].name] == nil {
+				params := stmt.params.(*transposeParams)
+				spec := emptyShardingSpec(outputSpec.Mesh, x[0].shape.Rank())
+				for axis, operandAxis := range params.permutation {
+					spec.Axes[operandAxis] = outputSpec.Axes[axis]
+				}
+				p.set(x[0], spec)
+			}
+		case vmapElementwiseOps[stmt.OpType]:
+			for _, input := range x {
+				if p.specs[input.name] == nil && input.shape.Rank() == outputSpec.Rank() {
+					p.set(input, outputSpec)
+				}
+			}
+		}
+	}
+}
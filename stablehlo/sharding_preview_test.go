@@ -0,0 +1,103 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewShardings(t *testing.T) {
+	mesh := must1(shardy.NewDeviceMesh("mesh", []int{2, 2}, []string{"data", "model"}))
+
+	t.Run("DotGeneral", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		// x: [batch, features] sharded on "data" and "model", w: [features, out] sharded on "model".
+		x := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 8, 16),
+			b.NewShardingSpec().AddShardedAxis("data").AddShardedAxis("model")))
+		w := must1(fn.NamedInputWithSharding("w", shapes.Make(dtypes.F32, 16, 4),
+			b.NewShardingSpec().AddShardedAxis("model")))
+		y := must1(Dot(x, w))
+		z := must1(Tanh(y))
+		require.NoError(t, fn.Return(z))
+
+		preview := must1(b.PreviewShardings())
+		report := preview.Report()
+		fmt.Printf("%s report:\n%s", t.Name(), report)
+		require.Equal(t, `<@mesh, [{"data"}, {}]>`, shardingToString(preview.Sharding(y), y.Shape()))
+		require.Equal(t, `<@mesh, [{"data"}, {}]>`, shardingToString(preview.Sharding(z), z.Shape()))
+		// The contracting axis is sharded on "model" on both sides: the output [4,4] shards are all-reduced.
+		require.Len(t, preview.Communications, 1)
+		comm := preview.Communications[0]
+		require.Equal(t, CommunicationAllReduce, comm.Kind)
+		require.Same(t, y, comm.Value)
+		require.Equal(t, int64(4*4*4), comm.Bytes)
+		require.Equal(t, int64(64), preview.CommunicationBytes())
+		require.Contains(t, report, "dot_general: all-reduce of %")
+		require.Contains(t, report, "Total communication: 64 B per device")
+
+		// w is only sharded along "model", so it is replicated along "data" -- but not fully replicated.
+		require.Empty(t, preview.ReplicatedValues(0))
+	})
+
+	t.Run("constraints and replication", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 1024, 256)))
+		bias := must1(fn.ConstantFromScalar(float32(1)))
+		bias = must1(BroadcastInDim(bias, x.Shape(), nil))
+		y := must1(Add(x, bias))
+		y = must1(ShardingConstraint(y, b.NewShardingSpec().AddShardedAxis("data")))
+		z := must1(Transpose(y, 1, 0))
+		require.NoError(t, fn.ReturnWithShardingAndAttributes([]*Value{z},
+			[]*shardy.ShardingSpec{b.NewShardingSpec().AddShardedAxis("model", "data")}, nil))
+
+		preview := must1(b.PreviewShardings())
+		report := preview.Report()
+		fmt.Printf("%s report:\n%s", t.Name(), report)
+		require.Equal(t, `<@mesh, [{"data"}, {}]>`, shardingToString(preview.Sharding(y), y.Shape()))
+		require.Equal(t, `<@mesh, [{}, {"data"}]>`, shardingToString(preview.Sharding(z), z.Shape()))
+
+		// The input x (1MiB) is replicated, and so are the broadcast bias and the addition.
+		replicated := preview.ReplicatedValues(1 << 20)
+		require.Len(t, replicated, 3)
+		require.Same(t, x, replicated[0])
+		require.Contains(t, report, "f32[1024,256] <@mesh, [{}, {}]>, 1.0 MiB per device")
+
+		// Returning z requires an all-to-all to the output sharding.
+		require.Len(t, preview.Communications, 1)
+		require.Equal(t, CommunicationAllToAll, preview.Communications[0].Kind)
+		require.Equal(t, int64(1<<20/4), preview.Communications[0].Bytes)
+	})
+
+	t.Run("Reduce", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInputWithSharding("x", shapes.Make(dtypes.F32, 8, 16),
+			b.NewShardingSpec().AddShardedAxis("data").AddShardedAxis("model")))
+		addFn := fn.Closure()
+		lhs := must1(addFn.Input(shapes.Make(dtypes.F32)))
+		rhs := must1(addFn.Input(shapes.Make(dtypes.F32)))
+		require.NoError(t, addFn.Return(must1(Add(lhs, rhs))))
+		sum := must1(Reduce(x, must1(fn.ConstantFromScalar(float32(0))), addFn, 1))
+		require.NoError(t, fn.Return(sum))
+		preview := must1(b.PreviewShardings())
+		fmt.Printf("%s report:\n%s", t.Name(), preview.Report())
+		require.Equal(t, `<@mesh, [{"data"}]>`, shardingToString(preview.Sharding(sum), sum.Shape()))
+		require.Len(t, preview.Communications, 1)
+		require.Equal(t, CommunicationAllReduce, preview.Communications[0].Kind)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		_, err := b.PreviewShardings()
+		require.ErrorContains(t, err, "WithShardy")
+		b = New(t.Name()).WithShardy(mesh)
+		_, err = b.PreviewShardings()
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,186 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharding(t *testing.T) {
+	mesh := must1(shardy.NewDeviceMesh("mesh", []int{2, 2}, []string{"data", "model"}))
+
+	t.Run("ShardingConstraint and Reshard", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		y := must1(ShardingConstraint(must1(Tanh(x)), b.NewShardingSpec().AddShardedAxis("data")))
+		y = must1(Reshard(y, b.NewShardingSpec().AddReplicated().AddShardedAxis("model")))
+		require.NoError(t, fn.Return(y))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `%1 = "sdy.sharding_constraint"(%0) { sharding = #sdy.sharding<@mesh, [{"data"}, {}]> }`+
+			` : (tensor<4x8xf32>) -> tensor<4x8xf32>`)
+		require.Contains(t, program, `%2 = "sdy.reshard"(%1) { sharding = #sdy.sharding<@mesh, [{}, {"model"}]> }`+
+			` : (tensor<4x8xf32>) -> tensor<4x8xf32>`)
+	})
+
+	t.Run("WithSharding", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		y := must1(must1(Tanh(x)).WithSharding(b.NewShardingSpec().AddShardedAxis("data", "model")))
+		require.Equal(t, "data", y.Sharding().Axes[0].MeshAxes[0].AxisName)
+		results := must1(OptimizationBarrier(x, y))
+		must1(results[1].WithSharding(b.NewShardingSpec().AddShardedAxis("data")))
+		require.NoError(t, fn.Return(results...))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `sdy.sharding = #sdy.sharding_per_value<[<@mesh, [{"data", "model"}, {}]>]>`)
+		require.Contains(t, program, `sdy.sharding = #sdy.sharding_per_value<[<@mesh, [{?}, {?}]>, <@mesh, [{"data"}, {}]>]>`)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4)))
+		otherMesh := must1(shardy.NewDeviceMesh("other", []int{2}, []string{"data"}))
+		_, err := ShardingConstraint(x, shardy.NewShardingSpec(otherMesh).AddShardedAxis("data"))
+		require.ErrorContains(t, err, "doesn't match any of the stablehlo.Builder meshes")
+		_, err = Reshard(x, b.NewShardingSpec().AddShardedAxis("data").AddReplicated())
+		require.ErrorContains(t, err, "larger than tensor rank")
+		_, err = ShardingConstraint(x, nil)
+		require.Error(t, err)
+		_, err = x.WithSharding(b.NewShardingSpec().AddShardedAxis("data"))
+		require.ErrorContains(t, err, "InputWithSharding")
+		_, err = must1(Negate(x)).WithSharding(b.NewShardingSpec().AddShardedAxis("unknown"))
+		require.ErrorContains(t, err, "unknown mesh axis")
+	})
+
+	t.Run("VJP and Vmap", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		y := must1(ShardingConstraint(must1(Multiply(x, x)), b.NewShardingSpec().AddShardedAxis("data")))
+		y = must1(Reshard(y, b.NewShardingSpec().AddShardedAxis("model")))
+		require.NoError(t, fn.Return(y))
+
+		grad := must1(VJP(fn, "grad", 0))
+		var numConstraints int
+		for _, stmt := range grad.Statements {
+			if stmt.OpType == optypes.ShardingConstraint {
+				numConstraints++
+			}
+		}
+		// One in the forward pass, one for the cotangent.
+		require.Equal(t, 2, numConstraints)
+
+		batched := must1(Vmap(fn, "batched", 2, []int{0}))
+		require.True(t, batched.Outputs[0].Shape().Equal(shapes.Make(dtypes.F32, 2, 4, 8)))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `"sdy.reshard"(%1) { sharding = #sdy.sharding<@mesh, [{}, {"model"}, {}]> }`+
+			` : (tensor<2x4x8xf32>) -> tensor<2x4x8xf32>`)
+	})
+
+	t.Run("ShardingGroup", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		param := must1(fn.NamedInputWithSharding("param", shapes.Make(dtypes.BF16, 4, 8),
+			b.NewShardingSpec().AddShardedAxisWithPriority(0, "data").WithReplicatedAxes("model")))
+		state := must1(fn.NamedInput("state", shapes.Make(dtypes.F32, 4, 8)))
+		groupID := must1(b.NewShardingGroup(param, state))
+		require.Equal(t, int64(0), groupID)
+		newState := must1(Add(state, state))
+		require.NoError(t, b.AddToShardingGroup(groupID, newState))
+		require.NoError(t, fn.Return(newState))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `sdy.sharding = #sdy.sharding<@mesh, [{"data"}p0, {}], replicated={"model"}>`)
+		require.Contains(t, program, `"sdy.sharding_group"(%param) { group_id = 0 : i64 } : (tensor<4x8xbf16>) -> ()`)
+		require.Contains(t, program, `"sdy.sharding_group"(%state) { group_id = 0 : i64 } : (tensor<4x8xf32>) -> ()`)
+		require.Contains(t, program, `"sdy.sharding_group"(%0) { group_id = 0 : i64 } : (tensor<4x8xf32>) -> ()`)
+
+		// Batched values are added to a new group.
+		batched := must1(Vmap(fn, "batched", 2, []int{-1, 0}))
+		var groups []int64
+		for _, stmt := range batched.Statements {
+			if stmt.OpType == optypes.ShardingGroup {
+				groups = append(groups, stmt.params.(*shardingGroupParams).groupID)
+			}
+		}
+		require.Equal(t, []int64{0, 1, 1}, groups)
+
+		// Errors.
+		b = New(t.Name()).WithShardy(mesh)
+		fn = b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		y := must1(fn.NamedInput("y", shapes.Make(dtypes.F32, 8, 4)))
+		_, err := b.NewShardingGroup(x, y)
+		require.ErrorContains(t, err, "same dimensions")
+		require.ErrorContains(t, b.AddToShardingGroup(0, x), "invalid sharding group ID")
+		_, err = New(t.Name()).NewShardingGroup()
+		require.ErrorContains(t, err, "WithShardy")
+	})
+
+	t.Run("ManualComputation", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		spec := b.NewShardingSpec().AddShardedAxis("data").AddShardedAxis("model")
+		mc := must1(ManualComputation([]string{"model", "data"}, []*shardy.ShardingSpec{spec}, x))
+		body := mc.Body()
+		require.Len(t, body.Inputs, 1)
+		require.True(t, body.Inputs[0].Shape().Equal(shapes.Make(dtypes.F32, 2, 4)))
+
+		groups := must1(mc.ReplicaGroups("data"))
+		require.Equal(t, [][]int{{0, 2}, {1, 3}}, groups)
+		addFn := body.Closure()
+		lhs := must1(addFn.Input(shapes.Make(dtypes.F32)))
+		rhs := must1(addFn.Input(shapes.Make(dtypes.F32)))
+		require.NoError(t, addFn.Return(must1(Add(lhs, rhs))))
+		config := &types.CollectiveConfig{ChannelType: types.CrossPartition, UseGlobalDeviceIDs: true}
+		sum := must1(AllReduce(body.Inputs, groups, addFn, config))
+
+		// Output replicated along "data".
+		outSpec := b.NewShardingSpec().AddReplicated().AddShardedAxis("model")
+		outputs := must1(mc.Done([]*shardy.ShardingSpec{outSpec}, sum...))
+		require.True(t, outputs[0].Shape().Equal(shapes.Make(dtypes.F32, 2, 8)))
+		require.NoError(t, fn.Return(outputs...))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, `"sdy.manual_computation"(%x) ({`)
+		require.Contains(t, program, `"sdy.return"(`)
+		require.Contains(t, program, `in_shardings = #sdy.sharding_per_value<[<@mesh, [{"data"}, {"model"}]>]>,`)
+		require.Contains(t, program, `manual_axes = #sdy<manual_axes{"data", "model"}>,`)
+		require.Contains(t, program, `out_shardings = #sdy.sharding_per_value<[<@mesh, [{}, {"model"}]>]>`)
+		require.Contains(t, program, `: (tensor<4x8xf32>) -> tensor<2x8xf32>`)
+	})
+
+	t.Run("ManualComputation errors", func(t *testing.T) {
+		b := New(t.Name()).WithShardy(mesh)
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.F32, 4, 8)))
+		spec := b.NewShardingSpec().AddShardedAxis("data")
+		_, err := ManualComputation([]string{"data"}, nil, x)
+		require.ErrorContains(t, err, "one sharding spec per operand")
+		_, err = ManualComputation([]string{"data", "data"}, []*shardy.ShardingSpec{spec}, x)
+		require.ErrorContains(t, err, "more than once")
+		_, err = ManualComputation([]string{"data"}, []*shardy.ShardingSpec{
+			b.NewShardingSpec().AddShardedAxis("model", "data")}, x)
+		require.ErrorContains(t, err, "must come before the free axis")
+
+		mc := must1(ManualComputation([]string{"data"}, []*shardy.ShardingSpec{spec}, x))
+		_, err = mc.ReplicaGroups("model")
+		require.ErrorContains(t, err, "not one of the manual axes")
+		// The body can't use values of the enclosing function.
+		y := must1(mc.Body().UseParentValue(x))
+		_, err = mc.Done([]*shardy.ShardingSpec{spec}, must1(Negate(y)))
+		require.ErrorContains(t, err, "from an enclosing function")
+	})
+}
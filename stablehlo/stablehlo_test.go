@@ -8,6 +8,7 @@ import (
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/go-xla/types/shapes"
 	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/stretchr/testify/require"
 )
 
 func must1[T any](value T, err error) T {
@@ -110,6 +111,20 @@ func TestBuilder(t *testing.T) {
 		}
 	})
 
+	t.Run("Layouts", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInputWithLayout("x", shapes.Make(dtypes.F32, 2, 3), shapes.ColumnMajorLayout(2)))
+		_, err := fn.NamedInputWithLayout("y", shapes.Make(dtypes.F32, 2, 3), shapes.ColumnMajorLayout(3))
+		require.ErrorContains(t, err, "invalid layout")
+		neg := must1(Negate(x))
+		require.NoError(t, fn.ReturnWithLayouts([]*Value{neg, x}, []*shapes.Layout{shapes.ColumnMajorLayout(2), nil}))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program,
+			`func.func @main(%x: tensor<2x3xf32> { mhlo.layout_mode = "{0,1}" }) -> (tensor<2x3xf32> { mhlo.layout_mode = "{0,1}" }, tensor<2x3xf32>)`)
+	})
+
 	t.Run("with inputs", func(t *testing.T) {
 		builder := New(t.Name())
 		shape := shapes.Make(dtypes.Float64)
@@ -16,6 +16,7 @@ import (
 	"github.com/gomlx/go-xla/internal/optypes"
 	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
 )
 
 // Statement represents a single operation line in ToStableHLO.
@@ -38,6 +39,12 @@ type Statement struct {
 
 	// Outputs of the operation. It may be nil for operations like func.return.
 	Outputs []*Value
+
+	// params holds the parameters of some ops, used by program transformations (e.g. VJP). See opparams.go.
+	params any
+
+	// needsShapeInference is set when the shapes of the inputs were changed by a rewrite, see Function.InferShapes.
+	needsShapeInference bool
 }
 
 func (s *Statement) AddFunctionParameter(name string, inlineFn *Function) {
@@ -80,7 +87,11 @@ func (s *Statement) Write(writer io.Writer, indentation string) error {
 	}
 
 	// Write op name and arguments:
-	w("%q(", s.OpType.ToStableHLO())
+	opName := s.OpType.ToStableHLO()
+	if s.OpType == optypes.FuncReturn && s.Function.isManualComputationBody {
+		opName = "sdy.return"
+	}
+	w("%q(", opName)
 	for i, input := range s.Inputs {
 		if i > 0 {
 			w(", ")
@@ -347,6 +358,9 @@ func podToStableHLO(pod any) string {
 	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
 		return fmt.Sprintf("%d", v)
 
+	case float8Bits:
+		return fmt.Sprintf("%#x", uint8(v))
+
 	case bool:
 		if v {
 			return "true"
@@ -368,6 +382,51 @@ func podToStableHLO(pod any) string {
 	}
 }
 
+// float8Bits holds the raw bits of an 8-bit float value (see utils.IsFloat8), rendered as an hexadecimal literal.
+type float8Bits uint8
+
+// normalizeConstantFlat validates and converts the flat values of constants of sub-byte (e.g. Int4) and 8-bit float
+// (e.g. F8E4M3FN) dtypes to the representation used to render them.
+// Flat values of other dtypes are returned unchanged.
+func normalizeConstantFlat(flat any, shape shapes.Shape) (any, error) {
+	dtype := shape.DType
+	size := shape.Size()
+	if size == 0 || (!utils.IsFloat8(dtype) && !dtype.IsPacked()) {
+		return flat, nil
+	}
+	if utils.IsFloat8(dtype) {
+		rawBits, ok := flat.([]uint8)
+		if !ok {
+			return nil, errors.Errorf("constants of dtype %s must be given as their raw bits in a []uint8, got %T", dtype, flat)
+		}
+		if len(rawBits) != size {
+			return nil, errors.Errorf("flat values size %d doesn't match shape size %d (%s)", len(rawBits), size, shape)
+		}
+		values := make([]float8Bits, size)
+		for i, v := range rawBits {
+			values[i] = float8Bits(v)
+		}
+		return values, nil
+	}
+
+	// Sub-byte dtypes: either given in their packed storage, or one value per element.
+	// When both have the same length (a single element), the values are taken as one per element, so that values
+	// that don't fit the dtype are reported instead of silently truncated.
+	if packed, ok := flat.([]uint8); ok && len(packed) != size &&
+		len(packed) == utils.DTypeSizeForDimensions(dtype, shape.Dimensions...) {
+		return utils.UnpackSubByte(dtype, packed, size)
+	}
+	flatV := reflect.ValueOf(flat)
+	if flatV.Kind() != reflect.Slice || flatV.Len() != size {
+		return nil, errors.Errorf("constants of dtype %s must be given either as packed bytes or with one value per element "+
+			"(%d values for shape %s), got %T", dtype, size, shape, flat)
+	}
+	if _, err := utils.PackSubByte(dtype, flat); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
 // tensorLiteral represents a literal tensor value, used to define constants.
 //
 // It has a different representation than other literals.
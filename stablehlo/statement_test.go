@@ -45,13 +45,69 @@ func TestTensorLiteral_ToStableHLO(t *testing.T) {
 			shape:    shapes.Make(dtypes.F32, 2, 0),
 			expected: "dense<[[], []]> : tensor<2x0xf32>",
 		},
+		{
+			name:     "Int4 packed",
+			value:    []uint8{0xE1, 0x07},
+			shape:    shapes.Make(dtypes.Int4, 3),
+			expected: "dense<[1, -2, 7]> : tensor<3xi4>",
+		},
+		{
+			name:     "Int4 one value per element",
+			value:    []int8{1, -2, 7},
+			shape:    shapes.Make(dtypes.Int4, 3),
+			expected: "dense<[1, -2, 7]> : tensor<3xi4>",
+		},
+		{
+			name:     "Uint4 packed",
+			value:    []uint8{0xE1},
+			shape:    shapes.Make(dtypes.Uint4, 2),
+			expected: "dense<[1, 14]> : tensor<2xui4>",
+		},
+		{
+			name:     "F8E4M3FN",
+			value:    []uint8{0x38, 0x40, 0xC0, 0x00},
+			shape:    shapes.Make(dtypes.F8E4M3FN, 2, 2),
+			expected: "dense<[[0x38, 0x40], [0xc0, 0x0]]> : tensor<2x2xf8E4M3FN>",
+		},
+		{
+			name:     "F8E5M2 scalar",
+			value:    []uint8{0x3C},
+			shape:    shapes.Make(dtypes.F8E5M2),
+			expected: "dense<0x3c> : tensor<f8E5M2>",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tl := newTensorLiteralFromFlatAndShape(tt.value, tt.shape)
+			value := must1(normalizeConstantFlat(tt.value, tt.shape))
+			tl := newTensorLiteralFromFlatAndShape(value, tt.shape)
 			actual := tl.ToStableHLO()
 			require.Equal(t, tt.expected, actual)
 		})
 	}
 }
+
+func TestConstantsOfSubByteAndFloat8DTypes(t *testing.T) {
+	b := New(t.Name())
+	fn := b.Main()
+	_, err := fn.ConstantFromFlatAndShape([]int8{8}, shapes.Make(dtypes.Int4))
+	require.ErrorContains(t, err, "out of range")
+	_, err = fn.ConstantFromFlatAndShape([]uint8{1, 2, 3}, shapes.Make(dtypes.Int4, 4))
+	require.ErrorContains(t, err, "packed bytes or with one value per element")
+	_, err = fn.ConstantFromFlatAndShape([]uint8{0x1F}, shapes.Make(dtypes.Uint4, 1))
+	require.ErrorContains(t, err, "out of range")
+	_, err = fn.ConstantFromFlatAndShape([]uint8{16}, shapes.Make(dtypes.Uint4))
+	require.ErrorContains(t, err, "out of range")
+	_, err = fn.ConstantFromFlatAndShape([]float32{1}, shapes.Make(dtypes.F8E4M3FN))
+	require.ErrorContains(t, err, "raw bits")
+
+	weights := must1(fn.ConstantFromFlatAndShape([]uint8{0x38, 0x40}, shapes.Make(dtypes.F8E4M3FN, 2)))
+	require.True(t, must1(BitcastConvert(weights, dtypes.Uint8)).Shape().Equal(shapes.Make(dtypes.Uint8, 2)))
+	scales := must1(fn.ConstantFromFlatAndShape([]int8{-8, 7}, shapes.Make(dtypes.Int4, 2)))
+	result := must1(Multiply(must1(Convert(weights, dtypes.Float16)), must1(Convert(scales, dtypes.Float16))))
+	require.NoError(t, fn.Return(result, must1(Convert(result, dtypes.F8E5M2))))
+	program := string(must1(b.Build()))
+	require.Contains(t, program, "tensor<2xf8E4M3FN>")
+	require.Contains(t, program, "tensor<2xf8E5M2>")
+	require.Contains(t, program, "dense<[-8, 7]> : tensor<2xi4>")
+}
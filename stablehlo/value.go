@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
 	"github.com/pkg/errors"
 )
 
@@ -30,6 +31,10 @@ type Value struct {
 
 	// outputIndex is the index of this value in stmt.Outputs. It is only valid when stmt != nil.
 	outputIndex int
+
+	// sharding annotated with WithSharding (rendered in the "sdy.sharding" attribute of stmt), or given
+	// to a function input or output.
+	sharding *shardy.ShardingSpec
 }
 
 // Shape returns the shape of the value.
@@ -0,0 +1,931 @@
+package stablehlo
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// VJP creates a new function (with the given name) in the builder of fn that computes the outputs of fn and
+// the vector-Jacobian product (VJP) of fn with respect to the inputs selected by wrt -- a.k.a. reverse-mode
+// automatic differentiation.
+//
+// The new function takes as inputs the inputs of fn, followed by one "cotangent" for each output of fn (with
+// the same shape as the corresponding output).
+// It returns the outputs of fn, followed by the VJP with respect to each of the inputs in wrt, in the order
+// given: for an input x, it is the sum over the outputs y_i of cotangent_i * dy_i/dx, with the same shape as x.
+// So for a scalar output and a cotangent of 1, the VJPs are the gradients of the output.
+//
+// fn must be a top-level function (not a closure) that has already returned, and the inputs in wrt must be
+// of a float dtype. Values with non-float dtypes (e.g. indices, masks) are not differentiated.
+//
+// Supported ops (when on the path from the wrt inputs to the outputs):
+//   - Elementwise: Abs, Add, Atan2, Cbrt, Clamp, Convert, Cosine, Divide, Erf, Exponential, ExponentialMinusOne,
+//     Log, LogPlusOne, Logistic, Maximum, Minimum, Multiply, Negate, Power, Remainder, Rsqrt, Select, Sine,
+//     Sqrt, Subtract, Tan, Tanh; and Ceil, Floor, RoundNearestAfz, RoundNearestEven and Sign, which have
+//     zero gradient.
+//   - BroadcastInDim, Reshape, Transpose, Reverse, Slice, Pad, Concatenate.
+//   - DotGeneral and Convolution (without channel or batch grouping).
+//   - Reduce with a single input and a sum, max or min reduction function.
+//   - Gather, and Scatter with a single input and an update function that either adds or replaces values.
+//   - While loops with a fixed trip count: a scalar integer counter, initialized with a constant,
+//     incremented (or decremented) by a constant in the body and compared against a constant in the condition.
+//     These loops are unrolled, so the program size grows with the number of iterations.
+//   - OptimizationBarrier.
+//   - ShardingConstraint, whose cotangent gets the same constraint, and Reshard, whose cotangent is passed through.
+//
+// It returns an error for any other op on the path from the wrt inputs to the outputs.
+func VJP(fn *Function, name string, wrt ...int) (vjpFn *Function, err error) {
+	if fn.Parent != nil {
+		return nil, errors.Errorf("VJP requires a top-level function, but %q is a closure", fn.Name)
+	}
+	if !fn.Returned {
+		return nil, errors.Errorf("VJP requires function %q to have returned", fn.Name)
+	}
+	for i, inputIdx := range wrt {
+		if inputIdx < 0 || inputIdx >= len(fn.Inputs) {
+			return nil, errors.Errorf("VJP wrt input #%d is out of range, function %q has %d inputs",
+				inputIdx, fn.Name, len(fn.Inputs))
+		}
+		if slices.Index(wrt, inputIdx) != i {
+			return nil, errors.Errorf("VJP wrt input #%d given more than once", inputIdx)
+		}
+		if !fn.Inputs[inputIdx].shape.DType.IsFloat() {
+			return nil, errors.Errorf("VJP wrt input #%d must be a float, got %s", inputIdx, fn.Inputs[inputIdx].shape)
+		}
+	}
+
+	numFunctions := len(fn.Builder.functions)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fn.Builder.recoverTransform(r, numFunctions)
+			err = errors.WithMessagef(err, "VJP of function %q", fn.Name)
+			vjpFn = nil
+		}
+	}()
+	b := &vjpBuilder{
+		fn:         fn.Builder.NewFunction(name),
+		values:     make(map[string]*Value),
+		srcValues:  make(map[string]*Value),
+		needsGrad:  make(map[*Value]bool),
+		cotangents: make(map[*Value]*Value),
+	}
+	b.indexSourceValues(fn)
+
+	// Inputs: the original inputs followed by the cotangents of the outputs.
+	for _, input := range fn.Inputs {
+		newInput := transformMust(b.fn.NamedInputWithAttributes(input.name, input.shape, input.Attributes))
+		b.values[input.name] = newInput
+	}
+	outputCotangents := make([]*Value, len(fn.Outputs))
+	for i, output := range fn.Outputs {
+		outputCotangents[i] = transformMust(b.fn.NamedInput(fmt.Sprintf("cotangent%d", i), output.shape))
+	}
+	for _, inputIdx := range wrt {
+		b.needsGrad[b.fn.Inputs[inputIdx]] = true
+	}
+
+	// Forward pass.
+	outputs := b.replay(fn.Statements)
+	forward := slices.Clone(b.fn.Statements)
+	b.markNeedsGrad(forward)
+
+	// Backward pass.
+	for i, output := range outputs {
+		if b.needsGrad[output] {
+			b.accumulate(output, outputCotangents[i])
+		}
+	}
+	for _, stmt := range slices.Backward(forward) {
+		outputsCts := make([]*Value, len(stmt.Outputs))
+		hasCotangent := false
+		for i, output := range stmt.Outputs {
+			outputsCts[i] = b.cotangents[output]
+			hasCotangent = hasCotangent || outputsCts[i] != nil
+		}
+		if !hasCotangent {
+			continue
+		}
+		inputsCts := b.statementVJP(stmt, outputsCts)
+		for i, input := range stmt.Inputs {
+			if inputsCts[i] != nil && b.needsGrad[input] {
+				b.accumulate(input, inputsCts[i])
+			}
+		}
+	}
+
+	results := slices.Clone(outputs)
+	for _, inputIdx := range wrt {
+		input := b.fn.Inputs[inputIdx]
+		ct := b.cotangents[input]
+		if ct == nil {
+			ct = b.constant(0, input.shape)
+		}
+		results = append(results, ct)
+	}
+	transformMust[any](nil, b.fn.Return(results...))
+	return b.fn, nil
+}
+
+// vjpBuilder holds the state of the VJP function being built.
+type vjpBuilder struct {
+	// fn is the new function being built.
+	fn *Function
+
+	// values maps the names of the values of the original function to the values in the new function.
+	values map[string]*Value
+
+	// srcValues maps the names of the values in the original function (and its closures) to the values.
+	srcValues map[string]*Value
+
+	// needsGrad marks the values of the new function that depend on the wrt inputs, and have a float dtype.
+	needsGrad map[*Value]bool
+
+	// cotangents accumulated for the values of the new function.
+	cotangents map[*Value]*Value
+}
+
+// indexSourceValues maps the names of all the values of fn and its closures.
+func (b *vjpBuilder) indexSourceValues(fn *Function) {
+	for _, input := range fn.Inputs {
+		b.srcValues[input.name] = input
+	}
+	for _, stmt := range fn.Statements {
+		for _, output := range stmt.Outputs {
+			b.srcValues[output.name] = output
+		}
+		for _, closure := range stmt.FunctionParameters {
+			b.indexSourceValues(closure)
+		}
+	}
+}
+
+// replay clones the statements into the new function, unrolling While loops.
+// It returns the values returned by the statements (the inputs to the FuncReturn statement).
+func (b *vjpBuilder) replay(statements []*Statement) []*Value {
+	for _, stmt := range statements {
+		switch stmt.OpType {
+		case optypes.FuncReturn:
+			returned := make([]*Value, len(stmt.Inputs))
+			for i, input := range stmt.Inputs {
+				returned[i] = b.values[input.name]
+				if returned[i] == nil {
+					transformMust[any](nil, errors.Errorf("returned value %s is not defined", input))
+				}
+			}
+			return returned
+		case optypes.While:
+			b.unrollWhile(stmt)
+		default:
+			transformMust(b.fn.cloneStatement(stmt, b.values))
+		}
+	}
+	transformMust[any](nil, errors.New("missing return statement"))
+	return nil
+}
+
+// unrollWhile unrolls a While loop with a fixed trip count into the new function.
+func (b *vjpBuilder) unrollWhile(stmt *Statement) {
+	tripCount := transformMust(b.whileTripCount(stmt))
+	body := stmt.FunctionParameters[1]
+	states := make([]*Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		states[i] = b.values[input.name]
+	}
+	for range tripCount {
+		for i, input := range body.Inputs {
+			b.values[input.name] = states[i]
+		}
+		states = b.replay(body.Statements)
+	}
+	for i, output := range stmt.Outputs {
+		b.values[output.name] = states[i]
+	}
+}
+
+// whileTripCount returns the fixed number of iterations of a While loop, or an error if it can't be determined.
+func (b *vjpBuilder) whileTripCount(stmt *Statement) (int, error) {
+	errNotFixed := errors.New("While loop doesn't have a fixed trip count: it must have a scalar counter " +
+		"initialized with a constant, incremented or decremented by a constant, and compared against a constant")
+	cond, body := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	if len(cond.Statements) == 0 || len(body.Statements) == 0 {
+		return 0, errNotFixed
+	}
+	condReturn := cond.Statements[len(cond.Statements)-1]
+	if condReturn.OpType != optypes.FuncReturn || len(condReturn.Inputs) != 1 || condReturn.Inputs[0].stmt == nil {
+		return 0, errNotFixed
+	}
+	compare := condReturn.Inputs[0].stmt
+	if compare.OpType != optypes.Compare {
+		return 0, errNotFixed
+	}
+	direction, _ := compare.Attributes["comparison_direction"].(types.ComparisonDirection)
+	counterIdx := slices.Index(cond.Inputs, compare.Inputs[0])
+	limit, ok := b.scalarConstant(compare.Inputs[1])
+	if counterIdx == -1 {
+		// Try the counter on the right-hand side, flipping the comparison.
+		counterIdx = slices.Index(cond.Inputs, compare.Inputs[1])
+		limit, ok = b.scalarConstant(compare.Inputs[0])
+		switch direction {
+		case types.CompareLT:
+			direction = types.CompareGT
+		case types.CompareLE:
+			direction = types.CompareGE
+		case types.CompareGT:
+			direction = types.CompareLT
+		case types.CompareGE:
+			direction = types.CompareLE
+		}
+	}
+	if counterIdx == -1 || !ok {
+		return 0, errNotFixed
+	}
+	start, ok := b.scalarConstant(stmt.Inputs[counterIdx])
+	if !ok {
+		return 0, errNotFixed
+	}
+
+	// Find the step in the body.
+	bodyReturn := body.Statements[len(body.Statements)-1]
+	next := bodyReturn.Inputs[counterIdx]
+	if next.stmt == nil || len(next.stmt.Inputs) != 2 {
+		return 0, errNotFixed
+	}
+	counter := body.Inputs[counterIdx]
+	var step float64
+	switch {
+	case next.stmt.OpType == optypes.Add && next.stmt.Inputs[0] == counter:
+		step, ok = b.scalarConstant(next.stmt.Inputs[1])
+	case next.stmt.OpType == optypes.Add && next.stmt.Inputs[1] == counter:
+		step, ok = b.scalarConstant(next.stmt.Inputs[0])
+	case next.stmt.OpType == optypes.Subtract && next.stmt.Inputs[0] == counter:
+		step, ok = b.scalarConstant(next.stmt.Inputs[1])
+		step = -step
+	default:
+		ok = false
+	}
+	if !ok || step == 0 {
+		return 0, errNotFixed
+	}
+
+	var count float64
+	switch {
+	case direction == types.CompareLT && step > 0:
+		count = math.Ceil((limit - start) / step)
+	case direction == types.CompareLE && step > 0:
+		count = math.Floor((limit-start)/step) + 1
+	case direction == types.CompareGT && step < 0:
+		count = math.Ceil((limit - start) / step)
+	case direction == types.CompareGE && step < 0:
+		count = math.Floor((limit-start)/step) + 1
+	case direction == types.CompareNE:
+		count = (limit - start) / step
+		if count != math.Trunc(count) || count < 0 {
+			return 0, errNotFixed
+		}
+	default:
+		return 0, errNotFixed
+	}
+	return int(max(count, 0)), nil
+}
+
+// scalarConstant returns the value of a scalar constant (of the original function), if v is one.
+func (b *vjpBuilder) scalarConstant(v *Value) (float64, bool) {
+	if v.stmt == nil {
+		// It may be a reference to a value of a parent function.
+		if src, found := b.srcValues[v.name]; found {
+			v = src
+		}
+	}
+	if v.stmt == nil || v.stmt.OpType != optypes.Constant || v.shape.Size() != 1 {
+		return 0, false
+	}
+	literal, ok := v.stmt.Attributes["value"].(tensorLiteral)
+	if !ok || literal.value == nil {
+		return 0, false
+	}
+	value := reflect.ValueOf(literal.value)
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		value = value.Index(0)
+	}
+	switch {
+	case value.CanInt():
+		return float64(value.Int()), true
+	case value.CanUint():
+		return float64(value.Uint()), true
+	case value.CanFloat():
+		return value.Float(), true
+	}
+	return 0, false
+}
+
+// markNeedsGrad marks the float outputs of the statements that depend on values that need gradients.
+func (b *vjpBuilder) markNeedsGrad(statements []*Statement) {
+	for _, stmt := range statements {
+		needsGrad := false
+		for _, input := range stmt.Inputs {
+			needsGrad = needsGrad || b.needsGrad[input]
+		}
+		for _, closure := range stmt.FunctionParameters {
+			closure.walkStatementsInOrder(true, func(closureStmt *Statement) {
+				for _, input := range closureStmt.Inputs {
+					if input.stmt != nil || input.fn == closure && slices.Contains(closure.Inputs, input) {
+						continue
+					}
+					for v := range b.needsGrad {
+						if v.name == input.name {
+							transformMust[any](nil, errors.Errorf("VJP of values captured by the closures of %s is not supported",
+								stmt.OpType))
+						}
+					}
+				}
+			})
+		}
+		if !needsGrad {
+			continue
+		}
+		for _, output := range stmt.Outputs {
+			if output.shape.DType.IsFloat() {
+				b.needsGrad[output] = true
+			}
+		}
+	}
+}
+
+// accumulate adds the cotangent ct to the value v.
+func (b *vjpBuilder) accumulate(v, ct *Value) {
+	if !ct.shape.Equal(v.shape) {
+		transformMust[any](nil, errors.Errorf("cotangent shape %s doesn't match value %s shape %s", ct.shape, v, v.shape))
+	}
+	if previous := b.cotangents[v]; previous != nil {
+		ct = transformMust(Add(previous, ct))
+	}
+	b.cotangents[v] = ct
+}
+
+// constant returns a constant of the given shape filled with value.
+func (b *vjpBuilder) constant(value float64, shape shapes.Shape) *Value {
+	scalar := transformMust(b.fn.ConstantFromScalar(shapes.CastAsDType(value, shape.DType)))
+	if shape.IsScalar() {
+		return scalar
+	}
+	return transformMust(BroadcastInDim(scalar, shapes.Make(shape.DType, shape.Dimensions...), nil))
+}
+
+// zerosLike returns zeros with the same shape as v.
+func (b *vjpBuilder) zerosLike(v *Value) *Value {
+	return b.constant(0, v.shape)
+}
+
+// mul, div, add, sub and neg are shortcuts for the corresponding ops.
+func (b *vjpBuilder) mul(lhs, rhs *Value) *Value { return transformMust(Multiply(lhs, rhs)) }
+func (b *vjpBuilder) div(lhs, rhs *Value) *Value { return transformMust(Divide(lhs, rhs)) }
+func (b *vjpBuilder) add(lhs, rhs *Value) *Value { return transformMust(Add(lhs, rhs)) }
+func (b *vjpBuilder) sub(lhs, rhs *Value) *Value { return transformMust(Subtract(lhs, rhs)) }
+func (b *vjpBuilder) neg(x *Value) *Value        { return transformMust(Negate(x)) }
+
+// selectOrZero returns g where mask is true, and zero otherwise.
+func (b *vjpBuilder) selectOrZero(mask, g *Value) *Value {
+	return transformMust(Select(mask, g, b.zerosLike(g)))
+}
+
+// compare x and y (with the same shape) with the given direction.
+func (b *vjpBuilder) compare(x, y *Value, direction types.ComparisonDirection) *Value {
+	return transformMust(Compare(x, y, direction, types.CompareFloat))
+}
+
+// broadcastTo broadcasts v to the given shape if it is a scalar, otherwise it returns v.
+func (b *vjpBuilder) broadcastTo(v *Value, shape shapes.Shape) *Value {
+	if !v.shape.IsScalar() || shape.IsScalar() {
+		return v
+	}
+	return transformMust(BroadcastInDim(v, shapes.Make(v.shape.DType, shape.Dimensions...), nil))
+}
+
+// sumToShape reduces ct to a scalar if shape is a scalar (the operand was implicitly broadcast).
+func (b *vjpBuilder) sumToShape(ct *Value, shape shapes.Shape) *Value {
+	if shape.IsScalar() && !ct.shape.IsScalar() {
+		return b.reduceSum(ct, allAxes(ct.shape.Rank())...)
+	}
+	return ct
+}
+
+// allAxes returns the list of axes 0, 1, ..., rank-1.
+func allAxes(rank int) []int {
+	axes := make([]int, rank)
+	for i := range axes {
+		axes[i] = i
+	}
+	return axes
+}
+
+// reduceSum x over the given axes.
+func (b *vjpBuilder) reduceSum(x *Value, axes ...int) *Value {
+	if len(axes) == 0 {
+		return x
+	}
+	zero := b.constant(0, shapes.Make(x.shape.DType))
+	return transformMust(Reduce(x, zero, scalarBinaryClosure(b.fn, optypes.Add, x.shape.DType), axes...))
+}
+
+// inversePermutation returns the inverse of the given permutation.
+func inversePermutation(permutation []int) []int {
+	inverse := make([]int, len(permutation))
+	for i, axis := range permutation {
+		inverse[axis] = i
+	}
+	return inverse
+}
+
+// isIdentityPermutation returns whether the permutation doesn't change the order of the axes.
+func isIdentityPermutation(permutation []int) bool {
+	for i, axis := range permutation {
+		if i != axis {
+			return false
+		}
+	}
+	return true
+}
+
+// freeAxes returns the axes (of a tensor of the given rank) that are not in any of the given lists, in increasing order.
+func freeAxes(rank int, used ...[]int) []int {
+	var free []int
+	for axis := range rank {
+		isUsed := false
+		for _, list := range used {
+			isUsed = isUsed || slices.Contains(list, axis)
+		}
+		if !isUsed {
+			free = append(free, axis)
+		}
+	}
+	return free
+}
+
+// rankInSorted returns the position of value in the sorted copy of values.
+func rankInSorted(values []int, value int) int {
+	sorted := slices.Sorted(slices.Values(values))
+	return slices.Index(sorted, value)
+}
+
+// dilateDim returns the size of a dimension after dilation.
+func dilateDim(size, dilation int) int {
+	if size == 0 {
+		return 0
+	}
+	return 1 + dilation*(size-1)
+}
+
+// singleInputClosureOp returns the op of a closure that takes two scalars and returns the result of a single
+// binary op on them (e.g. the reduction function of a sum), or FuncReturn if it returns its second input
+// unchanged (e.g. the update function of a scatter that replaces values).
+// It returns false if the closure is anything else.
+func singleInputClosureOp(closure *Function) (optypes.OpType, bool) {
+	if len(closure.Inputs) != 2 || len(closure.Outputs) != 1 || len(closure.Statements) == 0 {
+		return optypes.Invalid, false
+	}
+	returnStmt := closure.Statements[len(closure.Statements)-1]
+	result := returnStmt.Inputs[0]
+	if result == closure.Inputs[1] && len(closure.Statements) == 1 {
+		return optypes.FuncReturn, true
+	}
+	if result.stmt == nil || len(closure.Statements) != 2 || len(result.stmt.Inputs) != 2 {
+		return optypes.Invalid, false
+	}
+	lhs, rhs := result.stmt.Inputs[0], result.stmt.Inputs[1]
+	if !(lhs == closure.Inputs[0] && rhs == closure.Inputs[1]) && !(lhs == closure.Inputs[1] && rhs == closure.Inputs[0]) {
+		return optypes.Invalid, false
+	}
+	return result.stmt.OpType, true
+}
+
+// statementVJP returns the cotangents of the inputs of stmt, given the cotangents of its outputs.
+// Inputs that are not differentiable get a nil cotangent.
+func (b *vjpBuilder) statementVJP(stmt *Statement, cts []*Value) []*Value {
+	x := stmt.Inputs
+	y := stmt.Outputs[0]
+	g := cts[0]
+	switch stmt.OpType {
+	// Unary ops:
+	case optypes.Negate:
+		return []*Value{b.neg(g)}
+	case optypes.Abs:
+		return []*Value{b.mul(g, transformMust(Sign(x[0])))}
+	case optypes.Exponential:
+		return []*Value{b.mul(g, y)}
+	case optypes.ExponentialMinusOne:
+		return []*Value{b.mul(g, b.add(y, b.constant(1, y.shape)))}
+	case optypes.Log:
+		return []*Value{b.div(g, x[0])}
+	case optypes.LogPlusOne:
+		return []*Value{b.div(g, b.add(x[0], b.constant(1, y.shape)))}
+	case optypes.Sqrt:
+		return []*Value{b.div(g, b.mul(b.constant(2, y.shape), y))}
+	case optypes.Rsqrt:
+		return []*Value{b.mul(g, b.div(b.mul(b.constant(-0.5, y.shape), y), x[0]))}
+	case optypes.Cbrt:
+		return []*Value{b.div(g, b.mul(b.constant(3, y.shape), b.mul(y, y)))}
+	case optypes.Tanh:
+		return []*Value{b.mul(g, b.sub(b.constant(1, y.shape), b.mul(y, y)))}
+	case optypes.Logistic:
+		return []*Value{b.mul(g, b.mul(y, b.sub(b.constant(1, y.shape), y)))}
+	case optypes.Sine:
+		return []*Value{b.mul(g, transformMust(Cosine(x[0])))}
+	case optypes.Cosine:
+		return []*Value{b.neg(b.mul(g, transformMust(Sine(x[0]))))}
+	case optypes.Tan:
+		return []*Value{b.mul(g, b.add(b.constant(1, y.shape), b.mul(y, y)))}
+	case optypes.Erf:
+		x2 := b.mul(x[0], x[0])
+		return []*Value{b.mul(g, b.mul(b.constant(2/math.Sqrt(math.Pi), y.shape), transformMust(Exponential(b.neg(x2)))))}
+	case optypes.Sign, optypes.Floor, optypes.Ceil, optypes.RoundNearestEven, optypes.RoundNearestAfz:
+		return []*Value{nil}
+	case optypes.Convert:
+		if !x[0].shape.DType.IsFloat() {
+			return []*Value{nil}
+		}
+		return []*Value{transformMust(Convert(g, x[0].shape.DType))}
+
+	// Binary ops:
+	case optypes.Add:
+		return []*Value{b.sumToShape(g, x[0].shape), b.sumToShape(g, x[1].shape)}
+	case optypes.Subtract:
+		return []*Value{b.sumToShape(g, x[0].shape), b.sumToShape(b.neg(g), x[1].shape)}
+	case optypes.Multiply:
+		lhs, rhs := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[1], y.shape)
+		return []*Value{b.sumToShape(b.mul(g, rhs), x[0].shape), b.sumToShape(b.mul(g, lhs), x[1].shape)}
+	case optypes.Divide:
+		rhs := b.broadcastTo(x[1], y.shape)
+		return []*Value{b.sumToShape(b.div(g, rhs), x[0].shape),
+			b.sumToShape(b.neg(b.div(b.mul(g, y), rhs)), x[1].shape)}
+	case optypes.Power:
+		lhs, rhs := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[1], y.shape)
+		dLhs := b.mul(g, b.mul(rhs, transformMust(Power(lhs, b.sub(rhs, b.constant(1, y.shape))))))
+		isZero := b.compare(lhs, b.zerosLike(lhs), types.CompareEQ)
+		safeLog := transformMust(Select(isZero, b.zerosLike(lhs), transformMust(Log(lhs))))
+		dRhs := b.mul(g, b.mul(y, safeLog))
+		return []*Value{b.sumToShape(dLhs, x[0].shape), b.sumToShape(dRhs, x[1].shape)}
+	case optypes.Maximum, optypes.Minimum:
+		lhs, rhs := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[1], y.shape)
+		direction := types.CompareGE
+		if stmt.OpType == optypes.Minimum {
+			direction = types.CompareLE
+		}
+		lhsSelected := b.compare(lhs, rhs, direction)
+		dLhs := b.selectOrZero(lhsSelected, g)
+		dRhs := transformMust(Select(lhsSelected, b.zerosLike(g), g))
+		return []*Value{b.sumToShape(dLhs, x[0].shape), b.sumToShape(dRhs, x[1].shape)}
+	case optypes.Atan2:
+		lhs, rhs := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[1], y.shape)
+		denominator := b.add(b.mul(lhs, lhs), b.mul(rhs, rhs))
+		dLhs := b.div(b.mul(g, rhs), denominator)
+		dRhs := b.neg(b.div(b.mul(g, lhs), denominator))
+		return []*Value{b.sumToShape(dLhs, x[0].shape), b.sumToShape(dRhs, x[1].shape)}
+	case optypes.Remainder:
+		lhs, rhs := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[1], y.shape)
+		quotient := b.div(lhs, rhs)
+		truncated := b.mul(transformMust(Sign(quotient)), transformMust(Floor(transformMust(Abs(quotient)))))
+		return []*Value{b.sumToShape(g, x[0].shape), b.sumToShape(b.neg(b.mul(g, truncated)), x[1].shape)}
+	case optypes.Select:
+		zeros := b.zerosLike(g)
+		return []*Value{nil, transformMust(Select(x[0], g, zeros)), transformMust(Select(x[0], zeros, g))}
+	case optypes.Clamp:
+		minV, maxV := b.broadcastTo(x[0], y.shape), b.broadcastTo(x[2], y.shape)
+		belowMin := b.compare(x[1], minV, types.CompareLT)
+		aboveMax := b.compare(x[1], maxV, types.CompareGT)
+		inRange := transformMust(Not(transformMust(Or(belowMin, aboveMax))))
+		return []*Value{
+			b.sumToShape(b.selectOrZero(belowMin, g), x[0].shape),
+			b.selectOrZero(inRange, g),
+			b.sumToShape(b.selectOrZero(aboveMax, g), x[2].shape),
+		}
+
+	// Shape ops:
+	case optypes.Reshape:
+		return []*Value{transformMust(Reshape(g, x[0].shape))}
+	case optypes.Transpose:
+		params := stmt.params.(*transposeParams)
+		return []*Value{transformMust(Transpose(g, inversePermutation(params.permutation)...))}
+
+	// Sharding ops:
+	case optypes.ShardingConstraint:
+		params := stmt.params.(*shardingParams)
+		return []*Value{transformMust(ShardingConstraint(g, params.shardingSpec))}
+	case optypes.Reshard:
+		return []*Value{g}
+	case optypes.Reverse:
+		params := stmt.params.(*reverseParams)
+		return []*Value{transformMust(Reverse(g, slices.Clone(params.axes)...))}
+	case optypes.BroadcastInDim:
+		return []*Value{b.broadcastInDimVJP(stmt, g)}
+	case optypes.Slice:
+		return []*Value{b.sliceVJP(stmt, g)}
+	case optypes.Pad:
+		params := stmt.params.(*padParams)
+		rank := x[0].shape.Rank()
+		unpadStart, unpadEnd := make([]int, rank), make([]int, rank)
+		strides := make([]int, rank)
+		for axis := range rank {
+			unpadStart[axis] = -params.start[axis]
+			unpadEnd[axis] = -params.end[axis]
+			strides[axis] = params.interior[axis] + 1
+		}
+		unpadded := transformMust(Pad(g, b.constant(0, shapes.Make(g.shape.DType)), unpadStart, unpadEnd, nil))
+		dx := transformMust(Slice(unpadded, make([]int, rank), unpadded.shape.Dimensions, strides))
+		dFill := b.sub(b.reduceSum(g, allAxes(rank)...), b.reduceSum(dx, allAxes(rank)...))
+		return []*Value{dx, dFill}
+	case optypes.Concatenate:
+		params := stmt.params.(*concatenateParams)
+		results := make([]*Value, len(x))
+		offset := 0
+		for i, operand := range x {
+			starts := make([]int, g.shape.Rank())
+			limits := slices.Clone(g.shape.Dimensions)
+			starts[params.axis] = offset
+			offset += operand.shape.Dimensions[params.axis]
+			limits[params.axis] = offset
+			results[i] = transformMust(Slice(g, starts, limits, nil))
+		}
+		return results
+
+	// Contractions, reductions, etc.
+	case optypes.DotGeneral:
+		return b.dotGeneralVJP(stmt, g)
+	case optypes.Convolution:
+		return b.convolutionVJP(stmt, g)
+	case optypes.Reduce:
+		return b.reduceVJP(stmt, g)
+	case optypes.Gather:
+		return b.gatherVJP(stmt, g)
+	case optypes.Scatter:
+		return b.scatterVJP(stmt, g)
+	case optypes.OptimizationBarrier:
+		results := make([]*Value, len(x))
+		for i, ct := range cts {
+			if ct == nil && b.needsGrad[x[i]] {
+				ct = b.zerosLike(x[i])
+			}
+			results[i] = ct
+		}
+		return results
+	}
+	transformMust[any](nil, errors.Errorf("VJP of op %s is not supported", stmt.OpType))
+	return nil
+}
+
+// broadcastInDimVJP sums the cotangent over the broadcast axes.
+func (b *vjpBuilder) broadcastInDimVJP(stmt *Statement, g *Value) *Value {
+	x := stmt.Inputs[0]
+	params := stmt.params.(*broadcastInDimParams)
+	outputShape := stmt.Outputs[0].shape
+	var reduceAxes, kept []int
+	for axis := range outputShape.Rank() {
+		operandAxis := slices.Index(params.axesMapping, axis)
+		if operandAxis == -1 || (x.shape.Dimensions[operandAxis] == 1 && outputShape.Dimensions[axis] != 1) {
+			reduceAxes = append(reduceAxes, axis)
+		}
+	}
+	for operandAxis, axis := range params.axesMapping {
+		if !slices.Contains(reduceAxes, axis) {
+			kept = append(kept, operandAxis)
+		}
+	}
+	ct := b.reduceSum(g, reduceAxes...)
+
+	// The remaining axes are sorted by their position in the output: transpose them to the operand order.
+	sortedKept := slices.SortedFunc(slices.Values(kept), func(a, b int) int {
+		return params.axesMapping[a] - params.axesMapping[b]
+	})
+	permutation := make([]int, len(kept))
+	for i, operandAxis := range kept {
+		permutation[i] = slices.Index(sortedKept, operandAxis)
+	}
+	if !isIdentityPermutation(permutation) {
+		ct = transformMust(Transpose(ct, permutation...))
+	}
+	return transformMust(Reshape(ct, x.shape))
+}
+
+// sliceVJP pads the cotangent back to the shape of the operand.
+func (b *vjpBuilder) sliceVJP(stmt *Statement, g *Value) *Value {
+	x := stmt.Inputs[0]
+	params := stmt.params.(*sliceParams)
+	if g.shape.Size() == 0 {
+		return b.zerosLike(x)
+	}
+	rank := x.shape.Rank()
+	end, interior := make([]int, rank), make([]int, rank)
+	for axis := range rank {
+		lastIndex := params.starts[axis] + (g.shape.Dimensions[axis]-1)*params.strides[axis]
+		end[axis] = x.shape.Dimensions[axis] - lastIndex - 1
+		interior[axis] = params.strides[axis] - 1
+	}
+	return transformMust(Pad(g, b.constant(0, shapes.Make(g.shape.DType)), params.starts, end, interior))
+}
+
+// dotGeneralVJP implements the VJP of DotGeneral.
+func (b *vjpBuilder) dotGeneralVJP(stmt *Statement, g *Value) []*Value {
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	params := stmt.params.(*dotGeneralParams)
+	if g.shape.DType != lhs.shape.DType {
+		g = transformMust(Convert(g, lhs.shape.DType))
+	}
+	numBatch := len(params.lhsBatchAxes)
+	lhsFree := freeAxes(lhs.shape.Rank(), params.lhsContractingAxes, params.lhsBatchAxes)
+	rhsFree := freeAxes(rhs.shape.Rank(), params.rhsContractingAxes, params.rhsBatchAxes)
+	gBatch := allAxes(numBatch)
+	gLhsFree := make([]int, len(lhsFree))
+	for i := range lhsFree {
+		gLhsFree[i] = numBatch + i
+	}
+	gRhsFree := make([]int, len(rhsFree))
+	for i := range rhsFree {
+		gRhsFree[i] = numBatch + len(lhsFree) + i
+	}
+
+	var dLhs, dRhs *Value
+	if b.needsGrad[lhs] {
+		// Result axes: [batch..., lhsFree..., rhs contracting axes (sorted)...].
+		result := transformMust(DotGeneral(g, gRhsFree, gBatch, rhs, rhsFree, params.rhsBatchAxes).
+			Precision(params.precision[0], params.precision[1]).Done())
+		permutation := make([]int, lhs.shape.Rank())
+		for axis := range permutation {
+			if k := slices.Index(params.lhsBatchAxes, axis); k != -1 {
+				permutation[axis] = k
+			} else if j := slices.Index(lhsFree, axis); j != -1 {
+				permutation[axis] = numBatch + j
+			} else {
+				c := slices.Index(params.lhsContractingAxes, axis)
+				permutation[axis] = numBatch + len(lhsFree) + rankInSorted(params.rhsContractingAxes, params.rhsContractingAxes[c])
+			}
+		}
+		dLhs = result
+		if !isIdentityPermutation(permutation) {
+			dLhs = transformMust(Transpose(result, permutation...))
+		}
+	}
+	if b.needsGrad[rhs] {
+		// Result axes: [batch..., rhsFree..., lhs contracting axes (sorted)...].
+		result := transformMust(DotGeneral(g, gLhsFree, gBatch, lhs, lhsFree, params.lhsBatchAxes).
+			Precision(params.precision[0], params.precision[1]).Done())
+		permutation := make([]int, rhs.shape.Rank())
+		for axis := range permutation {
+			if k := slices.Index(params.rhsBatchAxes, axis); k != -1 {
+				permutation[axis] = k
+			} else if j := slices.Index(rhsFree, axis); j != -1 {
+				permutation[axis] = numBatch + j
+			} else {
+				c := slices.Index(params.rhsContractingAxes, axis)
+				permutation[axis] = numBatch + len(rhsFree) + rankInSorted(params.lhsContractingAxes, params.lhsContractingAxes[c])
+			}
+		}
+		dRhs = result
+		if !isIdentityPermutation(permutation) {
+			dRhs = transformMust(Transpose(result, permutation...))
+		}
+		if dRhs.shape.DType != rhs.shape.DType {
+			dRhs = transformMust(Convert(dRhs, rhs.shape.DType))
+		}
+	}
+	return []*Value{dLhs, dRhs}
+}
+
+// convolutionVJP implements the VJP of Convolution, for the case without channel or batch grouping.
+func (b *vjpBuilder) convolutionVJP(stmt *Statement, g *Value) []*Value {
+	input, kernel := stmt.Inputs[0], stmt.Inputs[1]
+	p := stmt.params.(*convolutionParams)
+	if p.channelGroupCount != 1 || p.batchGroupCount != 1 {
+		transformMust[any](nil, errors.Errorf("VJP of Convolution with channelGroupCount=%d and batchGroupCount=%d is not supported",
+			p.channelGroupCount, p.batchGroupCount))
+	}
+	numSpatial := len(p.inputSpatialAxes)
+	var dInput, dKernel *Value
+	if b.needsGrad[input] {
+		paddings := make([][2]int, numSpatial)
+		for i := range numSpatial {
+			inDim := dilateDim(input.shape.Dimensions[p.inputSpatialAxes[i]], p.inputDilations[i])
+			kernelDim := dilateDim(kernel.shape.Dimensions[p.kernelSpatialAxes[i]], p.kernelDilations[i])
+			outDim := dilateDim(g.shape.Dimensions[p.outputSpatialAxes[i]], p.strides[i])
+			before := kernelDim - p.paddings[i][0] - 1
+			after := inDim + kernelDim - 1 - outDim - before
+			paddings[i] = [2]int{before, after}
+		}
+		reversedKernel := transformMust(Reverse(kernel, slices.Clone(p.kernelSpatialAxes)...))
+		dInput = transformMust(Convolution(g, reversedKernel,
+			p.inputDilations, paddings, p.strides, p.kernelDilations,
+			p.outputBatchAxis, p.outputChannelsAxis, p.outputSpatialAxes,
+			p.kernelOutputChannelsAxis, p.kernelInputChannelsAxis, p.kernelSpatialAxes,
+			p.inputBatchAxis, p.inputChannelsAxis, p.inputSpatialAxes,
+			1, 1, p.inputPrecision, p.kernelPrecision))
+	}
+	if b.needsGrad[kernel] {
+		paddings := make([][2]int, numSpatial)
+		for i := range numSpatial {
+			inDim := dilateDim(input.shape.Dimensions[p.inputSpatialAxes[i]], p.inputDilations[i])
+			kernelDim := dilateDim(kernel.shape.Dimensions[p.kernelSpatialAxes[i]], p.kernelDilations[i])
+			outDim := dilateDim(g.shape.Dimensions[p.outputSpatialAxes[i]], p.strides[i])
+			before := p.paddings[i][0]
+			after := (outDim - inDim) + (kernelDim - before - 1)
+			paddings[i] = [2]int{before, after}
+		}
+		dKernel = transformMust(Convolution(input, g,
+			p.kernelDilations, paddings, p.inputDilations, p.strides,
+			p.inputChannelsAxis, p.inputBatchAxis, p.inputSpatialAxes,
+			p.outputBatchAxis, p.outputChannelsAxis, p.outputSpatialAxes,
+			p.kernelInputChannelsAxis, p.kernelOutputChannelsAxis, p.kernelSpatialAxes,
+			1, 1, p.inputPrecision, p.kernelPrecision))
+	}
+	return []*Value{dInput, dKernel}
+}
+
+// reduceVJP implements the VJP of a Reduce with a single input and a sum, max or min reduction function.
+func (b *vjpBuilder) reduceVJP(stmt *Statement, g *Value) []*Value {
+	if len(stmt.Outputs) != 1 {
+		transformMust[any](nil, errors.New("VJP of Reduce with multiple inputs is not supported"))
+	}
+	x, initialValue, y := stmt.Inputs[0], stmt.Inputs[1], stmt.Outputs[0]
+	params := stmt.params.(*reduceParams)
+	kept := freeAxes(x.shape.Rank(), params.axes)
+	op, ok := singleInputClosureOp(stmt.FunctionParameters[0])
+	broadcast := func(v *Value) *Value {
+		return transformMust(BroadcastInDim(v, x.shape, kept))
+	}
+	switch {
+	case ok && op == optypes.Add:
+		var dInit *Value
+		if b.needsGrad[initialValue] {
+			dInit = b.reduceSum(g, allAxes(g.shape.Rank())...)
+		}
+		return []*Value{broadcast(g), dInit}
+	case ok && (op == optypes.Maximum || op == optypes.Minimum):
+		// The cotangent is split equally among the elements equal to the max (or min).
+		isSelected := b.compare(x, broadcast(y), types.CompareEQ)
+		count := b.reduceSum(transformMust(Convert(isSelected, x.shape.DType)), params.axes...)
+		return []*Value{b.selectOrZero(isSelected, broadcast(b.div(g, count))), nil}
+	}
+	transformMust[any](nil, errors.New("VJP of Reduce is only supported for sum, max or min reductions"))
+	return nil
+}
+
+// gatherVJP implements the VJP of Gather, by scatter-adding the cotangent.
+func (b *vjpBuilder) gatherVJP(stmt *Statement, g *Value) []*Value {
+	operand, startIndices := stmt.Inputs[0], stmt.Inputs[1]
+	p := stmt.params.(*gatherParams)
+	dOperand := transformMust(Scatter(b.zerosLike(operand), startIndices, g,
+		p.offsetOutputAxes, p.collapsedSliceAxes,
+		p.operandBatchingAxes, p.startIndicesBatchingAxes,
+		p.startIndexMap, p.indexVectorAxis,
+		p.indicesAreSorted, false,
+		scalarBinaryClosure(b.fn, optypes.Add, operand.shape.DType)))
+	return []*Value{dOperand, nil}
+}
+
+// scatterVJP implements the VJP of Scatter, for the cases where the updates are added or replace the input values.
+func (b *vjpBuilder) scatterVJP(stmt *Statement, g *Value) []*Value {
+	if len(stmt.Outputs) != 1 {
+		transformMust[any](nil, errors.New("VJP of Scatter with multiple inputs is not supported"))
+	}
+	input, indices, updates := stmt.Inputs[0], stmt.Inputs[1], stmt.Inputs[2]
+	p := stmt.params.(*scatterParams)
+	op, ok := singleInputClosureOp(stmt.FunctionParameters[0])
+	if !ok || (op != optypes.Add && op != optypes.FuncReturn) {
+		transformMust[any](nil, errors.New("VJP of Scatter is only supported for update functions that add or replace values"))
+	}
+
+	// The cotangent of the updates is gathered from the cotangent of the output.
+	var dUpdates *Value
+	if b.needsGrad[updates] {
+		sliceSizes := make([]int, input.shape.Rank())
+		pos := 0
+		for axis := range sliceSizes {
+			if slices.Contains(p.insertedWindowAxes, axis) || slices.Contains(p.inputBatchingAxes, axis) {
+				sliceSizes[axis] = 1
+			} else {
+				sliceSizes[axis] = updates.shape.Dimensions[p.updateWindowAxes[pos]]
+				pos++
+			}
+		}
+		dUpdates = transformMust(Gather(g, indices, p.indexVectorAxis,
+			p.updateWindowAxes, p.insertedWindowAxes,
+			p.inputBatchingAxes, p.scatterIndicesBatchingAxes,
+			p.indexedInputAxes, sliceSizes, p.indicesAreSorted))
+	}
+
+	dInput := g
+	if op == optypes.FuncReturn && b.needsGrad[input] {
+		// Values replaced by the updates don't contribute to the output.
+		dInput = transformMust(Scatter(g, indices, b.zerosLike(updates),
+			p.updateWindowAxes, p.insertedWindowAxes,
+			p.inputBatchingAxes, p.scatterIndicesBatchingAxes,
+			p.indexedInputAxes, p.indexVectorAxis,
+			p.indicesAreSorted, p.uniqueIndices,
+			scalarBinaryClosure(b.fn, optypes.FuncReturn, g.shape.DType)))
+	}
+	return []*Value{dInput, nil, dUpdates}
+}
@@ -0,0 +1,106 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVJP(t *testing.T) {
+	t.Run("matmul and reduce", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 2, 3)))
+		w := must1(fn.NamedInput("w", shapes.Make(dtypes.Float32, 3, 4)))
+		y := must1(DotGeneral(x, []int{1}, nil, w, []int{0}, nil).Done())
+		y = must1(Tanh(y))
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		closure := fn.Closure()
+		lhs := must1(closure.Input(shapes.Make(dtypes.Float32)))
+		rhs := must1(closure.Input(shapes.Make(dtypes.Float32)))
+		require.NoError(t, closure.Return(must1(Add(lhs, rhs))))
+		loss := must1(Reduce(y, zero, closure, 0, 1))
+		require.NoError(t, fn.Return(loss))
+
+		grad := must1(VJP(fn, "grad", 0, 1))
+		require.Len(t, grad.Inputs, 3)
+		require.Equal(t, "cotangent0", grad.Inputs[2].name)
+		require.Len(t, grad.Outputs, 3)
+		require.True(t, grad.Outputs[0].Shape().IsScalar())
+		require.True(t, grad.Outputs[1].Shape().Equal(x.Shape()))
+		require.True(t, grad.Outputs[2].Shape().Equal(w.Shape()))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, "func.func @grad(")
+	})
+
+	t.Run("unrolled while", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		counter := must1(fn.ConstantFromScalar(int32(0)))
+
+		cond := fn.Closure()
+		condCounter := must1(cond.Input(counter.Shape()))
+		must1(cond.Input(x.Shape()))
+		limit := must1(cond.ConstantFromScalar(int32(3)))
+		require.NoError(t, cond.Return(must1(Compare(condCounter, limit, types.CompareLT, types.CompareSigned))))
+
+		body := fn.Closure()
+		bodyCounter := must1(body.Input(counter.Shape()))
+		bodyX := must1(body.Input(x.Shape()))
+		one := must1(body.ConstantFromScalar(int32(1)))
+		require.NoError(t, body.Return(must1(Add(bodyCounter, one)), must1(Multiply(bodyX, bodyX))))
+
+		results := must1(While(cond, body, counter, x))
+		require.NoError(t, fn.Return(results[1]))
+
+		grad := must1(VJP(fn, "grad", 0))
+		numMultiply := 0
+		for _, stmt := range grad.Statements {
+			require.NotEqual(t, optypes.While, stmt.OpType)
+			if stmt.OpType == optypes.Multiply {
+				numMultiply++
+			}
+		}
+		// 3 iterations in the forward pass, and 2 multiplications for each in the backward pass.
+		require.Equal(t, 9, numMultiply)
+		_ = must1(b.Build())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		i := must1(fn.NamedInput("i", shapes.Make(dtypes.Int32, 3)))
+		y := must1(Cosine(x))
+		_, err := VJP(fn, "grad", 0)
+		require.ErrorContains(t, err, "to have returned")
+		require.NoError(t, fn.Return(y, i))
+
+		_, err = VJP(fn, "grad", 1)
+		require.ErrorContains(t, err, "must be a float")
+		_, err = VJP(fn, "grad", 2)
+		require.ErrorContains(t, err, "out of range")
+		_, err = VJP(fn, "grad", 0, 0)
+		require.ErrorContains(t, err, "more than once")
+
+		fn2 := b.NewFunction("sorted")
+		x2 := must1(fn2.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		cmp := fn2.Closure()
+		lhs := must1(cmp.Input(shapes.Make(dtypes.Float32)))
+		rhs := must1(cmp.Input(shapes.Make(dtypes.Float32)))
+		require.NoError(t, cmp.Return(must1(Compare(lhs, rhs, types.CompareLT, types.CompareFloat))))
+		sorted := must1(Sort(cmp, 0, false, x2))
+		require.NoError(t, fn2.Return(sorted...))
+		numFunctions := len(b.functions)
+		_, err = VJP(fn2, "grad2", 0)
+		require.ErrorContains(t, err, "not supported")
+		require.Len(t, b.functions, numFunctions, "partially built VJP function should be removed")
+	})
+}
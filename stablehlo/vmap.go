@@ -0,0 +1,645 @@
+package stablehlo
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/internal/shapeinference"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/gomlx/go-xla/types/shardy"
+	"github.com/pkg/errors"
+)
+
+// Vmap creates a new function (with the given name) in the builder of fn that applies fn to a batch of
+// batchSize examples at once -- a.k.a. "vectorizing map".
+//
+// inputAxes must have one entry per input of fn: the axis of the corresponding input of the new function that
+// holds the batch, or -1 if the input is not batched (it is shared by all examples).
+// So the inputs of the new function have the shapes of the inputs of fn, with an extra axis of dimension
+// batchSize inserted at inputAxes[i] (for batched inputs).
+//
+// The outputs of the new function have the shapes of the outputs of fn, with an extra leading axis of
+// dimension batchSize. Outputs that don't depend on any batched input are broadcast.
+// Attributes (e.g. shardings) of the inputs and outputs of fn are not carried over, since the shapes change.
+//
+// fn must be a top-level function (not a closure) that has already returned.
+//
+// Supported ops (when they depend on a batched value):
+//   - All elementwise ops (unary, binary, Compare, Select, Clamp, Convert, IsFinite, etc.) and OptimizationBarrier.
+//   - BroadcastInDim, Reshape, Transpose, Reverse, Slice, Pad (with a non-batched fill value), Concatenate.
+//   - ShardingConstraint and Reshard: the batch axis is replicated.
+//   - Sharding groups (see Builder.NewShardingGroup): batched values are added to new groups.
+//   - DotGeneral: batched operands get an extra batch (or free) axis.
+//   - Convolution with a batched input (but not a batched kernel) and no batch grouping.
+//   - Reduce (with non-batched initial values), Gather and Scatter: the batch becomes a batching axis.
+//   - While: the states that depend on batched values are batched. If the condition is batched, the loop runs
+//     while the condition is true for any example, and the states of examples whose condition is false are kept
+//     unchanged (with a Select).
+//   - If: with a non-batched predicate the branches are batched; with a batched predicate both branches are
+//     evaluated and the results selected per example.
+//
+// Closures of other ops (e.g. the reduction function of Reduce) can't use batched values of a parent function.
+// It returns an error for any other op that depends on a batched value.
+func Vmap(fn *Function, name string, batchSize int, inputAxes []int) (vmapFn *Function, err error) {
+	if fn.Parent != nil {
+		return nil, errors.Errorf("Vmap requires a top-level function, but %q is a closure", fn.Name)
+	}
+	if !fn.Returned {
+		return nil, errors.Errorf("Vmap requires function %q to have returned", fn.Name)
+	}
+	if batchSize <= 0 {
+		return nil, errors.Errorf("Vmap requires a positive batchSize, got %d", batchSize)
+	}
+	if len(inputAxes) != len(fn.Inputs) {
+		return nil, errors.Errorf("Vmap requires one input axis per input of function %q: got %d axes for %d inputs",
+			fn.Name, len(inputAxes), len(fn.Inputs))
+	}
+	for i, axis := range inputAxes {
+		if axis < -1 || axis > fn.Inputs[i].shape.Rank() {
+			return nil, errors.Errorf("Vmap input axis %d for input #%d (shape %s) is out of range",
+				axis, i, fn.Inputs[i].shape)
+		}
+	}
+
+	numFunctions := len(fn.Builder.functions)
+	defer func() {
+		if r := recover(); r != nil {
+			err = fn.Builder.recoverTransform(r, numFunctions)
+			err = errors.WithMessagef(err, "Vmap of function %q", fn.Name)
+			vmapFn = nil
+		}
+	}()
+	b := &vmapBuilder{
+		batchSize: batchSize,
+		values:    make(map[string]*Value),
+		batched:   make(map[string]bool),
+	}
+	vmapFn = fn.Builder.NewFunction(name)
+	for i, input := range fn.Inputs {
+		axis := inputAxes[i]
+		if axis == -1 {
+			b.values[input.name] = transformMust(vmapFn.NamedInput(input.name, input.shape))
+			continue
+		}
+		shape := input.shape.Clone()
+		shape.Dimensions = slices.Insert(shape.Dimensions, axis, batchSize)
+		newInput := transformMust(vmapFn.NamedInput(input.name, shape))
+		if axis != 0 {
+			permutation := slices.Insert(slices.Delete(allAxes(shape.Rank()), axis, axis+1), 0, axis)
+			newInput = transformMust(Transpose(newInput, permutation...))
+		}
+		b.values[input.name] = newInput
+		b.batched[input.name] = true
+	}
+
+	b.analyze(fn.Statements)
+	results := b.build(vmapFn, fn.Statements)
+	for i, result := range results {
+		results[i] = b.ensureBatched(result, fn.Outputs[i].shape)
+	}
+	transformMust[any](nil, vmapFn.Return(results...))
+	return vmapFn, nil
+}
+
+// vmapBuilder holds the state of the Vmap function being built.
+//
+// All batched values have the batch as their leading axis.
+type vmapBuilder struct {
+	batchSize int
+
+	// values maps the names of the values of the original function to the values in the new function.
+	values map[string]*Value
+
+	// batched marks the names of the values of the original function that are batched.
+	batched map[string]bool
+
+	// shardingGroups maps the sharding groups of batched values to the new groups created for their batched
+	// version: batched values have different dimensions, so they can't be in the original groups.
+	shardingGroups map[int64]int64
+}
+
+// vmapElementwiseOps are the ops handled generically by Vmap: they work on any shape, and their
+// attributes don't depend on it.
+var vmapElementwiseOps = func() map[optypes.OpType]bool {
+	ops := map[optypes.OpType]bool{
+		optypes.Compare:             true,
+		optypes.Select:              true,
+		optypes.Clamp:               true,
+		optypes.Convert:             true,
+		optypes.IsFinite:            true,
+		optypes.Complex:             true,
+		optypes.Real:                true,
+		optypes.Imag:                true,
+		optypes.OptimizationBarrier: true,
+	}
+	for op := range shapeinference.StandardUnaryOperations {
+		ops[op] = true
+	}
+	for op := range shapeinference.StandardBinaryOperations {
+		ops[op] = true
+	}
+	return ops
+}()
+
+// analyze marks which values of the statements (and of their closures) are batched.
+//
+// It over-approximates: a value marked as batched may be built unbatched (it is then broadcast), but
+// not the other way around.
+func (b *vmapBuilder) analyze(statements []*Statement) {
+	for _, stmt := range statements {
+		switch stmt.OpType {
+		case optypes.FuncReturn:
+			continue
+		case optypes.While:
+			b.analyzeWhile(stmt)
+			continue
+		case optypes.If:
+			b.analyze(stmt.FunctionParameters[0].Statements)
+			b.analyze(stmt.FunctionParameters[1].Statements)
+			predBatched := b.batched[stmt.Inputs[0].name]
+			for i, output := range stmt.Outputs {
+				if predBatched || b.isReturnedBatched(stmt.FunctionParameters[0], i) ||
+					b.isReturnedBatched(stmt.FunctionParameters[1], i) {
+					b.batched[output.name] = true
+				}
+			}
+			continue
+		}
+		for _, closure := range stmt.FunctionParameters {
+			b.analyze(closure.Statements)
+			closure.walkStatementsInOrder(true, func(closureStmt *Statement) {
+				for _, input := range closureStmt.Inputs {
+					if b.batched[input.name] {
+						transformMust[any](nil, errors.Errorf("Vmap of %s with closures using batched values is not supported",
+							stmt.OpType))
+					}
+				}
+			})
+		}
+		anyBatched := false
+		for _, input := range stmt.Inputs {
+			anyBatched = anyBatched || b.batched[input.name]
+		}
+		if anyBatched {
+			for _, output := range stmt.Outputs {
+				b.batched[output.name] = true
+			}
+		}
+	}
+}
+
+// isReturnedBatched returns whether the i-th value returned by fn is batched.
+func (b *vmapBuilder) isReturnedBatched(fn *Function, i int) bool {
+	returnStmt := fn.Statements[len(fn.Statements)-1]
+	return b.batched[returnStmt.Inputs[i].name]
+}
+
+// analyzeWhile marks the batched states of a While loop, until a fixed point is reached.
+func (b *vmapBuilder) analyzeWhile(stmt *Statement) {
+	cond, body := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	markState := func(i int) {
+		b.batched[cond.Inputs[i].name] = true
+		b.batched[body.Inputs[i].name] = true
+		b.batched[stmt.Outputs[i].name] = true
+	}
+	for i, input := range stmt.Inputs {
+		if b.batched[input.name] {
+			markState(i)
+		}
+	}
+	for {
+		b.analyze(cond.Statements)
+		b.analyze(body.Statements)
+		predBatched := b.isReturnedBatched(cond, 0)
+		changed := false
+		for i, input := range body.Inputs {
+			if !b.batched[input.name] && (predBatched || b.isReturnedBatched(body, i)) {
+				markState(i)
+				changed = true
+			}
+		}
+		if !changed {
+			return
+		}
+	}
+}
+
+// get returns the value in target that corresponds to the value v of the original function.
+func (b *vmapBuilder) get(target *Function, v *Value) *Value {
+	mapped, found := b.values[v.name]
+	if !found {
+		transformMust[any](nil, errors.Errorf("value %s is not defined", v))
+	}
+	return transformMust(target.valueRef(mapped))
+}
+
+// ensureBatched returns v with a leading batch axis: if v is not batched (it has the shape srcShape of the
+// original value), it is broadcast.
+func (b *vmapBuilder) ensureBatched(v *Value, srcShape shapes.Shape) *Value {
+	if v.shape.Rank() == srcShape.Rank()+1 {
+		return v
+	}
+	mapping := make([]int, srcShape.Rank())
+	for axis := range mapping {
+		mapping[axis] = axis + 1
+	}
+	return transformMust(BroadcastInDim(v, b.batchedShape(srcShape), mapping))
+}
+
+// batchedIn returns the value in target corresponding to the input v of the original function, with
+// a leading batch axis.
+func (b *vmapBuilder) batchedIn(target *Function, v *Value) *Value {
+	return b.ensureBatched(b.get(target, v), v.shape)
+}
+
+// batchedShape returns the shape with a leading batch axis.
+func (b *vmapBuilder) batchedShape(shape shapes.Shape) shapes.Shape {
+	return shapes.Make(shape.DType, append([]int{b.batchSize}, shape.Dimensions...)...)
+}
+
+// broadcastBatchedScalar broadcasts a batched scalar (shape [batchSize]) to the batched shape.
+func (b *vmapBuilder) broadcastBatchedScalar(v *Value, shape shapes.Shape) *Value {
+	return transformMust(BroadcastInDim(v, b.batchedShape(shapes.Make(v.shape.DType, shape.Dimensions...)), []int{0}))
+}
+
+// shiftAxes returns the axes shifted by one, to account for the leading batch axis.
+// If withBatch is true, the batch axis 0 is prepended.
+func shiftAxes(axes []int, withBatch bool) []int {
+	shifted := make([]int, 0, len(axes)+1)
+	if withBatch {
+		shifted = append(shifted, 0)
+	}
+	for _, axis := range axes {
+		shifted = append(shifted, axis+1)
+	}
+	return shifted
+}
+
+// moveAxisToFront transposes x such that axis becomes the leading axis.
+func moveAxisToFront(x *Value, axis int) *Value {
+	if axis == 0 {
+		return x
+	}
+	permutation := slices.Insert(slices.Delete(allAxes(x.shape.Rank()), axis, axis+1), 0, axis)
+	return transformMust(Transpose(x, permutation...))
+}
+
+// build creates the batched version of the statements in target.
+// It returns the values returned by the statements (the inputs to the FuncReturn statement), but it doesn't
+// add the return statement to target.
+func (b *vmapBuilder) build(target *Function, statements []*Statement) []*Value {
+	for _, stmt := range statements {
+		if stmt.OpType == optypes.FuncReturn {
+			returned := make([]*Value, len(stmt.Inputs))
+			for i, input := range stmt.Inputs {
+				returned[i] = b.get(target, input)
+			}
+			return returned
+		}
+		if stmt.OpType == optypes.ShardingGroup && b.batched[stmt.Inputs[0].name] {
+			b.buildShardingGroup(target, stmt)
+			continue
+		}
+		anyBatched := false
+		for _, output := range stmt.Outputs {
+			anyBatched = anyBatched || b.batched[output.name]
+		}
+		if !anyBatched {
+			transformMust(target.cloneStatement(stmt, b.values))
+			continue
+		}
+		results := b.buildStatement(target, stmt)
+		for i, output := range stmt.Outputs {
+			result := results[i]
+			if b.batched[output.name] {
+				result = b.ensureBatched(result, output.shape)
+			}
+			b.values[output.name] = result
+		}
+	}
+	transformMust[any](nil, errors.New("missing return statement"))
+	return nil
+}
+
+// buildShardingGroup adds the batched input of the ShardingGroup stmt to a new sharding group.
+func (b *vmapBuilder) buildShardingGroup(target *Function, stmt *Statement) {
+	params := stmt.params.(*shardingGroupParams)
+	if b.shardingGroups == nil {
+		b.shardingGroups = make(map[int64]int64)
+	}
+	groupID, found := b.shardingGroups[params.groupID]
+	if !found {
+		groupID = transformMust(target.Builder.NewShardingGroup())
+		b.shardingGroups[params.groupID] = groupID
+	}
+	transformMust[any](nil, target.Builder.AddToShardingGroup(groupID, b.batchedIn(target, stmt.Inputs[0])))
+}
+
+// buildStatement creates the batched version of stmt in target, and returns its outputs.
+func (b *vmapBuilder) buildStatement(target *Function, stmt *Statement) []*Value {
+	x := stmt.Inputs
+	if vmapElementwiseOps[stmt.OpType] {
+		return b.buildElementwise(target, stmt)
+	}
+	switch stmt.OpType {
+	case optypes.Reshape:
+		return []*Value{transformMust(Reshape(b.batchedIn(target, x[0]), b.batchedShape(stmt.Outputs[0].shape)))}
+	case optypes.Transpose:
+		params := stmt.params.(*transposeParams)
+		return []*Value{transformMust(Transpose(b.batchedIn(target, x[0]), shiftAxes(params.permutation, true)...))}
+	case optypes.Reverse:
+		params := stmt.params.(*reverseParams)
+		return []*Value{transformMust(Reverse(b.batchedIn(target, x[0]), shiftAxes(params.axes, false)...))}
+	case optypes.ShardingConstraint, optypes.Reshard:
+		params := stmt.params.(*shardingParams)
+		batchedSpec := &shardy.ShardingSpec{
+			Mesh:           params.shardingSpec.Mesh,
+			Axes:           append([]shardy.TensorAxisSpec{{}}, params.shardingSpec.Axes...),
+			ReplicatedAxes: params.shardingSpec.ReplicatedAxes,
+		}
+		return []*Value{transformMust(shardingOp(stmt.OpType, b.batchedIn(target, x[0]), batchedSpec))}
+	case optypes.BroadcastInDim:
+		params := stmt.params.(*broadcastInDimParams)
+		return []*Value{transformMust(BroadcastInDim(b.batchedIn(target, x[0]), b.batchedShape(stmt.Outputs[0].shape),
+			shiftAxes(params.axesMapping, true)))}
+	case optypes.Slice:
+		params := stmt.params.(*sliceParams)
+		return []*Value{transformMust(Slice(b.batchedIn(target, x[0]),
+			append([]int{0}, params.starts...), append([]int{b.batchSize}, params.limits...),
+			append([]int{1}, params.strides...)))}
+	case optypes.Pad:
+		if b.batched[x[1].name] {
+			transformMust[any](nil, errors.New("Vmap of Pad with a batched fill value is not supported"))
+		}
+		params := stmt.params.(*padParams)
+		return []*Value{transformMust(Pad(b.batchedIn(target, x[0]), b.get(target, x[1]),
+			append([]int{0}, params.start...), append([]int{0}, params.end...),
+			append([]int{0}, params.interior...)))}
+	case optypes.Concatenate:
+		params := stmt.params.(*concatenateParams)
+		operands := make([]*Value, len(x))
+		for i, operand := range x {
+			operands[i] = b.batchedIn(target, operand)
+		}
+		return []*Value{transformMust(Concatenate(params.axis+1, operands...))}
+	case optypes.DotGeneral:
+		return []*Value{b.buildDotGeneral(target, stmt)}
+	case optypes.Convolution:
+		return []*Value{b.buildConvolution(target, stmt)}
+	case optypes.Reduce:
+		numInputs := len(x) / 2
+		inputs := make([]*Value, numInputs)
+		initialValues := make([]*Value, numInputs)
+		for i := range numInputs {
+			if b.batched[x[numInputs+i].name] {
+				transformMust[any](nil, errors.New("Vmap of Reduce with batched initial values is not supported"))
+			}
+			inputs[i] = b.batchedIn(target, x[i])
+			initialValues[i] = b.get(target, x[numInputs+i])
+		}
+		params := stmt.params.(*reduceParams)
+		reductionFn := transformMust(target.cloneClosure(stmt.FunctionParameters[0], b.values))
+		return transformMust(MultiReduce(inputs, initialValues, reductionFn, shiftAxes(params.axes, false)...))
+	case optypes.Gather:
+		return []*Value{b.buildGather(target, stmt)}
+	case optypes.Scatter:
+		return b.buildScatter(target, stmt)
+	case optypes.While:
+		return b.buildWhile(target, stmt)
+	case optypes.If:
+		return b.buildIf(target, stmt)
+	}
+	transformMust[any](nil, errors.Errorf("Vmap of op %s is not supported", stmt.OpType))
+	return nil
+}
+
+// buildElementwise creates the batched version of an elementwise op: all operands are batched, and the op
+// is re-created with the same attributes.
+func (b *vmapBuilder) buildElementwise(target *Function, stmt *Statement) []*Value {
+	outputShapes := make([]shapes.Shape, len(stmt.Outputs))
+	for i, output := range stmt.Outputs {
+		outputShapes[i] = b.batchedShape(output.shape)
+	}
+	inputs := make([]*Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		// Select and Clamp accept scalars for some of their operands.
+		isScalarOperand := (stmt.OpType == optypes.Select || stmt.OpType == optypes.Clamp) &&
+			input.shape.IsScalar() && !stmt.Outputs[0].shape.IsScalar()
+		switch {
+		case isScalarOperand && b.batched[input.name]:
+			inputs[i] = b.broadcastBatchedScalar(b.get(target, input), stmt.Outputs[0].shape)
+		case isScalarOperand:
+			inputs[i] = b.get(target, input)
+		default:
+			inputs[i] = b.batchedIn(target, input)
+		}
+	}
+	if target.Returned {
+		transformMust[any](nil, errors.Errorf("cannot add operation %s after returning, in function %q",
+			stmt.OpType, target.Name))
+	}
+	newStmt := target.addMultiOp(stmt.OpType, outputShapes, inputs)
+	newStmt.Attributes = maps.Clone(stmt.Attributes)
+	return newStmt.Outputs
+}
+
+// buildDotGeneral creates the batched version of a DotGeneral: if both operands are batched, the batch is
+// added as a batch axis. Otherwise, it is a free axis of the batched operand, and it is moved to the front
+// of the result.
+func (b *vmapBuilder) buildDotGeneral(target *Function, stmt *Statement) *Value {
+	lhs, rhs := stmt.Inputs[0], stmt.Inputs[1]
+	params := stmt.params.(*dotGeneralParams)
+	lhsBatched, rhsBatched := b.batched[lhs.name], b.batched[rhs.name]
+	lhsContracting, lhsBatch := params.lhsContractingAxes, params.lhsBatchAxes
+	rhsContracting, rhsBatch := params.rhsContractingAxes, params.rhsBatchAxes
+	numBatch := len(lhsBatch)
+	batchAxis := 0
+	switch {
+	case lhsBatched && rhsBatched:
+		lhsContracting, lhsBatch = shiftAxes(lhsContracting, false), shiftAxes(lhsBatch, true)
+		rhsContracting, rhsBatch = shiftAxes(rhsContracting, false), shiftAxes(rhsBatch, true)
+	case lhsBatched:
+		lhsContracting, lhsBatch = shiftAxes(lhsContracting, false), shiftAxes(lhsBatch, false)
+		batchAxis = numBatch
+	default:
+		rhsContracting, rhsBatch = shiftAxes(rhsContracting, false), shiftAxes(rhsBatch, false)
+		batchAxis = numBatch + lhs.shape.Rank() - len(lhsContracting) - numBatch
+	}
+	builder := DotGeneral(b.get(target, lhs), lhsContracting, lhsBatch, b.get(target, rhs), rhsContracting, rhsBatch).
+		Precision(params.precision[0], params.precision[1]).
+		OutputDType(params.outputDType)
+	if params.algorithm != nil {
+		builder = builder.Algorithm(params.algorithm)
+	}
+	return moveAxisToFront(transformMust(builder.Done()), batchAxis)
+}
+
+// buildConvolution creates the batched version of a Convolution with a batched input: the batch is merged
+// into the batch axis of the input, and split back from the batch axis of the output.
+func (b *vmapBuilder) buildConvolution(target *Function, stmt *Statement) *Value {
+	input, kernel := stmt.Inputs[0], stmt.Inputs[1]
+	p := stmt.params.(*convolutionParams)
+	if b.batched[kernel.name] {
+		transformMust[any](nil, errors.New("Vmap of Convolution with a batched kernel is not supported"))
+	}
+	if p.batchGroupCount != 1 {
+		transformMust[any](nil, errors.Errorf("Vmap of Convolution with batchGroupCount=%d is not supported",
+			p.batchGroupCount))
+	}
+
+	// Move the vmap batch axis next to the input batch axis, and merge them.
+	x := b.batchedIn(target, input)
+	permutation := slices.Insert(allAxes(x.shape.Rank())[1:], p.inputBatchAxis, 0)
+	x = transformMust(Transpose(x, permutation...))
+	mergedShape := input.shape.Clone()
+	mergedShape.Dimensions[p.inputBatchAxis] *= b.batchSize
+	x = transformMust(Reshape(x, mergedShape))
+
+	y := transformMust(Convolution(x, b.get(target, kernel),
+		p.strides, p.paddings, p.inputDilations, p.kernelDilations,
+		p.inputBatchAxis, p.inputChannelsAxis, p.inputSpatialAxes,
+		p.kernelInputChannelsAxis, p.kernelOutputChannelsAxis, p.kernelSpatialAxes,
+		p.outputBatchAxis, p.outputChannelsAxis, p.outputSpatialAxes,
+		p.channelGroupCount, p.batchGroupCount, p.inputPrecision, p.kernelPrecision))
+
+	// Split the output batch axis and move the vmap batch axis to the front.
+	splitShape := stmt.Outputs[0].shape.Clone()
+	splitShape.Dimensions = slices.Insert(splitShape.Dimensions, p.outputBatchAxis, b.batchSize)
+	y = transformMust(Reshape(y, splitShape))
+	return moveAxisToFront(y, p.outputBatchAxis)
+}
+
+// buildGather creates the batched version of a Gather: if the operand is batched, the batch becomes a
+// batching axis of the operand and of the indices. Otherwise, it is just one more batch axis of the indices.
+func (b *vmapBuilder) buildGather(target *Function, stmt *Statement) *Value {
+	operand, indices := stmt.Inputs[0], stmt.Inputs[1]
+	p := stmt.params.(*gatherParams)
+	newIndices := b.batchedIn(target, indices)
+	if !b.batched[operand.name] {
+		return transformMust(Gather(b.get(target, operand), newIndices, p.indexVectorAxis+1,
+			shiftAxes(p.offsetOutputAxes, false), p.collapsedSliceAxes,
+			p.operandBatchingAxes, shiftAxes(p.startIndicesBatchingAxes, false),
+			p.startIndexMap, p.sliceSizes, p.indicesAreSorted))
+	}
+	return transformMust(Gather(b.get(target, operand), newIndices, p.indexVectorAxis+1,
+		shiftAxes(p.offsetOutputAxes, false), shiftAxes(p.collapsedSliceAxes, false),
+		shiftAxes(p.operandBatchingAxes, true), shiftAxes(p.startIndicesBatchingAxes, true),
+		shiftAxes(p.startIndexMap, false), append([]int{1}, p.sliceSizes...), p.indicesAreSorted))
+}
+
+// buildScatter creates the batched version of a Scatter: the batch becomes a batching axis of the inputs
+// and of the indices.
+func (b *vmapBuilder) buildScatter(target *Function, stmt *Statement) []*Value {
+	numInputs := (len(stmt.Inputs) - 1) / 2
+	p := stmt.params.(*scatterParams)
+	inputs := make([]*Value, numInputs)
+	updates := make([]*Value, numInputs)
+	for i := range numInputs {
+		inputs[i] = b.batchedIn(target, stmt.Inputs[i])
+		updates[i] = b.batchedIn(target, stmt.Inputs[numInputs+1+i])
+	}
+	indices := b.batchedIn(target, stmt.Inputs[numInputs])
+	updateFn := transformMust(target.cloneClosure(stmt.FunctionParameters[0], b.values))
+	return transformMust(MultiScatter(inputs, indices, updates,
+		shiftAxes(p.updateWindowAxes, false), shiftAxes(p.insertedWindowAxes, false),
+		shiftAxes(p.inputBatchingAxes, true), shiftAxes(p.scatterIndicesBatchingAxes, true),
+		shiftAxes(p.indexedInputAxes, false), p.indexVectorAxis+1,
+		p.indicesAreSorted, p.uniqueIndices, updateFn))
+}
+
+// buildClosureInputs creates the inputs of closure for the inputs of src, batched according to the analysis.
+func (b *vmapBuilder) buildClosureInputs(closure, src *Function) []*Value {
+	inputs := make([]*Value, len(src.Inputs))
+	for i, input := range src.Inputs {
+		shape := input.shape
+		if b.batched[input.name] {
+			shape = b.batchedShape(shape)
+		}
+		inputs[i] = transformMust(closure.Input(shape))
+		b.values[input.name] = inputs[i]
+	}
+	return inputs
+}
+
+// buildWhile creates the batched version of a While loop.
+//
+// If the condition is batched, the loop runs while any of the examples is still running, and the states of the
+// examples that are done are kept unchanged.
+func (b *vmapBuilder) buildWhile(target *Function, stmt *Statement) []*Value {
+	cond, body := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	predBatched := b.isReturnedBatched(cond, 0)
+	initialStates := make([]*Value, len(stmt.Inputs))
+	for i, input := range stmt.Inputs {
+		initialStates[i] = b.get(target, input)
+		if b.batched[body.Inputs[i].name] {
+			initialStates[i] = b.ensureBatched(initialStates[i], input.shape)
+		}
+	}
+
+	newCond := target.Closure()
+	b.buildClosureInputs(newCond, cond)
+	pred := b.build(newCond, cond.Statements)[0]
+	if predBatched {
+		pred = b.ensureBatched(pred, shapes.Make(dtypes.Bool))
+		falseValue := transformMust(newCond.ConstantFromScalar(false))
+		pred = transformMust(Reduce(pred, falseValue, scalarBinaryClosure(newCond, optypes.Or, dtypes.Bool), 0))
+	}
+	transformMust[any](nil, newCond.Return(pred))
+
+	newBody := target.Closure()
+	states := b.buildClosureInputs(newBody, body)
+	if predBatched {
+		// Re-evaluate the condition per example in the body.
+		for i, input := range cond.Inputs {
+			b.values[input.name] = states[i]
+		}
+		pred = b.ensureBatched(b.build(newBody, cond.Statements)[0], shapes.Make(dtypes.Bool))
+	}
+	newStates := b.build(newBody, body.Statements)
+	for i, input := range body.Inputs {
+		if !b.batched[input.name] {
+			continue
+		}
+		newStates[i] = b.ensureBatched(newStates[i], input.shape)
+		if predBatched {
+			newStates[i] = transformMust(Select(b.broadcastBatchedScalar(pred, input.shape), newStates[i], states[i]))
+		}
+	}
+	transformMust[any](nil, newBody.Return(newStates...))
+	return transformMust(While(newCond, newBody, initialStates...))
+}
+
+// buildIf creates the batched version of an If.
+//
+// If the predicate is batched, both branches are evaluated and the results are selected per example.
+func (b *vmapBuilder) buildIf(target *Function, stmt *Statement) []*Value {
+	pred := stmt.Inputs[0]
+	trueBranch, falseBranch := stmt.FunctionParameters[0], stmt.FunctionParameters[1]
+	if b.batched[pred.name] {
+		onTrue := b.build(target, trueBranch.Statements)
+		onFalse := b.build(target, falseBranch.Statements)
+		batchedPred := b.get(target, pred)
+		results := make([]*Value, len(stmt.Outputs))
+		for i, output := range stmt.Outputs {
+			results[i] = transformMust(Select(b.broadcastBatchedScalar(batchedPred, output.shape),
+				b.ensureBatched(onTrue[i], output.shape), b.ensureBatched(onFalse[i], output.shape)))
+		}
+		return results
+	}
+
+	buildBranch := func(branch *Function) *Function {
+		newBranch := target.Closure()
+		results := b.build(newBranch, branch.Statements)
+		for i, output := range stmt.Outputs {
+			if b.batched[output.name] {
+				results[i] = b.ensureBatched(results[i], output.shape)
+			}
+		}
+		transformMust[any](nil, newBranch.Return(results...))
+		return newBranch
+	}
+	newTrue, newFalse := buildBranch(trueBranch), buildBranch(falseBranch)
+	return transformMust(If(b.get(target, pred), newTrue, newFalse))
+}
@@ -0,0 +1,102 @@
+package stablehlo
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/optypes"
+	"github.com/gomlx/go-xla/types"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVmap(t *testing.T) {
+	t.Run("matmul", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		w := must1(fn.NamedInput("w", shapes.Make(dtypes.Float32, 3, 4)))
+		y := must1(DotGeneral(x, []int{0}, nil, w, []int{0}, nil).Done())
+		zero := must1(fn.ConstantFromScalar(float32(0)))
+		y = must1(Maximum(y, must1(BroadcastInDim(zero, y.Shape(), nil))))
+		require.NoError(t, fn.Return(y, w))
+
+		// x is batched on its last axis, w is shared.
+		batched := must1(Vmap(fn, "batched", 5, []int{1, -1}))
+		require.True(t, batched.Inputs[0].Shape().Equal(shapes.Make(dtypes.Float32, 3, 5)))
+		require.True(t, batched.Inputs[1].Shape().Equal(w.Shape()))
+		require.True(t, batched.Outputs[0].Shape().Equal(shapes.Make(dtypes.Float32, 5, 4)))
+		require.True(t, batched.Outputs[1].Shape().Equal(shapes.Make(dtypes.Float32, 5, 3, 4)))
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+		require.Contains(t, program, "func.func @batched(")
+	})
+
+	t.Run("while with batched condition", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32)))
+
+		cond := fn.Closure()
+		condX := must1(cond.Input(x.Shape()))
+		limit := must1(cond.ConstantFromScalar(float32(100)))
+		require.NoError(t, cond.Return(must1(Compare(condX, limit, types.CompareLT, types.CompareFloat))))
+		body := fn.Closure()
+		bodyX := must1(body.Input(x.Shape()))
+		require.NoError(t, body.Return(must1(Multiply(bodyX, bodyX))))
+		results := must1(While(cond, body, x))
+		require.NoError(t, fn.Return(results...))
+
+		batched := must1(Vmap(fn, "batched", 3, []int{0}))
+		require.True(t, batched.Outputs[0].Shape().Equal(shapes.Make(dtypes.Float32, 3)))
+		var whileStmt *Statement
+		for _, stmt := range batched.Statements {
+			if stmt.OpType == optypes.While {
+				whileStmt = stmt
+			}
+		}
+		require.NotNil(t, whileStmt)
+		// The condition reduces the per-example predicates, and the body selects the updated examples.
+		condOps := make(map[optypes.OpType]bool)
+		for _, stmt := range whileStmt.FunctionParameters[0].Statements {
+			condOps[stmt.OpType] = true
+		}
+		require.True(t, condOps[optypes.Reduce])
+		bodyOps := make(map[optypes.OpType]bool)
+		for _, stmt := range whileStmt.FunctionParameters[1].Statements {
+			bodyOps[stmt.OpType] = true
+		}
+		require.True(t, bodyOps[optypes.Select])
+		program := string(must1(b.Build()))
+		fmt.Printf("%s program:\n%s", t.Name(), program)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		b := New(t.Name())
+		fn := b.Main()
+		x := must1(fn.NamedInput("x", shapes.Make(dtypes.Float32, 3)))
+		_, err := Vmap(fn, "batched", 2, []int{0})
+		require.ErrorContains(t, err, "to have returned")
+
+		cmp := fn.Closure()
+		lhs := must1(cmp.Input(shapes.Make(dtypes.Float32)))
+		rhs := must1(cmp.Input(shapes.Make(dtypes.Float32)))
+		require.NoError(t, cmp.Return(must1(Compare(lhs, rhs, types.CompareLT, types.CompareFloat))))
+		sorted := must1(Sort(cmp, 0, false, x))
+		require.NoError(t, fn.Return(sorted...))
+		_, err = Vmap(fn, "batched", 2, []int{2})
+		require.ErrorContains(t, err, "out of range")
+		_, err = Vmap(fn, "batched", 2, nil)
+		require.ErrorContains(t, err, "one input axis per input")
+
+		numFunctions := len(b.functions)
+		_, err = Vmap(fn, "batched", 2, []int{0})
+		require.ErrorContains(t, err, "not supported")
+		require.Len(t, b.functions, numFunctions, "partially built functions should be removed")
+
+		// Not batching the input is fine.
+		_ = must1(Vmap(fn, "notBatched", 2, []int{-1}))
+		_ = must1(b.Build())
+	})
+}
@@ -0,0 +1,171 @@
+// Package ffi defines Go handlers of XLA FFI (foreign function interface) custom calls: Go functions called from
+// inside compiled programs.
+//
+// Handlers are registered with a PJRT plugin (see pjrt.Plugin.RegisterFFIHandler) under a custom-call target name,
+// and called with stablehlo.CustomCallFFI. For one-off Go functions, stablehlo.HostCallback registers the
+// function as a host callback (see RegisterHostCallback) and calls it through the HostCallbackTarget handler.
+//
+// See https://openxla.org/xla/custom_call for details on XLA FFI.
+package ffi
+
+import (
+	"sync"
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/compute/dtypes/gotype"
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Buffer is an input or output buffer of an FFI call, in the device memory: for the CPU plugin it is host memory
+// that can be accessed directly.
+//
+// Its data is only valid during the call of the handler.
+type Buffer struct {
+	DType      dtypes.DType
+	Dimensions []int
+	data       unsafe.Pointer
+}
+
+// NewBuffer creates a Buffer pointing to data. It is used by the PJRT bindings to present the buffers to the
+// handler.
+func NewBuffer(dtype dtypes.DType, dimensions []int, data unsafe.Pointer) *Buffer {
+	return &Buffer{DType: dtype, Dimensions: dimensions, data: data}
+}
+
+// Shape of the buffer.
+func (b *Buffer) Shape() shapes.Shape {
+	return shapes.Make(b.DType, b.Dimensions...)
+}
+
+// Size returns the number of elements of the buffer.
+func (b *Buffer) Size() int {
+	size := 1
+	for _, dim := range b.Dimensions {
+		size *= dim
+	}
+	return size
+}
+
+// Data returns the raw pointer to the buffer data.
+func (b *Buffer) Data() unsafe.Pointer {
+	return b.data
+}
+
+// Bytes returns the buffer data as a byte slice (not a copy).
+// Sub-byte dtypes (e.g. Int4) are packed.
+func (b *Buffer) Bytes() []byte {
+	numBytes := b.DType.SizeForDimensions(b.Dimensions...)
+	if numBytes == 0 || b.data == nil {
+		return nil
+	}
+	return unsafe.Slice((*byte)(b.data), numBytes)
+}
+
+// Flat returns the buffer data as a flat slice of T (not a copy), in row-major order.
+// It returns an error if T doesn't match the buffer dtype.
+func Flat[T gotype.Supported](b *Buffer) ([]T, error) {
+	dtype := dtypes.FromGenericsType[T]()
+	if dtype != b.DType {
+		return nil, errors.Errorf("ffi.Flat[%s] requested for a buffer of dtype %s", dtype, b.DType)
+	}
+	size := b.Size()
+	if size == 0 || b.data == nil {
+		return nil, nil
+	}
+	return unsafe.Slice((*T)(b.data), size), nil
+}
+
+// CallFrame holds the inputs, outputs and attributes of an FFI call.
+type CallFrame struct {
+	// Inputs (operands) of the custom call.
+	Inputs []*Buffer
+
+	// Outputs of the custom call, to be filled by the handler.
+	Outputs []*Buffer
+
+	// Attributes of the call, given in the backend_config dictionary of the custom call (see
+	// stablehlo.CustomCallFFI). Values are Go scalars (bool, int8 to int64, uint8 to uint64, float32, float64),
+	// slices of scalars, strings or map[string]any for nested dictionaries.
+	Attributes map[string]any
+}
+
+// Handler is a Go function that implements an FFI custom call.
+//
+// It is called from the threads that execute the program: it should not block for long, and it must be
+// safe for concurrent use.
+type Handler func(call *CallFrame) error
+
+// Attribute returns the attribute with the given name converted to T, or an error if it is missing or of
+// another type.
+func Attribute[T any](call *CallFrame, name string) (T, error) {
+	var zero T
+	value, found := call.Attributes[name]
+	if !found {
+		return zero, errors.Errorf("FFI call has no attribute %q", name)
+	}
+	typed, ok := value.(T)
+	if !ok {
+		return zero, errors.Errorf("FFI call attribute %q is a %T, not a %T", name, value, zero)
+	}
+	return typed, nil
+}
+
+const (
+	// HostCallbackTarget is the custom-call target name of the handler that dispatches the host callbacks (see
+	// RegisterHostCallback) registered by the PJRT bindings.
+	HostCallbackTarget = "goxla_host_callback"
+
+	// HostCallbackIDAttribute is the attribute with the ID of the host callback to call.
+	// The ID is only valid in the process that registered the callback.
+	HostCallbackIDAttribute = "callback_id"
+)
+
+var (
+	hostCallbacksMu    sync.RWMutex
+	hostCallbacks      = make(map[int64]Handler)
+	nextHostCallbackID int64
+)
+
+// RegisterHostCallback registers fn as a host callback, and returns its ID, to be given as the
+// HostCallbackIDAttribute of a call to HostCallbackTarget.
+//
+// Host callbacks are kept for the lifetime of the process, unless unregistered with UnregisterHostCallback.
+func RegisterHostCallback(fn Handler) int64 {
+	hostCallbacksMu.Lock()
+	defer hostCallbacksMu.Unlock()
+	id := nextHostCallbackID
+	nextHostCallbackID++
+	hostCallbacks[id] = fn
+	return id
+}
+
+// UnregisterHostCallback removes the host callback: programs calling it will fail.
+func UnregisterHostCallback(id int64) {
+	hostCallbacksMu.Lock()
+	defer hostCallbacksMu.Unlock()
+	delete(hostCallbacks, id)
+}
+
+// HostCallback returns the host callback registered with the given ID.
+func HostCallback(id int64) (Handler, bool) {
+	hostCallbacksMu.RLock()
+	defer hostCallbacksMu.RUnlock()
+	fn, found := hostCallbacks[id]
+	return fn, found
+}
+
+// DispatchHostCallback is the Handler of HostCallbackTarget: it calls the host callback given by the
+// HostCallbackIDAttribute of the call.
+func DispatchHostCallback(call *CallFrame) error {
+	id, err := Attribute[int64](call, HostCallbackIDAttribute)
+	if err != nil {
+		return err
+	}
+	fn, found := HostCallback(id)
+	if !found {
+		return errors.Errorf("host callback #%d is not registered", id)
+	}
+	return fn(call)
+}
@@ -0,0 +1,68 @@
+package ffi
+
+import (
+	"testing"
+	"unsafe"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/pkg/errors"
+)
+
+func TestBuffer(t *testing.T) {
+	data := []float32{1, 2, 3, 4, 5, 6}
+	b := NewBuffer(dtypes.Float32, []int{2, 3}, unsafe.Pointer(&data[0]))
+	if b.Size() != 6 {
+		t.Fatalf("Size()=%d, want 6", b.Size())
+	}
+	if got := b.Shape().String(); got != "(Float32)[2 3]" {
+		t.Errorf("Shape()=%s", got)
+	}
+	if len(b.Bytes()) != 24 {
+		t.Errorf("len(Bytes())=%d, want 24", len(b.Bytes()))
+	}
+	flat, err := Flat[float32](b)
+	if err != nil {
+		t.Fatalf("Flat[float32] failed: %+v", err)
+	}
+	flat[5] = 60
+	if data[5] != 60 {
+		t.Errorf("Flat should return a view of the buffer data")
+	}
+	if _, err := Flat[int32](b); err == nil {
+		t.Errorf("Flat[int32] of a Float32 buffer should fail")
+	}
+}
+
+func TestHostCallback(t *testing.T) {
+	var called int
+	id := RegisterHostCallback(func(call *CallFrame) error {
+		called++
+		return nil
+	})
+	call := &CallFrame{Attributes: map[string]any{HostCallbackIDAttribute: id}}
+	if err := DispatchHostCallback(call); err != nil {
+		t.Fatalf("DispatchHostCallback failed: %+v", err)
+	}
+	if called != 1 {
+		t.Errorf("host callback called %d times, want 1", called)
+	}
+
+	failing := RegisterHostCallback(func(call *CallFrame) error { return errors.New("failed") })
+	if failing == id {
+		t.Fatalf("host callbacks got the same ID %d", id)
+	}
+	call.Attributes[HostCallbackIDAttribute] = failing
+	if err := DispatchHostCallback(call); err == nil {
+		t.Errorf("DispatchHostCallback should return the callback error")
+	}
+
+	UnregisterHostCallback(id)
+	call.Attributes[HostCallbackIDAttribute] = id
+	if err := DispatchHostCallback(call); err == nil {
+		t.Errorf("DispatchHostCallback of an unregistered callback should fail")
+	}
+	call.Attributes[HostCallbackIDAttribute] = "x"
+	if err := DispatchHostCallback(call); err == nil {
+		t.Errorf("DispatchHostCallback with an invalid ID attribute should fail")
+	}
+}
@@ -0,0 +1,188 @@
+package shapes
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Layout describes how the elements of an array are laid out in memory.
+//
+// It follows XLA's convention: MinorToMajor lists the axes from the fastest varying (minor) to
+// the slowest varying (major) one. The default "row-major" (C order) layout of a rank-3 array is
+// {2, 1, 0}, and the "column-major" (Fortran order) layout is {0, 1, 2}.
+//
+// Tiles are optional: they describe a tiled layout, where the array is first split into tiles
+// of the given dimensions (applied from the first tile to the last, each on the minor-most axes),
+// as used by accelerators (e.g. TPU's T(8,128)). Most users will leave Tiles empty.
+type Layout struct {
+	// MinorToMajor is a permutation of the axes of the array, from minor to major.
+	MinorToMajor []int
+
+	// Tiles are the optional tiling dimensions, each applied to the minor-most axes of the array.
+	Tiles [][]int
+}
+
+// RowMajorLayout returns the default (C order) layout for an array of the given rank, where
+// the last axis is the minor (fastest varying) one.
+func RowMajorLayout(rank int) *Layout {
+	l := &Layout{MinorToMajor: make([]int, rank)}
+	for i := range rank {
+		l.MinorToMajor[i] = rank - 1 - i
+	}
+	return l
+}
+
+// ColumnMajorLayout returns the column-major (Fortran order) layout for an array of the given rank,
+// where the first axis is the minor (fastest varying) one.
+func ColumnMajorLayout(rank int) *Layout {
+	l := &Layout{MinorToMajor: make([]int, rank)}
+	for i := range rank {
+		l.MinorToMajor[i] = i
+	}
+	return l
+}
+
+// Rank returns the rank of the arrays this layout can be used with.
+func (l *Layout) Rank() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.MinorToMajor)
+}
+
+// Validate checks that the layout is valid for an array of the given rank: MinorToMajor must be
+// a permutation of the axes, and each tile must have at least one dimension, all positive and no more
+// than the rank.
+func (l *Layout) Validate(rank int) error {
+	if l == nil {
+		return errors.New("nil Layout")
+	}
+	if len(l.MinorToMajor) != rank {
+		return errors.Errorf("layout %s has %d axes in MinorToMajor, but the array has rank %d",
+			l, len(l.MinorToMajor), rank)
+	}
+	seen := make([]bool, rank)
+	for _, axis := range l.MinorToMajor {
+		if axis < 0 || axis >= rank {
+			return errors.Errorf("layout %s has invalid axis %d for an array of rank %d", l, axis, rank)
+		}
+		if seen[axis] {
+			return errors.Errorf("layout %s has axis %d repeated in MinorToMajor", l, axis)
+		}
+		seen[axis] = true
+	}
+	for i, tile := range l.Tiles {
+		if len(tile) == 0 || len(tile) > rank {
+			return errors.Errorf("layout %s tile #%d has %d dimensions, it must have between 1 and %d (the rank)",
+				l, i, len(tile), rank)
+		}
+		for _, dim := range tile {
+			if dim <= 0 {
+				return errors.Errorf("layout %s tile #%d has invalid dimension %d, all must be > 0", l, i, dim)
+			}
+		}
+	}
+	return nil
+}
+
+// IsRowMajor returns whether the layout is the default row-major (C order) layout, without tiles.
+// A nil layout is considered row-major, since that is the default.
+func (l *Layout) IsRowMajor() bool {
+	if l == nil {
+		return true
+	}
+	if len(l.Tiles) > 0 {
+		return false
+	}
+	rank := len(l.MinorToMajor)
+	for i, axis := range l.MinorToMajor {
+		if axis != rank-1-i {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a deep copy of the layout.
+func (l *Layout) Clone() *Layout {
+	if l == nil {
+		return nil
+	}
+	l2 := &Layout{MinorToMajor: slices.Clone(l.MinorToMajor)}
+	if len(l.Tiles) > 0 {
+		l2.Tiles = make([][]int, len(l.Tiles))
+		for i, tile := range l.Tiles {
+			l2.Tiles[i] = slices.Clone(tile)
+		}
+	}
+	return l2
+}
+
+// Equal returns whether both layouts are the same.
+func (l *Layout) Equal(l2 *Layout) bool {
+	if l == nil || l2 == nil {
+		return l == l2
+	}
+	if !slices.Equal(l.MinorToMajor, l2.MinorToMajor) || len(l.Tiles) != len(l2.Tiles) {
+		return false
+	}
+	for i, tile := range l.Tiles {
+		if !slices.Equal(tile, l2.Tiles[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Strides returns the strides in bytes of each axis for a dense (non-tiled) array with the given
+// dimensions and element size, laid out according to the layout.
+//
+// It returns an error if the layout has tiles, since those can't be described by strides.
+func (l *Layout) Strides(dimensions []int, elementSize int) ([]int64, error) {
+	if err := l.Validate(len(dimensions)); err != nil {
+		return nil, err
+	}
+	if len(l.Tiles) > 0 {
+		return nil, errors.Errorf("tiled layout %s cannot be represented with strides", l)
+	}
+	strides := make([]int64, len(dimensions))
+	stride := int64(elementSize)
+	for _, axis := range l.MinorToMajor {
+		strides[axis] = stride
+		stride *= int64(dimensions[axis])
+	}
+	return strides, nil
+}
+
+// String implements fmt.Stringer, using XLA's notation, e.g.: "{1,0}" or "{1,0:T(8,128)}".
+func (l *Layout) String() string {
+	if l == nil {
+		return "{}"
+	}
+	var sb strings.Builder
+	sb.WriteString("{")
+	for i, axis := range l.MinorToMajor {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		_, _ = fmt.Fprintf(&sb, "%d", axis)
+	}
+	if len(l.Tiles) > 0 {
+		sb.WriteString(":")
+		for _, tile := range l.Tiles {
+			sb.WriteString("T(")
+			for i, dim := range tile {
+				if i > 0 {
+					sb.WriteString(",")
+				}
+				_, _ = fmt.Fprintf(&sb, "%d", dim)
+			}
+			sb.WriteString(")")
+		}
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
@@ -0,0 +1,75 @@
+package shapes
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLayout(t *testing.T) {
+	rowMajor := RowMajorLayout(3)
+	if got := rowMajor.String(); got != "{2,1,0}" {
+		t.Errorf("RowMajorLayout(3).String() = %q, want %q", got, "{2,1,0}")
+	}
+	if !rowMajor.IsRowMajor() {
+		t.Error("RowMajorLayout(3).IsRowMajor() should be true")
+	}
+	colMajor := ColumnMajorLayout(3)
+	if got := colMajor.String(); got != "{0,1,2}" {
+		t.Errorf("ColumnMajorLayout(3).String() = %q, want %q", got, "{0,1,2}")
+	}
+	if colMajor.IsRowMajor() {
+		t.Error("ColumnMajorLayout(3).IsRowMajor() should be false")
+	}
+	if rowMajor.Equal(colMajor) || !colMajor.Equal(colMajor.Clone()) {
+		t.Error("Layout.Equal returned unexpected results")
+	}
+
+	tiled := &Layout{MinorToMajor: []int{1, 0}, Tiles: [][]int{{8, 128}, {2}}}
+	if got, want := tiled.String(), "{1,0:T(8,128)T(2)}"; got != want {
+		t.Errorf("tiled.String() = %q, want %q", got, want)
+	}
+	if err := tiled.Validate(2); err != nil {
+		t.Errorf("tiled.Validate(2) failed: %v", err)
+	}
+	if tiled.IsRowMajor() {
+		t.Error("tiled layout should not be row-major")
+	}
+	clone := tiled.Clone()
+	clone.Tiles[0][0] = 4
+	if tiled.Tiles[0][0] != 8 || tiled.Equal(clone) {
+		t.Error("Layout.Clone should make a deep copy")
+	}
+
+	// Validation errors.
+	for _, invalid := range []*Layout{
+		nil,
+		{MinorToMajor: []int{0}},
+		{MinorToMajor: []int{0, 0}},
+		{MinorToMajor: []int{0, 2}},
+		{MinorToMajor: []int{0, 1}, Tiles: [][]int{{}}},
+		{MinorToMajor: []int{0, 1}, Tiles: [][]int{{0, 128}}},
+	} {
+		if err := invalid.Validate(2); err == nil {
+			t.Errorf("Layout %s should be invalid for rank 2", invalid)
+		}
+	}
+
+	// Strides in bytes for a float32[2, 3, 5].
+	strides, err := colMajor.Clone().Strides([]int{2, 3, 5}, 4)
+	if err != nil {
+		t.Fatalf("Strides failed: %v", err)
+	}
+	if want := []int64{4, 8, 24}; !slices.Equal(strides, want) {
+		t.Errorf("column-major strides = %v, want %v", strides, want)
+	}
+	strides, err = rowMajor.Strides([]int{2, 3, 5}, 4)
+	if err != nil {
+		t.Fatalf("Strides failed: %v", err)
+	}
+	if want := []int64{60, 20, 4}; !slices.Equal(strides, want) {
+		t.Errorf("row-major strides = %v, want %v", strides, want)
+	}
+	if _, err = tiled.Strides([]int{16, 256}, 4); err == nil {
+		t.Error("Strides of a tiled layout should fail")
+	}
+}
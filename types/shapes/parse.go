@@ -0,0 +1,554 @@
+package shapes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/internal/utils"
+	"github.com/pkg/errors"
+)
+
+// stableHLODTypes maps the StableHLO names of the dtypes (e.g.: "f32", "ui8", "i1") to the DType.
+var stableHLODTypes = make(map[string]dtypes.DType)
+
+func init() {
+	for dtype := range dtypes.Uint1 + 1 {
+		name := utils.DTypeToStableHLO(dtype)
+		if strings.HasPrefix(name, "unknown_dtype") {
+			continue
+		}
+		stableHLODTypes[name] = dtype
+	}
+}
+
+// ParseDType parses the name of a dtype. It accepts:
+//
+//   - The StableHLO names (e.g.: "f32", "bf16", "ui8", "i1", "complex<f32>", "f8E4M3FN").
+//   - The HLO names used in the short form of shapes (e.g.: "f32", "s32", "u8", "pred", "c64", "f8e4m3fn").
+//   - The DType names, in any case (e.g.: "Float32", "int64", "BFloat16").
+//
+// Notice "i1" is the StableHLO name of a Bool, not of an Int1 (whose name is "s1").
+func ParseDType(name string) (dtypes.DType, error) {
+	if dtype, found := stableHLODTypes[name]; found {
+		return dtype, nil
+	}
+	dtype, found := dtypes.MapOfNames[name]
+	if !found {
+		dtype, found = dtypes.MapOfNames[strings.ToLower(name)]
+	}
+	if !found || dtype == dtypes.InvalidDType || dtype == dtypes.TOKEN {
+		return dtypes.InvalidDType, errors.Errorf("unknown dtype %q", name)
+	}
+	return dtype, nil
+}
+
+// dtypeShortName returns the HLO name of the dtype, used in the short form of shapes (see Shape.ShortString).
+func dtypeShortName(dtype dtypes.DType) string {
+	switch dtype {
+	case dtypes.Bool:
+		return "pred"
+	case dtypes.Float16:
+		return "f16"
+	case dtypes.Float32:
+		return "f32"
+	case dtypes.Float64:
+		return "f64"
+	case dtypes.BFloat16:
+		return "bf16"
+	case dtypes.Complex64:
+		return "c64"
+	case dtypes.Complex128:
+		return "c128"
+	}
+	if utils.IsFloat8(dtype) {
+		return strings.ToLower(dtype.String())
+	}
+	if dtype.IsUnsigned() {
+		return fmt.Sprintf("u%d", utils.DTypeBits(dtype))
+	}
+	if dtype.IsInt() {
+		return fmt.Sprintf("s%d", utils.DTypeBits(dtype))
+	}
+	return strings.ToLower(dtype.String())
+}
+
+// ShortString returns the short (HLO like) textual form of the shape, that can be parsed back with Parse.
+//
+// Examples: "f32[2,3]", "s64[]" (a scalar), "bf16[?,<=8]" (a dynamic dimension, and a dynamic dimension
+// bounded to 8) and "(f32[2], pred[])" (a tuple).
+//
+// Quantized shapes have no short form, and they are rendered in their StableHLO form (see ToStableHLO),
+// which is also accepted by Parse.
+func (s Shape) ShortString() string {
+	if s.IsTuple() {
+		parts := make([]string, 0, s.TupleSize())
+		for _, element := range s.TupleShapes {
+			parts = append(parts, element.ShortString())
+		}
+		return fmt.Sprintf("(%s)", strings.Join(parts, ", "))
+	}
+	if s.Quantization != nil {
+		s2 := s.Clone()
+		s2.EncodeBounds = true
+		return s2.ToStableHLO()
+	}
+	var sb strings.Builder
+	sb.WriteString(dtypeShortName(s.DType))
+	sb.WriteString("[")
+	for i, dim := range s.Dimensions {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		if dim != DimUnknown {
+			sb.WriteString(strconv.Itoa(dim))
+		} else if i < len(s.DimensionBounds) && s.DimensionBounds[i] > 0 {
+			_, _ = fmt.Fprintf(&sb, "<=%d", s.DimensionBounds[i])
+		} else {
+			sb.WriteString("?")
+		}
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// Parse a shape from its short (HLO like) textual form (see Shape.ShortString) or from its
+// StableHLO form (see ParseStableHLO).
+//
+// Examples of the short form:
+//
+//   - "f32[2,3]": a float32 matrix; the dtype can be given in any of the forms accepted by ParseDType.
+//   - "s64[]" or "s64": a scalar.
+//   - "bf16[?,<=8]": a dynamic dimension, and a dynamic dimension bounded to 8.
+//   - "(f32[2], pred[])": a tuple.
+func Parse(text string) (Shape, error) {
+	p := &shapeParser{text: text}
+	shape, err := p.parseShape()
+	if err == nil {
+		err = p.expectEnd()
+	}
+	if err != nil {
+		return Invalid(), errors.WithMessagef(err, "failed to parse shape %q", text)
+	}
+	return shape, nil
+}
+
+// ParseStableHLO parses a shape from its StableHLO form (see Shape.ToStableHLO), e.g.:
+// "tensor<2x3xf32>", "tensor<2x?xbf16, #stablehlo.bounds<?, 8>>", "tuple<tensor<f32>, tensor<2xi1>>" or
+// "tensor<4x!quant.uniform<i8:f32, 0.1:-3>>".
+func ParseStableHLO(text string) (Shape, error) {
+	p := &shapeParser{text: text}
+	shape, err := p.parseStableHLO()
+	if err == nil {
+		err = p.expectEnd()
+	}
+	if err != nil {
+		return Invalid(), errors.WithMessagef(err, "failed to parse StableHLO shape %q", text)
+	}
+	return shape, nil
+}
+
+// MarshalText implements encoding.TextMarshaler, using the short form of the shape (see Shape.ShortString).
+// It also makes Shape be encoded as a string in JSON.
+//
+// An invalid shape (e.g.: Shape{}) is marshaled as an empty string.
+func (s Shape) MarshalText() ([]byte, error) {
+	if !s.Ok() {
+		return []byte{}, nil
+	}
+	return []byte(s.ShortString()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, accepting any of the forms accepted by Parse.
+// An empty text is unmarshaled as an invalid shape.
+func (s *Shape) UnmarshalText(text []byte) error {
+	if len(strings.TrimSpace(string(text))) == 0 {
+		*s = Invalid()
+		return nil
+	}
+	shape, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = shape
+	return nil
+}
+
+// shapeParser is a simple recursive descent parser for the textual forms of shapes.
+type shapeParser struct {
+	text string
+	pos  int
+}
+
+func (p *shapeParser) skipSpaces() {
+	for p.pos < len(p.text) && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t' || p.text[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+// peek returns whether the next non-space text starts with the given prefix.
+func (p *shapeParser) peek(prefix string) bool {
+	p.skipSpaces()
+	return strings.HasPrefix(p.text[p.pos:], prefix)
+}
+
+// consume the prefix if the next non-space text starts with it, and returns whether it was consumed.
+func (p *shapeParser) consume(prefix string) bool {
+	if !p.peek(prefix) {
+		return false
+	}
+	p.pos += len(prefix)
+	return true
+}
+
+// expect consumes the prefix or returns an error.
+func (p *shapeParser) expect(prefix string) error {
+	if !p.consume(prefix) {
+		return p.errorf("expected %q", prefix)
+	}
+	return nil
+}
+
+func (p *shapeParser) expectEnd() error {
+	p.skipSpaces()
+	if p.pos != len(p.text) {
+		return p.errorf("unexpected trailing text")
+	}
+	return nil
+}
+
+func (p *shapeParser) errorf(format string, args ...any) error {
+	return errors.Errorf("%s at position %d", fmt.Sprintf(format, args...), p.pos)
+}
+
+// readWhile reads the characters while isValid returns true.
+func (p *shapeParser) readWhile(isValid func(c byte) bool) string {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.text) && isValid(p.text[p.pos]) {
+		p.pos++
+	}
+	return p.text[start:p.pos]
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// readInt reads an integer, optionally with a sign.
+func (p *shapeParser) readInt() (int64, error) {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos < len(p.text) && (p.text[p.pos] == '-' || p.text[p.pos] == '+') {
+		p.pos++
+	}
+	for p.pos < len(p.text) && isDigit(p.text[p.pos]) {
+		p.pos++
+	}
+	value, err := strconv.ParseInt(p.text[start:p.pos], 10, 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("expected an integer")
+	}
+	return value, nil
+}
+
+// readFloat reads a floating point number.
+func (p *shapeParser) readFloat() (float64, error) {
+	p.skipSpaces()
+	start := p.pos
+	text := p.readWhile(func(c byte) bool {
+		return isDigit(c) || c == '.' || c == '-' || c == '+' || c == 'e' || c == 'E'
+	})
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("expected a floating point number")
+	}
+	return value, nil
+}
+
+// readDType reads a dtype in any of the forms accepted by ParseDType.
+func (p *shapeParser) readDType() (dtypes.DType, error) {
+	if p.consume("complex<") {
+		name := p.readWhile(isIdentChar)
+		if err := p.expect(">"); err != nil {
+			return dtypes.InvalidDType, err
+		}
+		return ParseDType(fmt.Sprintf("complex<%s>", name))
+	}
+	start := p.pos
+	name := p.readWhile(isIdentChar)
+	if name == "" {
+		return dtypes.InvalidDType, p.errorf("expected a dtype")
+	}
+	dtype, err := ParseDType(name)
+	if err != nil {
+		p.pos = start
+		return dtypes.InvalidDType, p.errorf("%v", err)
+	}
+	return dtype, nil
+}
+
+// parseShape parses the short form of a shape, or its StableHLO form.
+func (p *shapeParser) parseShape() (Shape, error) {
+	if p.peek("tensor<") || p.peek("tuple<") {
+		return p.parseStableHLO()
+	}
+	if p.consume("(") {
+		elements := make([]Shape, 0)
+		for !p.consume(")") {
+			if len(elements) > 0 {
+				if err := p.expect(","); err != nil {
+					return Invalid(), err
+				}
+			}
+			element, err := p.parseShape()
+			if err != nil {
+				return Invalid(), err
+			}
+			elements = append(elements, element)
+		}
+		return MakeTuple(elements), nil
+	}
+
+	dtype, err := p.readDType()
+	if err != nil {
+		return Invalid(), err
+	}
+	shape := Make(dtype)
+	if !p.consume("[") {
+		return shape, nil
+	}
+	var bounds []int
+	for !p.consume("]") {
+		if len(shape.Dimensions) > 0 {
+			if err := p.expect(","); err != nil {
+				return Invalid(), err
+			}
+		}
+		axis := len(shape.Dimensions)
+		switch {
+		case p.consume("?"):
+			shape.Dimensions = append(shape.Dimensions, DimUnknown)
+		case p.consume("<="):
+			bound, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			if bound <= 0 {
+				return Invalid(), p.errorf("invalid bound %d for axis %d", bound, axis)
+			}
+			shape.Dimensions = append(shape.Dimensions, DimUnknown)
+			if bounds == nil {
+				bounds = make([]int, axis, axis+1)
+			}
+			bounds = append(bounds, int(bound))
+		default:
+			dim, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			if dim < 0 {
+				return Invalid(), p.errorf("invalid dimension %d for axis %d", dim, axis)
+			}
+			shape.Dimensions = append(shape.Dimensions, int(dim))
+		}
+		if bounds != nil && len(bounds) < len(shape.Dimensions) {
+			bounds = append(bounds, 0)
+		}
+	}
+	if bounds != nil {
+		shape.DimensionBounds = bounds
+		shape.EncodeBounds = true
+	}
+	return shape, nil
+}
+
+// parseStableHLO parses the StableHLO form of a shape.
+func (p *shapeParser) parseStableHLO() (Shape, error) {
+	if p.consume("tuple<") {
+		elements := make([]Shape, 0)
+		for !p.consume(">") {
+			if len(elements) > 0 {
+				if err := p.expect(","); err != nil {
+					return Invalid(), err
+				}
+			}
+			element, err := p.parseStableHLO()
+			if err != nil {
+				return Invalid(), err
+			}
+			elements = append(elements, element)
+		}
+		return MakeTuple(elements), nil
+	}
+
+	if err := p.expect("tensor<"); err != nil {
+		return Invalid(), err
+	}
+	var dimensions []int
+	for {
+		p.skipSpaces()
+		if p.consume("?") {
+			dimensions = append(dimensions, DimUnknown)
+		} else if p.pos < len(p.text) && isDigit(p.text[p.pos]) {
+			dim, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			dimensions = append(dimensions, int(dim))
+		} else {
+			break
+		}
+		if err := p.expect("x"); err != nil {
+			return Invalid(), err
+		}
+	}
+
+	var shape Shape
+	if p.consume("!quant.uniform<") {
+		quantization, err := p.parseQuantization()
+		if err != nil {
+			return Invalid(), err
+		}
+		shape = Make(quantization.ExpressedType, dimensions...).WithQuantization(quantization)
+	} else {
+		dtype, err := p.readDType()
+		if err != nil {
+			return Invalid(), err
+		}
+		shape = Make(dtype, dimensions...)
+	}
+
+	if p.consume(",") {
+		if err := p.expect("#stablehlo.bounds<"); err != nil {
+			return Invalid(), err
+		}
+		bounds := make([]int, 0, len(dimensions))
+		for !p.consume(">") {
+			if len(bounds) > 0 {
+				if err := p.expect(","); err != nil {
+					return Invalid(), err
+				}
+			}
+			if p.consume("?") {
+				bounds = append(bounds, 0)
+				continue
+			}
+			bound, err := p.readInt()
+			if err != nil {
+				return Invalid(), err
+			}
+			if bound <= 0 {
+				return Invalid(), p.errorf("invalid bound %d", bound)
+			}
+			bounds = append(bounds, int(bound))
+		}
+		if len(bounds) != len(dimensions) {
+			return Invalid(), p.errorf("got %d bounds for a tensor of rank %d", len(bounds), len(dimensions))
+		}
+		for axis, bound := range bounds {
+			if bound > 0 && dimensions[axis] != DimUnknown {
+				return Invalid(), p.errorf("bound %d given for static axis %d", bound, axis)
+			}
+		}
+		shape.DimensionBounds = bounds
+		shape.EncodeBounds = true
+	}
+	if err := p.expect(">"); err != nil {
+		return Invalid(), err
+	}
+	return shape, nil
+}
+
+// parseQuantization parses the contents of a "!quant.uniform<...>" type, after the "!quant.uniform<" prefix
+// (see Quantization.ToStableHLO).
+func (p *shapeParser) parseQuantization() (*Quantization, error) {
+	q := &Quantization{}
+	var err error
+	if q.StorageType, err = p.readDType(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	if q.ExpressedType, err = p.readDType(); err != nil {
+		return nil, err
+	}
+
+	// Axis / block information.
+	if p.consume(":") {
+		if p.consume("{") {
+			for !p.consume("}") {
+				if len(q.QuantizedAxes) > 0 {
+					if err = p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				axis, err := p.readInt()
+				if err != nil {
+					return nil, err
+				}
+				if err = p.expect(":"); err != nil {
+					return nil, err
+				}
+				blockSize, err := p.readInt()
+				if err != nil {
+					return nil, err
+				}
+				q.QuantizedAxes = append(q.QuantizedAxes, int(axis))
+				q.BlockSizes = append(q.BlockSizes, blockSize)
+			}
+		} else {
+			axis, err := p.readInt()
+			if err != nil {
+				return nil, err
+			}
+			q.QuantizedAxes = []int{int(axis)}
+		}
+	}
+	if err = p.expect(","); err != nil {
+		return nil, err
+	}
+
+	// Scales and zero-points.
+	readParam := func() error {
+		scale, err := p.readFloat()
+		if err != nil {
+			return err
+		}
+		zeroPoint := int64(0)
+		if p.consume(":") {
+			if zeroPoint, err = p.readInt(); err != nil {
+				return err
+			}
+		}
+		q.Scales = append(q.Scales, scale)
+		q.ZeroPoints = append(q.ZeroPoints, zeroPoint)
+		return nil
+	}
+	if p.consume("{") {
+		for !p.consume("}") {
+			if len(q.Scales) > 0 {
+				if err = p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			if err = readParam(); err != nil {
+				return nil, err
+			}
+		}
+	} else if err = readParam(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(">"); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
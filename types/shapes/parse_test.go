@@ -0,0 +1,161 @@
+package shapes
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+)
+
+func TestParseDType(t *testing.T) {
+	for name, want := range map[string]dtypes.DType{
+		"f32":          dtypes.Float32,
+		"Float32":      dtypes.Float32,
+		"float32":      dtypes.Float32,
+		"bf16":         dtypes.BFloat16,
+		"i1":           dtypes.Bool,
+		"pred":         dtypes.Bool,
+		"s1":           dtypes.Int1,
+		"s32":          dtypes.Int32,
+		"ui8":          dtypes.Uint8,
+		"u8":           dtypes.Uint8,
+		"complex<f64>": dtypes.Complex128,
+		"c64":          dtypes.Complex64,
+		"f8E4M3FN":     dtypes.F8E4M3FN,
+		"f8e5m2":       dtypes.F8E5M2,
+		"i4":           dtypes.Int4,
+	} {
+		got, err := ParseDType(name)
+		if err != nil {
+			t.Errorf("ParseDType(%q) failed: %v", name, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("ParseDType(%q) = %s, want %s", name, got, want)
+		}
+	}
+	for _, name := range []string{"", "f33", "invalid", "token"} {
+		if _, err := ParseDType(name); err == nil {
+			t.Errorf("ParseDType(%q) should have failed", name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	bounded := Make(dtypes.BFloat16, 2, DimUnknown, DimUnknown)
+	bounded.DimensionBounds = []int{0, 8, 0}
+	bounded.EncodeBounds = true
+	quantized := Make(dtypes.Int8, 4, 2).WithQuantization(&Quantization{
+		StorageType:   dtypes.Int8,
+		ExpressedType: dtypes.Float32,
+		Scales:        []float64{0.5, 0.25},
+		ZeroPoints:    []int64{-3, 0},
+		QuantizedAxes: []int{1},
+	})
+	tests := []struct {
+		text      string
+		want      Shape
+		shortForm string
+	}{
+		{"f32[2,3]", Make(dtypes.Float32, 2, 3), "f32[2,3]"},
+		{" Float32 [ 2 , 3 ] ", Make(dtypes.Float32, 2, 3), "f32[2,3]"},
+		{"s64[]", Make(dtypes.Int64), "s64[]"},
+		{"pred", Make(dtypes.Bool), "pred[]"},
+		{"u4[?,0]", Make(dtypes.Uint4, DimUnknown, 0), "u4[?,0]"},
+		{"bf16[2,<=8,?]", bounded, "bf16[2,<=8,?]"},
+		{"(f32[2], (s32[]))", MakeTuple([]Shape{Make(dtypes.Float32, 2), MakeTuple([]Shape{Make(dtypes.Int32)})}),
+			"(f32[2], (s32[]))"},
+		{"tensor<2x?x?xbf16, #stablehlo.bounds<?, 8, ?>>", bounded, "bf16[2,<=8,?]"},
+		{"tensor<4x2x!quant.uniform<i8:f32:1, {0.5:-3, 0.25:0}>>", quantized,
+			"tensor<4x2x!quant.uniform<i8:f32:1, {0.5:-3, 0.25:0}>>"},
+		{"(f8e4m3fn[2], tensor<complex<f32>>)",
+			MakeTuple([]Shape{Make(dtypes.F8E4M3FN, 2), Make(dtypes.Complex64)}), "(f8e4m3fn[2], c64[])"},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.text)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", test.text, got, test.want)
+		}
+		if shortForm := got.ShortString(); shortForm != test.shortForm {
+			t.Errorf("Parse(%q).ShortString() = %q, want %q", test.text, shortForm, test.shortForm)
+		}
+		// Round-trip.
+		got2, err := Parse(got.ShortString())
+		if err != nil || !reflect.DeepEqual(got2, got) {
+			t.Errorf("Parse(%q) round-trip failed: got %#v, err=%v", got.ShortString(), got2, err)
+		}
+	}
+
+	for _, text := range []string{"", "f32[2,", "f32[-2]", "f32[<=0]", "f32[2]x", "(f32[2] s32[])", "tensor<2xf32",
+		"tensor<2xf32, #stablehlo.bounds<8>>", "tensor<2x!quant.uniform<i8:f32>>"} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) should have failed", text)
+		}
+	}
+}
+
+func TestParseStableHLO(t *testing.T) {
+	for _, shape := range []Shape{
+		Make(dtypes.Float32, 2, 3),
+		Make(dtypes.Bool),
+		Make(dtypes.Uint8, DimUnknown),
+		MakeTuple([]Shape{Make(dtypes.Complex128, 1), Make(dtypes.Int4, 3)}),
+		Make(dtypes.Float32, 3).WithUniformQuantization(dtypes.Int8, dtypes.Float32, 0.1, -3),
+		Make(dtypes.Float32, 4, 6).WithQuantization(&Quantization{
+			StorageType:   dtypes.Int4,
+			ExpressedType: dtypes.Float32,
+			Scales:        []float64{1, 2, 3, 4},
+			ZeroPoints:    []int64{0, 1, 2, 3},
+			QuantizedAxes: []int{0, 1},
+			BlockSizes:    []int64{2, 3},
+		}),
+	} {
+		text := shape.ToStableHLO()
+		got, err := ParseStableHLO(text)
+		if err != nil {
+			t.Errorf("ParseStableHLO(%q) failed: %v", text, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, shape) {
+			t.Errorf("ParseStableHLO(%q) = %#v, want %#v", text, got, shape)
+		}
+	}
+	if _, err := ParseStableHLO("f32[2]"); err == nil {
+		t.Error("ParseStableHLO should not accept the short form")
+	}
+}
+
+func TestShapeJSON(t *testing.T) {
+	type signature struct {
+		Inputs []Shape
+		Output Shape
+		Extra  Shape
+	}
+	sig := signature{
+		Inputs: []Shape{Make(dtypes.Float32, 2, 3), Make(dtypes.Int4, 8)},
+		Output: MakeTuple([]Shape{Make(dtypes.Bool), Make(dtypes.BFloat16, DimUnknown)}),
+	}
+	data, err := json.Marshal(sig)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	want := `{"Inputs":["f32[2,3]","s4[8]"],"Output":"(pred[], bf16[?])","Extra":""}`
+	if string(data) != want {
+		t.Errorf("json.Marshal = %s, want %s", data, want)
+	}
+	var got signature
+	if err = json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, sig) {
+		t.Errorf("json.Unmarshal = %#v, want %#v", got, sig)
+	}
+	if err = json.Unmarshal([]byte(`{"Output":"f32[2"}`), &got); err == nil {
+		t.Error("json.Unmarshal should have failed for an invalid shape")
+	}
+}
@@ -83,6 +83,7 @@ import (
 
 	"github.com/gomlx/compute/dtypes"
 	"github.com/gomlx/compute/dtypes/gotype"
+	"github.com/gomlx/go-xla/internal/utils"
 	"github.com/pkg/errors"
 )
 
@@ -242,7 +243,12 @@ func (s Shape) IsZeroSize() bool {
 
 // Memory returns the memory used to store an array of the given shape, the same as the size in bytes.
 // Careful, so far all types in Go and on device seem to use the same sizes, but future type this is not guaranteed.
+//
+// Sub-byte dtypes (e.g. Int4) are packed, and 8-bit floats (e.g. F8E4M3FN) use one byte per element.
 func (s Shape) Memory() uintptr {
+	if utils.IsFloat8(s.DType) || s.DType.IsPacked() {
+		return uintptr(utils.DTypeSizeForDimensions(s.DType, s.Size()))
+	}
 	return s.DType.Memory() * uintptr(s.Size())
 }
 
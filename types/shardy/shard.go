@@ -0,0 +1,341 @@
+package shardy
+
+import (
+	"slices"
+
+	"github.com/gomlx/go-xla/types/shapes"
+	"github.com/pkg/errors"
+)
+
+// Shard describes the piece of a global (logical) tensor held by one device, for a given ShardingSpec.
+type Shard struct {
+	// Device is the logical device number: the index in the device assignment used to compile the program,
+	// and hence the position of this shard in the inputs (or outputs) of a sharded execution.
+	Device int
+
+	// MeshPosition is the position of the device in the mesh, one index per mesh axis.
+	MeshPosition []int
+
+	// Offsets in the global tensor where the shard starts, one per tensor axis.
+	Offsets []int
+
+	// Shape of the shard: same dtype as the global shape, with the dimensions of the shard.
+	Shape shapes.Shape
+}
+
+// meshAxisSplit returns the number of shards and the shard index of a device at meshPosition, for the given
+// (sub-)axis of the mesh.
+//
+// A sub-axis "x":(preSize)size splits the mesh axis x in [preSize, size, rest] (major to minor), and only the
+// middle part is used.
+func (s *ShardingSpec) meshAxisSplit(meshAxisSpec MeshAxisSpec, meshPosition []int) (numShards, index int) {
+	axisIdx := s.Mesh.nameToAxis[meshAxisSpec.AxisName]
+	axisSize := s.Mesh.axesSizes[axisIdx]
+	position := meshPosition[axisIdx]
+	if meshAxisSpec.Size <= 0 {
+		return axisSize, position
+	}
+	postSize := axisSize / (meshAxisSpec.PreSize * meshAxisSpec.Size)
+	return meshAxisSpec.Size, (position / postSize) % meshAxisSpec.Size
+}
+
+// NumShards returns the number of shards of each of the axes of a tensor of the given rank.
+// Replicated (or not specified) axes have 1 shard.
+func (s *ShardingSpec) NumShards(rank int) ([]int, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Rank() > rank {
+		return nil, errors.Errorf("ShardingSpec rank %d is larger than tensor rank %d", s.Rank(), rank)
+	}
+	meshPosition := make([]int, s.Mesh.Rank())
+	numShards := make([]int, rank)
+	for axis := range numShards {
+		numShards[axis] = 1
+		if axis >= len(s.Axes) {
+			continue
+		}
+		for _, meshAxisSpec := range s.Axes[axis].MeshAxes {
+			n, _ := s.meshAxisSplit(meshAxisSpec, meshPosition)
+			numShards[axis] *= n
+		}
+	}
+	return numShards, nil
+}
+
+// ShardShape returns the shape of the shard held by each device, for a tensor with the given global shape.
+//
+// Each sharded axis of the global shape must be divisible by its number of shards (see NumShards).
+func (s *ShardingSpec) ShardShape(globalShape shapes.Shape) (shapes.Shape, error) {
+	if globalShape.IsTuple() {
+		return shapes.Invalid(), errors.Errorf("ShardingSpec.ShardShape doesn't support tuple shapes (%s)", globalShape)
+	}
+	numShards, err := s.NumShards(globalShape.Rank())
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	shardShape := globalShape.Clone()
+	for axis, dim := range globalShape.Dimensions {
+		if numShards[axis] == 1 {
+			continue
+		}
+		if dim == shapes.DimUnknown {
+			return shapes.Invalid(), errors.Errorf("ShardingSpec.ShardShape can't shard dynamic axis %d of %s",
+				axis, globalShape)
+		}
+		if dim%numShards[axis] != 0 {
+			return shapes.Invalid(), errors.Errorf(
+				"ShardingSpec.ShardShape: axis %d of %s (dimension %d) is not divisible by its number of shards %d",
+				axis, globalShape, dim, numShards[axis])
+		}
+		shardShape.Dimensions[axis] = dim / numShards[axis]
+	}
+	return shardShape, nil
+}
+
+// Shards returns the description of the shard of each device, for a tensor with the given global shape.
+//
+// The shards are ordered by logical device number (see Shard.Device and DeviceMesh.SetLogicalDeviceAssignment),
+// which is the order the per-device buffers are fed to (or returned by) a sharded execution.
+//
+// Devices along mesh axes not used by the spec hold replicas of the same shard.
+func (s *ShardingSpec) Shards(globalShape shapes.Shape) ([]Shard, error) {
+	shardShape, err := s.ShardShape(globalShape)
+	if err != nil {
+		return nil, err
+	}
+	assignment := s.Mesh.LogicalDeviceAssignment()
+	numDevices := s.Mesh.NumDevices()
+	shards := make([]Shard, numDevices)
+	rank := globalShape.Rank()
+	for flatPosition := range numDevices {
+		// Position in the mesh, the last axis is the minor one.
+		meshPosition := make([]int, s.Mesh.Rank())
+		remaining := flatPosition
+		for axisIdx := s.Mesh.Rank() - 1; axisIdx >= 0; axisIdx-- {
+			meshPosition[axisIdx] = remaining % s.Mesh.axesSizes[axisIdx]
+			remaining /= s.Mesh.axesSizes[axisIdx]
+		}
+
+		// Offsets: mesh axes sharding a tensor axis are listed from major to minor.
+		offsets := make([]int, rank)
+		for axis := range min(rank, len(s.Axes)) {
+			shardIdx := 0
+			for _, meshAxisSpec := range s.Axes[axis].MeshAxes {
+				n, idx := s.meshAxisSplit(meshAxisSpec, meshPosition)
+				shardIdx = shardIdx*n + idx
+			}
+			offsets[axis] = shardIdx * shardShape.Dimensions[axis]
+		}
+
+		device := flatPosition
+		if assignment != nil {
+			device = assignment[flatPosition]
+		}
+		shards[device] = Shard{
+			Device:       device,
+			MeshPosition: meshPosition,
+			Offsets:      offsets,
+			Shape:        shardShape.Clone(),
+		}
+	}
+	return shards, nil
+}
+
+// copyShard copies the shard at the given offsets of the global flat array (with the given dimensions) from/to
+// the shard flat array.
+//
+// If toShard is true, it copies from global to shard, otherwise from shard to global.
+func copyShard[T any](global []T, globalDims []int, shard []T, shardDims []int, offsets []int, toShard bool) {
+	rank := len(globalDims)
+	if rank == 0 {
+		if toShard {
+			shard[0] = global[0]
+		} else {
+			global[0] = shard[0]
+		}
+		return
+	}
+	if slices.Contains(shardDims, 0) {
+		return
+	}
+
+	// Strides of the global array.
+	globalStrides := make([]int, rank)
+	stride := 1
+	for axis := rank - 1; axis >= 0; axis-- {
+		globalStrides[axis] = stride
+		stride *= globalDims[axis]
+	}
+
+	// Iterate over all rows (the last axis is contiguous) of the shard.
+	rowLen := shardDims[rank-1]
+	index := make([]int, rank-1)
+	for shardPos := 0; shardPos < len(shard); shardPos += rowLen {
+		globalPos := offsets[rank-1]
+		for axis, idx := range index {
+			globalPos += (offsets[axis] + idx) * globalStrides[axis]
+		}
+		if toShard {
+			copy(shard[shardPos:shardPos+rowLen], global[globalPos:globalPos+rowLen])
+		} else {
+			copy(global[globalPos:globalPos+rowLen], shard[shardPos:shardPos+rowLen])
+		}
+
+		// Next row.
+		for axis := rank - 2; axis >= 0; axis-- {
+			index[axis]++
+			if index[axis] < shardDims[axis] {
+				break
+			}
+			index[axis] = 0
+		}
+	}
+}
+
+// ShardFlatData splits the flat (row-major) values of a tensor with the given global shape into the pieces
+// held by each device, according to the spec.
+//
+// It returns one flat slice per device, ordered by logical device number (see ShardingSpec.Shards).
+// Devices holding replicas of the same shard get separate copies of the values.
+func ShardFlatData[T any](spec *ShardingSpec, globalShape shapes.Shape, flat []T) ([][]T, error) {
+	if spec == nil {
+		return nil, errors.New("ShardFlatData requires a non-nil ShardingSpec")
+	}
+	if len(flat) != globalShape.Size() {
+		return nil, errors.Errorf("ShardFlatData: shape %s requires %d values, got %d",
+			globalShape, globalShape.Size(), len(flat))
+	}
+	shards, err := spec.Shards(globalShape)
+	if err != nil {
+		return nil, err
+	}
+	pieces := make([][]T, len(shards))
+	for i, shard := range shards {
+		pieces[i] = make([]T, shard.Shape.Size())
+		copyShard(flat, globalShape.Dimensions, pieces[i], shard.Shape.Dimensions, shard.Offsets, true)
+	}
+	return pieces, nil
+}
+
+// UnshardFlatData reassembles the flat (row-major) values of a tensor with the given global shape from the
+// pieces held by each device, according to the spec. It is the inverse of ShardFlatData.
+//
+// The pieces must be ordered by logical device number (see ShardingSpec.Shards), one per device in the mesh.
+// For replicated shards, the values of the last device holding the shard are used.
+func UnshardFlatData[T any](spec *ShardingSpec, globalShape shapes.Shape, pieces [][]T) ([]T, error) {
+	if spec == nil {
+		return nil, errors.New("UnshardFlatData requires a non-nil ShardingSpec")
+	}
+	shards, err := spec.Shards(globalShape)
+	if err != nil {
+		return nil, err
+	}
+	if len(pieces) != len(shards) {
+		return nil, errors.Errorf("UnshardFlatData requires one piece per device (%d), got %d pieces",
+			len(shards), len(pieces))
+	}
+	flat := make([]T, globalShape.Size())
+	for i, shard := range shards {
+		if len(pieces[i]) != shard.Shape.Size() {
+			return nil, errors.Errorf("UnshardFlatData: piece for device #%d should have %d values (shape %s), got %d",
+				i, shard.Shape.Size(), shard.Shape, len(pieces[i]))
+		}
+		copyShard(flat, globalShape.Dimensions, pieces[i], shard.Shape.Dimensions, shard.Offsets, false)
+	}
+	return flat, nil
+}
+
+// manualNumShards returns the number of shards of each of the axes of a tensor of the given rank, counting only
+// the given manual mesh axes (see ManualShardShape).
+func (s *ShardingSpec) manualNumShards(rank int, manualAxes []string) ([]int, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	if s.Rank() > rank {
+		return nil, errors.Errorf("ShardingSpec rank %d is larger than tensor rank %d", s.Rank(), rank)
+	}
+	for _, axisName := range manualAxes {
+		if _, found := s.Mesh.nameToAxis[axisName]; !found {
+			return nil, errors.Errorf("manual axis %q not found in mesh %q", axisName, s.Mesh.Name())
+		}
+	}
+	meshPosition := make([]int, s.Mesh.Rank())
+	numShards := make([]int, rank)
+	for axis := range numShards {
+		numShards[axis] = 1
+		if axis >= len(s.Axes) {
+			continue
+		}
+		freeAxis := ""
+		for _, meshAxisSpec := range s.Axes[axis].MeshAxes {
+			if !slices.Contains(manualAxes, meshAxisSpec.AxisName) {
+				freeAxis = meshAxisSpec.AxisName
+				continue
+			}
+			if freeAxis != "" {
+				return nil, errors.Errorf("ShardingSpec tensor axis %d: manual axis %q must come before the free axis %q",
+					axis, meshAxisSpec.AxisName, freeAxis)
+			}
+			n, _ := s.meshAxisSplit(meshAxisSpec, meshPosition)
+			numShards[axis] *= n
+		}
+	}
+	return numShards, nil
+}
+
+// ManualShardShape returns the shape of the shard held by each device in a manual computation over the given
+// mesh axes (see stablehlo.ManualComputation), for a tensor with the given global shape.
+//
+// Only the manual mesh axes split the tensor: axes sharded over other (free) mesh axes keep their global
+// dimension, and in each tensor axis the manual mesh axes must come before the free ones.
+func (s *ShardingSpec) ManualShardShape(globalShape shapes.Shape, manualAxes []string) (shapes.Shape, error) {
+	if globalShape.IsTuple() {
+		return shapes.Invalid(), errors.Errorf("ShardingSpec.ManualShardShape doesn't support tuple shapes (%s)", globalShape)
+	}
+	numShards, err := s.manualNumShards(globalShape.Rank(), manualAxes)
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	shardShape := globalShape.Clone()
+	for axis, dim := range globalShape.Dimensions {
+		if numShards[axis] == 1 {
+			continue
+		}
+		if dim == shapes.DimUnknown {
+			return shapes.Invalid(), errors.Errorf("ShardingSpec.ManualShardShape can't shard dynamic axis %d of %s",
+				axis, globalShape)
+		}
+		if dim%numShards[axis] != 0 {
+			return shapes.Invalid(), errors.Errorf(
+				"ShardingSpec.ManualShardShape: axis %d of %s (dimension %d) is not divisible by its number of shards %d",
+				axis, globalShape, dim, numShards[axis])
+		}
+		shardShape.Dimensions[axis] = dim / numShards[axis]
+	}
+	return shardShape, nil
+}
+
+// ManualGlobalShape is the inverse of ManualShardShape: it returns the global shape of a tensor whose shard,
+// in a manual computation over the given mesh axes, has the given shape.
+func (s *ShardingSpec) ManualGlobalShape(shardShape shapes.Shape, manualAxes []string) (shapes.Shape, error) {
+	if shardShape.IsTuple() {
+		return shapes.Invalid(), errors.Errorf("ShardingSpec.ManualGlobalShape doesn't support tuple shapes (%s)", shardShape)
+	}
+	numShards, err := s.manualNumShards(shardShape.Rank(), manualAxes)
+	if err != nil {
+		return shapes.Invalid(), err
+	}
+	globalShape := shardShape.Clone()
+	for axis, dim := range shardShape.Dimensions {
+		if numShards[axis] == 1 {
+			continue
+		}
+		if dim == shapes.DimUnknown {
+			return shapes.Invalid(), errors.Errorf("ShardingSpec.ManualGlobalShape can't shard dynamic axis %d of %s",
+				axis, shardShape)
+		}
+		globalShape.Dimensions[axis] = dim * numShards[axis]
+	}
+	return globalShape, nil
+}
@@ -0,0 +1,197 @@
+package shardy
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/shapes"
+)
+
+func TestShards(t *testing.T) {
+	mesh, err := NewDeviceMesh("mesh", []int{2, 2}, []string{"data", "model"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
+	globalShape := shapes.Make(dtypes.Float32, 4, 6)
+
+	// Axis 0 sharded on "data", axis 1 replicated ("model" devices hold replicas).
+	spec := NewShardingSpec(mesh).AddShardedAxis("data")
+	shardShape, err := spec.ShardShape(globalShape)
+	if err != nil {
+		t.Fatalf("ShardShape() error = %v", err)
+	}
+	if !shardShape.Equal(shapes.Make(dtypes.Float32, 2, 6)) {
+		t.Errorf("ShardShape() = %s, want (Float32)[2 6]", shardShape)
+	}
+	shards, err := spec.Shards(globalShape)
+	if err != nil {
+		t.Fatalf("Shards() error = %v", err)
+	}
+	wantOffsets := [][]int{{0, 0}, {0, 0}, {2, 0}, {2, 0}}
+	for i, shard := range shards {
+		if shard.Device != i || !slices.Equal(shard.Offsets, wantOffsets[i]) {
+			t.Errorf("shard #%d = %+v, want offsets %v", i, shard, wantOffsets[i])
+		}
+	}
+
+	// Both axes sharded, with a reversed logical device assignment.
+	if err = mesh.SetLogicalDeviceAssignment(3, 2, 1, 0); err != nil {
+		t.Fatalf("SetLogicalDeviceAssignment() error = %v", err)
+	}
+	spec = NewShardingSpec(mesh).AddShardedAxis("model").AddShardedAxis("data")
+	shards, err = spec.Shards(globalShape)
+	if err != nil {
+		t.Fatalf("Shards() error = %v", err)
+	}
+	// Device 0 is at mesh position {1, 1}: row shard 1 ("model"), column shard 1 ("data").
+	if !slices.Equal(shards[0].MeshPosition, []int{1, 1}) || !slices.Equal(shards[0].Offsets, []int{2, 3}) {
+		t.Errorf("shard of device 0 = %+v, want mesh position [1 1] and offsets [2 3]", shards[0])
+	}
+	if !slices.Equal(shards[1].MeshPosition, []int{1, 0}) || !slices.Equal(shards[1].Offsets, []int{0, 3}) {
+		t.Errorf("shard of device 1 = %+v, want mesh position [1 0] and offsets [0 3]", shards[1])
+	}
+
+	// Axis not divisible by the number of shards.
+	if _, err = spec.ShardShape(shapes.Make(dtypes.Float32, 3, 6)); err == nil {
+		t.Error("ShardShape() should fail for a non-divisible axis")
+	}
+}
+
+func TestShardsWithSubAxes(t *testing.T) {
+	mesh, err := NewDeviceMesh("mesh", []int{4}, []string{"x"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
+	// Axis 0 sharded on the major sub-axis x:(1)2, and axis 1 sharded on the minor sub-axis x:(2)2.
+	spec := &ShardingSpec{
+		Mesh: mesh,
+		Axes: []TensorAxisSpec{
+			{MeshAxes: []MeshAxisSpec{{AxisName: "x", PreSize: 1, Size: 2}}},
+			{MeshAxes: []MeshAxisSpec{{AxisName: "x", PreSize: 2, Size: 2}}},
+		},
+	}
+	numShards, err := spec.NumShards(2)
+	if err != nil {
+		t.Fatalf("NumShards() error = %v", err)
+	}
+	if !slices.Equal(numShards, []int{2, 2}) {
+		t.Errorf("NumShards() = %v, want [2 2]", numShards)
+	}
+	shards, err := spec.Shards(shapes.Make(dtypes.Int32, 2, 4))
+	if err != nil {
+		t.Fatalf("Shards() error = %v", err)
+	}
+	wantOffsets := [][]int{{0, 0}, {0, 2}, {1, 0}, {1, 2}}
+	for i, shard := range shards {
+		if !slices.Equal(shard.Offsets, wantOffsets[i]) {
+			t.Errorf("shard #%d offsets = %v, want %v", i, shard.Offsets, wantOffsets[i])
+		}
+	}
+}
+
+func TestShardFlatData(t *testing.T) {
+	mesh, err := NewDeviceMesh("mesh", []int{2, 2}, []string{"data", "model"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
+	globalShape := shapes.Make(dtypes.Int32, 2, 4)
+	flat := []int32{0, 1, 2, 3, 4, 5, 6, 7}
+
+	// First axis sharded on "data", second axis sharded on "model".
+	spec := NewShardingSpec(mesh).AddShardedAxis("data").AddShardedAxis("model")
+	pieces, err := ShardFlatData(spec, globalShape, flat)
+	if err != nil {
+		t.Fatalf("ShardFlatData() error = %v", err)
+	}
+	want := [][]int32{{0, 1}, {2, 3}, {4, 5}, {6, 7}}
+	if !reflect.DeepEqual(pieces, want) {
+		t.Errorf("ShardFlatData() = %v, want %v", pieces, want)
+	}
+	got, err := UnshardFlatData(spec, globalShape, pieces)
+	if err != nil {
+		t.Fatalf("UnshardFlatData() error = %v", err)
+	}
+	if !slices.Equal(got, flat) {
+		t.Errorf("UnshardFlatData() = %v, want %v", got, flat)
+	}
+
+	// Second axis sharded across both mesh axes, first axis replicated.
+	spec = NewShardingSpec(mesh).AddReplicated().AddShardedAxis("model", "data")
+	pieces, err = ShardFlatData(spec, globalShape, flat)
+	if err != nil {
+		t.Fatalf("ShardFlatData() error = %v", err)
+	}
+	// Mesh position {data, model}: shard index along axis 1 is model*2+data.
+	want = [][]int32{{0, 4}, {2, 6}, {1, 5}, {3, 7}}
+	if !reflect.DeepEqual(pieces, want) {
+		t.Errorf("ShardFlatData() = %v, want %v", pieces, want)
+	}
+	got, err = UnshardFlatData(spec, globalShape, pieces)
+	if err != nil {
+		t.Fatalf("UnshardFlatData() error = %v", err)
+	}
+	if !slices.Equal(got, flat) {
+		t.Errorf("UnshardFlatData() = %v, want %v", got, flat)
+	}
+
+	// Fully replicated scalar.
+	pieces, err = ShardFlatData(NewShardingSpec(mesh), shapes.Make(dtypes.Int32), []int32{7})
+	if err != nil {
+		t.Fatalf("ShardFlatData() error = %v", err)
+	}
+	if !reflect.DeepEqual(pieces, [][]int32{{7}, {7}, {7}, {7}}) {
+		t.Errorf("ShardFlatData() of replicated scalar = %v", pieces)
+	}
+
+	// Errors.
+	if _, err = ShardFlatData(spec, globalShape, flat[:7]); err == nil {
+		t.Error("ShardFlatData() should fail for the wrong number of values")
+	}
+	if _, err = UnshardFlatData(spec, globalShape, pieces[:3]); err == nil {
+		t.Error("UnshardFlatData() should fail for the wrong number of pieces")
+	}
+}
+
+func TestManualShardShape(t *testing.T) {
+	mesh, err := NewDeviceMesh("mesh", []int{2, 4}, []string{"data", "model"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
+	globalShape := shapes.Make(dtypes.Float32, 8, 16)
+	// Axis 0 sharded on "data" (manual), axis 1 on "model" (free).
+	spec := NewShardingSpec(mesh).AddShardedAxis("data").AddShardedAxis("model")
+	shardShape, err := spec.ManualShardShape(globalShape, []string{"data"})
+	if err != nil {
+		t.Fatalf("ManualShardShape() error = %v", err)
+	}
+	if !shardShape.Equal(shapes.Make(dtypes.Float32, 4, 16)) {
+		t.Errorf("ManualShardShape() = %s, want (Float32)[4 16]", shardShape)
+	}
+	got, err := spec.ManualGlobalShape(shardShape, []string{"data"})
+	if err != nil {
+		t.Fatalf("ManualGlobalShape() error = %v", err)
+	}
+	if !got.Equal(globalShape) {
+		t.Errorf("ManualGlobalShape() = %s, want %s", got, globalShape)
+	}
+
+	// Both mesh axes are manual.
+	shardShape, err = spec.ManualShardShape(globalShape, []string{"data", "model"})
+	if err != nil {
+		t.Fatalf("ManualShardShape() error = %v", err)
+	}
+	if !shardShape.Equal(shapes.Make(dtypes.Float32, 4, 4)) {
+		t.Errorf("ManualShardShape() = %s, want (Float32)[4 4]", shardShape)
+	}
+
+	// Errors.
+	if _, err = spec.ManualShardShape(globalShape, []string{"unknown"}); err == nil {
+		t.Error("ManualShardShape() should fail for an unknown manual axis")
+	}
+	freeFirst := NewShardingSpec(mesh).AddShardedAxis("model", "data")
+	if _, err = freeFirst.ManualShardShape(globalShape, []string{"data"}); err == nil {
+		t.Error("ManualShardShape() should fail if a free axis comes before a manual axis")
+	}
+}
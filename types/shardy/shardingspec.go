@@ -2,6 +2,7 @@ package shardy
 
 import (
 	"fmt"
+	"slices"
 	"sort"
 	"strings"
 
@@ -31,17 +32,26 @@ import (
 //	// Second axis is sharded across both "data" and "model" devices.
 //	 largeWeights := NewShardingSpec(mesh).AddReplicated().AddShardedAxis("data", "model")
 //
-// There are two advanced features supported but not tested (pls if you need let us know how it goes, or if you find
+// There are some advanced features supported but not tested (pls if you need let us know how it goes, or if you find
 // any issues):
 //
 //  1. The tensor can also be sharded across mesh "sub-axes" -- seed detailed documentation in [1]
 //  2. If using ShardingSpec for hints, instead of mesh axes one can give an "open" (in StableHLO marked as "?")
 //     axis, with the semantics that XLA Shardy can choose any mesh axis (or axes) to shard the tensor. See [1].
+//  3. Each tensor axis can have a propagation priority (see AddShardedAxisWithPriority), and mesh axes can be
+//     explicitly marked as replicated (see WithReplicatedAxes), so they are not used to further shard the tensor.
 //
 // [1] https://github.com/openxla/shardy/blob/main/docs/sharding_representation.md
 type ShardingSpec struct {
 	Mesh *DeviceMesh
 	Axes []TensorAxisSpec
+
+	// ReplicatedAxes are mesh axes along which the tensor is explicitly replicated: Shardy won't use them to
+	// further shard the tensor, even on opened axes.
+	//
+	// If set, it replaces the implicit list of replicated axes used by ToStableHLO (all mesh axes not used to
+	// shard the tensor): mesh axes that are neither used nor listed here are left free for Shardy to use.
+	ReplicatedAxes []string
 }
 
 // TensorAxisSpec specifies how a tensor axis is to be sharded (or replicated).
@@ -51,6 +61,11 @@ type ShardingSpec struct {
 type TensorAxisSpec struct {
 	MeshAxes []MeshAxisSpec
 	Opened   bool // If opened to further sharding.
+
+	// Priority of the sharding of this axis in Shardy's propagation, only used if HasPriority is set.
+	// Shardings with lower values (0 is the highest priority) are propagated first.
+	Priority    int
+	HasPriority bool
 }
 
 type MeshAxisSpec struct {
@@ -62,7 +77,7 @@ type MeshAxisSpec struct {
 
 // NewShardingSpec creates a new ShardingSpec.
 func NewShardingSpec(mesh *DeviceMesh) *ShardingSpec {
-	return &ShardingSpec{mesh, make([]TensorAxisSpec, 0)}
+	return &ShardingSpec{Mesh: mesh, Axes: make([]TensorAxisSpec, 0)}
 }
 
 // AddShardedAxis adds a new sharded axis to the ShardingSpec using one or more mesh axes.
@@ -77,6 +92,35 @@ func (s *ShardingSpec) AddShardedAxis(meshAxesNames ...string) *ShardingSpec {
 	return s
 }
 
+// AddShardedAxisWithPriority adds a new sharded axis to the ShardingSpec using one or more mesh axes, with the given
+// propagation priority (0 is the highest priority). It is rendered as, e.g., {"data"}p0.
+//
+// It returns itself, so calls can be chained.
+func (s *ShardingSpec) AddShardedAxisWithPriority(priority int, meshAxesNames ...string) *ShardingSpec {
+	s.AddShardedAxis(meshAxesNames...)
+	s.Axes[len(s.Axes)-1].Priority = priority
+	s.Axes[len(s.Axes)-1].HasPriority = true
+	return s
+}
+
+// AddOpenedAxis adds a new axis sharded using the given mesh axes (it can be none), but opened so that Shardy can
+// further shard it along other mesh axes. It is rendered as, e.g., {"data", ?}.
+//
+// It returns itself, so calls can be chained.
+func (s *ShardingSpec) AddOpenedAxis(meshAxesNames ...string) *ShardingSpec {
+	s.AddShardedAxis(meshAxesNames...)
+	s.Axes[len(s.Axes)-1].Opened = true
+	return s
+}
+
+// WithReplicatedAxes marks the given mesh axes as explicitly replicated, see ShardingSpec.ReplicatedAxes.
+//
+// It returns itself, so calls can be chained.
+func (s *ShardingSpec) WithReplicatedAxes(meshAxesNames ...string) *ShardingSpec {
+	s.ReplicatedAxes = append(s.ReplicatedAxes, meshAxesNames...)
+	return s
+}
+
 // AddReplicated adds a new replicated axis to the ShardingSpec.
 //
 // It returns itself, so calls can be chained.
@@ -106,7 +150,11 @@ func (s *ShardingSpec) IsReplicated() bool {
 
 // Validate checks that the ShardingSpec is valid for the given mesh.
 func (s *ShardingSpec) Validate() error {
+	usedAxes := make(map[string]bool)
 	for i, axisSpec := range s.Axes {
+		if axisSpec.HasPriority && axisSpec.Priority < 0 {
+			return errors.Errorf("ShardingSpec tensor axis %d has invalid negative priority %d", i, axisSpec.Priority)
+		}
 		for j, meshAxisSpec := range axisSpec.MeshAxes {
 			axisName := meshAxisSpec.AxisName
 			if axisName == "" {
@@ -120,6 +168,7 @@ func (s *ShardingSpec) Validate() error {
 					i, j, axisName)
 			}
 			meshAxisSize := s.Mesh.axesSizes[axisIdx]
+			usedAxes[axisName] = true
 
 			// Check sub-axis specification.
 			if meshAxisSpec.Size > 0 {
@@ -136,9 +185,37 @@ func (s *ShardingSpec) Validate() error {
 			}
 		}
 	}
+	for i, axisName := range s.ReplicatedAxes {
+		if _, ok := s.Mesh.nameToAxis[axisName]; !ok {
+			return errors.Errorf("ShardingSpec replicated axis #%d refers to unknown mesh axis %q", i, axisName)
+		}
+		if slices.Contains(s.ReplicatedAxes[:i], axisName) {
+			return errors.Errorf("ShardingSpec replicated axis %q is listed more than once", axisName)
+		}
+		if usedAxes[axisName] {
+			return errors.Errorf("ShardingSpec replicated axis %q is also used to shard a tensor axis", axisName)
+		}
+	}
 	return nil
 }
 
+// sortedReplicatedAxes returns the ReplicatedAxes in the order of the mesh axes, as required by Shardy.
+func (s *ShardingSpec) sortedReplicatedAxes() []string {
+	sorted := slices.Clone(s.ReplicatedAxes)
+	slices.SortFunc(sorted, func(a, b string) int {
+		return s.Mesh.nameToAxis[a] - s.Mesh.nameToAxis[b]
+	})
+	return sorted
+}
+
+// priorityToStableHLO returns the priority suffix of the axis sharding (e.g. "p0"), or "" if it has no priority.
+func (axisSpec TensorAxisSpec) priorityToStableHLO() string {
+	if !axisSpec.HasPriority {
+		return ""
+	}
+	return fmt.Sprintf("p%d", axisSpec.Priority)
+}
+
 func (s *ShardingSpec) ValidateShape(shape shapes.Shape) error {
 	if s == nil {
 		// No sharding spec (nil) means fully replicated, and it's always valid for any shape.
@@ -155,6 +232,10 @@ func (s *ShardingSpec) ValidateShape(shape shapes.Shape) error {
 }
 
 // ToStableHLO converts the ShardingSpec to its StableHLO string representation.
+//
+// The mesh axes not used to shard the tensor are listed as replicated, unless ReplicatedAxes is set, in which case
+// only those are listed (the explicit list replaces the implicit one).
+//
 // See details in:
 // https://github.com/openxla/shardy/blob/main/docs/sharding_representation.md
 func (s *ShardingSpec) ToStableHLO() string {
@@ -177,14 +258,19 @@ func (s *ShardingSpec) ToStableHLO() string {
 		if axisSpec.Opened {
 			hloAxes = append(hloAxes, "?")
 		}
-		dimShardings = append(dimShardings, fmt.Sprintf("{%s}", strings.Join(hloAxes, ", ")))
+		dimShardings = append(dimShardings, fmt.Sprintf("{%s}%s", strings.Join(hloAxes, ", "),
+			axisSpec.priorityToStableHLO()))
 	}
 
 	var replicatedStrs []string
-	for axisName := range replicatedAxes {
-		replicatedStrs = append(replicatedStrs, axisName)
+	if len(s.ReplicatedAxes) > 0 {
+		replicatedStrs = s.sortedReplicatedAxes()
+	} else {
+		for axisName := range replicatedAxes {
+			replicatedStrs = append(replicatedStrs, axisName)
+		}
+		sort.Strings(replicatedStrs)
 	}
-	sort.Strings(replicatedStrs)
 
 	replicatedPart := ""
 	if len(replicatedStrs) > 0 {
@@ -220,6 +306,7 @@ func (s *ShardingSpec) ToValueAttribute(shape shapes.Shape) string {
 			} else {
 				w("{}")
 			}
+			w("%s", tensorAxisSpec.priorityToStableHLO())
 			continue
 		}
 		w("{")
@@ -236,8 +323,19 @@ func (s *ShardingSpec) ToValueAttribute(shape shapes.Shape) string {
 		if tensorAxisSpec.Opened {
 			w(", ?")
 		}
+		w("}%s", tensorAxisSpec.priorityToStableHLO())
+	}
+	w("]")
+	if len(s.ReplicatedAxes) > 0 {
+		w(", replicated={")
+		for i, axisName := range s.sortedReplicatedAxes() {
+			if i > 0 {
+				w(", ")
+			}
+			w("%q", axisName)
+		}
 		w("}")
 	}
-	w("]>")
+	w(">")
 	return buf.String()
 }
@@ -2,6 +2,9 @@ package shardy
 
 import (
 	"testing"
+
+	"github.com/gomlx/compute/dtypes"
+	"github.com/gomlx/go-xla/types/shapes"
 )
 
 func TestShardSpec_ToStableHLO(t *testing.T) {
@@ -9,6 +12,10 @@ func TestShardSpec_ToStableHLO(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NewDeviceMesh() error = %v", err)
 	}
+	mesh3, err := NewDeviceMesh("mesh3", []int{2, 2, 2}, []string{"z", "a", "b"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
 	testCases := []struct {
 		name     string
 		spec     *ShardingSpec
@@ -44,6 +51,23 @@ func TestShardSpec_ToStableHLO(t *testing.T) {
 			spec:     &ShardingSpec{Mesh: mesh, Axes: []TensorAxisSpec{{Opened: true}}},
 			expected: "#sdy.sharding<@test_mesh, [{?}], replicated={a, z}>",
 		},
+		{
+			name:     "Priorities",
+			spec:     NewShardingSpec(mesh).AddShardedAxisWithPriority(0, "z").AddOpenedAxis("a"),
+			expected: "#sdy.sharding<@test_mesh, [{z}p0, {a, ?}]>",
+		},
+		{
+			name:     "Explicitly replicated",
+			spec:     NewShardingSpec(mesh).AddOpenedAxis().WithReplicatedAxes("a"),
+			expected: "#sdy.sharding<@test_mesh, [{?}], replicated={a}>",
+		},
+		{
+			// The explicit replicated axes replace the implicit ones: "a" is neither sharded nor replicated.
+			name: "Sharded and explicitly replicated",
+			spec: NewShardingSpec(mesh3).AddShardedAxis("z").AddOpenedAxis().
+				WithReplicatedAxes("b"),
+			expected: "#sdy.sharding<@mesh3, [{z}, {?}], replicated={b}>",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -85,6 +109,31 @@ func TestShardSpec_Validate(t *testing.T) {
 			},
 			expectError: false,
 		},
+		{
+			name:        "Valid priority and replicated axes",
+			spec:        NewShardingSpec(mesh).AddShardedAxisWithPriority(1, "z").WithReplicatedAxes("a"),
+			expectError: false,
+		},
+		{
+			name:        "Negative priority",
+			spec:        NewShardingSpec(mesh).AddShardedAxisWithPriority(-1, "z"),
+			expectError: true,
+		},
+		{
+			name:        "Unknown replicated axis",
+			spec:        NewShardingSpec(mesh).WithReplicatedAxes("x"),
+			expectError: true,
+		},
+		{
+			name:        "Duplicate replicated axis",
+			spec:        NewShardingSpec(mesh).WithReplicatedAxes("a", "a"),
+			expectError: true,
+		},
+		{
+			name:        "Replicated axis used for sharding",
+			spec:        NewShardingSpec(mesh).AddShardedAxis("z").WithReplicatedAxes("z"),
+			expectError: true,
+		},
 		{
 			name: "Invalid sub-axis (PreSize)",
 			spec: &ShardingSpec{
@@ -122,3 +171,19 @@ func TestShardSpec_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestShardSpec_ToValueAttribute(t *testing.T) {
+	mesh, err := NewDeviceMesh("mesh", []int{2, 2, 2}, []string{"data", "model", "expert"})
+	if err != nil {
+		t.Fatalf("NewDeviceMesh() error = %v", err)
+	}
+	spec := NewShardingSpec(mesh).
+		AddShardedAxisWithPriority(1, "data").
+		AddOpenedAxis().
+		WithReplicatedAxes("expert", "model")
+	got := spec.ToValueAttribute(shapes.Make(dtypes.Float32, 4, 4, 4))
+	want := `#sdy.sharding<@mesh, [{"data"}p1, {?}, {}], replicated={"model", "expert"}>`
+	if got != want {
+		t.Errorf("ToValueAttribute() = %q, want %q", got, want)
+	}
+}